@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var openAPIURL string
+var openAPIOutput string
+
+func init() {
+	openapiCmd.Flags().StringVar(&openAPIURL, "url", "http://localhost:3001/openapi.json", "URL of the OpenAPI document served by the running service")
+	openapiCmd.Flags().StringVarP(&openAPIOutput, "output", "o", "openapi.json", "file to write the OpenAPI document to")
+
+	rootCmd.AddCommand(openapiCmd)
+}
+
+var openapiCmd = &cobra.Command{
+	Use:   "openapi",
+	Short: "Write the OpenAPI document of a running service to a file.",
+	Long:  `Fetches the OpenAPI document generated by the server module of a running service and writes it to a file, to be used for client generation. The server module must have "openapi" enabled.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		writeOpenAPISpec(openAPIURL, openAPIOutput)
+	},
+}
+
+func writeOpenAPISpec(url string, output string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		log.Fatalf("Failed to fetch OpenAPI document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Failed to fetch OpenAPI document: %s returned %s", url, resp.Status)
+	}
+
+	spec, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Failed to read OpenAPI document: %v", err)
+	}
+
+	err = os.WriteFile(output, spec, 0644)
+	if err != nil {
+		log.Fatalf("Failed to write OpenAPI document: %v", err)
+	}
+
+	log.Printf("OpenAPI document written to %s", output)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"github.com/alsey89/gogetter/pkg/apikey"
 	"github.com/alsey89/gogetter/pkg/config"
+	"github.com/alsey89/gogetter/pkg/credentials"
 	"github.com/alsey89/gogetter/pkg/logger"
 	"github.com/alsey89/gogetter/pkg/mailer"
+	"github.com/alsey89/gogetter/pkg/oidc"
 	"github.com/alsey89/gogetter/pkg/pgconn"
 	"github.com/alsey89/gogetter/pkg/server"
+	"github.com/alsey89/gogetter/pkg/session"
 	"github.com/alsey89/gogetter/pkg/token"
 	"go.uber.org/fx"
 )
@@ -38,6 +42,11 @@ func init() {
 		"server.csrf_secure":     false,
 		"server.csrf_domain":     "localhost",
 
+		// Internal admin listener, not exposed publicly
+		"admin.host":             "127.0.0.1",
+		"admin.port":             5002,
+		"admin.server_log_level": "PROD",
+
 		// Database
 		"database.host":         "postgres",
 		"database.port":         5432,
@@ -58,20 +67,30 @@ func init() {
 		"mailer.app_password": "foo bar baz qux",
 		"mailer.tls":          true,
 
+		"session.store":                     "postgres",
+		"session.secret":                    "sessionsecret",
+		"session.idle_timeout_in_minutes":   30,
+		"session.absolute_timeout_in_hours": 24,
+
+		"apikey.store":  "postgres",
+		"apikey.prefix": "gg",
+
+		"credentials.algorithm":  "argon2id",
+		"credentials.min_length": 12,
+
+		"oidc.token_scope":    "jwt_auth",
+		"google.issuer":       "https://accounts.google.com",
+		"google.client_id":    "client-id.apps.googleusercontent.com",
+		"google.redirect_url": "http://localhost:3001/auth/oidc/google/callback",
+
 		"jwt_auth.signing_key":    "authsecret",
 		"jwt_auth.token_lookup":   "cookie:jwt",
 		"jwt_auth.signing_method": "HS256",
 		"jwt_auth.exp_in_hours":   72,
 
-		"jwt_email.signing_key":    "authsecret",
-		"jwt_email.token_lookup":   "query:jwt",
-		"jwt_email.signing_method": "HS256",
-		"jwt_email.exp_in_hours":   1,
-
-		"jwt_reset.signing_key":    "authsecret",
-		"jwt_reset.token_lookup":   "query:jwt",
-		"jwt_reset.signing_method": "HS256",
-		"jwt_reset.exp_in_hours":   1,
+		// single-use tokens for email verification and password reset links
+		"jwt.one_time_store": "postgres",
+		"jwt.one_time_exp":   "15m",
 	})
 }
 
@@ -85,7 +104,7 @@ func init() {
 // 		User{},
 // 		ContactInfo{},
 // 	)
-// 	jwt := token.NewTokenManager("jwt", logger, "jwt_auth", "jwt_email", "jwt_reset")
+// 	jwt := token.NewTokenManager("jwt", logger, "jwt_auth")
 // 	mailer := mailer.NewMailer("mailer", logger)
 // 	server := server.NewServer("server", logger)
 
@@ -99,10 +118,18 @@ func main() {
 		//* Modules ---------------------------------------------------------------
 		logger.InjectModule("logger"),
 		pgconn.InjectModule("database"),
-		token.InjectModule("jwt", "jwt_auth", "jwt_email", "jwt_reset"),
+		token.InjectModule("jwt", "jwt_auth"),
 		mailer.InjectModule("mailer", false),
 		server.InjectModule("server"),
+		server.InjectNamedModule("admin"),
+		session.InjectModule("session"),
+		apikey.InjectModule("apikey"),
+		oidc.InjectModule("oidc", "google"),
+		credentials.InjectModule("credentials"),
 		//* Domains ---------------------------------------------------------------
+		fx.Invoke(func(m *oidc.Module, s *server.Module) {
+			m.RegisterRoutes(s.GetServer().Group("/auth/oidc"))
+		}),
 
 		//* Migration -------------------------------------------------------------
 		fx.Invoke(func(m *pgconn.Module) {
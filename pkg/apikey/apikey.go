@@ -0,0 +1,55 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// key of the API key in the echo context
+const ContextKey = "api_key"
+
+/*
+An API key of a machine client, as persisted by the Store.
+Only the hash of the key is stored, the key itself is returned once by CreateKey.
+*/
+type APIKey struct {
+	// public part of the key, safe to expose e.g. to list and revoke keys
+	ID string
+	// describes the client, e.g. "billing service"
+	Name string
+	// owner of the key, e.g. a user or service account ID
+	Subject string
+	Scopes  []string
+	Hash    string `json:"-"`
+
+	CreatedAt time.Time
+	// nil for keys that do not expire
+	ExpiresAt *time.Time
+	// updated at most once per last_used_interval_in_seconds
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Returns the API key authenticated by the API key middleware, or nil if there is none.
+func FromContext(c echo.Context) *APIKey {
+	key, _ := c.Get(ContextKey).(*APIKey)
+	return key
+}
+
+// Reports whether the key is expired at the given time.
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Reports whether the key was granted the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,370 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/pgconn"
+	"github.com/alsey89/gogetter/pkg/util"
+)
+
+// Returned for API keys that are malformed, unknown, revoked or expired.
+var ErrInvalidKey = errors.New("invalid api key")
+
+// to be provided to the fx framework
+type Module struct {
+	config *Config
+	logger *zap.Logger
+	scope  string
+	store  Store
+}
+
+// injected through the fx framework
+type Params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Logger    *zap.Logger
+	// required by the postgres store
+	Database *pgconn.Module `optional:"true"`
+	// replaces the configured store if provided
+	Store Store `optional:"true"`
+}
+
+// holds configurations for the module
+type Config struct {
+	Store       string
+	AutoMigrate bool
+
+	// request header holding the key
+	Header string
+	// prepended to generated keys, e.g. "gg" for "gg_<id>_<secret>", so leaked keys are easy to recognize
+	Prefix string
+	// last used times are saved at most once per interval
+	LastUsedIntervalInSeconds int
+}
+
+// store types
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// default values
+const (
+	DefaultStore       = StoreMemory
+	DefaultAutoMigrate = true
+
+	DefaultHeader                    = "X-API-Key"
+	DefaultPrefix                    = "gg"
+	DefaultLastUsedIntervalInSeconds = 60
+)
+
+//! MODULE ---------------------------------------------------------------
+
+/*
+Provides the Module struct to the fx framework, and registers lifecycle hooks.
+The postgres store requires the pgconn module to be injected as well.
+A custom Store provided to the fx framework replaces the configured store.
+*/
+func InjectModule(scope string) fx.Option {
+	return fx.Module(
+		scope,
+		fx.Provide(func(p Params) *Module {
+
+			m := &Module{scope: scope}
+			m.config = m.setupConfig(scope)
+			m.logger = m.setupLogger(scope, p)
+			m.store = p.Store
+			if m.store == nil {
+				m.store = m.setupStore(p.Database)
+			}
+
+			return m
+		}),
+		fx.Invoke(func(m *Module, p Params) {
+			p.Lifecycle.Append(fx.Hook{
+				OnStart: m.onStart,
+				OnStop:  m.onStop,
+			})
+		}),
+	)
+}
+
+// Instantiates new Module without using the fx framework.
+// The database is only required by the postgres store and can be nil otherwise.
+func NewAPIKeyManager(scope string, logger *zap.Logger, database *pgconn.Module) *Module {
+	m := &Module{scope: scope}
+	m.logger = logger.Named("[" + scope + "]")
+	m.config = m.setupConfig(scope)
+	m.store = m.setupStore(database)
+
+	m.onStart(context.Background())
+
+	return m
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupConfig(scope string) *Config {
+	// searches for pattern: "scope.key"
+	viper.SetDefault(util.GetConfigPath(scope, "store"), DefaultStore)
+	viper.SetDefault(util.GetConfigPath(scope, "auto_migrate"), DefaultAutoMigrate)
+	viper.SetDefault(util.GetConfigPath(scope, "header"), DefaultHeader)
+	viper.SetDefault(util.GetConfigPath(scope, "prefix"), DefaultPrefix)
+	viper.SetDefault(util.GetConfigPath(scope, "last_used_interval_in_seconds"), DefaultLastUsedIntervalInSeconds)
+
+	return &Config{
+		Store:                     viper.GetString(util.GetConfigPath(scope, "store")),
+		AutoMigrate:               viper.GetBool(util.GetConfigPath(scope, "auto_migrate")),
+		Header:                    viper.GetString(util.GetConfigPath(scope, "header")),
+		Prefix:                    viper.GetString(util.GetConfigPath(scope, "prefix")),
+		LastUsedIntervalInSeconds: viper.GetInt(util.GetConfigPath(scope, "last_used_interval_in_seconds")),
+	}
+}
+
+func (m *Module) setupLogger(scope string, p Params) *zap.Logger {
+	logger := p.Logger.Named("[" + scope + "]")
+	return logger
+}
+
+func (m *Module) setupStore(database *pgconn.Module) Store {
+	switch m.config.Store {
+	case StorePostgres:
+		if database == nil {
+			m.logger.Fatal("postgres api key store requires the pgconn module")
+		}
+		return NewPostgresStore(database.GetDB())
+	case StoreMemory:
+		return NewMemoryStore()
+	default:
+		m.logger.Warn("invalid api key store, using memory", zap.String("store", m.config.Store))
+		return NewMemoryStore()
+	}
+}
+
+func (m *Module) onStart(ctx context.Context) error {
+	m.logger.Info("Starting api key manager.")
+
+	if store, ok := m.store.(*PostgresStore); ok && m.config.AutoMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			m.logger.Error("failed to migrate api_keys table", zap.Error(err))
+		}
+	}
+
+	if viper.GetString("system.system_log_level") == "DEBUG" || viper.GetString("system.system_log_level") == "debug" {
+		m.logConfigurations()
+	}
+
+	return nil
+}
+
+func (m *Module) onStop(ctx context.Context) error {
+	m.logger.Info("Stopping api key manager.")
+	return nil
+}
+
+func (m *Module) logConfigurations() {
+	m.logger.Debug("----- API Key Configuration -----")
+	m.logger.Debug("Store", zap.String("Store", m.config.Store))
+	m.logger.Debug("AutoMigrate", zap.Bool("AutoMigrate", m.config.AutoMigrate))
+	m.logger.Debug("Header", zap.String("Header", m.config.Header))
+	m.logger.Debug("Prefix", zap.String("Prefix", m.config.Prefix))
+	m.logger.Debug("LastUsedIntervalInSeconds", zap.Int("LastUsedIntervalInSeconds", m.config.LastUsedIntervalInSeconds))
+}
+
+// Returns the key handed out and its ID. The ID is hex encoded, so it never contains the separator.
+func (m *Module) newKey() (string, string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key id: %w", err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	keyID := hex.EncodeToString(id)
+	return m.config.Prefix + "_" + keyID + "_" + hex.EncodeToString(secret), keyID, nil
+}
+
+// Returns the ID of a key, or an empty string if the key is malformed.
+func (m *Module) parseKeyID(key string) string {
+	rest := strings.TrimPrefix(key, m.config.Prefix+"_")
+	if rest == key {
+		return ""
+	}
+	id, _, found := strings.Cut(rest, "_")
+	if !found {
+		return ""
+	}
+	return id
+}
+
+// keys have 256 bits of entropy, so a fast hash is sufficient
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func (m *Module) authenticate(c echo.Context, scopes []string) error {
+	key, err := m.VerifyKey(c.Request().Context(), c.Request().Header.Get(m.config.Header))
+	if errors.Is(err, ErrInvalidKey) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing or invalid api key")
+	}
+	if err != nil {
+		m.logger.Error("failed to verify api key", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+	}
+
+	for _, scope := range scopes {
+		if !key.HasScope(scope) {
+			return echo.NewHTTPError(http.StatusForbidden, "insufficient scope").
+				SetInternal(fmt.Errorf("api key %s requires the scope %s", key.ID, scope))
+		}
+	}
+
+	c.Set(ContextKey, key)
+	return nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Generates an API key for the subject, and stores its hash.
+The key is returned once and can not be recovered, only revoked. A ttl of 0 creates a key that does not expire.
+
+	key, apiKey, err := apiKeyModule.CreateKey(ctx, user.ID, "billing service", []string{"invoices:read"}, 0)
+*/
+func (m *Module) CreateKey(ctx context.Context, subject string, name string, scopes []string, ttl time.Duration) (string, *APIKey, error) {
+	key, id, err := m.newKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	apiKey := &APIKey{
+		ID:        id,
+		Name:      name,
+		Subject:   subject,
+		Scopes:    scopes,
+		Hash:      hashKey(key),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := m.store.Create(ctx, apiKey); err != nil {
+		m.logger.Error("failed to create api key", zap.Error(err))
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+/*
+Returns the API key if it is valid, and updates its last used time.
+Returns ErrInvalidKey for malformed, unknown, revoked and expired keys.
+*/
+func (m *Module) VerifyKey(ctx context.Context, key string) (*APIKey, error) {
+	id := m.parseKeyID(key)
+	if id == "" {
+		return nil, ErrInvalidKey
+	}
+
+	apiKey, err := m.store.Get(ctx, id)
+	if errors.Is(err, ErrKeyNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(key)), []byte(apiKey.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil || apiKey.IsExpired(now) {
+		return nil, ErrInvalidKey
+	}
+
+	interval := time.Duration(m.config.LastUsedIntervalInSeconds) * time.Second
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= interval {
+		// failing to track usage does not reject the request
+		if err := m.store.UpdateLastUsed(ctx, apiKey.ID, now); err != nil {
+			m.logger.Error("failed to update api key last used time", zap.String("api_key_id", apiKey.ID), zap.Error(err))
+		} else {
+			apiKey.LastUsedAt = &now
+		}
+	}
+	return apiKey, nil
+}
+
+// Revokes an API key by ID, requests using it are rejected immediately.
+func (m *Module) RevokeKey(ctx context.Context, id string) error {
+	return m.store.Revoke(ctx, id, time.Now())
+}
+
+// Returns the API keys of the subject, including revoked and expired keys.
+func (m *Module) ListKeys(ctx context.Context, subject string) ([]*APIKey, error) {
+	return m.store.ListBySubject(ctx, subject)
+}
+
+/*
+Returns an echo middleware that authenticates requests with the API key of the configured header.
+Responds with 401 for missing or invalid keys, and with 403 if the key lacks any of the scopes.
+The key is available through apikey.FromContext(c).
+
+	e.GET("/invoices", handler, apiKeyModule.GetAPIKeyMiddleware("invoices:read"))
+*/
+func (m *Module) GetAPIKeyMiddleware(scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := m.authenticate(c, scopes); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+/*
+Returns an echo middleware accepting either an API key or a JWT.
+Requests with the API key header are authenticated as in GetAPIKeyMiddleware, others are passed to the JWT middleware.
+The scopes only apply to API keys, use the authorization middleware of the token module for JWTs.
+
+	e.GET("/invoices", handler, apiKeyModule.GetAPIKeyOrJWTMiddleware(tokenModule.GetJWTMiddleware("jwt_auth"), "invoices:read"))
+*/
+func (m *Module) GetAPIKeyOrJWTMiddleware(jwtMiddleware echo.MiddlewareFunc, scopes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withJWT := jwtMiddleware(next)
+		return func(c echo.Context) error {
+			if c.Request().Header.Get(m.config.Header) == "" {
+				return withJWT(c)
+			}
+			if err := m.authenticate(c, scopes); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Returns the store of the module.
+func (m *Module) GetStore() Store {
+	return m.store
+}
@@ -0,0 +1,207 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/servertest"
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+func TestSetupConfig(t *testing.T) {
+	scope := "apikey"
+	m := Module{scope: scope}
+
+	t.Run("TestSetupWithNoConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		m.config = m.setupConfig(scope)
+
+		assert.Equal(t, DefaultStore, m.config.Store)
+		assert.Equal(t, DefaultAutoMigrate, m.config.AutoMigrate)
+		assert.Equal(t, DefaultHeader, m.config.Header)
+		assert.Equal(t, DefaultPrefix, m.config.Prefix)
+		assert.Equal(t, DefaultLastUsedIntervalInSeconds, m.config.LastUsedIntervalInSeconds)
+	})
+
+	t.Run("TestSetupWithConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("apikey.store", "postgres")
+		viper.Set("apikey.header", "Api-Key")
+		viper.Set("apikey.prefix", "acme_live")
+		viper.Set("apikey.last_used_interval_in_seconds", 0)
+
+		m.config = m.setupConfig(scope)
+
+		assert.Equal(t, StorePostgres, m.config.Store)
+		assert.Equal(t, "Api-Key", m.config.Header)
+		assert.Equal(t, "acme_live", m.config.Prefix)
+		assert.Equal(t, 0, m.config.LastUsedIntervalInSeconds)
+	})
+}
+
+func newTestModule(prefix string) *Module {
+	return &Module{
+		config: &Config{Header: DefaultHeader, Prefix: prefix, LastUsedIntervalInSeconds: DefaultLastUsedIntervalInSeconds},
+		logger: zap.NewNop(),
+		store:  NewMemoryStore(),
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	m := newTestModule(DefaultPrefix)
+
+	key, apiKey, err := m.CreateKey(ctx, "user123", "billing service", []string{"invoices:read"}, 0)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "gg_"+apiKey.ID+"_"))
+	assert.Nil(t, apiKey.ExpiresAt)
+
+	// only the hash is stored
+	stored, err := m.store.Get(ctx, apiKey.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.Hash, strings.TrimPrefix(key, "gg_"+apiKey.ID+"_"))
+
+	t.Run("TestVerify", func(t *testing.T) {
+		verified, err := m.VerifyKey(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, "user123", verified.Subject)
+		assert.True(t, verified.HasScope("invoices:read"))
+		require.NotNil(t, verified.LastUsedAt)
+
+		// saved at most once per interval
+		firstUse := *verified.LastUsedAt
+		verified, err = m.VerifyKey(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, verified.LastUsedAt.Equal(firstUse))
+	})
+
+	t.Run("TestInvalid", func(t *testing.T) {
+		for _, invalid := range []string{
+			"",
+			"gg_",
+			"gg_" + apiKey.ID,
+			"gg_" + apiKey.ID + "_forged",
+			"xx_" + strings.TrimPrefix(key, "gg_"),
+			"gg_unknown_" + strings.TrimPrefix(key, "gg_"+apiKey.ID+"_"),
+		} {
+			_, err := m.VerifyKey(ctx, invalid)
+			assert.ErrorIs(t, err, ErrInvalidKey, invalid)
+		}
+	})
+
+	t.Run("TestExpired", func(t *testing.T) {
+		expired, _, err := m.CreateKey(ctx, "user123", "expired", nil, time.Nanosecond)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+
+		_, err = m.VerifyKey(ctx, expired)
+		assert.ErrorIs(t, err, ErrInvalidKey)
+	})
+
+	t.Run("TestRevoke", func(t *testing.T) {
+		require.NoError(t, m.RevokeKey(ctx, apiKey.ID))
+		_, err := m.VerifyKey(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidKey)
+
+		keys, err := m.ListKeys(ctx, "user123")
+		require.NoError(t, err)
+		assert.Len(t, keys, 2)
+	})
+
+	t.Run("TestPrefixWithSeparator", func(t *testing.T) {
+		m := newTestModule("acme_live")
+		key, _, err := m.CreateKey(ctx, "user123", "", nil, 0)
+		require.NoError(t, err)
+		_, err = m.VerifyKey(ctx, key)
+		assert.NoError(t, err)
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("jwt_auth.token_lookup", "header:Authorization:Bearer ")
+
+	var m *Module
+	var tokenModule *token.Module
+	h := servertest.New(t, "server",
+		token.InjectModule("jwt", "jwt_auth"),
+		InjectModule("apikey"),
+		fx.Populate(&m, &tokenModule),
+	)
+
+	e := h.Echo()
+	e.GET("/invoices", func(c echo.Context) error {
+		return c.String(http.StatusOK, FromContext(c).Subject)
+	}, m.GetAPIKeyMiddleware("invoices:read"))
+	e.GET("/reports", func(c echo.Context) error {
+		if key := FromContext(c); key != nil {
+			return c.String(http.StatusOK, "api key "+key.Subject)
+		}
+		claims, err := token.GetClaims(c)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, "jwt "+claims["sub"].(string))
+	}, m.GetAPIKeyOrJWTMiddleware(tokenModule.GetJWTMiddleware("jwt_auth"), "reports:read"))
+
+	ctx := context.Background()
+	reader, _, err := m.CreateKey(ctx, "service1", "reader", []string{"invoices:read", "reports:read"}, 0)
+	require.NoError(t, err)
+	other, _, err := m.CreateKey(ctx, "service2", "other", []string{"users:read"}, 0)
+	require.NoError(t, err)
+
+	t.Run("TestAPIKey", func(t *testing.T) {
+		res := h.Get("/invoices", servertest.WithHeader(DefaultHeader, reader))
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		assert.Equal(t, "service1", res.Body.String())
+
+		assert.Equal(t, http.StatusForbidden, h.Get("/invoices", servertest.WithHeader(DefaultHeader, other)).Code)
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/invoices", servertest.WithHeader(DefaultHeader, "gg_forged")).Code)
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/invoices").Code)
+	})
+
+	t.Run("TestAPIKeyOrJWT", func(t *testing.T) {
+		res := h.Get("/reports", servertest.WithHeader(DefaultHeader, reader))
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		assert.Equal(t, "api key service1", res.Body.String())
+
+		res = h.Get("/reports", servertest.WithJWT("jwt_auth", jwt.MapClaims{"sub": "user123"}))
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		assert.Equal(t, "jwt user123", res.Body.String())
+
+		// an invalid key is rejected, even with a valid JWT
+		res = h.Get("/reports", servertest.WithHeader(DefaultHeader, "gg_forged"), servertest.WithJWT("jwt_auth", jwt.MapClaims{"sub": "user123"}))
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+		assert.Equal(t, http.StatusForbidden, h.Get("/reports", servertest.WithHeader(DefaultHeader, other)).Code)
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/reports").Code)
+	})
+}
+
+func TestNewAPIKeyManager(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("apikey.store", "invalid")
+
+	m := NewAPIKeyManager("apikey", zap.NewNop(), nil)
+	assert.IsType(t, &MemoryStore{}, m.GetStore())
+	assert.NoError(t, m.onStop(context.Background()))
+}
@@ -0,0 +1,140 @@
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Row of the api_keys table used by PostgresStore.
+type APIKeyRecord struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	Name       string
+	Subject    string `gorm:"index"`
+	Scopes     []byte `gorm:"type:jsonb"`
+	Hash       string `gorm:"size:64"`
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+func (APIKeyRecord) TableName() string {
+	return "api_keys"
+}
+
+// Store backed by postgres through the pgconn module, for deployments with multiple instances.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Use pgconn.Module.GetDB() to obtain the database.
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Creates or updates the api_keys table.
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&APIKeyRecord{})
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	var record APIKeyRecord
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromRecord(&record)
+}
+
+func (s *PostgresStore) Create(ctx context.Context, key *APIKey) error {
+	record, err := toRecord(key)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Create(record).Error
+}
+
+func (s *PostgresStore) ListBySubject(ctx context.Context, subject string) ([]*APIKey, error) {
+	var records []APIKeyRecord
+	err := s.db.WithContext(ctx).Where("subject = ?", subject).Order("created_at").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(records))
+	for i := range records {
+		key, err := fromRecord(&records[i])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *PostgresStore) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	result := s.db.WithContext(ctx).Model(&APIKeyRecord{}).
+		Where("id = ?", id).
+		Update("revoked_at", gorm.Expr("COALESCE(revoked_at, ?)", revokedAt))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) UpdateLastUsed(ctx context.Context, id string, lastUsedAt time.Time) error {
+	return s.db.WithContext(ctx).Model(&APIKeyRecord{}).
+		Where("id = ?", id).
+		Update("last_used_at", lastUsedAt).Error
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func toRecord(key *APIKey) (*APIKeyRecord, error) {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &APIKeyRecord{
+		ID:         key.ID,
+		Name:       key.Name,
+		Subject:    key.Subject,
+		Scopes:     scopes,
+		Hash:       key.Hash,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}, nil
+}
+
+func fromRecord(record *APIKeyRecord) (*APIKey, error) {
+	key := &APIKey{
+		ID:         record.ID,
+		Name:       record.Name,
+		Subject:    record.Subject,
+		Hash:       record.Hash,
+		CreatedAt:  record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+		LastUsedAt: record.LastUsedAt,
+		RevokedAt:  record.RevokedAt,
+	}
+	if len(record.Scopes) > 0 {
+		if err := json.Unmarshal(record.Scopes, &key.Scopes); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,120 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// returned by Store.Get if the API key does not exist
+var ErrKeyNotFound = errors.New("api key not found")
+
+/*
+Persists API keys.
+Keys are looked up by APIKey.ID, the public part of the key, and verified against APIKey.Hash,
+so the keys can not be recovered from the store.
+*/
+type Store interface {
+	Get(ctx context.Context, id string) (*APIKey, error)
+	Create(ctx context.Context, key *APIKey) error
+	ListBySubject(ctx context.Context, subject string) ([]*APIKey, error)
+	Revoke(ctx context.Context, id string, revokedAt time.Time) error
+	UpdateLastUsed(ctx context.Context, id string, lastUsedAt time.Time) error
+}
+
+// In-memory Store, for development, tests and single instance deployments.
+type MemoryStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		keys: make(map[string]*APIKey),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key.clone(), nil
+}
+
+func (s *MemoryStore) Create(ctx context.Context, key *APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// copies are stored, so keys behave the same as with the postgres store
+	s.keys[key.ID] = key.clone()
+	return nil
+}
+
+func (s *MemoryStore) ListBySubject(ctx context.Context, subject string) ([]*APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	keys := make([]*APIKey, 0)
+	for _, key := range s.keys {
+		if key.Subject == subject {
+			keys = append(keys, key.clone())
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *MemoryStore) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if key.RevokedAt == nil {
+		key.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateLastUsed(ctx context.Context, id string, lastUsedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	key.LastUsedAt = &lastUsedAt
+	return nil
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
+
+func (k *APIKey) clone() *APIKey {
+	cloned := *k
+	cloned.Scopes = append([]string(nil), k.Scopes...)
+	cloned.ExpiresAt = copyTime(k.ExpiresAt)
+	cloned.LastUsedAt = copyTime(k.LastUsedAt)
+	cloned.RevokedAt = copyTime(k.RevokedAt)
+	return &cloned
+}
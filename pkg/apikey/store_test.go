@@ -0,0 +1,52 @@
+package apikey
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	first := &APIKey{ID: "id1", Name: "billing", Subject: "user123", Scopes: []string{"invoices:read"}, Hash: "hash1", CreatedAt: now}
+	second := &APIKey{ID: "id2", Subject: "user123", Hash: "hash2", CreatedAt: now.Add(time.Second)}
+	require.NoError(t, store.Create(ctx, first))
+	require.NoError(t, store.Create(ctx, second))
+
+	key, err := store.Get(ctx, "id1")
+	require.NoError(t, err)
+	assert.Equal(t, "billing", key.Name)
+	assert.Equal(t, []string{"invoices:read"}, key.Scopes)
+
+	// stored keys are copies
+	key.Scopes[0] = "invoices:write"
+	key, _ = store.Get(ctx, "id1")
+	assert.Equal(t, []string{"invoices:read"}, key.Scopes)
+
+	_, err = store.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	keys, err := store.ListBySubject(ctx, "user123")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "id1", keys[0].ID)
+
+	require.NoError(t, store.UpdateLastUsed(ctx, "id1", now))
+	key, _ = store.Get(ctx, "id1")
+	require.NotNil(t, key.LastUsedAt)
+	assert.True(t, key.LastUsedAt.Equal(now))
+
+	require.NoError(t, store.Revoke(ctx, "id1", now))
+	require.NoError(t, store.Revoke(ctx, "id1", now.Add(time.Hour)))
+	key, _ = store.Get(ctx, "id1")
+	require.NotNil(t, key.RevokedAt)
+	assert.True(t, key.RevokedAt.Equal(now))
+
+	assert.ErrorIs(t, store.Revoke(ctx, "unknown", now), ErrKeyNotFound)
+}
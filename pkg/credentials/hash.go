@@ -0,0 +1,148 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// Returned when the password does not match the hash.
+	ErrMismatchedPassword = errors.New("password does not match")
+	// Returned for hashes of unknown algorithms, or with malformed parameters.
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+)
+
+// Parameters of argon2id hashes, encoded in the hash so they can be changed without invalidating stored hashes.
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	saltLength  uint32
+	keyLength   uint32
+}
+
+// An argon2id hash, decoded from the PHC string format.
+type argon2Hash struct {
+	params argon2Params
+	salt   []byte
+	key    []byte
+}
+
+// upper bounds of decoded parameters, so a corrupted hash can not exhaust memory
+const (
+	maxArgon2Memory     = 4 * 1024 * 1024
+	maxArgon2Iterations = 100
+	maxArgon2KeyLength  = 1024
+)
+
+//! INTERNAL ---------------------------------------------------------------
+
+func algorithmOf(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
+
+// Encodes as "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>", the format used by the reference implementation.
+func hashArgon2(password string, params argon2Params) (string, error) {
+	salt := make([]byte, params.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.memory, params.iterations, params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2(encoded string) (*argon2Hash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("%w: argon2 version %q", ErrUnsupportedHash, parts[2])
+	}
+
+	var h argon2Hash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.params.memory, &h.params.iterations, &h.params.parallelism); err != nil {
+		return nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnsupportedHash, parts[3])
+	}
+	if h.params.memory == 0 || h.params.memory > maxArgon2Memory ||
+		h.params.iterations == 0 || h.params.iterations > maxArgon2Iterations || h.params.parallelism == 0 {
+		return nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnsupportedHash, parts[3])
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedHash, err)
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedHash, err)
+	}
+	if len(h.key) == 0 || len(h.key) > maxArgon2KeyLength {
+		return nil, fmt.Errorf("%w: argon2 key length %d", ErrUnsupportedHash, len(h.key))
+	}
+	h.params.saltLength = uint32(len(h.salt))
+	h.params.keyLength = uint32(len(h.key))
+
+	return &h, nil
+}
+
+func verifyArgon2(password string, encoded string) (*argon2Hash, error) {
+	h, err := decodeArgon2(encoded)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.params.iterations, h.params.memory, h.params.parallelism, h.params.keyLength)
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return nil, ErrMismatchedPassword
+	}
+	return h, nil
+}
+
+func hashBcrypt(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func verifyBcrypt(password string, encoded string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatchedPassword
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnsupportedHash, err)
+	}
+	return nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Compares two secrets in constant time, e.g. recovery codes or tokens stored in plain text.
+The time taken does not depend on the contents, only on the length of the secrets.
+*/
+func Equal(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,64 @@
+package credentials
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testArgon2Params = argon2Params{memory: 1024, iterations: 1, parallelism: 1, saltLength: 16, keyLength: 32}
+
+func TestArgon2(t *testing.T) {
+	encoded, err := hashArgon2("correct horse", testArgon2Params)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.Equal(t, AlgorithmArgon2id, algorithmOf(encoded))
+
+	h, err := verifyArgon2("correct horse", encoded)
+	require.NoError(t, err)
+	assert.Equal(t, testArgon2Params, h.params)
+
+	_, err = verifyArgon2("wrong horse", encoded)
+	assert.ErrorIs(t, err, ErrMismatchedPassword)
+
+	// salted, so equal passwords have different hashes
+	other, err := hashArgon2("correct horse", testArgon2Params)
+	require.NoError(t, err)
+	assert.NotEqual(t, encoded, other)
+
+	t.Run("TestMalformed", func(t *testing.T) {
+		for _, encoded := range []string{
+			"$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHQ",
+			"$argon2i$v=19$m=1024,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub",
+			"$argon2id$v=16$m=1024,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub",
+			"$argon2id$v=19$m=0,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub",
+			"$argon2id$v=19$m=1073741824,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub",
+			"$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHQ$",
+			"$argon2id$v=19$m=1024,t=1,p=1$!!!$RdescudvJCsgt3ub",
+		} {
+			_, err := verifyArgon2("password", encoded)
+			assert.ErrorIs(t, err, ErrUnsupportedHash, encoded)
+		}
+	})
+}
+
+func TestBcrypt(t *testing.T) {
+	encoded, err := hashBcrypt("correct horse", 4)
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmBcrypt, algorithmOf(encoded))
+
+	assert.NoError(t, verifyBcrypt("correct horse", encoded))
+	assert.ErrorIs(t, verifyBcrypt("wrong horse", encoded), ErrMismatchedPassword)
+	assert.ErrorIs(t, verifyBcrypt("correct horse", "$2a$04$short"), ErrUnsupportedHash)
+
+	_, err = hashBcrypt(strings.Repeat("a", 73), 4)
+	assert.Error(t, err)
+}
+
+func TestEqual(t *testing.T) {
+	assert.True(t, Equal("recovery-code", "recovery-code"))
+	assert.False(t, Equal("recovery-code", "recovery-cod3"))
+	assert.False(t, Equal("recovery-code", "recovery"))
+}
@@ -0,0 +1,341 @@
+package credentials
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/alsey89/gogetter/pkg/util"
+)
+
+// to be provided to the fx framework
+type Module struct {
+	config *Config
+	logger *zap.Logger
+	scope  string
+
+	// hash verified for unknown users, created on first use
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// injected through the fx framework
+type Params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Logger    *zap.Logger
+}
+
+// holds configurations for the module
+type Config struct {
+	// algorithm of new hashes, hashes of the other algorithm are still verified and rehashed on login
+	Algorithm string
+
+	// argon2id parameters, as recommended by RFC 9106 for memory constrained environments
+	Argon2MemoryInKiB int
+	Argon2Iterations  int
+	Argon2Parallelism int
+	Argon2SaltLength  int
+	Argon2KeyLength   int
+
+	BcryptCost int
+
+	Policy Policy
+}
+
+// hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// default values
+const (
+	DefaultAlgorithm = AlgorithmArgon2id
+
+	DefaultArgon2MemoryInKiB = 64 * 1024
+	DefaultArgon2Iterations  = 3
+	DefaultArgon2Parallelism = 4
+	DefaultArgon2SaltLength  = 16
+	DefaultArgon2KeyLength   = 32
+
+	DefaultBcryptCost = 12
+
+	DefaultMinLength        = 12
+	DefaultMaxLength        = 128
+	DefaultRequireUppercase = false
+	DefaultRequireLowercase = false
+	DefaultRequireDigit     = false
+	DefaultRequireSymbol    = false
+)
+
+// bcrypt only hashes the first 72 bytes, and rejects longer passwords
+const bcryptMaxBytes = 72
+
+//! MODULE ---------------------------------------------------------------
+
+// Provides the Module struct to the fx framework, and registers lifecycle hooks.
+func InjectModule(scope string) fx.Option {
+	return fx.Module(
+		scope,
+		fx.Provide(func(p Params) *Module {
+
+			m := &Module{scope: scope}
+			m.config = m.setupConfig(scope)
+			m.logger = m.setupLogger(scope, p)
+			m.validateConfig()
+
+			return m
+		}),
+		fx.Invoke(func(m *Module, p Params) {
+			p.Lifecycle.Append(fx.Hook{
+				OnStart: m.onStart,
+				OnStop:  m.onStop,
+			})
+		}),
+	)
+}
+
+// Instantiates new Module without using the fx framework.
+func NewCredentialsManager(scope string, logger *zap.Logger) *Module {
+	m := &Module{scope: scope}
+	m.logger = logger.Named("[" + scope + "]")
+	m.config = m.setupConfig(scope)
+	m.validateConfig()
+
+	m.onStart(context.Background())
+
+	return m
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupConfig(scope string) *Config {
+	// searches for pattern: "scope.key"
+	viper.SetDefault(util.GetConfigPath(scope, "algorithm"), DefaultAlgorithm)
+	viper.SetDefault(util.GetConfigPath(scope, "argon2_memory_in_kib"), DefaultArgon2MemoryInKiB)
+	viper.SetDefault(util.GetConfigPath(scope, "argon2_iterations"), DefaultArgon2Iterations)
+	viper.SetDefault(util.GetConfigPath(scope, "argon2_parallelism"), DefaultArgon2Parallelism)
+	viper.SetDefault(util.GetConfigPath(scope, "argon2_salt_length"), DefaultArgon2SaltLength)
+	viper.SetDefault(util.GetConfigPath(scope, "argon2_key_length"), DefaultArgon2KeyLength)
+	viper.SetDefault(util.GetConfigPath(scope, "bcrypt_cost"), DefaultBcryptCost)
+	viper.SetDefault(util.GetConfigPath(scope, "min_length"), DefaultMinLength)
+	viper.SetDefault(util.GetConfigPath(scope, "max_length"), DefaultMaxLength)
+	viper.SetDefault(util.GetConfigPath(scope, "require_uppercase"), DefaultRequireUppercase)
+	viper.SetDefault(util.GetConfigPath(scope, "require_lowercase"), DefaultRequireLowercase)
+	viper.SetDefault(util.GetConfigPath(scope, "require_digit"), DefaultRequireDigit)
+	viper.SetDefault(util.GetConfigPath(scope, "require_symbol"), DefaultRequireSymbol)
+
+	return &Config{
+		Algorithm:         viper.GetString(util.GetConfigPath(scope, "algorithm")),
+		Argon2MemoryInKiB: viper.GetInt(util.GetConfigPath(scope, "argon2_memory_in_kib")),
+		Argon2Iterations:  viper.GetInt(util.GetConfigPath(scope, "argon2_iterations")),
+		Argon2Parallelism: viper.GetInt(util.GetConfigPath(scope, "argon2_parallelism")),
+		Argon2SaltLength:  viper.GetInt(util.GetConfigPath(scope, "argon2_salt_length")),
+		Argon2KeyLength:   viper.GetInt(util.GetConfigPath(scope, "argon2_key_length")),
+		BcryptCost:        viper.GetInt(util.GetConfigPath(scope, "bcrypt_cost")),
+		Policy: Policy{
+			MinLength:        viper.GetInt(util.GetConfigPath(scope, "min_length")),
+			MaxLength:        viper.GetInt(util.GetConfigPath(scope, "max_length")),
+			RequireUppercase: viper.GetBool(util.GetConfigPath(scope, "require_uppercase")),
+			RequireLowercase: viper.GetBool(util.GetConfigPath(scope, "require_lowercase")),
+			RequireDigit:     viper.GetBool(util.GetConfigPath(scope, "require_digit")),
+			RequireSymbol:    viper.GetBool(util.GetConfigPath(scope, "require_symbol")),
+		},
+	}
+}
+
+func (m *Module) setupLogger(scope string, p Params) *zap.Logger {
+	logger := p.Logger.Named("[" + scope + "]")
+	return logger
+}
+
+// Replaces invalid values with the defaults, so passwords are never hashed with unsafe parameters.
+func (m *Module) validateConfig() {
+	if m.config.Algorithm != AlgorithmArgon2id && m.config.Algorithm != AlgorithmBcrypt {
+		m.logger.Warn("invalid password hashing algorithm, using argon2id", zap.String("algorithm", m.config.Algorithm))
+		m.config.Algorithm = DefaultAlgorithm
+	}
+	if m.config.Algorithm == AlgorithmBcrypt {
+		m.config.Policy.MaxBytes = bcryptMaxBytes
+	}
+	if m.config.BcryptCost < bcrypt.MinCost || m.config.BcryptCost > bcrypt.MaxCost {
+		m.logger.Warn("invalid bcrypt cost, using default", zap.Int("bcrypt_cost", m.config.BcryptCost))
+		m.config.BcryptCost = DefaultBcryptCost
+	}
+	if m.config.Argon2MemoryInKiB <= 0 || m.config.Argon2MemoryInKiB > maxArgon2Memory {
+		m.logger.Warn("invalid argon2 memory, using default", zap.Int("argon2_memory_in_kib", m.config.Argon2MemoryInKiB))
+		m.config.Argon2MemoryInKiB = DefaultArgon2MemoryInKiB
+	}
+	if m.config.Argon2Iterations <= 0 || m.config.Argon2Iterations > maxArgon2Iterations {
+		m.logger.Warn("invalid argon2 iterations, using default", zap.Int("argon2_iterations", m.config.Argon2Iterations))
+		m.config.Argon2Iterations = DefaultArgon2Iterations
+	}
+	if m.config.Argon2Parallelism <= 0 || m.config.Argon2Parallelism > 255 {
+		m.logger.Warn("invalid argon2 parallelism, using default", zap.Int("argon2_parallelism", m.config.Argon2Parallelism))
+		m.config.Argon2Parallelism = DefaultArgon2Parallelism
+	}
+	if m.config.Argon2SaltLength < 8 {
+		m.logger.Warn("argon2 salt length below 8 bytes, using default", zap.Int("argon2_salt_length", m.config.Argon2SaltLength))
+		m.config.Argon2SaltLength = DefaultArgon2SaltLength
+	}
+	if m.config.Argon2KeyLength < 16 || m.config.Argon2KeyLength > maxArgon2KeyLength {
+		m.logger.Warn("invalid argon2 key length, using default", zap.Int("argon2_key_length", m.config.Argon2KeyLength))
+		m.config.Argon2KeyLength = DefaultArgon2KeyLength
+	}
+}
+
+func (m *Module) onStart(ctx context.Context) error {
+	m.logger.Info("Starting credentials manager.")
+
+	if viper.GetString("system.system_log_level") == "DEBUG" || viper.GetString("system.system_log_level") == "debug" {
+		m.logConfigurations()
+	}
+
+	return nil
+}
+
+func (m *Module) onStop(ctx context.Context) error {
+	m.logger.Info("Stopping credentials manager.")
+	return nil
+}
+
+func (m *Module) logConfigurations() {
+	m.logger.Debug("----- Credentials Configuration -----")
+	m.logger.Debug("Algorithm", zap.String("Algorithm", m.config.Algorithm))
+	m.logger.Debug("Argon2MemoryInKiB", zap.Int("Argon2MemoryInKiB", m.config.Argon2MemoryInKiB))
+	m.logger.Debug("Argon2Iterations", zap.Int("Argon2Iterations", m.config.Argon2Iterations))
+	m.logger.Debug("Argon2Parallelism", zap.Int("Argon2Parallelism", m.config.Argon2Parallelism))
+	m.logger.Debug("Argon2SaltLength", zap.Int("Argon2SaltLength", m.config.Argon2SaltLength))
+	m.logger.Debug("Argon2KeyLength", zap.Int("Argon2KeyLength", m.config.Argon2KeyLength))
+	m.logger.Debug("BcryptCost", zap.Int("BcryptCost", m.config.BcryptCost))
+	m.logger.Debug("MinLength", zap.Int("MinLength", m.config.Policy.MinLength))
+	m.logger.Debug("MaxLength", zap.Int("MaxLength", m.config.Policy.MaxLength))
+	m.logger.Debug("RequireUppercase", zap.Bool("RequireUppercase", m.config.Policy.RequireUppercase))
+	m.logger.Debug("RequireLowercase", zap.Bool("RequireLowercase", m.config.Policy.RequireLowercase))
+	m.logger.Debug("RequireDigit", zap.Bool("RequireDigit", m.config.Policy.RequireDigit))
+	m.logger.Debug("RequireSymbol", zap.Bool("RequireSymbol", m.config.Policy.RequireSymbol))
+}
+
+func (m *Module) getArgon2Params() argon2Params {
+	return argon2Params{
+		memory:      uint32(m.config.Argon2MemoryInKiB),
+		iterations:  uint32(m.config.Argon2Iterations),
+		parallelism: uint8(m.config.Argon2Parallelism),
+		saltLength:  uint32(m.config.Argon2SaltLength),
+		keyLength:   uint32(m.config.Argon2KeyLength),
+	}
+}
+
+func (m *Module) getDummyHash() string {
+	m.dummyOnce.Do(func() {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			m.logger.Error("failed to generate dummy password", zap.Error(err))
+			return
+		}
+		hash, err := m.HashPassword(base64.RawStdEncoding.EncodeToString(b))
+		if err != nil {
+			m.logger.Error("failed to create dummy password hash", zap.Error(err))
+		}
+		m.dummyHash = hash
+	})
+	return m.dummyHash
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Hashes a password with the configured algorithm. The parameters are encoded in the hash,
+so it can be verified after the configuration changes. Validate new passwords with ValidatePassword first.
+Bcrypt only supports passwords up to 72 bytes, longer passwords return an error, and are rejected by ValidatePassword.
+*/
+func (m *Module) HashPassword(password string) (string, error) {
+	if m.config.Algorithm == AlgorithmBcrypt {
+		return hashBcrypt(password, m.config.BcryptCost)
+	}
+	return hashArgon2(password, m.getArgon2Params())
+}
+
+/*
+Verifies a password against a stored hash. Returns ErrMismatchedPassword if the password is wrong.
+If the hash uses another algorithm or outdated parameters, the password is hashed again with the current
+configuration and the new hash returned, which should replace the stored hash. Otherwise an empty string is returned.
+
+	rehashed, err := credentials.VerifyPassword(password, user.PasswordHash)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
+	}
+	if rehashed != "" {
+		user.PasswordHash = rehashed // save the user
+	}
+*/
+func (m *Module) VerifyPassword(password string, encoded string) (string, error) {
+	switch algorithmOf(encoded) {
+	case AlgorithmArgon2id:
+		if _, err := verifyArgon2(password, encoded); err != nil {
+			return "", err
+		}
+	case AlgorithmBcrypt:
+		if err := verifyBcrypt(password, encoded); err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrUnsupportedHash
+	}
+
+	if !m.NeedsRehash(encoded) {
+		return "", nil
+	}
+	// the login succeeded, a failed rehash is retried on the next login
+	rehashed, err := m.HashPassword(password)
+	if err != nil {
+		m.logger.Warn("failed to rehash password", zap.Error(err))
+		return "", nil
+	}
+	return rehashed, nil
+}
+
+// Reports whether a hash uses another algorithm or other parameters than configured.
+func (m *Module) NeedsRehash(encoded string) bool {
+	algorithm := algorithmOf(encoded)
+	if algorithm != m.config.Algorithm {
+		return true
+	}
+
+	if algorithm == AlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != m.config.BcryptCost
+	}
+	h, err := decodeArgon2(encoded)
+	return err != nil || h.params != m.getArgon2Params()
+}
+
+/*
+Verifies the password against a dummy hash, and always returns ErrMismatchedPassword.
+Call it when no user is found for a login, so the response time does not reveal which users exist.
+*/
+func (m *Module) DummyVerify(password string) error {
+	if _, err := m.VerifyPassword(password, m.getDummyHash()); err != nil && !errors.Is(err, ErrMismatchedPassword) {
+		m.logger.Warn("failed to verify dummy password hash", zap.Error(err))
+	}
+	return ErrMismatchedPassword
+}
+
+// Validates a new password against the configured policy, see Policy.Validate.
+func (m *Module) ValidatePassword(password string, userInputs ...string) error {
+	return m.config.Policy.Validate(password, userInputs...)
+}
+
+// Returns the configured password policy, e.g. to describe the requirements to users.
+func (m *Module) GetPolicy() Policy {
+	return m.config.Policy
+}
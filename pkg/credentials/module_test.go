@@ -0,0 +1,191 @@
+package credentials
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSetupConfig(t *testing.T) {
+	scope := "credentials"
+	m := Module{scope: scope, logger: zap.NewNop()}
+
+	t.Run("TestSetupWithNoConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		m.config = m.setupConfig(scope)
+		m.validateConfig()
+
+		assert.Equal(t, DefaultAlgorithm, m.config.Algorithm)
+		assert.Equal(t, DefaultArgon2MemoryInKiB, m.config.Argon2MemoryInKiB)
+		assert.Equal(t, DefaultArgon2Iterations, m.config.Argon2Iterations)
+		assert.Equal(t, DefaultArgon2Parallelism, m.config.Argon2Parallelism)
+		assert.Equal(t, DefaultBcryptCost, m.config.BcryptCost)
+		assert.Equal(t, DefaultMinLength, m.config.Policy.MinLength)
+		assert.Equal(t, DefaultMaxLength, m.config.Policy.MaxLength)
+	})
+
+	t.Run("TestSetupWithConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("credentials.algorithm", "bcrypt")
+		viper.Set("credentials.bcrypt_cost", 10)
+		viper.Set("credentials.min_length", 8)
+		viper.Set("credentials.require_digit", true)
+
+		m.config = m.setupConfig(scope)
+		m.validateConfig()
+
+		assert.Equal(t, AlgorithmBcrypt, m.config.Algorithm)
+		assert.Equal(t, 10, m.config.BcryptCost)
+		assert.Equal(t, 8, m.config.Policy.MinLength)
+		assert.True(t, m.config.Policy.RequireDigit)
+
+		// passwords bcrypt rejects are policy violations, instead of failing to hash
+		assert.ErrorIs(t, m.ValidatePassword(strings.Repeat("ä", 36)+"1"), ErrWeakPassword)
+		assert.NoError(t, m.ValidatePassword(strings.Repeat("ä", 35)+"1"))
+	})
+
+	t.Run("TestSetupWithInvalidConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("credentials.algorithm", "md5")
+		viper.Set("credentials.bcrypt_cost", 2)
+		viper.Set("credentials.argon2_iterations", 0)
+		viper.Set("credentials.argon2_salt_length", 4)
+
+		m.config = m.setupConfig(scope)
+		m.validateConfig()
+
+		assert.Equal(t, DefaultAlgorithm, m.config.Algorithm)
+		assert.Equal(t, DefaultBcryptCost, m.config.BcryptCost)
+		assert.Equal(t, DefaultArgon2Iterations, m.config.Argon2Iterations)
+		assert.Equal(t, DefaultArgon2SaltLength, m.config.Argon2SaltLength)
+	})
+}
+
+// cheap parameters, so tests run fast
+func newTestModule(algorithm string) *Module {
+	return &Module{
+		config: &Config{
+			Algorithm:         algorithm,
+			Argon2MemoryInKiB: 1024,
+			Argon2Iterations:  1,
+			Argon2Parallelism: 1,
+			Argon2SaltLength:  DefaultArgon2SaltLength,
+			Argon2KeyLength:   DefaultArgon2KeyLength,
+			BcryptCost:        bcrypt.MinCost,
+			Policy:            Policy{MinLength: DefaultMinLength, MaxLength: DefaultMaxLength},
+		},
+		logger: zap.NewNop(),
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmArgon2id, AlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			m := newTestModule(algorithm)
+
+			encoded, err := m.HashPassword("correct horse")
+			require.NoError(t, err)
+			assert.NotContains(t, encoded, "correct horse")
+			assert.False(t, m.NeedsRehash(encoded))
+
+			rehashed, err := m.VerifyPassword("correct horse", encoded)
+			require.NoError(t, err)
+			assert.Empty(t, rehashed)
+
+			_, err = m.VerifyPassword("wrong horse", encoded)
+			assert.ErrorIs(t, err, ErrMismatchedPassword)
+		})
+	}
+
+	t.Run("TestUnsupportedHash", func(t *testing.T) {
+		m := newTestModule(AlgorithmArgon2id)
+		_, err := m.VerifyPassword("correct horse", "5f4dcc3b5aa765d61d8327deb882cf99")
+		assert.ErrorIs(t, err, ErrUnsupportedHash)
+	})
+}
+
+func TestRehash(t *testing.T) {
+	m := newTestModule(AlgorithmArgon2id)
+	encoded, err := m.HashPassword("correct horse")
+	require.NoError(t, err)
+
+	t.Run("TestChangedParameters", func(t *testing.T) {
+		m.config.Argon2Iterations = 2
+		defer func() { m.config.Argon2Iterations = 1 }()
+
+		assert.True(t, m.NeedsRehash(encoded))
+
+		// wrong passwords are never rehashed
+		rehashed, err := m.VerifyPassword("wrong horse", encoded)
+		assert.ErrorIs(t, err, ErrMismatchedPassword)
+		assert.Empty(t, rehashed)
+
+		rehashed, err = m.VerifyPassword("correct horse", encoded)
+		require.NoError(t, err)
+		assert.True(t, strings.Contains(rehashed, "t=2"))
+		assert.False(t, m.NeedsRehash(rehashed))
+	})
+
+	t.Run("TestChangedAlgorithm", func(t *testing.T) {
+		legacy := newTestModule(AlgorithmBcrypt)
+		bcryptHash, err := legacy.HashPassword("correct horse")
+		require.NoError(t, err)
+
+		// bcrypt hashes are still verified, and migrated to argon2id
+		rehashed, err := m.VerifyPassword("correct horse", bcryptHash)
+		require.NoError(t, err)
+		assert.Equal(t, AlgorithmArgon2id, algorithmOf(rehashed))
+
+		_, err = m.VerifyPassword("correct horse", rehashed)
+		assert.NoError(t, err)
+	})
+
+	t.Run("TestChangedBcryptCost", func(t *testing.T) {
+		legacy := newTestModule(AlgorithmBcrypt)
+		bcryptHash, err := legacy.HashPassword("correct horse")
+		require.NoError(t, err)
+
+		legacy.config.BcryptCost = bcrypt.MinCost + 1
+		rehashed, err := legacy.VerifyPassword("correct horse", bcryptHash)
+		require.NoError(t, err)
+		cost, err := bcrypt.Cost([]byte(rehashed))
+		require.NoError(t, err)
+		assert.Equal(t, bcrypt.MinCost+1, cost)
+	})
+}
+
+func TestDummyVerify(t *testing.T) {
+	m := newTestModule(AlgorithmArgon2id)
+	assert.ErrorIs(t, m.DummyVerify("correct horse"), ErrMismatchedPassword)
+	assert.NotEmpty(t, m.dummyHash)
+}
+
+func TestValidatePassword(t *testing.T) {
+	m := newTestModule(AlgorithmArgon2id)
+	assert.NoError(t, m.ValidatePassword("correct horse battery"))
+	assert.ErrorIs(t, m.ValidatePassword("short"), ErrWeakPassword)
+	assert.Equal(t, DefaultMinLength, m.GetPolicy().MinLength)
+}
+
+func TestNewCredentialsManager(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("credentials.algorithm", "invalid")
+
+	m := NewCredentialsManager("credentials", zap.NewNop())
+	assert.Equal(t, AlgorithmArgon2id, m.config.Algorithm)
+	assert.NoError(t, m.onStop(context.Background()))
+}
@@ -0,0 +1,116 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Wrapped by the PolicyError returned for passwords that do not satisfy the password policy.
+var ErrWeakPassword = errors.New("password does not satisfy the password policy")
+
+// Lists every rule a password violates, e.g. to show them all on a registration form.
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrWeakPassword, strings.Join(e.Violations, ", "))
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrWeakPassword
+}
+
+// Rules new passwords have to satisfy, read from the module scope.
+type Policy struct {
+	// lengths are counted in characters
+	MinLength        int
+	MaxLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// limit in bytes, set to 72 when hashing with bcrypt, which rejects longer passwords
+	MaxBytes int
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// user inputs shorter than this are not checked, so short names do not reject most passwords
+const minUserInputLength = 4
+
+// Checks the inputs, and the local part of email addresses.
+func containsUserInput(password string, userInputs []string) bool {
+	lower := strings.ToLower(password)
+	for _, input := range userInputs {
+		candidates := []string{input}
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= minUserInputLength && strings.Contains(lower, strings.ToLower(candidate)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Validates a new password against the policy. Returns a *PolicyError, wrapping ErrWeakPassword, listing all violations.
+User inputs such as the email or username are rejected as part of the password, ignoring case.
+*/
+func (p Policy) Validate(password string, userInputs ...string) error {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters", p.MaxLength))
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		// characters outside of ASCII take up to 4 bytes each
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes", p.MaxBytes))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if containsUserInput(password, userInputs) {
+		violations = append(violations, "must not contain personal information")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package credentials
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	policy := Policy{MinLength: 12, MaxLength: 64}
+
+	assert.NoError(t, policy.Validate("correct horse battery"))
+	// lengths are counted in characters, not bytes
+	assert.NoError(t, policy.Validate("pässwörtchen"))
+
+	err := policy.Validate("short")
+	assert.ErrorIs(t, err, ErrWeakPassword)
+
+	t.Run("TestViolations", func(t *testing.T) {
+		policy := Policy{MinLength: 12, RequireUppercase: true, RequireLowercase: true, RequireDigit: true, RequireSymbol: true}
+
+		var policyError *PolicyError
+		require.True(t, errors.As(policy.Validate("abc"), &policyError))
+		assert.Equal(t, []string{
+			"must be at least 12 characters",
+			"must contain an uppercase letter",
+			"must contain a digit",
+			"must contain a symbol",
+		}, policyError.Violations)
+
+		assert.NoError(t, policy.Validate("Correct-Horse-42"))
+	})
+
+	t.Run("TestMaxLength", func(t *testing.T) {
+		assert.ErrorIs(t, policy.Validate(string(make([]byte, 65))), ErrWeakPassword)
+		assert.NoError(t, Policy{MinLength: 1}.Validate(string(make([]byte, 1000))))
+
+		// bytes are counted separately, e.g. for bcrypt
+		bcryptPolicy := Policy{MinLength: 12, MaxLength: 64, MaxBytes: 72}
+		assert.NoError(t, bcryptPolicy.Validate(strings.Repeat("ä", 36)))
+		var policyError *PolicyError
+		require.True(t, errors.As(bcryptPolicy.Validate(strings.Repeat("ä", 37)), &policyError))
+		assert.Equal(t, []string{"must be at most 72 bytes"}, policyError.Violations)
+	})
+
+	t.Run("TestUserInputs", func(t *testing.T) {
+		assert.ErrorIs(t, policy.Validate("my name is Alice Smith", "alice smith"), ErrWeakPassword)
+		// the local part of email addresses
+		assert.ErrorIs(t, policy.Validate("alice.smith-2024!", "alice.smith@example.com"), ErrWeakPassword)
+		// short inputs are ignored
+		assert.NoError(t, policy.Validate("correct horse battery", "bo", ""))
+	})
+}
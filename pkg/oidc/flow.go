@@ -0,0 +1,110 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// State of a login in progress, kept in a cookie between the login and callback requests.
+type flow struct {
+	Provider string `json:"provider"`
+	// binds the callback to the browser that started the login
+	State string `json:"state"`
+	// binds the ID token to the login
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+	// local path the user is redirected to after the login
+	ReturnTo  string `json:"return_to"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newRandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newFlow(providerName string, returnTo string, expiresAt time.Time) (*flow, error) {
+	state, err := newRandomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := newRandomString()
+	if err != nil {
+		return nil, err
+	}
+	// 43 characters, the minimum length of RFC 7636
+	codeVerifier, err := newRandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	return &flow{
+		Provider:     providerName,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ReturnTo:     returnTo,
+		ExpiresAt:    expiresAt.Unix(),
+	}, nil
+}
+
+// S256 code challenge of RFC 7636.
+func newCodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (f *flow) encode() (string, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeFlow(value string) (*flow, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var f flow
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (f *flow) isExpired(now time.Time) bool {
+	return !now.Before(time.Unix(f.ExpiresAt, 0))
+}
+
+// Accepts local paths only, so the login can not be used as an open redirect.
+func isLocalPath(path string) bool {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return false
+	}
+	// browsers strip tabs and newlines and treat backslashes as slashes, e.g. "/\t/evil.com" is followed to "//evil.com"
+	for _, r := range path {
+		if unicode.IsControl(r) || r == '\\' {
+			return false
+		}
+	}
+	// also checked once decoded, e.g. "/%09/evil.com"
+	if unescaped, err := url.PathUnescape(path); err != nil || (unescaped != path && !isLocalPath(unescaped)) {
+		return false
+	}
+	u, err := url.Parse(path)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
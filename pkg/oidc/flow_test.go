@@ -0,0 +1,55 @@
+package oidc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlow(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+	f, err := newFlow("google", "/dashboard", expiresAt)
+	require.NoError(t, err)
+
+	assert.Len(t, f.CodeVerifier, 43)
+	assert.NotEqual(t, f.State, f.Nonce)
+
+	value, err := f.encode()
+	require.NoError(t, err)
+	decoded, err := decodeFlow(value)
+	require.NoError(t, err)
+	assert.Equal(t, f, decoded)
+
+	assert.False(t, decoded.isExpired(time.Now()))
+	assert.True(t, decoded.isExpired(expiresAt.Add(time.Second)))
+
+	_, err = decodeFlow("not a flow")
+	assert.Error(t, err)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// example of RFC 7636, appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", newCodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestIsLocalPath(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"/":                       true,
+		"/dashboard?tab=settings": true,
+		"":                        false,
+		"dashboard":               false,
+		"//evil.com":              false,
+		"/\\evil.com":             false,
+		"https://evil.com":        false,
+		"/path\r\nSet-Cookie: a":  false,
+		"/\t/evil.com":            false,
+		"/%09/evil.com":           false,
+		"/%2509/evil.com":         false,
+		"/\\/evil.com":            false,
+		"/%5C/evil.com":           false,
+	} {
+		assert.Equal(t, expected, isLocalPath(path), path)
+	}
+}
@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// An external identity, read from the validated ID token of a provider.
+type Identity struct {
+	// name of the provider scope, e.g. "google"
+	Provider string
+	// "iss" and "sub" of the ID token, which together identify the external account
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// all claims of the ID token
+	Claims jwt.MapClaims
+}
+
+/*
+Maps an external identity to a local user, e.g. by looking up or creating the user linked to the issuer and subject.
+Returns the claims of the JWT issued by the token module, which should include the local user ID as "sub".
+*/
+type IdentityResolver interface {
+	ResolveIdentity(ctx context.Context, identity *Identity) (jwt.MapClaims, error)
+}
+
+// Adapts a function to the IdentityResolver interface.
+type IdentityResolverFunc func(ctx context.Context, identity *Identity) (jwt.MapClaims, error)
+
+func (f IdentityResolverFunc) ResolveIdentity(ctx context.Context, identity *Identity) (jwt.MapClaims, error) {
+	return f(ctx, identity)
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newIdentity(providerName string, claims jwt.MapClaims) *Identity {
+	identity := &Identity{Provider: providerName, Claims: claims}
+	identity.Issuer, _ = claims.GetIssuer()
+	identity.Subject, _ = claims.GetSubject()
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	return identity
+}
+
+/*
+Used without a resolver, e.g. during development. Users are identified by the provider and external subject,
+so accounts of different providers are never linked through unverified email addresses.
+*/
+func resolveExternalIdentity(ctx context.Context, identity *Identity) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{"sub": identity.Provider + ":" + identity.Subject}
+	if identity.Email != "" {
+		claims["email"] = identity.Email
+		claims["email_verified"] = identity.EmailVerified
+	}
+	if identity.Name != "" {
+		claims["name"] = identity.Name
+	}
+	return claims, nil
+}
@@ -0,0 +1,429 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/token"
+	"github.com/alsey89/gogetter/pkg/util"
+)
+
+// to be provided to the fx framework
+type Module struct {
+	config    *Config
+	logger    *zap.Logger
+	scope     string
+	providers map[string]*provider
+	token     *token.Module
+	resolver  IdentityResolver
+}
+
+// injected through the fx framework
+type Params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Logger    *zap.Logger
+	// issues the JWT after a successful login
+	Token *token.Module
+	// maps external identities to local users if provided
+	Resolver IdentityResolver `optional:"true"`
+}
+
+// holds the module wide configuration, read from the module scope
+type Config struct {
+	// token scope of the JWT issued after a successful login
+	TokenScope string
+
+	// cookie holding the state of logins in progress
+	CookieName   string
+	CookieDomain string
+	CookieSecure bool
+
+	FlowTimeoutInMinutes int
+	// local path users are redirected to after the login, unless the login was started with return_to
+	SuccessRedirectURL string
+	// tolerated clock skew when validating ID tokens
+	LeewayInSeconds int
+}
+
+// default values
+const (
+	DefaultTokenScope = "jwt_auth"
+
+	DefaultCookieName   = "oidc_flow"
+	DefaultCookieDomain = ""
+	DefaultCookieSecure = false
+
+	DefaultFlowTimeoutInMinutes = 10
+	DefaultSuccessRedirectURL   = "/"
+	DefaultLeewayInSeconds      = 30
+
+	DefaultProviderScopes = "openid email profile"
+)
+
+// timeout of requests to the providers
+const providerTimeout = 10 * time.Second
+
+//! MODULE ---------------------------------------------------------------
+
+/*
+Provides the Module struct to the fx framework, and registers lifecycle hooks.
+Takes the provider scopes as variadic parameter, each holding the configuration of an OIDC provider.
+Requires the token module, which issues the JWT after a successful login.
+*/
+func InjectModule(scope string, providers ...string) fx.Option {
+	return fx.Module(
+		scope,
+		fx.Provide(func(p Params) *Module {
+
+			m := &Module{scope: scope, token: p.Token, resolver: p.Resolver}
+			m.config = m.setupConfig(scope)
+			m.logger = m.setupLogger(scope, p)
+			m.providers = m.setupProviders(providers...)
+
+			return m
+		}),
+		fx.Invoke(func(m *Module, p Params) {
+			p.Lifecycle.Append(fx.Hook{
+				OnStart: m.onStart,
+				OnStop:  m.onStop,
+			})
+		}),
+	)
+}
+
+// Instantiates new Module without using the fx framework.
+func NewOIDCManager(scope string, logger *zap.Logger, tokenModule *token.Module, providers ...string) *Module {
+	m := &Module{scope: scope, token: tokenModule}
+	m.logger = logger.Named("[" + scope + "]")
+	m.config = m.setupConfig(scope)
+	m.providers = m.setupProviders(providers...)
+
+	m.onStart(context.Background())
+
+	return m
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupConfig(scope string) *Config {
+	// searches for pattern: "scope.key"
+	viper.SetDefault(util.GetConfigPath(scope, "token_scope"), DefaultTokenScope)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_name"), DefaultCookieName)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_domain"), DefaultCookieDomain)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_secure"), DefaultCookieSecure)
+	viper.SetDefault(util.GetConfigPath(scope, "flow_timeout_in_minutes"), DefaultFlowTimeoutInMinutes)
+	viper.SetDefault(util.GetConfigPath(scope, "success_redirect_url"), DefaultSuccessRedirectURL)
+	viper.SetDefault(util.GetConfigPath(scope, "leeway_in_seconds"), DefaultLeewayInSeconds)
+
+	return &Config{
+		TokenScope:           viper.GetString(util.GetConfigPath(scope, "token_scope")),
+		CookieName:           viper.GetString(util.GetConfigPath(scope, "cookie_name")),
+		CookieDomain:         viper.GetString(util.GetConfigPath(scope, "cookie_domain")),
+		CookieSecure:         viper.GetBool(util.GetConfigPath(scope, "cookie_secure")),
+		FlowTimeoutInMinutes: viper.GetInt(util.GetConfigPath(scope, "flow_timeout_in_minutes")),
+		SuccessRedirectURL:   viper.GetString(util.GetConfigPath(scope, "success_redirect_url")),
+		LeewayInSeconds:      viper.GetInt(util.GetConfigPath(scope, "leeway_in_seconds")),
+	}
+}
+
+func (m *Module) setupLogger(scope string, p Params) *zap.Logger {
+	logger := p.Logger.Named("[" + scope + "]")
+	return logger
+}
+
+func (m *Module) setupProviders(providers ...string) map[string]*provider {
+	client := &http.Client{Timeout: providerTimeout}
+	configured := make(map[string]*provider)
+
+	for _, name := range providers {
+		viper.SetDefault(util.GetConfigPath(name, "scopes"), DefaultProviderScopes)
+
+		config := &ProviderConfig{
+			Issuer:       viper.GetString(util.GetConfigPath(name, "issuer")),
+			ClientID:     viper.GetString(util.GetConfigPath(name, "client_id")),
+			ClientSecret: viper.GetString(util.GetConfigPath(name, "client_secret")),
+			RedirectURL:  viper.GetString(util.GetConfigPath(name, "redirect_url")),
+			Scopes:       strings.Fields(viper.GetString(util.GetConfigPath(name, "scopes"))),
+		}
+		// ID tokens are only issued for the openid scope
+		if !contains(config.Scopes, "openid") {
+			config.Scopes = append([]string{"openid"}, config.Scopes...)
+		}
+		if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+			m.logger.Error("OIDC provider requires issuer, client_id and redirect_url", zap.String("Provider", name))
+		}
+
+		configured[name] = newProvider(name, config, client)
+	}
+	return configured
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Module) onStart(ctx context.Context) error {
+	m.logger.Info("Starting OIDC manager.")
+
+	if len(m.providers) == 0 {
+		m.logger.Warn("No OIDC providers configured.")
+	}
+	if m.resolver == nil {
+		m.logger.Warn("No identity resolver provided, tokens are issued for the external identities.")
+	}
+
+	if viper.GetString("system.system_log_level") == "DEBUG" || viper.GetString("system.system_log_level") == "debug" {
+		m.logConfigurations()
+	}
+
+	return nil
+}
+
+func (m *Module) onStop(ctx context.Context) error {
+	m.logger.Info("Stopping OIDC manager.")
+	return nil
+}
+
+func (m *Module) logConfigurations() {
+	m.logger.Debug("----- OIDC Configuration -----")
+	m.logger.Debug("TokenScope", zap.String("TokenScope", m.config.TokenScope))
+	m.logger.Debug("CookieName", zap.String("CookieName", m.config.CookieName))
+	m.logger.Debug("CookieDomain", zap.String("CookieDomain", m.config.CookieDomain))
+	m.logger.Debug("CookieSecure", zap.Bool("CookieSecure", m.config.CookieSecure))
+	m.logger.Debug("FlowTimeoutInMinutes", zap.Int("FlowTimeoutInMinutes", m.config.FlowTimeoutInMinutes))
+	m.logger.Debug("SuccessRedirectURL", zap.String("SuccessRedirectURL", m.config.SuccessRedirectURL))
+	m.logger.Debug("LeewayInSeconds", zap.Int("LeewayInSeconds", m.config.LeewayInSeconds))
+	for name, p := range m.providers {
+		m.logger.Debug("----- OIDC Provider -----")
+		m.logger.Debug("Provider", zap.String("Provider", name))
+		m.logger.Debug("Issuer", zap.String("Issuer", p.config.Issuer))
+		m.logger.Debug("ClientID", zap.String("ClientID", p.config.ClientID))
+		m.logger.Debug("RedirectURL", zap.String("RedirectURL", p.config.RedirectURL))
+		m.logger.Debug("Scopes", zap.Strings("Scopes", p.config.Scopes))
+	}
+}
+
+func (m *Module) getProvider(name string) (*provider, error) {
+	p, ok := m.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("OIDC provider %s not found", name)
+	}
+	return p, nil
+}
+
+// SameSite=Lax, so the cookie is sent with the top-level redirect back from the provider.
+func (m *Module) setFlowCookie(c echo.Context, value string, expires time.Time, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Path:     "/",
+		Domain:   m.config.CookieDomain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (m *Module) clearFlowCookie(c echo.Context) {
+	m.setFlowCookie(c, "", time.Unix(0, 0), -1)
+}
+
+// Reads and clears the flow cookie, so a callback can only be completed once.
+func (m *Module) loadFlow(c echo.Context, providerName string) (*flow, error) {
+	cookie, err := c.Cookie(m.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, errors.New("no login in progress")
+	}
+	m.clearFlowCookie(c)
+
+	f, err := decodeFlow(cookie.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid flow cookie: %w", err)
+	}
+	if f.Provider != providerName {
+		return nil, fmt.Errorf("login was started with provider %s", f.Provider)
+	}
+	if f.isExpired(time.Now()) {
+		return nil, errors.New("login expired")
+	}
+	state := c.QueryParam("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(f.State)) != 1 {
+		return nil, errors.New("state mismatch")
+	}
+	return f, nil
+}
+
+func (m *Module) resolveIdentity(ctx context.Context, identity *Identity) (jwt.MapClaims, error) {
+	if m.resolver == nil {
+		return resolveExternalIdentity(ctx, identity)
+	}
+	return m.resolver.ResolveIdentity(ctx, identity)
+}
+
+// Issues the JWT of the token module, as a cookie if the token scope reads tokens from a cookie.
+func (m *Module) completeLogin(c echo.Context, claims jwt.MapClaims, returnTo string) error {
+	signed, err := m.token.GenerateToken(m.config.TokenScope, claims)
+	if err != nil {
+		m.logger.Error("failed to generate token", zap.Error(err))
+		return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+	}
+
+	err = m.token.SetTokenCookie(c, m.config.TokenScope, *signed)
+	if errors.Is(err, token.ErrNoTokenCookie) {
+		return c.JSON(http.StatusOK, map[string]string{"access_token": *signed, "token_type": "Bearer"})
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+	}
+
+	// checked again, the flow cookie is not signed
+	if !isLocalPath(returnTo) {
+		returnTo = m.config.SuccessRedirectURL
+	}
+	return c.Redirect(http.StatusFound, returnTo)
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Replaces the identity resolver, e.g. to use NewOIDCManager with a resolver.
+func (m *Module) SetIdentityResolver(resolver IdentityResolver) {
+	m.resolver = resolver
+}
+
+/*
+Returns an echo handler starting the login with a provider: the state, nonce and PKCE code verifier are stored
+in a cookie, and the user is redirected to the provider. The optional return_to query parameter sets the local path
+the user is redirected to after the login.
+*/
+func (m *Module) GetLoginHandler(providerName string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, err := m.getProvider(providerName)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound).SetInternal(err)
+		}
+
+		metadata, _, err := p.discover(c.Request().Context())
+		if err != nil {
+			m.logger.Error("OIDC discovery failed", zap.String("Provider", providerName), zap.Error(err))
+			return echo.NewHTTPError(http.StatusBadGateway, "identity provider unavailable").SetInternal(err)
+		}
+
+		returnTo := c.QueryParam("return_to")
+		if !isLocalPath(returnTo) {
+			returnTo = ""
+		}
+
+		timeout := time.Duration(m.config.FlowTimeoutInMinutes) * time.Minute
+		expiresAt := time.Now().Add(timeout)
+		f, err := newFlow(providerName, returnTo, expiresAt)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+		}
+		value, err := f.encode()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+		}
+		authorizationURL, err := p.authorizationURL(metadata, f)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+		}
+
+		m.setFlowCookie(c, value, expiresAt, int(timeout.Seconds()))
+		return c.Redirect(http.StatusFound, authorizationURL)
+	}
+}
+
+/*
+Returns an echo handler completing the login with a provider, at the redirect_url registered with the provider.
+The code is exchanged for the ID token, which is validated and mapped to a local user by the identity resolver.
+The JWT of token_scope is then set as a cookie and the user redirected, or returned as JSON if the scope does not read a cookie.
+*/
+func (m *Module) GetCallbackHandler(providerName string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		p, err := m.getProvider(providerName)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound).SetInternal(err)
+		}
+
+		// e.g. the user denied access
+		if providerError := c.QueryParam("error"); providerError != "" {
+			m.clearFlowCookie(c)
+			return echo.NewHTTPError(http.StatusUnauthorized, "login failed").
+				SetInternal(fmt.Errorf("%s: %s", providerError, c.QueryParam("error_description")))
+		}
+
+		f, err := m.loadFlow(c, providerName)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid login state").SetInternal(err)
+		}
+
+		ctx := c.Request().Context()
+		metadata, keys, err := p.discover(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadGateway, "identity provider unavailable").SetInternal(err)
+		}
+
+		tokens, err := p.exchange(ctx, metadata, c.QueryParam("code"), f.CodeVerifier)
+		if err != nil {
+			m.logger.Warn("OIDC code exchange failed", zap.String("Provider", providerName), zap.Error(err))
+			return echo.NewHTTPError(http.StatusUnauthorized, "login failed").SetInternal(err)
+		}
+
+		leeway := time.Duration(m.config.LeewayInSeconds) * time.Second
+		claims, err := p.verifyIDToken(ctx, metadata, keys, tokens.IDToken, f.Nonce, leeway)
+		if err != nil {
+			m.logger.Warn("OIDC ID token rejected", zap.String("Provider", providerName), zap.Error(err))
+			return echo.NewHTTPError(http.StatusUnauthorized, "login failed").SetInternal(err)
+		}
+
+		identity := newIdentity(providerName, claims)
+		localClaims, err := m.resolveIdentity(ctx, identity)
+		if err != nil {
+			// resolvers may deny the login, e.g. with 403 for disabled accounts
+			var httpError *echo.HTTPError
+			if errors.As(err, &httpError) {
+				return httpError
+			}
+			m.logger.Error("failed to resolve identity", zap.String("Provider", providerName), zap.Error(err))
+			return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+		}
+
+		m.logger.Info("OIDC login", zap.String("Provider", providerName), zap.String("subject", identity.Subject))
+		return m.completeLogin(c, localClaims, f.ReturnTo)
+	}
+}
+
+/*
+Registers the login and callback routes of every provider on a group.
+
+	GET /<provider>/login       starts the login, with an optional return_to path
+	GET /<provider>/callback    the redirect_url to register with the provider
+
+	oidcModule.RegisterRoutes(server.GetServer().Group("/auth/oidc"))
+*/
+func (m *Module) RegisterRoutes(group *echo.Group) {
+	for name := range m.providers {
+		group.GET("/"+name+"/login", m.GetLoginHandler(name))
+		group.GET("/"+name+"/callback", m.GetCallbackHandler(name))
+	}
+}
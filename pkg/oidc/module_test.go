@@ -0,0 +1,252 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/oidc/oidctest"
+	"github.com/alsey89/gogetter/pkg/servertest"
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+func TestSetupConfig(t *testing.T) {
+	scope := "oidc"
+	m := Module{scope: scope, logger: zap.NewNop()}
+
+	t.Run("TestSetupWithNoConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		m.config = m.setupConfig(scope)
+
+		assert.Equal(t, DefaultTokenScope, m.config.TokenScope)
+		assert.Equal(t, DefaultCookieName, m.config.CookieName)
+		assert.Equal(t, DefaultCookieSecure, m.config.CookieSecure)
+		assert.Equal(t, DefaultFlowTimeoutInMinutes, m.config.FlowTimeoutInMinutes)
+		assert.Equal(t, DefaultSuccessRedirectURL, m.config.SuccessRedirectURL)
+		assert.Equal(t, DefaultLeewayInSeconds, m.config.LeewayInSeconds)
+	})
+
+	t.Run("TestSetupWithConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("oidc.token_scope", "jwt_session")
+		viper.Set("oidc.cookie_secure", true)
+		viper.Set("oidc.success_redirect_url", "/app")
+
+		m.config = m.setupConfig(scope)
+
+		assert.Equal(t, "jwt_session", m.config.TokenScope)
+		assert.True(t, m.config.CookieSecure)
+		assert.Equal(t, "/app", m.config.SuccessRedirectURL)
+	})
+
+	t.Run("TestSetupProviders", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("google.issuer", "https://accounts.google.com")
+		viper.Set("google.client_id", "client")
+		viper.Set("google.redirect_url", "https://example.com/auth/oidc/google/callback")
+		viper.Set("azure.scopes", "email profile")
+
+		providers := m.setupProviders("google", "azure")
+
+		assert.Equal(t, "https://accounts.google.com", providers["google"].config.Issuer)
+		assert.Equal(t, []string{"openid", "email", "profile"}, providers["google"].config.Scopes)
+		// openid is always requested
+		assert.Equal(t, []string{"openid", "email", "profile"}, providers["azure"].config.Scopes)
+	})
+}
+
+// Starts an app with the token and OIDC modules, logging in with the fake provider.
+func newTestApp(t *testing.T, fake *oidctest.Provider, opts ...fx.Option) (*servertest.Harness, *Module, *token.Module) {
+	viper.Set("fake.issuer", fake.Issuer())
+	viper.Set("fake.client_id", fake.ClientID)
+	viper.Set("fake.client_secret", fake.ClientSecret)
+	viper.Set("fake.redirect_url", "http://example.com/auth/oidc/fake/callback")
+
+	var m *Module
+	var tokenModule *token.Module
+	options := []fx.Option{
+		token.InjectModule("jwt", "jwt_auth"),
+		InjectModule("oidc", "fake"),
+		fx.Populate(&m, &tokenModule),
+	}
+	h := servertest.New(t, "server", append(options, opts...)...)
+	m.RegisterRoutes(h.Echo().Group("/auth/oidc"))
+
+	return h, m, tokenModule
+}
+
+// Starts the login, and returns the path the provider redirects back to.
+func login(t *testing.T, h *servertest.Harness, fake *oidctest.Provider, path string) string {
+	res := h.Get(path)
+	require.Equal(t, http.StatusFound, res.Code, res.String())
+	require.NotNil(t, h.Cookie(DefaultCookieName))
+
+	location := res.Header().Get(echo.HeaderLocation)
+	require.True(t, strings.HasPrefix(location, fake.Issuer()), location)
+
+	callback, err := fake.Authorize(location)
+	require.NoError(t, err)
+	return callback.RequestURI()
+}
+
+func TestLogin(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	fake := oidctest.NewProvider(t, "client", "secret")
+	h, _, tokenModule := newTestApp(t, fake)
+
+	callback := login(t, h, fake, "/auth/oidc/fake/login?return_to=/dashboard")
+	res := h.Get(callback)
+	require.Equal(t, http.StatusFound, res.Code, res.String())
+	assert.Equal(t, "/dashboard", res.Header().Get(echo.HeaderLocation))
+	assert.Nil(t, h.Cookie(DefaultCookieName))
+
+	cookie := h.Cookie("jwt")
+	require.NotNil(t, cookie)
+	parsed, err := tokenModule.ParseToken("jwt_auth", cookie.Value)
+	require.NoError(t, err)
+	claims := parsed.Claims.(jwt.MapClaims)
+	assert.Equal(t, "fake:"+oidctest.DefaultSubject, claims["sub"])
+	assert.Equal(t, oidctest.DefaultEmail, claims["email"])
+
+	t.Run("TestReplay", func(t *testing.T) {
+		res := h.Get(callback)
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+	})
+
+	t.Run("TestOpenRedirect", func(t *testing.T) {
+		res := h.Get(login(t, h, fake, "/auth/oidc/fake/login?return_to=//evil.com"))
+		require.Equal(t, http.StatusFound, res.Code, res.String())
+		assert.Equal(t, DefaultSuccessRedirectURL, res.Header().Get(echo.HeaderLocation))
+	})
+
+	t.Run("TestForgedFlowCookie", func(t *testing.T) {
+		callback := login(t, h, fake, "/auth/oidc/fake/login?return_to=/dashboard")
+
+		// the flow cookie is not signed, so its return_to is checked again
+		cookie := h.Cookie(DefaultCookieName)
+		f, err := decodeFlow(cookie.Value)
+		require.NoError(t, err)
+		f.ReturnTo = "/\t/evil.com"
+		cookie.Value, err = f.encode()
+		require.NoError(t, err)
+		h.SetCookie(cookie)
+
+		res := h.Get(callback)
+		require.Equal(t, http.StatusFound, res.Code, res.String())
+		assert.Equal(t, DefaultSuccessRedirectURL, res.Header().Get(echo.HeaderLocation))
+	})
+
+	t.Run("TestInvalidState", func(t *testing.T) {
+		callback := login(t, h, fake, "/auth/oidc/fake/login")
+		callback = strings.Replace(callback, "state=", "state=forged", 1)
+		assert.Equal(t, http.StatusUnauthorized, h.Get(callback).Code)
+	})
+
+	t.Run("TestMissingFlowCookie", func(t *testing.T) {
+		callback := login(t, h, fake, "/auth/oidc/fake/login")
+		h.ClearSession()
+		assert.Equal(t, http.StatusUnauthorized, h.Get(callback).Code)
+	})
+
+	t.Run("TestProviderError", func(t *testing.T) {
+		login(t, h, fake, "/auth/oidc/fake/login")
+		res := h.Get("/auth/oidc/fake/callback?error=access_denied")
+		assert.Equal(t, http.StatusUnauthorized, res.Code)
+		assert.Nil(t, h.Cookie(DefaultCookieName))
+	})
+
+	t.Run("TestInvalidIDToken", func(t *testing.T) {
+		defer fake.ResetClaims()
+
+		fake.SetClaims(jwt.MapClaims{"nonce": "forged"})
+		assert.Equal(t, http.StatusUnauthorized, h.Get(login(t, h, fake, "/auth/oidc/fake/login")).Code)
+
+		fake.ResetClaims()
+		fake.SetClaims(jwt.MapClaims{"aud": "other client"})
+		assert.Equal(t, http.StatusUnauthorized, h.Get(login(t, h, fake, "/auth/oidc/fake/login")).Code)
+	})
+
+	t.Run("TestUnknownProvider", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, h.Get("/auth/oidc/other/login").Code)
+	})
+}
+
+func TestIdentityResolver(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	fake := oidctest.NewProvider(t, "client", "")
+
+	resolver := IdentityResolverFunc(func(ctx context.Context, identity *Identity) (jwt.MapClaims, error) {
+		if identity.Email == "blocked@example.com" {
+			return nil, echo.NewHTTPError(http.StatusForbidden, "account disabled")
+		}
+		assert.Equal(t, "fake", identity.Provider)
+		assert.Equal(t, fake.Issuer(), identity.Issuer)
+		assert.True(t, identity.EmailVerified)
+		return jwt.MapClaims{"sub": "user42", "role": "admin"}, nil
+	})
+	h, _, tokenModule := newTestApp(t, fake, fx.Supply(fx.Annotate(resolver, fx.As(new(IdentityResolver)))))
+
+	res := h.Get(login(t, h, fake, "/auth/oidc/fake/login"))
+	require.Equal(t, http.StatusFound, res.Code, res.String())
+	assert.Equal(t, DefaultSuccessRedirectURL, res.Header().Get(echo.HeaderLocation))
+
+	parsed, err := tokenModule.ParseToken("jwt_auth", h.Cookie("jwt").Value)
+	require.NoError(t, err)
+	assert.Equal(t, "user42", parsed.Claims.(jwt.MapClaims)["sub"])
+	assert.Equal(t, "admin", parsed.Claims.(jwt.MapClaims)["role"])
+
+	t.Run("TestDenied", func(t *testing.T) {
+		defer fake.ResetClaims()
+		fake.SetClaims(jwt.MapClaims{"email": "blocked@example.com"})
+		assert.Equal(t, http.StatusForbidden, h.Get(login(t, h, fake, "/auth/oidc/fake/login")).Code)
+	})
+}
+
+func TestTokenResponse(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	// tokens of scopes read from a header are returned instead of set as cookie
+	viper.Set("jwt_auth.token_lookup", "header:Authorization:Bearer ")
+
+	fake := oidctest.NewProvider(t, "client", "secret")
+	h, _, tokenModule := newTestApp(t, fake)
+
+	res := h.Get(login(t, h, fake, "/auth/oidc/fake/login"))
+	require.Equal(t, http.StatusOK, res.Code, res.String())
+
+	var body map[string]string
+	res.DecodeJSON(&body)
+	assert.Equal(t, "Bearer", body["token_type"])
+	_, err := tokenModule.ParseToken("jwt_auth", body["access_token"])
+	assert.NoError(t, err)
+}
+
+func TestNewOIDCManager(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	tokenModule := token.NewTokenManager("jwt", zap.NewNop(), "jwt_auth")
+	m := NewOIDCManager("oidc", zap.NewNop(), tokenModule, "google")
+	assert.Contains(t, m.providers, "google")
+	assert.NoError(t, m.onStop(context.Background()))
+}
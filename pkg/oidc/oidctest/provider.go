@@ -0,0 +1,328 @@
+/*
+Package oidctest runs a local OpenID Connect provider for tests.
+It serves discovery, authorization, token and JWKS endpoints with httptest, and signs ID tokens with a generated RSA key.
+Every authorization request is approved without user interaction.
+*/
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+// Endpoint paths of the provider, relative to the issuer.
+const (
+	DiscoveryPath     = "/.well-known/openid-configuration"
+	AuthorizationPath = "/authorize"
+	TokenPath         = "/token"
+	JWKSPath          = "/jwks"
+)
+
+// Claims of the ID tokens issued by default, besides iss, aud, exp, iat and nonce.
+const (
+	DefaultSubject = "external123"
+	DefaultEmail   = "user@example.com"
+	DefaultName    = "Test User"
+)
+
+// kid of the signing key
+const keyID = "oidctest"
+
+// A local OIDC provider, with a single registered client.
+type Provider struct {
+	t      testing.TB
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	ClientID     string
+	ClientSecret string
+
+	mutex  sync.Mutex
+	codes  map[string]authorization
+	claims jwt.MapClaims
+}
+
+// An authorization request, redeemable once with its code.
+type authorization struct {
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Starts a provider for the client, which is closed when the test ends.
+Leave the client secret empty to register a public client.
+
+	provider := oidctest.NewProvider(t, "client", "secret")
+	viper.Set("google.issuer", provider.Issuer())
+*/
+func NewProvider(t testing.TB, clientID string, clientSecret string) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("oidctest: failed to generate key: %v", err)
+	}
+
+	p := &Provider{
+		t:            t,
+		key:          key,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        make(map[string]authorization),
+		claims:       make(jwt.MapClaims),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(DiscoveryPath, p.handleDiscovery)
+	mux.HandleFunc(AuthorizationPath, p.handleAuthorization)
+	mux.HandleFunc(TokenPath, p.handleToken)
+	mux.HandleFunc(JWKSPath, p.handleJWKS)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// Returns the issuer URL, which serves the discovery document.
+func (p *Provider) Issuer() string {
+	return p.server.URL
+}
+
+/*
+Sets claims of the following ID tokens, overriding the defaults, e.g. to issue tokens for another subject,
+or with an invalid audience or nonce. A nil value removes the claim.
+*/
+func (p *Provider) SetClaims(claims jwt.MapClaims) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for key, value := range claims {
+		p.claims[key] = value
+	}
+}
+
+// Restores the default claims of the following ID tokens.
+func (p *Provider) ResetClaims() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.claims = make(jwt.MapClaims)
+}
+
+/*
+Follows the redirect of a login handler to the provider, and returns the URL the provider redirects back to,
+holding the code and state for the callback handler.
+*/
+func (p *Provider) Authorize(authorizationURL string) (*url.URL, error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authorizationURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		return nil, fmt.Errorf("oidctest: authorization failed with status %d", res.StatusCode)
+	}
+	return res.Location()
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (p *Provider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + AuthorizationPath,
+		"token_endpoint":                        p.Issuer() + TokenPath,
+		"jwks_uri":                              p.Issuer() + JWKSPath,
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// Approves the request, and redirects back with a code.
+func (p *Provider) handleAuthorization(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString(p.t)
+	p.mutex.Lock()
+	p.codes[code] = authorization{
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+	}
+	p.mutex.Unlock()
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	redirectURI.RawQuery = callback.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// Redeems a code for an ID token, after authenticating the client and checking the PKCE code verifier.
+func (p *Provider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if !p.authenticateClient(r) {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	// codes are single use
+	code := r.PostForm.Get("code")
+	p.mutex.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mutex.Unlock()
+
+	if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	hash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(hash[:]) != auth.codeChallenge {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := p.signIDToken(auth.nonce)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(p.t),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// Accepts client_secret_basic for confidential clients, and the client_id for public clients.
+func (p *Provider) authenticateClient(r *http.Request) bool {
+	if p.ClientSecret == "" {
+		return r.PostForm.Get("client_id") == p.ClientID
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	clientID, err := url.QueryUnescape(username)
+	if err != nil {
+		return false
+	}
+	clientSecret, err := url.QueryUnescape(password)
+	if err != nil {
+		return false
+	}
+	return clientID == p.ClientID && subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) == 1
+}
+
+func (p *Provider) signIDToken(nonce string) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer(),
+		"sub":            DefaultSubject,
+		"aud":            p.ClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"email":          DefaultEmail,
+		"email_verified": true,
+		"name":           DefaultName,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	p.mutex.Lock()
+	for key, value := range p.claims {
+		if value == nil {
+			delete(claims, key)
+			continue
+		}
+		claims[key] = value
+	}
+	p.mutex.Unlock()
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+	return idToken.SignedString(p.key)
+}
+
+func (p *Provider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, token.JWKS{Keys: []token.JWK{{
+		Kty: "RSA",
+		Kid: keyID,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+func randomString(t testing.TB) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("oidctest: failed to generate random value: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
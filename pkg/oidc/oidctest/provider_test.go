@@ -0,0 +1,56 @@
+package oidctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider(t *testing.T) {
+	p := NewProvider(t, "client", "secret")
+
+	res, err := http.Get(p.Issuer() + DiscoveryPath)
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	var metadata map[string]interface{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&metadata))
+	assert.Equal(t, p.Issuer(), metadata["issuer"])
+	assert.Equal(t, p.Issuer()+TokenPath, metadata["token_endpoint"])
+
+	t.Run("TestAuthorize", func(t *testing.T) {
+		query := url.Values{
+			"client_id":             {"client"},
+			"response_type":         {"code"},
+			"redirect_uri":          {"http://example.com/callback?keep=1"},
+			"state":                 {"state123"},
+			"code_challenge":        {"challenge"},
+			"code_challenge_method": {"S256"},
+		}
+		callback, err := p.Authorize(p.Issuer() + AuthorizationPath + "?" + query.Encode())
+		require.NoError(t, err)
+		assert.Equal(t, "/callback", callback.Path)
+		assert.Equal(t, "1", callback.Query().Get("keep"))
+		assert.Equal(t, "state123", callback.Query().Get("state"))
+		assert.NotEmpty(t, callback.Query().Get("code"))
+	})
+
+	t.Run("TestRequiresPKCE", func(t *testing.T) {
+		query := url.Values{"client_id": {"client"}, "response_type": {"code"}, "redirect_uri": {"http://example.com/callback"}}
+		_, err := p.Authorize(p.Issuer() + AuthorizationPath + "?" + query.Encode())
+		assert.Error(t, err)
+	})
+
+	t.Run("TestInvalidClient", func(t *testing.T) {
+		res, err := http.Post(p.Issuer()+TokenPath, "application/x-www-form-urlencoded",
+			strings.NewReader(url.Values{"grant_type": {"authorization_code"}, "client_id": {"client"}}.Encode()))
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+}
@@ -0,0 +1,211 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+// Returned for ID tokens that are invalid, e.g. of another client, expired or replayed with another nonce.
+var ErrInvalidIDToken = errors.New("invalid id token")
+
+// Holds the configuration of a provider, read from the provider scope.
+type ProviderConfig struct {
+	// discovery is fetched from <issuer>/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// callback URL registered with the provider, e.g. "https://example.com/auth/oidc/google/callback"
+	RedirectURL string
+	Scopes      []string
+}
+
+// Provider metadata, as published at the discovery endpoint.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// Response of the token endpoint.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// A configured provider, discovered on first use.
+type provider struct {
+	name   string
+	config *ProviderConfig
+	client *http.Client
+
+	mutex     sync.Mutex
+	discovery *discovery
+	keys      *token.RemoteKeySet
+}
+
+// signing methods accepted for ID tokens, HMAC is excluded as it would use the client secret as key
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// ID token keys are refetched after this interval, and at most once per minimum interval for unknown kids
+const (
+	jwksRefreshInterval    = time.Hour
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newProvider(name string, config *ProviderConfig, client *http.Client) *provider {
+	return &provider{name: name, config: config, client: client}
+}
+
+// Fetches the discovery document once. Failed attempts are retried on the next login.
+func (p *provider) discover(ctx context.Context) (*discovery, *token.RemoteKeySet, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.keys, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch discovery of %s: %w", p.name, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("failed to fetch discovery of %s: unexpected status %d", p.name, res.StatusCode)
+	}
+
+	var metadata discovery
+	if err := json.NewDecoder(res.Body).Decode(&metadata); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode discovery of %s: %w", p.name, err)
+	}
+	// prevents a compromised discovery endpoint from impersonating another issuer
+	if metadata.Issuer != p.config.Issuer {
+		return nil, nil, fmt.Errorf("discovery of %s returned issuer %q", p.name, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, nil, fmt.Errorf("discovery of %s is missing endpoints", p.name)
+	}
+
+	p.discovery = &metadata
+	p.keys = token.NewRemoteKeySet(metadata.JWKSURI, jwksRefreshInterval, jwksMinRefreshInterval)
+	return p.discovery, p.keys, nil
+}
+
+func (p *provider) authorizationURL(metadata *discovery, f *flow) (string, error) {
+	u, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", f.State)
+	query.Set("nonce", f.Nonce)
+	query.Set("code_challenge", newCodeChallenge(f.CodeVerifier))
+	query.Set("code_challenge_method", "S256")
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Exchanges the authorization code for the ID token, proving possession of the PKCE code verifier.
+func (p *provider) exchange(ctx context.Context, metadata *discovery, code string, codeVerifier string) (*tokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.config.ClientSecret == "" {
+		// public clients identify themselves without authenticating
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// client_secret_basic, with the credentials form encoded as required by RFC 6749
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code with %s: %w", p.name, err)
+	}
+	defer res.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response of %s: %w", p.name, err)
+	}
+	if res.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("failed to exchange code with %s: %s %s", p.name, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response of %s has no id_token, is the openid scope requested?", p.name)
+	}
+	return &tokens, nil
+}
+
+// Validates the signature, issuer, audience, expiry and nonce of the ID token, and returns its claims.
+func (p *provider) verifyIDToken(ctx context.Context, metadata *discovery, keys *token.RemoteKeySet, idToken string, nonce string, leeway time.Duration) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, keys.KeyfuncWithContext(ctx),
+		jwt.WithValidMethods(idTokenMethods),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce == "" || claimNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	// the authorized party must be this client if the token was issued to several audiences
+	if audience, _ := claims.GetAudience(); len(audience) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.config.ClientID {
+			return nil, fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidIDToken, azp)
+		}
+	}
+	if subject, _ := claims.GetSubject(); subject == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
@@ -0,0 +1,154 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/alsey89/gogetter/pkg/oidc/oidctest"
+)
+
+func TestDiscover(t *testing.T) {
+	fake := oidctest.NewProvider(t, "client", "secret")
+
+	p := newProvider("fake", &ProviderConfig{Issuer: fake.Issuer(), ClientID: "client"}, http.DefaultClient)
+	metadata, keys, err := p.discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, fake.Issuer()+oidctest.TokenPath, metadata.TokenEndpoint)
+	assert.NotNil(t, keys)
+
+	t.Run("TestIssuerMismatch", func(t *testing.T) {
+		p := newProvider("fake", &ProviderConfig{Issuer: fake.Issuer() + "/", ClientID: "client"}, http.DefaultClient)
+		_, _, err := p.discover(context.Background())
+		assert.Error(t, err)
+	})
+
+	t.Run("TestUnavailable", func(t *testing.T) {
+		p := newProvider("fake", &ProviderConfig{Issuer: "http://127.0.0.1:1", ClientID: "client"}, http.DefaultClient)
+		_, _, err := p.discover(context.Background())
+		assert.Error(t, err)
+	})
+}
+
+// Runs the authorization request against the fake provider, and returns the flow and callback code.
+func authorize(t *testing.T, fake *oidctest.Provider, p *provider, metadata *discovery) (*flow, string) {
+	f, err := newFlow(p.name, "", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	authorizationURL, err := p.authorizationURL(metadata, f)
+	require.NoError(t, err)
+	callback, err := fake.Authorize(authorizationURL)
+	require.NoError(t, err)
+	return f, callback.Query().Get("code")
+}
+
+func TestExchange(t *testing.T) {
+	for name, clientSecret := range map[string]string{"TestConfidentialClient": "secret", "TestPublicClient": ""} {
+		t.Run(name, func(t *testing.T) {
+			fake := oidctest.NewProvider(t, "client", clientSecret)
+			p := newProvider("fake", &ProviderConfig{
+				Issuer:       fake.Issuer(),
+				ClientID:     "client",
+				ClientSecret: clientSecret,
+				RedirectURL:  "http://example.com/callback",
+			}, http.DefaultClient)
+			metadata, _, err := p.discover(context.Background())
+			require.NoError(t, err)
+
+			f, code := authorize(t, fake, p, metadata)
+			tokens, err := p.exchange(context.Background(), metadata, code, f.CodeVerifier)
+			require.NoError(t, err)
+			assert.NotEmpty(t, tokens.IDToken)
+
+			// codes are single use
+			_, err = p.exchange(context.Background(), metadata, code, f.CodeVerifier)
+			assert.Error(t, err)
+
+			f, code = authorize(t, fake, p, metadata)
+			_, err = p.exchange(context.Background(), metadata, code, "forged verifier")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	fake := oidctest.NewProvider(t, "client", "secret")
+	p := newProvider("fake", &ProviderConfig{
+		Issuer:       fake.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://example.com/callback",
+	}, http.DefaultClient)
+	metadata, keys, err := p.discover(context.Background())
+	require.NoError(t, err)
+
+	issue := func(t *testing.T, claims jwt.MapClaims) (string, string) {
+		fake.ResetClaims()
+		fake.SetClaims(claims)
+		f, code := authorize(t, fake, p, metadata)
+		tokens, err := p.exchange(context.Background(), metadata, code, f.CodeVerifier)
+		require.NoError(t, err)
+		return tokens.IDToken, f.Nonce
+	}
+
+	t.Run("TestValid", func(t *testing.T) {
+		idToken, nonce := issue(t, nil)
+		claims, err := p.verifyIDToken(context.Background(), metadata, keys, idToken, nonce, 0)
+		require.NoError(t, err)
+		assert.Equal(t, oidctest.DefaultSubject, claims["sub"])
+		assert.Equal(t, oidctest.DefaultEmail, claims["email"])
+
+		// replayed for another login
+		_, err = p.verifyIDToken(context.Background(), metadata, keys, idToken, "other nonce", 0)
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+
+	t.Run("TestMultipleAudiences", func(t *testing.T) {
+		idToken, nonce := issue(t, jwt.MapClaims{"aud": []string{"client", "other"}, "azp": "client"})
+		_, err := p.verifyIDToken(context.Background(), metadata, keys, idToken, nonce, 0)
+		assert.NoError(t, err)
+	})
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"TestIssuer":            {"iss": "https://evil.com"},
+		"TestAudience":          {"aud": "other"},
+		"TestAuthorizedParty":   {"aud": []string{"client", "other"}, "azp": "other"},
+		"TestExpired":           {"exp": time.Now().Add(-time.Minute).Unix()},
+		"TestMissingExpiration": {"exp": nil},
+		"TestMissingNonce":      {"nonce": nil},
+		"TestMissingSubject":    {"sub": nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			idToken, nonce := issue(t, claims)
+			_, err := p.verifyIDToken(context.Background(), metadata, keys, idToken, nonce, 0)
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("TestSignature", func(t *testing.T) {
+		now := time.Now()
+		claims := jwt.MapClaims{"iss": fake.Issuer(), "aud": "client", "sub": "external123", "nonce": "nonce", "exp": now.Add(time.Minute).Unix(), "iat": now.Unix()}
+
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		forged := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		forged.Header["kid"] = "oidctest"
+		idToken, err := forged.SignedString(other)
+		require.NoError(t, err)
+		_, err = p.verifyIDToken(context.Background(), metadata, keys, idToken, "nonce", 0)
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+
+		// HMAC with the client secret is not accepted
+		forged = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		idToken, err = forged.SignedString([]byte("secret"))
+		require.NoError(t, err)
+		_, err = p.verifyIDToken(context.Background(), metadata, keys, idToken, "nonce", 0)
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
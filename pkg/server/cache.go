@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alsey89/gogetter/pkg/token"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// set on responses of cached routes, either HIT or MISS
+const HeaderXCache = "X-Cache"
+
+// Configures the response cache of a route.
+type CacheOptions struct {
+	// How long responses are cached, defaults to the configured cache_ttl_in_seconds.
+	TTL time.Duration
+	// Request headers that select different responses, e.g. Accept-Language.
+	VaryHeaders []string
+	// Claims of the JWT under "user" that select different responses, e.g. "sub" for per-user responses.
+	VaryClaims []string
+}
+
+// Buffers the response, so it can be replaced by 304 Not Modified.
+type conditionalWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupResponseCache() ResponseCache {
+	return NewLRUCache(m.config.CacheMaxEntries)
+}
+
+// weak, as the gzip middleware may change the encoding of the same body
+func newETag(body []byte) string {
+	hash := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// Compares entity tags with the weak comparison, as required for If-None-Match.
+func matchesETag(ifNoneMatch string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// If-None-Match takes precedence over If-Modified-Since.
+func isFresh(req *http.Request, header http.Header) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return matchesETag(ifNoneMatch, header.Get("ETag"))
+	}
+
+	ifModifiedSince, err := http.ParseTime(req.Header.Get(echo.HeaderIfModifiedSince))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get(echo.HeaderLastModified))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ifModifiedSince)
+}
+
+func (m *Module) handleConditionalRequest(c echo.Context, next echo.HandlerFunc) error {
+	res := c.Response()
+	writer := &conditionalWriter{ResponseWriter: res.Writer}
+	res.Writer = writer
+
+	err := next(c)
+	res.Writer = writer.ResponseWriter
+
+	// errors are rendered by the error handler, unless the handler already wrote a response
+	if !res.Committed {
+		return err
+	}
+
+	header := res.Header()
+	if writer.status == http.StatusOK {
+		if header.Get("ETag") == "" {
+			header.Set("ETag", newETag(writer.body.Bytes()))
+		}
+
+		if isFresh(c.Request(), header) {
+			header.Del(echo.HeaderContentType)
+			header.Del(echo.HeaderContentLength)
+			res.Status = http.StatusNotModified
+			writer.ResponseWriter.WriteHeader(http.StatusNotModified)
+			return err
+		}
+	}
+
+	writer.ResponseWriter.WriteHeader(writer.status)
+	if _, writeErr := writer.ResponseWriter.Write(writer.body.Bytes()); writeErr != nil {
+		m.logger.Error("failed to write response", zap.Error(writeErr))
+	}
+	return err
+}
+
+// Returns the claim of the JWT under "user" as a string, or an empty string.
+func getClaim(c echo.Context, name string) string {
+	claims, err := token.GetClaims(c)
+	if err != nil || claims[name] == nil {
+		return ""
+	}
+	return fmt.Sprint(claims[name])
+}
+
+func newCacheKey(c echo.Context, options CacheOptions) string {
+	req := c.Request()
+
+	hash := sha256.New()
+	for _, part := range []string{req.Method, req.URL.Path, normalizeQuery(req)} {
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	for _, name := range options.VaryHeaders {
+		value := req.Header.Get(name)
+		fmt.Fprintf(hash, "h%d:%s", len(value), value)
+	}
+	for _, name := range options.VaryClaims {
+		value := getClaim(c, name)
+		fmt.Fprintf(hash, "c%d:%s", len(value), value)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// the same query parameters in another order select the same response
+func normalizeQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var normalized strings.Builder
+	for _, key := range keys {
+		for _, value := range query[key] {
+			fmt.Fprintf(&normalized, "%s=%s&", key, value)
+		}
+	}
+	return normalized.String()
+}
+
+// responses setting cookies or marked as private must not be shared
+func isCacheableResponse(res *echo.Response) bool {
+	if res.Status != http.StatusOK {
+		return false
+	}
+	header := res.Header()
+	if header.Get(echo.HeaderSetCookie) != "" {
+		return false
+	}
+	cacheControl := strings.ToLower(header.Get("Cache-Control"))
+	return !strings.Contains(cacheControl, "no-store") && !strings.Contains(cacheControl, "private")
+}
+
+func (m *Module) handleCachedRequest(c echo.Context, next echo.HandlerFunc, options CacheOptions) error {
+	ctx := c.Request().Context()
+	res := c.Response()
+	key := newCacheKey(c, options)
+
+	for _, name := range options.VaryHeaders {
+		res.Header().Add(echo.HeaderVary, name)
+	}
+
+	m.middlewareMutex.RLock()
+	cache := m.responseCache
+	m.middlewareMutex.RUnlock()
+
+	if cached, ok := cache.Get(ctx, key); ok {
+		// copied, so later changes to the response headers do not change the cached entry
+		header := res.Header()
+		for name, values := range cached.Header {
+			header[name] = append([]string(nil), values...)
+		}
+		header.Set(HeaderXCache, "HIT")
+		header.Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+
+		return c.Blob(cached.Status, header.Get(echo.HeaderContentType), cached.Body)
+	}
+
+	res.Header().Set(HeaderXCache, "MISS")
+	recorder := &responseRecorder{ResponseWriter: res.Writer}
+	res.Writer = recorder
+
+	err := next(c)
+	res.Writer = recorder.ResponseWriter
+	if err != nil || !isCacheableResponse(res) {
+		return err
+	}
+
+	now := time.Now()
+	cached := &CachedResponse{
+		Status:    res.Status,
+		Header:    make(http.Header),
+		Body:      bytes.Clone(recorder.body.Bytes()),
+		StoredAt:  now,
+		ExpiresAt: now.Add(options.TTL),
+	}
+	for name, values := range res.Header() {
+		if !skippedCacheHeaders[name] {
+			cached.Header[name] = append([]string(nil), values...)
+		}
+	}
+
+	if err := cache.Set(context.WithoutCancel(ctx), key, cached); err != nil {
+		m.logger.Error("failed to cache response", zap.Error(err))
+	}
+	return nil
+}
+
+// response headers that are not stored by the cache
+var skippedCacheHeaders = map[string]bool{
+	HeaderXCache:               true,
+	echo.HeaderXRequestID:      true,
+	"Date":                     true,
+	echo.HeaderContentLength:   true,
+	echo.HeaderContentEncoding: true,
+	echo.HeaderVary:            true,
+}
+
+func (w *conditionalWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *conditionalWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// buffered responses are written at once after the handler returns
+func (w *conditionalWriter) Flush() {}
+
+func (w *conditionalWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Returns an echo middleware that adds an ETag to successful GET and HEAD responses,
+and answers with 304 Not Modified if it matches If-None-Match,
+or if a Last-Modified header set by the handler is not newer than If-Modified-Since.
+Place it before the cache middleware, so cached responses are answered with 304 as well.
+
+	e.GET("/products", listProducts, m.GetConditionalMiddleware(), m.GetCacheMiddleware(server.CacheOptions{}))
+*/
+func (m *Module) GetConditionalMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			if (method != http.MethodGet && method != http.MethodHead) || m.isStreamRequest(c) {
+				return next(c)
+			}
+			return m.handleConditionalRequest(c, next)
+		}
+	}
+}
+
+/*
+Returns an echo middleware that caches successful GET responses by path, query, and the configured headers and claims.
+Responses that set cookies or have a private or no-store Cache-Control are not cached.
+Vary by the "sub" claim for responses that differ per user, and place it after the authentication middleware.
+
+	e.GET("/me/orders", listOrders, tokenModule.GetJWTMiddleware("jwt_auth"),
+		m.GetCacheMiddleware(server.CacheOptions{TTL: time.Minute, VaryClaims: []string{"sub"}}))
+*/
+func (m *Module) GetCacheMiddleware(options CacheOptions) echo.MiddlewareFunc {
+	if options.TTL <= 0 {
+		options.TTL = time.Duration(m.config.CacheTTLInSeconds) * time.Second
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method != http.MethodGet {
+				return next(c)
+			}
+			return m.handleCachedRequest(c, next, options)
+		}
+	}
+}
+
+/*
+Sets the ETag and Last-Modified headers, and returns true if the client's copy is still fresh.
+Allows handlers to skip expensive queries, e.g. by comparing the latest updated_at of a table.
+
+	if server.IsNotModified(c, "", latestUpdate) {
+		return c.NoContent(http.StatusNotModified)
+	}
+*/
+func IsNotModified(c echo.Context, etag string, lastModified time.Time) bool {
+	header := c.Response().Header()
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		header.Set(echo.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+	return isFresh(c.Request(), header)
+}
+
+// Replaces the response cache, by default an LRUCache holding cache_max_entries responses.
+func (m *Module) SetResponseCache(cache ResponseCache) {
+	m.middlewareMutex.Lock()
+	defer m.middlewareMutex.Unlock()
+
+	m.responseCache = cache
+}
+
+// Removes all cached responses, e.g. after data of cached routes changed.
+func (m *Module) ClearResponseCache(ctx context.Context) error {
+	m.middlewareMutex.RLock()
+	cache := m.responseCache
+	m.middlewareMutex.RUnlock()
+
+	return cache.Clear(ctx)
+}
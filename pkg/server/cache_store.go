@@ -0,0 +1,125 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A response stored by the cache middleware.
+type CachedResponse struct {
+	Status    int
+	Header    http.Header
+	Body      []byte
+	StoredAt  time.Time
+	ExpiresAt time.Time
+}
+
+/*
+Stores responses for the cache middleware.
+Implementations must be safe for concurrent use, e.g. to share a cache between instances through redis.
+*/
+type ResponseCache interface {
+	// Returns the response stored under the key, and false if there is none or it expired.
+	Get(ctx context.Context, key string) (*CachedResponse, bool)
+	Set(ctx context.Context, key string, response *CachedResponse) error
+	Delete(ctx context.Context, key string) error
+	// Removes all stored responses.
+	Clear(ctx context.Context) error
+}
+
+// In-memory ResponseCache that evicts the least recently used response once full.
+type LRUCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type lruEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Holds at most maxEntries responses, or an unlimited number if maxEntries is 0.
+func NewLRUCache(maxEntries int) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (c *LRUCache) Get(ctx context.Context, key string) (*CachedResponse, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.response.ExpiresAt) {
+		c.removeElement(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.response, true
+}
+
+func (c *LRUCache) Set(ctx context.Context, key string, response *CachedResponse) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*lruEntry).response = response
+		c.order.MoveToFront(element)
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, response: response})
+	if c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+	return nil
+}
+
+func (c *LRUCache) Delete(ctx context.Context, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+	return nil
+}
+
+func (c *LRUCache) Clear(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	return nil
+}
+
+// Returns the number of stored responses, including expired ones not yet evicted.
+func (c *LRUCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.order.Len()
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (c *LRUCache) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newModuleWithCache() *Module {
+	m := newModuleWithMiddleware(&Config{
+		ServerLogLevel:    "PROD",
+		CacheMaxEntries:   DefaultCacheMaxEntries,
+		CacheTTLInSeconds: DefaultCacheTTLInSeconds,
+	})
+	m.server.HTTPErrorHandler = m.handleHTTPError
+	m.responseCache = m.setupResponseCache()
+	return m
+}
+
+func sendGet(m *Module, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	m.server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConditionalMiddleware(t *testing.T) {
+	m := newModuleWithCache()
+	lastModified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m.server.GET("/products", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, []string{"a", "b"})
+	}, m.GetConditionalMiddleware())
+	m.server.GET("/articles", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderLastModified, lastModified.Format(http.TimeFormat))
+		return c.String(http.StatusOK, "article")
+	}, m.GetConditionalMiddleware())
+	m.server.GET("/missing", func(c echo.Context) error {
+		return NewError(http.StatusNotFound, "not found")
+	}, m.GetConditionalMiddleware())
+
+	t.Run("TestETag", func(t *testing.T) {
+		res := sendGet(m, "/products", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.JSONEq(t, `["a","b"]`, res.Body.String())
+
+		etag := res.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+		assert.Equal(t, etag, sendGet(m, "/products", nil).Header().Get("ETag"))
+
+		res = sendGet(m, "/products", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, res.Code)
+		assert.Empty(t, res.Body.String())
+
+		res = sendGet(m, "/products", map[string]string{"If-None-Match": `"other", ` + etag})
+		assert.Equal(t, http.StatusNotModified, res.Code)
+
+		res = sendGet(m, "/products", map[string]string{"If-None-Match": `"other"`})
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("TestLastModified", func(t *testing.T) {
+		res := sendGet(m, "/articles", map[string]string{echo.HeaderIfModifiedSince: lastModified.Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusNotModified, res.Code)
+
+		res = sendGet(m, "/articles", map[string]string{echo.HeaderIfModifiedSince: lastModified.Add(-time.Hour).Format(http.TimeFormat)})
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "article", res.Body.String())
+	})
+
+	t.Run("TestErrors", func(t *testing.T) {
+		res := sendGet(m, "/missing", map[string]string{"If-None-Match": "*"})
+		assert.Equal(t, http.StatusNotFound, res.Code)
+		assert.Empty(t, res.Header().Get("ETag"))
+	})
+}
+
+func TestIsNotModified(t *testing.T) {
+	m := newModuleWithCache()
+	lastModified := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var queries int32
+	m.server.GET("/reports", func(c echo.Context) error {
+		if IsNotModified(c, `"v1"`, lastModified) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		atomic.AddInt32(&queries, 1)
+		return c.String(http.StatusOK, "report")
+	})
+
+	res := sendGet(m, "/reports", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, `"v1"`, res.Header().Get("ETag"))
+	assert.Equal(t, lastModified.Format(http.TimeFormat), res.Header().Get(echo.HeaderLastModified))
+
+	res = sendGet(m, "/reports", map[string]string{"If-None-Match": `"v1"`})
+	assert.Equal(t, http.StatusNotModified, res.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&queries))
+}
+
+func TestCacheMiddleware(t *testing.T) {
+	m := newModuleWithCache()
+
+	var calls int32
+	handler := func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return c.String(http.StatusOK, c.Request().Header.Get(echo.HeaderAcceptEncoding)+c.QueryParam("page"))
+	}
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", &jwt.Token{Claims: jwt.MapClaims{"sub": c.Request().Header.Get("X-User")}})
+			return next(c)
+		}
+	}
+
+	m.server.GET("/products", handler, m.GetConditionalMiddleware(), m.GetCacheMiddleware(CacheOptions{}))
+	m.server.GET("/localized", handler, m.GetCacheMiddleware(CacheOptions{VaryHeaders: []string{"Accept-Language"}}))
+	m.server.GET("/me", handler, setUser, m.GetCacheMiddleware(CacheOptions{VaryClaims: []string{"sub"}}))
+	m.server.GET("/short", handler, m.GetCacheMiddleware(CacheOptions{TTL: 50 * time.Millisecond}))
+	m.server.GET("/private", func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		c.Response().Header().Set("Cache-Control", "private")
+		return c.String(http.StatusOK, "private")
+	}, m.GetCacheMiddleware(CacheOptions{}))
+
+	t.Run("TestHit", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		first := sendGet(m, "/products?page=1&size=10", nil)
+		assert.Equal(t, "MISS", first.Header().Get(HeaderXCache))
+
+		second := sendGet(m, "/products?size=10&page=1", nil)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, "HIT", second.Header().Get(HeaderXCache))
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+		// cached responses are answered with 304 as well
+		res := sendGet(m, "/products?page=1&size=10", map[string]string{"If-None-Match": first.Header().Get("ETag")})
+		assert.Equal(t, http.StatusNotModified, res.Code)
+
+		sendGet(m, "/products?page=2", nil)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("TestHeadersCopied", func(t *testing.T) {
+		first := sendGet(m, "/products?page=3", nil)
+		contentType := first.Header().Get(echo.HeaderContentType)
+
+		// e.g. middleware changing the headers after the handler
+		first.Header()[echo.HeaderContentType][0] = "changed"
+		second := sendGet(m, "/products?page=3", nil)
+		second.Header()[echo.HeaderContentType][0] = "changed"
+
+		third := sendGet(m, "/products?page=3", nil)
+		assert.Equal(t, "HIT", third.Header().Get(HeaderXCache))
+		assert.Equal(t, contentType, third.Header().Get(echo.HeaderContentType))
+	})
+
+	t.Run("TestVaryHeaders", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		sendGet(m, "/localized", map[string]string{"Accept-Language": "en"})
+		res := sendGet(m, "/localized", map[string]string{"Accept-Language": "de"})
+		assert.Equal(t, "MISS", res.Header().Get(HeaderXCache))
+		assert.Contains(t, res.Header().Values(echo.HeaderVary), "Accept-Language")
+
+		res = sendGet(m, "/localized", map[string]string{"Accept-Language": "en"})
+		assert.Equal(t, "HIT", res.Header().Get(HeaderXCache))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("TestVaryClaims", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		sendGet(m, "/me", map[string]string{"X-User": "alice"})
+		sendGet(m, "/me", map[string]string{"X-User": "bob"})
+		res := sendGet(m, "/me", map[string]string{"X-User": "alice"})
+		assert.Equal(t, "HIT", res.Header().Get(HeaderXCache))
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("TestTTL", func(t *testing.T) {
+		sendGet(m, "/short", nil)
+		assert.Equal(t, "HIT", sendGet(m, "/short", nil).Header().Get(HeaderXCache))
+
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, "MISS", sendGet(m, "/short", nil).Header().Get(HeaderXCache))
+	})
+
+	t.Run("TestPrivateResponses", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+
+		sendGet(m, "/private", nil)
+		sendGet(m, "/private", nil)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("TestClear", func(t *testing.T) {
+		sendGet(m, "/products", nil)
+		assert.Equal(t, "HIT", sendGet(m, "/products", nil).Header().Get(HeaderXCache))
+
+		assert.NoError(t, m.ClearResponseCache(context.Background()))
+		assert.Equal(t, "MISS", sendGet(m, "/products", nil).Header().Get(HeaderXCache))
+	})
+}
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	cache := NewLRUCache(2)
+	response := func(body string, ttl time.Duration) *CachedResponse {
+		return &CachedResponse{Status: http.StatusOK, Body: []byte(body), ExpiresAt: time.Now().Add(ttl)}
+	}
+
+	assert.NoError(t, cache.Set(ctx, "a", response("a", time.Minute)))
+	assert.NoError(t, cache.Set(ctx, "b", response("b", time.Minute)))
+
+	// reading "a" makes "b" the least recently used
+	_, ok := cache.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.NoError(t, cache.Set(ctx, "c", response("c", time.Minute)))
+
+	_, ok = cache.Get(ctx, "b")
+	assert.False(t, ok)
+	cached, ok := cache.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), cached.Body)
+	assert.Equal(t, 2, cache.Len())
+
+	t.Run("TestExpired", func(t *testing.T) {
+		assert.NoError(t, cache.Set(ctx, "expired", response("expired", -time.Second)))
+		_, ok := cache.Get(ctx, "expired")
+		assert.False(t, ok)
+	})
+
+	t.Run("TestDelete", func(t *testing.T) {
+		assert.NoError(t, cache.Delete(ctx, "a"))
+		_, ok := cache.Get(ctx, "a")
+		assert.False(t, ok)
+	})
+
+	t.Run("TestClear", func(t *testing.T) {
+		assert.NoError(t, cache.Clear(ctx))
+		assert.Equal(t, 0, cache.Len())
+	})
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Runtime statistics served by the debug routes.
+type RuntimeStats struct {
+	GoVersion    string `json:"go_version"`
+	NumCPU       int    `json:"num_cpu"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumGoroutine int    `json:"num_goroutine"`
+	NumCgoCall   int64  `json:"num_cgo_call"`
+
+	Memory MemoryStats `json:"memory"`
+	GC     GCStats     `json:"gc"`
+}
+
+type MemoryStats struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"total_alloc"`
+	Sys          uint64 `json:"sys"`
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapSys      uint64 `json:"heap_sys"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+}
+
+type GCStats struct {
+	NumGC         int64           `json:"num_gc"`
+	LastGC        time.Time       `json:"last_gc"`
+	PauseTotal    time.Duration   `json:"pause_total_ns"`
+	RecentPauses  []time.Duration `json:"recent_pauses_ns"`
+	NextGC        uint64          `json:"next_gc"`
+	GCCPUFraction float64         `json:"gc_cpu_fraction"`
+}
+
+// Build information of the running binary served by the debug routes.
+type BuildInfo struct {
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings"`
+	Deps      map[string]string `json:"deps"`
+}
+
+// number of recent GC pauses included in the runtime stats
+const recentGCPauses = 10
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setUpDebugRoutes() {
+	// defaults to not serving the debug routes if unspecified
+	if !m.config.Debug {
+		return
+	}
+
+	middlewares := []echo.MiddlewareFunc{}
+	if m.config.DebugTokenScope != "" {
+		if m.token == nil {
+			m.logger.Fatal("debug token scope requires the token module")
+		}
+		middlewares = append(middlewares, m.token.GetJWTMiddleware(m.config.DebugTokenScope))
+	} else if !isLoopbackHost(m.config.Host) {
+		// fails closed, profiles and stack traces must not be served publicly
+		m.logger.Error("debug routes require debug_token_scope, or the server to be bound to a loopback address, not serving them",
+			zap.String("host", m.config.Host),
+		)
+		m.config.Debug = false
+		return
+	}
+
+	m.RegisterDebugRoutes(m.server.Group(m.config.DebugPath, middlewares...))
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// the debug routes are not part of the API
+func (m *Module) isDebugRoute(path string) bool {
+	return m.config.Debug && strings.HasPrefix(path, strings.TrimSuffix(m.config.DebugPath, "/")+"/")
+}
+
+func newRuntimeStats() RuntimeStats {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	var gcStats debug.GCStats
+	debug.ReadGCStats(&gcStats)
+
+	pauses := gcStats.Pause
+	if len(pauses) > recentGCPauses {
+		pauses = pauses[:recentGCPauses]
+	}
+
+	return RuntimeStats{
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		NumCgoCall:   runtime.NumCgoCall(),
+		Memory: MemoryStats{
+			Alloc:        memStats.Alloc,
+			TotalAlloc:   memStats.TotalAlloc,
+			Sys:          memStats.Sys,
+			HeapAlloc:    memStats.HeapAlloc,
+			HeapSys:      memStats.HeapSys,
+			HeapIdle:     memStats.HeapIdle,
+			HeapInuse:    memStats.HeapInuse,
+			HeapReleased: memStats.HeapReleased,
+			HeapObjects:  memStats.HeapObjects,
+			StackInuse:   memStats.StackInuse,
+			Mallocs:      memStats.Mallocs,
+			Frees:        memStats.Frees,
+		},
+		GC: GCStats{
+			NumGC:         gcStats.NumGC,
+			LastGC:        gcStats.LastGC,
+			PauseTotal:    gcStats.PauseTotal,
+			RecentPauses:  pauses,
+			NextGC:        memStats.NextGC,
+			GCCPUFraction: memStats.GCCPUFraction,
+		},
+	}
+}
+
+func newBuildInfo() (BuildInfo, bool) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{}, false
+	}
+
+	buildInfo := BuildInfo{
+		GoVersion: info.GoVersion,
+		Path:      info.Main.Path,
+		Version:   info.Main.Version,
+		Settings:  make(map[string]string),
+		Deps:      make(map[string]string),
+	}
+	// e.g. vcs.revision, vcs.time and vcs.modified
+	for _, setting := range info.Settings {
+		buildInfo.Settings[setting.Key] = setting.Value
+	}
+	for _, dep := range info.Deps {
+		buildInfo.Deps[dep.Path] = dep.Version
+	}
+	return buildInfo, true
+}
+
+// The pprof handlers expect the /debug/pprof/ prefix, so the routes are served under any debug_path.
+func withPprofPath(r *http.Request, profile string) *http.Request {
+	r = r.Clone(r.Context())
+	r.URL.Path = "/debug/pprof/" + profile
+	r.URL.RawPath = ""
+	return r
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Registers the debug routes on a group, e.g. to protect them with custom middleware.
+Enabling the debug configuration registers them under debug_path, protected by debug_token_scope if set.
+
+	GET  /pprof/             index of the pprof profiles
+	GET  /pprof/:profile     e.g. heap, allocs, goroutine, block, mutex, threadcreate, cmdline, profile, symbol, trace
+	GET  /goroutines         stack traces of all goroutines
+	GET  /runtime            runtime, memory and GC statistics
+	POST /gc                 runs a garbage collection and returns memory to the OS
+	GET  /fx                 the fx dependency graph in DOT format
+	GET  /build              build information of the binary
+
+	go tool pprof http://localhost:3002/debug/pprof/heap
+*/
+func (m *Module) RegisterDebugRoutes(group *echo.Group) {
+	group.GET("/pprof", func(c echo.Context) error {
+		return c.Redirect(http.StatusMovedPermanently, c.Request().URL.Path+"/")
+	})
+	group.GET("/pprof/", func(c echo.Context) error {
+		pprof.Index(c.Response(), withPprofPath(c.Request(), ""))
+		return nil
+	})
+	group.GET("/pprof/:profile", func(c echo.Context) error {
+		var handler http.Handler
+		switch profile := c.Param("profile"); profile {
+		case "cmdline":
+			handler = http.HandlerFunc(pprof.Cmdline)
+		case "profile":
+			handler = http.HandlerFunc(pprof.Profile)
+		case "symbol":
+			handler = http.HandlerFunc(pprof.Symbol)
+		case "trace":
+			handler = http.HandlerFunc(pprof.Trace)
+		default:
+			handler = pprof.Handler(profile)
+		}
+		handler.ServeHTTP(c.Response(), withPprofPath(c.Request(), c.Param("profile")))
+		return nil
+	})
+	group.POST("/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+
+	group.GET("/goroutines", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+		c.Response().WriteHeader(http.StatusOK)
+		// debug level 2 prints the full stack traces, as in a panic
+		return runtimepprof.Lookup("goroutine").WriteTo(c.Response(), 2)
+	})
+
+	group.GET("/runtime", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, newRuntimeStats())
+	})
+
+	group.POST("/gc", func(c echo.Context) error {
+		debug.FreeOSMemory()
+		m.logger.Info("forced garbage collection through debug route")
+		return c.JSON(http.StatusOK, newRuntimeStats())
+	})
+
+	group.GET("/fx", func(c echo.Context) error {
+		if m.dotGraph == "" {
+			return NewError(http.StatusNotFound, "fx dependency graph is only available when using the fx framework")
+		}
+		return c.Blob(http.StatusOK, "text/vnd.graphviz; charset=UTF-8", []byte(m.dotGraph))
+	})
+
+	group.GET("/build", func(c echo.Context) error {
+		buildInfo, ok := newBuildInfo()
+		if !ok {
+			return NewError(http.StatusNotFound, "build information is not available")
+		}
+		return c.JSON(http.StatusOK, buildInfo)
+	})
+}
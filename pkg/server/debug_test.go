@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+func newModuleWithDebug(config *Config) *Module {
+	config.ServerLogLevel = "PROD"
+	config.Debug = true
+	config.DebugPath = DefaultDebugPath
+	m := newModuleWithMiddleware(config)
+	m.server.HTTPErrorHandler = m.handleHTTPError
+	return m
+}
+
+func sendDebug(m *Module, method string, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	m.server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDebugRoutes(t *testing.T) {
+	m := newModuleWithDebug(&Config{Host: "127.0.0.1"})
+	m.dotGraph = "digraph {}"
+	m.setUpDebugRoutes()
+
+	t.Run("TestPprof", func(t *testing.T) {
+		res := sendDebug(m, http.MethodGet, "/debug/pprof/", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), "heap")
+
+		res = sendDebug(m, http.MethodGet, "/debug/pprof/heap?debug=1", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), "heap profile")
+
+		res = sendDebug(m, http.MethodGet, "/debug/pprof/cmdline", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+	})
+
+	t.Run("TestGoroutines", func(t *testing.T) {
+		res := sendDebug(m, http.MethodGet, "/debug/goroutines", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Contains(t, res.Body.String(), "goroutine")
+		assert.Contains(t, res.Body.String(), "TestDebugRoutes")
+	})
+
+	t.Run("TestRuntime", func(t *testing.T) {
+		res := sendDebug(m, http.MethodGet, "/debug/runtime", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		var stats RuntimeStats
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &stats))
+		assert.NotEmpty(t, stats.GoVersion)
+		assert.Greater(t, stats.NumGoroutine, 0)
+		assert.Greater(t, stats.Memory.HeapAlloc, uint64(0))
+	})
+
+	t.Run("TestGC", func(t *testing.T) {
+		before := newRuntimeStats().GC.NumGC
+
+		res := sendDebug(m, http.MethodPost, "/debug/gc", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		var stats RuntimeStats
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &stats))
+		assert.Greater(t, stats.GC.NumGC, before)
+	})
+
+	t.Run("TestFxGraph", func(t *testing.T) {
+		res := sendDebug(m, http.MethodGet, "/debug/fx", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "digraph {}", res.Body.String())
+	})
+
+	t.Run("TestBuildInfo", func(t *testing.T) {
+		res := sendDebug(m, http.MethodGet, "/debug/build", nil)
+		assert.Equal(t, http.StatusOK, res.Code)
+
+		var buildInfo BuildInfo
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &buildInfo))
+		assert.NotEmpty(t, buildInfo.GoVersion)
+	})
+
+	t.Run("TestNotDocumented", func(t *testing.T) {
+		assert.True(t, m.isOwnRoute(&echo.Route{Method: http.MethodGet, Path: "/debug/runtime"}))
+		assert.False(t, m.isOwnRoute(&echo.Route{Method: http.MethodGet, Path: "/debugger"}))
+	})
+}
+
+func TestDebugRoutesCustomPath(t *testing.T) {
+	m := newModuleWithDebug(&Config{Host: "127.0.0.1"})
+	m.config.DebugPath = "/internal/debug"
+	m.setUpDebugRoutes()
+
+	res := sendDebug(m, http.MethodGet, "/internal/debug/pprof/", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	// profiles are linked relative to the index
+	assert.Contains(t, res.Body.String(), "href='heap?debug=1'")
+
+	res = sendDebug(m, http.MethodGet, "/internal/debug/pprof/heap?debug=1", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), "heap profile")
+
+	res = sendDebug(m, http.MethodGet, "/internal/debug/pprof", nil)
+	assert.Equal(t, http.StatusMovedPermanently, res.Code)
+	assert.Equal(t, "/internal/debug/pprof/", res.Header().Get(echo.HeaderLocation))
+}
+
+func TestDebugRoutesDisabled(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{ServerLogLevel: "PROD", DebugPath: DefaultDebugPath})
+	m.setUpDebugRoutes()
+
+	res := sendDebug(m, http.MethodGet, "/debug/runtime", nil)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+}
+
+func TestDebugRoutesUnprotected(t *testing.T) {
+	// neither protected by a token scope, nor bound to a loopback address
+	for _, host := range []string{"0.0.0.0", ""} {
+		m := newModuleWithDebug(&Config{Host: host})
+		m.setUpDebugRoutes()
+
+		assert.Equal(t, http.StatusNotFound, sendDebug(m, http.MethodGet, "/debug/goroutines", nil).Code, host)
+		assert.Equal(t, http.StatusNotFound, sendDebug(m, http.MethodGet, "/debug/pprof/cmdline", nil).Code, host)
+		assert.False(t, m.isDebugRoute("/debug/runtime"))
+	}
+}
+
+func TestDebugRoutesTokenScope(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("jwt_admin.token_lookup", "header:Authorization:Bearer ")
+
+	tokenModule := token.NewTokenManager("token", zap.NewNop(), "jwt_admin")
+	m := newModuleWithDebug(&Config{DebugTokenScope: "jwt_admin"})
+	m.token = tokenModule
+	m.setUpDebugRoutes()
+
+	res := sendDebug(m, http.MethodGet, "/debug/runtime", nil)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	signed, err := tokenModule.GenerateToken("jwt_admin", jwt.MapClaims{"sub": "admin"})
+	assert.NoError(t, err)
+
+	res = sendDebug(m, http.MethodGet, "/debug/runtime", map[string]string{echo.HeaderAuthorization: "Bearer " + *signed})
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestDebugFxGraph(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("admin.listen", false)
+	viper.Set("admin.debug", true)
+
+	var admin *Module
+	app := fxtest.New(t,
+		fx.Supply(zap.NewNop()),
+		InjectNamedModule("admin"),
+		fx.Populate(fx.Annotate(&admin, fx.ParamTags(`name:"admin"`))),
+	)
+	app.RequireStart()
+	defer app.RequireStop()
+
+	res := sendDebug(admin, http.MethodGet, "/debug/fx", nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), "digraph")
+}
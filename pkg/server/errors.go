@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// content type of RFC 7807 responses
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// RFC 7807 problem details, with the request ID and field errors as extensions
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// Typed application error.
+// Handlers return it to control the status code and the problem returned to the client.
+type Error struct {
+	Status int
+	Type   string
+	Title  string
+	Detail string
+	Err    error
+}
+
+// A single invalid field in a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Returned when a request fails validation, mapped to 422 Unprocessable Entity
+type ValidationError struct {
+	Errors []FieldError
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Creates an application error with the given status code, 500 if it is not a valid status code.
+// Detail is returned to the client as is, so it should not contain internal information.
+func NewError(status int, detail string) *Error {
+	// invalid status codes would panic when the response is written
+	if status < 100 || status > 599 {
+		status = http.StatusInternalServerError
+	}
+	return &Error{
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d: %s: %v", e.Status, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%d: %s", e.Status, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Sets the problem type URI. Defaults to "about:blank".
+func (e *Error) WithType(problemType string) *Error {
+	e.Type = problemType
+	return e
+}
+
+// Sets the problem title. Defaults to the status text.
+func (e *Error) WithTitle(title string) *Error {
+	e.Title = title
+	return e
+}
+
+// Attaches the underlying error. It is logged, but only returned to the client outside of PROD.
+func (e *Error) Wrap(err error) *Error {
+	e.Err = err
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validation failed: %v", e.Errors)
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// Central echo error handler, writes every error as problem+json.
+func (m *Module) handleHTTPError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	// unknown paths match the static route, other methods than GET and HEAD are not found rather than not allowed
+	if errors.Is(err, echo.ErrMethodNotAllowed) && m.isStaticRequest(c) {
+		c.Response().Header().Del(echo.HeaderAllow)
+		err = echo.ErrNotFound
+	}
+
+	problem, internal := m.newProblem(err)
+	problem.Instance = c.Request().URL.Path
+	problem.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+	if problem.Status >= http.StatusInternalServerError {
+		m.logger.Error("request failed",
+			zap.Int("status", problem.Status),
+			zap.String("URI", c.Request().RequestURI),
+			zap.String("request_id", problem.RequestID),
+			zap.Error(internal),
+		)
+	}
+
+	var writeErr error
+	if c.Request().Method == http.MethodHead {
+		writeErr = c.NoContent(problem.Status)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEApplicationProblemJSON)
+		writeErr = c.JSON(problem.Status, problem)
+	}
+	if writeErr != nil {
+		m.logger.Error("failed to write error response", zap.Error(writeErr))
+	}
+}
+
+// Maps an error to a problem, and returns the error to be logged.
+func (m *Module) newProblem(err error) (*Problem, error) {
+	var appErr *Error
+	var validationErr *ValidationError
+	var httpErr *echo.HTTPError
+
+	problem := &Problem{Type: "about:blank"}
+	internal := err
+
+	switch {
+	case errors.As(err, &appErr):
+		problem.Status = appErr.Status
+		problem.Detail = appErr.Detail
+		if appErr.Type != "" {
+			problem.Type = appErr.Type
+		}
+		problem.Title = appErr.Title
+		if appErr.Err != nil && !m.isProduction() {
+			problem.Detail = fmt.Sprintf("%s: %v", appErr.Detail, appErr.Err)
+		}
+	case errors.As(err, &validationErr):
+		problem.Status = http.StatusUnprocessableEntity
+		problem.Detail = "request validation failed"
+		problem.Errors = validationErr.Errors
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		problem.Status = http.StatusNotFound
+		problem.Detail = "resource not found"
+	case isJWTError(err):
+		problem.Status = http.StatusUnauthorized
+		problem.Detail = "invalid or expired token"
+	case errors.As(err, &httpErr):
+		problem.Status = httpErr.Code
+		problem.Detail = fmt.Sprint(httpErr.Message)
+		if httpErr.Internal != nil {
+			internal = httpErr.Internal
+			if !m.isProduction() {
+				problem.Detail = fmt.Sprintf("%s: %v", problem.Detail, httpErr.Internal)
+			}
+		}
+	default:
+		problem.Status = http.StatusInternalServerError
+		if !m.isProduction() {
+			problem.Detail = err.Error()
+		}
+	}
+
+	// internal details are never exposed in PROD
+	if problem.Status >= http.StatusInternalServerError && m.isProduction() {
+		problem.Detail = ""
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+
+	return problem, internal
+}
+
+func isJWTError(err error) bool {
+	var tokenErr *echojwt.TokenError
+	if errors.As(err, &tokenErr) {
+		return true
+	}
+	for _, jwtErr := range []error{
+		jwt.ErrTokenMalformed,
+		jwt.ErrTokenUnverifiable,
+		jwt.ErrTokenSignatureInvalid,
+		jwt.ErrTokenExpired,
+		jwt.ErrTokenNotValidYet,
+		jwt.ErrTokenUsedBeforeIssued,
+		jwt.ErrTokenInvalidIssuer,
+		jwt.ErrTokenInvalidAudience,
+		jwt.ErrTokenInvalidSubject,
+		jwt.ErrTokenInvalidId,
+		jwt.ErrTokenInvalidClaims,
+	} {
+		if errors.Is(err, jwtErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// internal details are hidden unless the server log level is DEV or DEBUG
+func (m *Module) isProduction() bool {
+	if m.config == nil {
+		return true
+	}
+	switch m.config.ServerLogLevel {
+	case "DEV", "dev", "DEBUG", "debug":
+		return false
+	default:
+		return true
+	}
+}
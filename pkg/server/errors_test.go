@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func serveError(t *testing.T, logLevel string, handlerErr error) (*httptest.ResponseRecorder, Problem) {
+	m := &Module{
+		scope:  "server",
+		config: &Config{ServerLogLevel: logLevel, RequestID: true},
+		logger: zap.NewNop(),
+	}
+	m.server = m.setupServer()
+	m.setUpMiddlewareChain()
+	m.server.GET("/test", func(c echo.Context) error {
+		return handlerErr
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	m.server.ServeHTTP(rec, req)
+
+	var problem Problem
+	err := json.Unmarshal(rec.Body.Bytes(), &problem)
+	assert.NoError(t, err)
+
+	return rec, problem
+}
+
+func TestHandleHTTPError(t *testing.T) {
+	t.Run("TestApplicationError", func(t *testing.T) {
+		rec, problem := serveError(t, "PROD", NewError(http.StatusConflict, "email already registered").WithType("/problems/conflict"))
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "/problems/conflict", problem.Type)
+		assert.Equal(t, "Conflict", problem.Title)
+		assert.Equal(t, http.StatusConflict, problem.Status)
+		assert.Equal(t, "email already registered", problem.Detail)
+		assert.Equal(t, "/test", problem.Instance)
+		assert.Equal(t, rec.Header().Get(echo.HeaderXRequestID), problem.RequestID)
+		assert.NotEmpty(t, problem.RequestID)
+	})
+
+	t.Run("TestInvalidStatus", func(t *testing.T) {
+		for _, status := range []int{0, -1, 99, 600} {
+			rec, problem := serveError(t, "PROD", NewError(status, "invalid status"))
+			assert.Equal(t, http.StatusInternalServerError, rec.Code, status)
+			assert.Equal(t, http.StatusInternalServerError, problem.Status, status)
+		}
+	})
+
+	t.Run("TestValidationError", func(t *testing.T) {
+		rec, problem := serveError(t, "PROD", &ValidationError{
+			Errors: []FieldError{{Field: "email", Message: "is required"}},
+		})
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Equal(t, []FieldError{{Field: "email", Message: "is required"}}, problem.Errors)
+	})
+
+	t.Run("TestRecordNotFound", func(t *testing.T) {
+		rec, _ := serveError(t, "PROD", gorm.ErrRecordNotFound)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("TestJWTError", func(t *testing.T) {
+		rec, problem := serveError(t, "PROD", jwt.ErrTokenExpired)
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "invalid or expired token", problem.Detail)
+	})
+
+	t.Run("TestHTTPError", func(t *testing.T) {
+		rec, problem := serveError(t, "PROD", echo.NewHTTPError(http.StatusForbidden, "forbidden"))
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, "forbidden", problem.Detail)
+	})
+
+	t.Run("TestRouteNotFound", func(t *testing.T) {
+		m := &Module{config: &Config{ServerLogLevel: "PROD"}, logger: zap.NewNop()}
+		m.server = m.setupServer()
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		m.server.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+	})
+
+	t.Run("TestInternalErrorHiddenInProd", func(t *testing.T) {
+		rec, problem := serveError(t, "PROD", errors.New("connection refused"))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Empty(t, problem.Detail)
+	})
+
+	t.Run("TestInternalErrorShownInDev", func(t *testing.T) {
+		rec, problem := serveError(t, "DEV", errors.New("connection refused"))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "connection refused", problem.Detail)
+	})
+
+	t.Run("TestWrappedErrorHiddenInProd", func(t *testing.T) {
+		_, problem := serveError(t, "PROD", NewError(http.StatusBadRequest, "invalid cursor").Wrap(errors.New("base64 decode failed")))
+
+		assert.Equal(t, "invalid cursor", problem.Detail)
+	})
+}
+
+func TestLogRequestWithInvalidLogLevel(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{ServerLogLevel: "INVALID"})
+	m.server.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
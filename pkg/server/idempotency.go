@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/pgconn"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// set on responses replayed from the idempotency store
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+// longest accepted idempotency key, e.g. a UUID is 36 characters
+const maxIdempotencyKeyLength = 255
+
+// expired idempotency records are removed in the background at this interval
+const idempotencyCleanupInterval = 10 * time.Minute
+
+// response headers that are not replayed
+var skippedIdempotencyHeaders = map[string]bool{
+	echo.HeaderSetCookie:       true,
+	echo.HeaderXRequestID:      true,
+	"Date":                     true,
+	echo.HeaderContentLength:   true,
+	echo.HeaderContentEncoding: true,
+	echo.HeaderVary:            true,
+}
+
+// Identifies the user of a request, so the same key sent by different users does not collide.
+type IdempotencyUserFunc func(c echo.Context) string
+
+// Records the response body written by the handler, while passing it through.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupIdempotencyStore(database *pgconn.Module) IdempotencyStore {
+	switch m.config.IdempotencyStore {
+	case IdempotencyStorePostgres:
+		if database == nil {
+			m.logger.Fatal("postgres idempotency store requires the pgconn module")
+		}
+		return NewPostgresIdempotencyStore(database.GetDB())
+	case IdempotencyStoreMemory:
+		return NewMemoryIdempotencyStore()
+	default:
+		m.logger.Warn("invalid idempotency store, using memory", zap.String("store", m.config.IdempotencyStore))
+		return NewMemoryIdempotencyStore()
+	}
+}
+
+func (m *Module) startIdempotencyStore(ctx context.Context) {
+	if store, ok := m.idempotencyStore.(*PostgresIdempotencyStore); ok && m.config.IdempotencyAutoMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			m.logger.Error("failed to migrate idempotency keys table", zap.Error(err))
+		}
+	}
+
+	cleanupCtx, cancel := context.WithCancel(context.Background())
+	m.stopIdempotencyCleanup = cancel
+	go func() {
+		ticker := time.NewTicker(idempotencyCleanupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-cleanupCtx.Done():
+				return
+			case <-ticker.C:
+				if err := m.idempotencyStore.DeleteExpired(cleanupCtx, time.Now()); err != nil {
+					m.logger.Error("failed to delete expired idempotency records", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Uses the subject of the JWT stored by the token module, or a string stored under "user".
+func defaultIdempotencyUser(c echo.Context) string {
+	switch user := c.Get("user").(type) {
+	case *jwt.Token:
+		if user.Claims == nil {
+			return ""
+		}
+		subject, _ := user.Claims.GetSubject()
+		return subject
+	case string:
+		return user
+	default:
+		return ""
+	}
+}
+
+func (m *Module) getIdempotencyUser(c echo.Context) string {
+	m.middlewareMutex.RLock()
+	userFunc := m.idempotencyUserFunc
+	m.middlewareMutex.RUnlock()
+
+	if userFunc == nil {
+		return defaultIdempotencyUser(c)
+	}
+	return userFunc(c)
+}
+
+// keys are scoped to the user, method and path, and hashed to a fixed length
+func hashIdempotencyKey(key string, user string, method string, path string) string {
+	hash := sha256.New()
+	for _, part := range []string{key, user, method, path} {
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Reads the request body into the fingerprint, and restores it for the handler.
+func fingerprintRequest(req *http.Request) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s?%s\n", req.Method, req.URL.Path, req.URL.RawQuery)
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return "", err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (m *Module) handleIdempotentRequest(c echo.Context, next echo.HandlerFunc) error {
+	req := c.Request()
+	key := req.Header.Get(HeaderIdempotencyKey)
+	if key == "" {
+		if m.config.IdempotencyRequired {
+			return NewError(http.StatusBadRequest, "Idempotency-Key header is required")
+		}
+		return next(c)
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must not exceed %d characters", maxIdempotencyKeyLength))
+	}
+
+	fingerprint, err := fingerprintRequest(req)
+	if err != nil {
+		return NewError(http.StatusBadRequest, "failed to read request body").Wrap(err)
+	}
+
+	ctx := req.Context()
+	now := time.Now()
+	record := &IdempotencyRecord{
+		Key:         hashIdempotencyKey(key, m.getIdempotencyUser(c), req.Method, req.URL.Path),
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		// in-flight records of crashed instances expire after the lock timeout
+		ExpiresAt: now.Add(time.Duration(m.config.IdempotencyLockTimeoutInSeconds) * time.Second),
+	}
+
+	existing, acquired, err := m.idempotencyStore.Begin(ctx, record)
+	if errors.Is(err, ErrIdempotencyKeyReleased) {
+		// the other request failed in the meantime, like an in-progress request the client retries
+		return NewError(http.StatusConflict, "a request with this Idempotency-Key is already in progress").Wrap(err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to begin idempotent request: %w", err)
+	}
+	if !acquired {
+		return m.replayIdempotentResponse(c, existing, fingerprint)
+	}
+
+	return m.recordIdempotentResponse(c, next, record)
+}
+
+func (m *Module) replayIdempotentResponse(c echo.Context, existing *IdempotencyRecord, fingerprint string) error {
+	if existing.Fingerprint != fingerprint {
+		return NewError(http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	}
+	if !existing.Completed {
+		return NewError(http.StatusConflict, "a request with this Idempotency-Key is already in progress")
+	}
+
+	header := c.Response().Header()
+	for name, values := range existing.Header {
+		header[name] = values
+	}
+	header.Set(HeaderIdempotentReplayed, "true")
+
+	return c.Blob(existing.Status, header.Get(echo.HeaderContentType), existing.Body)
+}
+
+func (m *Module) recordIdempotentResponse(c echo.Context, next echo.HandlerFunc, record *IdempotencyRecord) error {
+	ctx := c.Request().Context()
+	res := c.Response()
+	recorder := &responseRecorder{ResponseWriter: res.Writer}
+	res.Writer = recorder
+
+	completed := false
+	defer func() {
+		res.Writer = recorder.ResponseWriter
+		// handler failed or panicked, the request can be retried with the same key
+		if !completed {
+			if releaseErr := m.idempotencyStore.Release(context.WithoutCancel(ctx), record); releaseErr != nil {
+				m.logger.Error("failed to release idempotency key", zap.Error(releaseErr))
+			}
+		}
+	}()
+
+	if err := next(c); err != nil {
+		// the error is rendered here, so the error response can be recorded
+		c.Error(err)
+	}
+
+	// server errors are not recorded, so they can be retried
+	if !res.Committed || res.Status >= http.StatusInternalServerError {
+		return nil
+	}
+
+	record.Status = res.Status
+	record.Header = make(http.Header)
+	for name, values := range res.Header() {
+		if !skippedIdempotencyHeaders[name] {
+			record.Header[name] = values
+		}
+	}
+	record.Body = recorder.body.Bytes()
+	record.ExpiresAt = record.CreatedAt.Add(time.Duration(m.config.IdempotencyTTLInHours) * time.Hour)
+
+	err := m.idempotencyStore.Complete(context.WithoutCancel(ctx), record)
+	if errors.Is(err, ErrIdempotencyKeyTakenOver) {
+		// the request took longer than the lock timeout, the response of the new owner is kept
+		m.logger.Warn("idempotency key acquired by another request, response not stored", zap.String("path", c.Request().URL.Path))
+		return nil
+	}
+	if err != nil {
+		m.logger.Error("failed to store idempotent response", zap.Error(err))
+		return nil
+	}
+	completed = true
+
+	return nil
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Returns an echo middleware that makes POST, PUT, PATCH and DELETE requests with an Idempotency-Key header safe to retry.
+The first response is stored by key, user and route, and replayed for retries with the Idempotent-Replayed header.
+Concurrent requests with the same key are rejected with 409, and keys reused with a different body with 422.
+Server errors are not stored, so the request can be retried with the same key.
+Place it after the authentication middleware, so keys are scoped to the user.
+
+	e.POST("/orders", createOrder, tokenModule.GetJWTMiddleware("jwt_auth"), m.GetIdempotencyMiddleware())
+*/
+func (m *Module) GetIdempotencyMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				return m.handleIdempotentRequest(c, next)
+			default:
+				return next(c)
+			}
+		}
+	}
+}
+
+// Replaces the store of idempotency records, e.g. with NewPostgresIdempotencyStore when using NewServer.
+func (m *Module) SetIdempotencyStore(store IdempotencyStore) {
+	m.idempotencyStore = store
+}
+
+// Replaces how the user of a request is identified, by default the subject of the JWT under "user".
+func (m *Module) SetIdempotencyUserFunc(userFunc IdempotencyUserFunc) {
+	m.middlewareMutex.Lock()
+	defer m.middlewareMutex.Unlock()
+
+	m.idempotencyUserFunc = userFunc
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// idempotency store types
+const (
+	IdempotencyStoreMemory   = "memory"
+	IdempotencyStorePostgres = "postgres"
+)
+
+// Returned by Begin if the existing record was released before it could be read, the request can be retried.
+var ErrIdempotencyKeyReleased = errors.New("idempotency record released concurrently")
+
+// Returned by Complete if the lock of the request expired, and another request acquired the key.
+var ErrIdempotencyKeyTakenOver = errors.New("idempotency key was acquired by another request")
+
+// A request identified by an idempotency key, and its response once completed.
+type IdempotencyRecord struct {
+	Key string
+	// hash of the method, path and body, to detect keys reused for a different request
+	Fingerprint string
+	Completed   bool
+
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// identifies the request that acquired the key, so it does not overwrite a later owner
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+/*
+Persists idempotency records for the idempotency middleware.
+Begin must be atomic, so that only one of several concurrent requests with the same key acquires it.
+*/
+type IdempotencyStore interface {
+	// Stores an in-flight record if none exists for the key, or if the existing one is expired.
+	// Returns the existing record and false otherwise, or ErrIdempotencyKeyReleased if it was removed meanwhile.
+	Begin(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, bool, error)
+	// Stores the response of an acquired key. Returns ErrIdempotencyKeyTakenOver if the record
+	// was replaced by a request with another CreatedAt, after the lock expired.
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	// Removes the record of an acquired key, so the request can be retried.
+	// Records of other requests, with another CreatedAt, are kept.
+	Release(ctx context.Context, record *IdempotencyRecord) error
+	// Removes records that expired before the given time.
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// In-memory IdempotencyStore, for development, tests and single instance deployments.
+type MemoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	records map[string]*IdempotencyRecord
+}
+
+// Row of the idempotency_keys table used by PostgresIdempotencyStore.
+type IdempotencyKeyRecord struct {
+	Key         string `gorm:"primaryKey;size:64"`
+	Fingerprint string `gorm:"size:64"`
+	Completed   bool
+	Status      int
+	Header      []byte `gorm:"type:jsonb"`
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+func (IdempotencyKeyRecord) TableName() string {
+	return "idempotency_keys"
+}
+
+// IdempotencyStore backed by postgres through the pgconn module, for deployments with multiple instances.
+type PostgresIdempotencyStore struct {
+	db *gorm.DB
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: make(map[string]*IdempotencyRecord),
+	}
+}
+
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, ok := s.records[record.Key]
+	if ok && time.Now().Before(existing.ExpiresAt) {
+		copied := *existing
+		return &copied, false, nil
+	}
+
+	stored := *record
+	s.records[record.Key] = &stored
+	return nil, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	existing, ok := s.records[record.Key]
+	if !ok || !existing.CreatedAt.Equal(record.CreatedAt) {
+		return ErrIdempotencyKeyTakenOver
+	}
+
+	stored := *record
+	stored.Completed = true
+	s.records[record.Key] = &stored
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, record *IdempotencyRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if existing, ok := s.records[record.Key]; ok && existing.CreatedAt.Equal(record.CreatedAt) {
+		delete(s.records, record.Key)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, record := range s.records {
+		if record.ExpiresAt.Before(before) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// Use pgconn.Module.GetDB() to obtain the database.
+func NewPostgresIdempotencyStore(db *gorm.DB) *PostgresIdempotencyStore {
+	return &PostgresIdempotencyStore{db: db}
+}
+
+// Creates or updates the idempotency_keys table.
+func (s *PostgresIdempotencyStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&IdempotencyKeyRecord{})
+}
+
+func (s *PostgresIdempotencyStore) Begin(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, bool, error) {
+	row, err := toIdempotencyKeyRecord(record)
+	if err != nil {
+		return nil, false, err
+	}
+
+	db := s.db.WithContext(ctx)
+
+	// expired records are taken over, the primary key makes sure only one request succeeds
+	err = db.Where("key = ? AND expires_at < ?", record.Key, time.Now()).Delete(&IdempotencyKeyRecord{}).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(row)
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, true, nil
+	}
+
+	var existing IdempotencyKeyRecord
+	err = db.Where("key = ?", record.Key).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// released in the meantime, the client can retry
+		return nil, false, ErrIdempotencyKeyReleased
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	found, err := fromIdempotencyKeyRecord(&existing)
+	if err != nil {
+		return nil, false, err
+	}
+	return found, false, nil
+}
+
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, record *IdempotencyRecord) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	result := s.db.WithContext(ctx).Model(&IdempotencyKeyRecord{}).
+		Where("key = ? AND created_at = ?", record.Key, toPostgresTime(record.CreatedAt)).
+		Updates(map[string]interface{}{
+			"completed":  true,
+			"status":     record.Status,
+			"header":     header,
+			"body":       record.Body,
+			"expires_at": record.ExpiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdempotencyKeyTakenOver
+	}
+	return nil
+}
+
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, record *IdempotencyRecord) error {
+	return s.db.WithContext(ctx).
+		Where("key = ? AND created_at = ?", record.Key, toPostgresTime(record.CreatedAt)).
+		Delete(&IdempotencyKeyRecord{}).Error
+}
+
+func (s *PostgresIdempotencyStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&IdempotencyKeyRecord{}).Error
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func toIdempotencyKeyRecord(record *IdempotencyRecord) (*IdempotencyKeyRecord, error) {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return nil, err
+	}
+	return &IdempotencyKeyRecord{
+		Key:         record.Key,
+		Fingerprint: record.Fingerprint,
+		Completed:   record.Completed,
+		Status:      record.Status,
+		Header:      header,
+		Body:        record.Body,
+		CreatedAt:   toPostgresTime(record.CreatedAt),
+		ExpiresAt:   record.ExpiresAt,
+	}, nil
+}
+
+// Postgres stores microseconds, so CreatedAt is compared at that precision.
+func toPostgresTime(t time.Time) time.Time {
+	return t.Truncate(time.Microsecond)
+}
+
+func fromIdempotencyKeyRecord(row *IdempotencyKeyRecord) (*IdempotencyRecord, error) {
+	record := &IdempotencyRecord{
+		Key:         row.Key,
+		Fingerprint: row.Fingerprint,
+		Completed:   row.Completed,
+		Status:      row.Status,
+		Body:        row.Body,
+		CreatedAt:   row.CreatedAt,
+		ExpiresAt:   row.ExpiresAt,
+	}
+	if len(row.Header) > 0 {
+		if err := json.Unmarshal(row.Header, &record.Header); err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
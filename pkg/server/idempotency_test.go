@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newModuleWithIdempotency() *Module {
+	m := newModuleWithMiddleware(&Config{
+		ServerLogLevel:                  "PROD",
+		IdempotencyTTLInHours:           DefaultIdempotencyTTLInHours,
+		IdempotencyLockTimeoutInSeconds: DefaultIdempotencyLockTimeoutInSeconds,
+	})
+	m.server.HTTPErrorHandler = m.handleHTTPError
+	m.idempotencyStore = NewMemoryIdempotencyStore()
+	return m
+}
+
+func sendIdempotent(m *Module, method string, path string, key string, body string, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	rec := httptest.NewRecorder()
+	m.server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	m := newModuleWithIdempotency()
+
+	var created int32
+	m.server.POST("/orders", func(c echo.Context) error {
+		id := atomic.AddInt32(&created, 1)
+		c.Response().Header().Set("X-Order", string(rune('0'+id)))
+		return c.JSON(http.StatusCreated, map[string]int32{"id": id})
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", c.Request().Header.Get("X-User"))
+			return next(c)
+		}
+	}, m.GetIdempotencyMiddleware())
+
+	t.Run("TestReplay", func(t *testing.T) {
+		first := sendIdempotent(m, http.MethodPost, "/orders", "key-1", `{"item":"a"}`, "alice")
+		assert.Equal(t, http.StatusCreated, first.Code)
+		assert.Empty(t, first.Header().Get(HeaderIdempotentReplayed))
+
+		second := sendIdempotent(m, http.MethodPost, "/orders", "key-1", `{"item":"a"}`, "alice")
+		assert.Equal(t, http.StatusCreated, second.Code)
+		assert.Equal(t, "true", second.Header().Get(HeaderIdempotentReplayed))
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, first.Header().Get("X-Order"), second.Header().Get("X-Order"))
+		assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, second.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+	})
+
+	t.Run("TestDifferentBody", func(t *testing.T) {
+		res := sendIdempotent(m, http.MethodPost, "/orders", "key-1", `{"item":"b"}`, "alice")
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+	})
+
+	t.Run("TestDifferentUser", func(t *testing.T) {
+		res := sendIdempotent(m, http.MethodPost, "/orders", "key-1", `{"item":"a"}`, "bob")
+		assert.Equal(t, http.StatusCreated, res.Code)
+		assert.Empty(t, res.Header().Get(HeaderIdempotentReplayed))
+	})
+
+	t.Run("TestWithoutKey", func(t *testing.T) {
+		before := atomic.LoadInt32(&created)
+		sendIdempotent(m, http.MethodPost, "/orders", "", `{"item":"a"}`, "alice")
+		sendIdempotent(m, http.MethodPost, "/orders", "", `{"item":"a"}`, "alice")
+		assert.Equal(t, before+2, atomic.LoadInt32(&created))
+	})
+
+	t.Run("TestKeyTooLong", func(t *testing.T) {
+		res := sendIdempotent(m, http.MethodPost, "/orders", strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`, "alice")
+		assert.Equal(t, http.StatusBadRequest, res.Code)
+	})
+}
+
+func TestIdempotencyRequired(t *testing.T) {
+	m := newModuleWithIdempotency()
+	m.config.IdempotencyRequired = true
+	m.server.POST("/orders", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetIdempotencyMiddleware())
+	m.server.GET("/orders", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetIdempotencyMiddleware())
+
+	assert.Equal(t, http.StatusBadRequest, sendIdempotent(m, http.MethodPost, "/orders", "", "", "").Code)
+	assert.Equal(t, http.StatusNoContent, sendIdempotent(m, http.MethodPost, "/orders", "key", "", "").Code)
+	// safe methods are not affected
+	assert.Equal(t, http.StatusNoContent, sendIdempotent(m, http.MethodGet, "/orders", "", "", "").Code)
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	m := newModuleWithIdempotency()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	m.server.POST("/payments", func(c echo.Context) error {
+		close(started)
+		<-release
+		return c.NoContent(http.StatusCreated)
+	}, m.GetIdempotencyMiddleware())
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- sendIdempotent(m, http.MethodPost, "/payments", "key", "", "")
+	}()
+	<-started
+
+	res := sendIdempotent(m, http.MethodPost, "/payments", "key", "", "")
+	assert.Equal(t, http.StatusConflict, res.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+}
+
+func TestIdempotencyServerErrorsAreReleased(t *testing.T) {
+	m := newModuleWithIdempotency()
+
+	var calls int32
+	m.server.POST("/payments", func(c echo.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return NewError(http.StatusServiceUnavailable, "provider unavailable")
+		}
+		return c.NoContent(http.StatusCreated)
+	}, m.GetIdempotencyMiddleware())
+
+	assert.Equal(t, http.StatusServiceUnavailable, sendIdempotent(m, http.MethodPost, "/payments", "key", "", "").Code)
+	assert.Equal(t, http.StatusCreated, sendIdempotent(m, http.MethodPost, "/payments", "key", "", "").Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotencyClientErrorsAreReplayed(t *testing.T) {
+	m := newModuleWithIdempotency()
+
+	var calls int32
+	m.server.POST("/payments", func(c echo.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return NewError(http.StatusBadRequest, "insufficient funds")
+	}, m.GetIdempotencyMiddleware())
+
+	first := sendIdempotent(m, http.MethodPost, "/payments", "key", "", "")
+	second := sendIdempotent(m, http.MethodPost, "/payments", "key", "", "")
+	assert.Equal(t, http.StatusBadRequest, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "true", second.Header().Get(HeaderIdempotentReplayed))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+// Fails like the postgres store, when the existing record is released between the insert and the read.
+type releasingIdempotencyStore struct {
+	*MemoryIdempotencyStore
+}
+
+func (s *releasingIdempotencyStore) Begin(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, bool, error) {
+	return nil, false, ErrIdempotencyKeyReleased
+}
+
+func TestIdempotencyKeyReleased(t *testing.T) {
+	m := newModuleWithIdempotency()
+	m.idempotencyStore = &releasingIdempotencyStore{NewMemoryIdempotencyStore()}
+	m.server.POST("/orders", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	}, m.GetIdempotencyMiddleware())
+
+	res := sendIdempotent(m, http.MethodPost, "/orders", "key", "{}", "")
+	assert.Equal(t, http.StatusConflict, res.Code)
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryIdempotencyStore()
+	now := time.Now()
+
+	record := &IdempotencyRecord{Key: "key", Fingerprint: "fp", CreatedAt: now, ExpiresAt: now.Add(time.Minute)}
+
+	_, acquired, err := store.Begin(ctx, record)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	existing, acquired, err := store.Begin(ctx, record)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.False(t, existing.Completed)
+
+	record.Status = http.StatusCreated
+	record.Body = []byte("created")
+	assert.NoError(t, store.Complete(ctx, record))
+
+	existing, _, _ = store.Begin(ctx, record)
+	assert.True(t, existing.Completed)
+	assert.Equal(t, []byte("created"), existing.Body)
+
+	t.Run("TestRelease", func(t *testing.T) {
+		assert.NoError(t, store.Release(ctx, record))
+		_, acquired, _ := store.Begin(ctx, record)
+		assert.True(t, acquired)
+	})
+
+	t.Run("TestTakenOver", func(t *testing.T) {
+		stale := &IdempotencyRecord{Key: "slow", CreatedAt: now, ExpiresAt: now.Add(-time.Second)}
+		store.Begin(ctx, stale)
+
+		// the lock expired, so another request acquires the key
+		owner := &IdempotencyRecord{Key: "slow", CreatedAt: now.Add(time.Second), ExpiresAt: now.Add(time.Minute)}
+		_, acquired, _ := store.Begin(ctx, owner)
+		require.True(t, acquired)
+
+		stale.Status = http.StatusCreated
+		assert.ErrorIs(t, store.Complete(ctx, stale), ErrIdempotencyKeyTakenOver)
+		assert.NoError(t, store.Release(ctx, stale))
+
+		existing, acquired, _ := store.Begin(ctx, owner)
+		require.False(t, acquired)
+		assert.False(t, existing.Completed)
+		assert.True(t, existing.CreatedAt.Equal(owner.CreatedAt))
+	})
+
+	t.Run("TestExpired", func(t *testing.T) {
+		expired := &IdempotencyRecord{Key: "expired", ExpiresAt: now.Add(-time.Second)}
+		store.Begin(ctx, expired)
+
+		// expired records are taken over
+		_, acquired, _ := store.Begin(ctx, expired)
+		assert.True(t, acquired)
+
+		assert.NoError(t, store.DeleteExpired(ctx, now))
+		assert.NotContains(t, store.records, "expired")
+		assert.Contains(t, store.records, "key")
+	})
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+//! INTERNAL ---------------------------------------------------------------
+
+// Publishes the public keys of the configured token scopes at /.well-known/jwks.json.
+func (m *Module) setUpJWKSRoute() {
+	if m.config.JWKSTokenScopes == "" {
+		return
+	}
+	if m.token == nil {
+		m.logger.Fatal("jwks token scopes require the token module")
+	}
+
+	scopes := strings.Split(m.config.JWKSTokenScopes, ",")
+	for i := range scopes {
+		scopes[i] = strings.TrimSpace(scopes[i])
+	}
+
+	m.server.GET(token.JWKSPath, m.token.GetJWKSHandler(scopes...))
+}
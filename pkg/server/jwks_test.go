@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+func TestJWKSRoute(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	m := newModuleWithMiddleware(&Config{ServerLogLevel: "PROD"})
+	m.setUpJWKSRoute()
+	assert.Equal(t, http.StatusNotFound, sendDebug(m, http.MethodGet, token.JWKSPath, nil).Code)
+
+	m = newModuleWithMiddleware(&Config{ServerLogLevel: "PROD", JWKSTokenScopes: "jwt_auth, jwt_email"})
+	m.token = token.NewTokenManager("token", zap.NewNop(), "jwt_auth", "jwt_email")
+	m.setUpJWKSRoute()
+
+	res := sendDebug(m, http.MethodGet, token.JWKSPath, nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"keys":[]}`, res.Body.String())
+	assert.True(t, m.isOwnRoute(m.server.Routes()[0]))
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/bytes"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Named positions of the standard middleware chain, listed in execution order.
+// Use them with UseBefore and UseAfter to insert application middleware.
+const (
+	MiddlewareRecover       = "recover"
+	MiddlewareRequestID     = "request_id"
+	MiddlewareRequestLogger = "request_logger"
+	MiddlewareSecure        = "secure"
+	MiddlewareCORS          = "cors"
+	MiddlewareBodyLimit     = "body_limit"
+	MiddlewareGzip          = "gzip"
+	MiddlewareRateLimit     = "rate_limit"
+	MiddlewareTimeout       = "timeout"
+	MiddlewareCSRF          = "csrf"
+)
+
+var middlewareOrder = []string{
+	MiddlewareRecover,
+	MiddlewareRequestID,
+	MiddlewareRequestLogger,
+	MiddlewareSecure,
+	MiddlewareCORS,
+	MiddlewareBodyLimit,
+	MiddlewareGzip,
+	MiddlewareRateLimit,
+	MiddlewareTimeout,
+	MiddlewareCSRF,
+}
+
+// application middleware registered around a named position
+type customMiddlewares struct {
+	before []echo.MiddlewareFunc
+	after  []echo.MiddlewareFunc
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// Builds the standard middleware from config and registers the chain on the server.
+// Disabled middleware is stored as nil and skipped.
+func (m *Module) setUpMiddlewareChain() {
+	m.middlewareMutex.Lock()
+	m.middlewares = map[string]echo.MiddlewareFunc{
+		MiddlewareRecover:       m.newRecoverMiddleware(),
+		MiddlewareRequestID:     m.newRequestIDMiddleware(),
+		MiddlewareRequestLogger: m.newRequestLoggerMiddleware(),
+		MiddlewareSecure:        m.newSecureMiddleware(),
+		MiddlewareCORS:          m.newCorsMiddleware(),
+		MiddlewareBodyLimit:     m.newBodyLimitMiddleware(),
+		MiddlewareGzip:          m.newGzipMiddleware(),
+		MiddlewareRateLimit:     m.newRateLimitMiddleware(),
+		MiddlewareTimeout:       m.newTimeoutMiddleware(),
+		MiddlewareCSRF:          m.newCSRFMiddleware(),
+	}
+	m.middlewareMutex.Unlock()
+
+	m.server.Use(m.applyMiddlewareChain)
+}
+
+// Echo composes middleware on every request, so the chain is resolved at request time.
+// This allows application middleware to be inserted after the server has started,
+// which is the case when the module is instantiated with NewServer.
+func (m *Module) applyMiddlewareChain(next echo.HandlerFunc) echo.HandlerFunc {
+	chain := m.getMiddlewareChain()
+
+	h := next
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h
+}
+
+func (m *Module) getMiddlewareChain() []echo.MiddlewareFunc {
+	m.middlewareMutex.RLock()
+	defer m.middlewareMutex.RUnlock()
+
+	chain := []echo.MiddlewareFunc{}
+	for _, position := range middlewareOrder {
+		custom := m.customMiddlewares[position]
+		if custom != nil {
+			chain = append(chain, custom.before...)
+		}
+		if mw := m.middlewares[position]; mw != nil {
+			chain = append(chain, mw)
+		}
+		if custom != nil {
+			chain = append(chain, custom.after...)
+		}
+	}
+	return chain
+}
+
+func (m *Module) addCustomMiddlewares(position string, before bool, middlewares ...echo.MiddlewareFunc) error {
+	if !isValidMiddlewarePosition(position) {
+		return fmt.Errorf("invalid middleware position: %s", position)
+	}
+
+	m.middlewareMutex.Lock()
+	defer m.middlewareMutex.Unlock()
+
+	if m.customMiddlewares == nil {
+		m.customMiddlewares = make(map[string]*customMiddlewares)
+	}
+	custom, exists := m.customMiddlewares[position]
+	if !exists {
+		custom = &customMiddlewares{}
+		m.customMiddlewares[position] = custom
+	}
+
+	if before {
+		custom.before = append(custom.before, middlewares...)
+	} else {
+		custom.after = append(custom.after, middlewares...)
+	}
+	return nil
+}
+
+func isValidMiddlewarePosition(position string) bool {
+	for _, p := range middlewareOrder {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Module) newRecoverMiddleware() echo.MiddlewareFunc {
+	if !m.config.Recover {
+		return nil
+	}
+	return middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogErrorFunc: func(c echo.Context, err error, stack []byte) error {
+			m.logger.Error("recovered from panic",
+				zap.Error(err),
+				zap.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+				zap.ByteString("stack", stack),
+			)
+			return err
+		},
+	})
+}
+
+func (m *Module) newRequestIDMiddleware() echo.MiddlewareFunc {
+	if !m.config.RequestID {
+		return nil
+	}
+	return middleware.RequestID()
+}
+
+func (m *Module) newSecureMiddleware() echo.MiddlewareFunc {
+	if !m.config.SecureHeaders {
+		return nil
+	}
+	return middleware.SecureWithConfig(middleware.SecureConfig{
+		XSSProtection:         middleware.DefaultSecureConfig.XSSProtection,
+		ContentTypeNosniff:    middleware.DefaultSecureConfig.ContentTypeNosniff,
+		XFrameOptions:         middleware.DefaultSecureConfig.XFrameOptions,
+		HSTSMaxAge:            m.config.HSTSMaxAge,
+		HSTSExcludeSubdomains: m.config.HSTSExcludeSubdomains,
+		HSTSPreloadEnabled:    m.config.HSTSPreload,
+		ContentSecurityPolicy: m.config.ContentSecurityPolicy,
+	})
+}
+
+func (m *Module) newBodyLimitMiddleware() echo.MiddlewareFunc {
+	// defaults to no body limit if unspecified
+	if m.config.BodyLimit == "" {
+		return nil
+	}
+	// echo panics on invalid limits, so the value is validated first
+	if _, err := bytes.Parse(m.config.BodyLimit); err != nil {
+		m.logger.Error("invalid body limit, skipping", zap.String("body_limit", m.config.BodyLimit), zap.Error(err))
+		return nil
+	}
+	return middleware.BodyLimit(m.config.BodyLimit)
+}
+
+func (m *Module) newGzipMiddleware() echo.MiddlewareFunc {
+	if !m.config.Gzip {
+		return nil
+	}
+	return middleware.GzipWithConfig(middleware.GzipConfig{
+		Skipper: func(c echo.Context) bool {
+			return m.isStreamRequest(c) || m.isStaticRequest(c)
+		},
+		Level: m.config.GzipLevel,
+	})
+}
+
+func (m *Module) newRateLimitMiddleware() echo.MiddlewareFunc {
+	if !m.config.RateLimit {
+		return nil
+	}
+	// requests are identified by c.RealIP()
+	store := middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+		Rate:      rate.Limit(m.config.RateLimitRPS),
+		Burst:     m.config.RateLimitBurst,
+		ExpiresIn: time.Duration(m.config.RateLimitExpiresInSeconds) * time.Second,
+	})
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: store,
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+		},
+	})
+}
+
+func (m *Module) newTimeoutMiddleware() echo.MiddlewareFunc {
+	// defaults to no timeout if unspecified
+	// handlers are expected to respect the request context deadline
+	if m.config.TimeoutInSeconds <= 0 {
+		return nil
+	}
+	return middleware.ContextTimeoutWithConfig(middleware.ContextTimeoutConfig{
+		// profiles and traces run for a requested duration, 30 seconds by default
+		Skipper: func(c echo.Context) bool {
+			return m.isStreamRequest(c) || m.isDebugRoute(c.Path())
+		},
+		Timeout: time.Duration(m.config.TimeoutInSeconds) * time.Second,
+	})
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Inserts application middleware before the named position in the standard chain.
+// Middleware is applied even if the standard middleware at that position is disabled.
+// Valid positions are the Middleware* constants.
+func (m *Module) UseBefore(position string, middlewares ...echo.MiddlewareFunc) error {
+	return m.addCustomMiddlewares(position, true, middlewares...)
+}
+
+// Inserts application middleware after the named position in the standard chain.
+// Middleware is applied even if the standard middleware at that position is disabled.
+// Valid positions are the Middleware* constants.
+func (m *Module) UseAfter(position string, middlewares ...echo.MiddlewareFunc) error {
+	return m.addCustomMiddlewares(position, false, middlewares...)
+}
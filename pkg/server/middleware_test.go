@@ -0,0 +1,185 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newModuleWithMiddleware(config *Config) *Module {
+	m := &Module{
+		scope:       "server",
+		config:      config,
+		server:      echo.New(),
+		connections: newConnectionRegistry(),
+		logger:      zap.NewNop(),
+	}
+	m.setUpMiddlewareChain()
+	return m
+}
+
+func TestMiddlewareChainOrder(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{ServerLogLevel: "PROD"})
+
+	calls := []string{}
+	record := func(name string) echo.MiddlewareFunc {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				calls = append(calls, name)
+				return next(c)
+			}
+		}
+	}
+
+	assert.NoError(t, m.UseAfter(MiddlewareCSRF, record("after_csrf")))
+	assert.NoError(t, m.UseBefore(MiddlewareRecover, record("before_recover")))
+	assert.NoError(t, m.UseAfter(MiddlewareRecover, record("after_recover")))
+	assert.NoError(t, m.UseBefore(MiddlewareCSRF, record("before_csrf")))
+
+	m.server.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"before_recover", "after_recover", "before_csrf", "after_csrf"}, calls)
+}
+
+func TestUseWithInvalidPosition(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{ServerLogLevel: "PROD"})
+
+	err := m.UseBefore("invalid", func(next echo.HandlerFunc) echo.HandlerFunc { return next })
+	assert.Error(t, err)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{Recover: true, RequestID: true, ServerLogLevel: "PROD"})
+	m.server.GET("/", func(c echo.Context) error {
+		panic("test panic")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(echo.HeaderXRequestID))
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	t.Run("TestBodyOverLimit", func(t *testing.T) {
+		m := newModuleWithMiddleware(&Config{BodyLimit: "1K", ServerLogLevel: "PROD"})
+		m.server.POST("/", func(c echo.Context) error {
+			return c.String(http.StatusOK, "OK")
+		})
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 2048)))
+		m.server.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	})
+
+	t.Run("TestInvalidBodyLimit", func(t *testing.T) {
+		m := &Module{
+			config: &Config{BodyLimit: "invalid"},
+			logger: zap.NewNop(),
+		}
+		assert.Nil(t, m.newBodyLimitMiddleware())
+	})
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{Gzip: true, GzipLevel: DefaultGzipLevel, ServerLogLevel: "PROD"})
+	m.server.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, "gzip", rec.Header().Get(echo.HeaderContentEncoding))
+}
+
+func TestSecureMiddleware(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{
+		SecureHeaders:         true,
+		HSTSMaxAge:            3600,
+		ContentSecurityPolicy: "default-src 'self'",
+		ServerLogLevel:        "PROD",
+	})
+	m.server.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderXForwardedProto, "https")
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, "nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+	assert.Equal(t, "max-age=3600; includeSubdomains", rec.Header().Get(echo.HeaderStrictTransportSecurity))
+	assert.Equal(t, "default-src 'self'", rec.Header().Get(echo.HeaderContentSecurityPolicy))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{
+		RateLimit:                 true,
+		RateLimitRPS:              1,
+		RateLimitBurst:            1,
+		RateLimitExpiresInSeconds: 60,
+		ServerLogLevel:            "PROD",
+	})
+	m.server.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "OK")
+	})
+
+	send := func() int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		m.server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send())
+	assert.Equal(t, http.StatusTooManyRequests, send())
+}
+
+func TestTimeoutMiddleware(t *testing.T) {
+	m := newModuleWithMiddleware(&Config{TimeoutInSeconds: 5, ServerLogLevel: "PROD"})
+	m.server.GET("/", func(c echo.Context) error {
+		_, hasDeadline := c.Request().Context().Deadline()
+		assert.True(t, hasDeadline)
+		return c.String(http.StatusOK, "OK")
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	t.Run("TestDebugRoutesSkipped", func(t *testing.T) {
+		m := newModuleWithMiddleware(&Config{TimeoutInSeconds: 5, ServerLogLevel: "PROD", Debug: true, DebugPath: DefaultDebugPath})
+		m.server.GET("/debug/pprof/:profile", func(c echo.Context) error {
+			_, hasDeadline := c.Request().Context().Deadline()
+			assert.False(t, hasDeadline)
+			return c.String(http.StatusOK, "OK")
+		})
+
+		rec := httptest.NewRecorder()
+		m.server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/pprof/profile", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
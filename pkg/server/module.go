@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -14,6 +15,8 @@ import (
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
+	"github.com/alsey89/gogetter/pkg/pgconn"
+	"github.com/alsey89/gogetter/pkg/token"
 	"github.com/alsey89/gogetter/pkg/util"
 )
 
@@ -21,10 +24,30 @@ import (
 
 // to be provided to the fx framework
 type Module struct {
-	config *Config
-	logger *zap.Logger
-	scope  string
-	server *echo.Echo
+	config      *Config
+	logger      *zap.Logger
+	scope       string
+	server      *echo.Echo
+	validator   *requestValidator
+	connections *connectionRegistry
+
+	middlewareMutex   sync.RWMutex
+	middlewares       map[string]echo.MiddlewareFunc
+	customMiddlewares map[string]*customMiddlewares
+
+	routeDocsMutex sync.RWMutex
+	routeDocs      map[string]RouteDoc
+
+	static staticFiles
+
+	idempotencyStore       IdempotencyStore
+	idempotencyUserFunc    IdempotencyUserFunc
+	stopIdempotencyCleanup context.CancelFunc
+
+	responseCache ResponseCache
+
+	token    *token.Module
+	dotGraph fx.DotGraph
 }
 
 // injected through the fx framework
@@ -32,6 +55,17 @@ type Params struct {
 	fx.In
 	Lifecycle fx.Lifecycle
 	Logger    *zap.Logger
+
+	// required by the postgres idempotency store
+	Database *pgconn.Module `optional:"true"`
+	// replaces the configured idempotency store
+	IdempotencyStore IdempotencyStore `optional:"true"`
+	// replaces the in-memory response cache
+	ResponseCache ResponseCache `optional:"true"`
+	// required to protect the debug routes with a token scope, and to publish the JWKS
+	Token *token.Module `optional:"true"`
+	// served by the debug routes
+	DotGraph fx.DotGraph `optional:"true"`
 }
 
 // holds configurations for the module
@@ -46,7 +80,62 @@ type Config struct {
 
 	Host           string
 	Port           int
+	Listen         bool
 	ServerLogLevel string
+
+	Recover   bool
+	RequestID bool
+	BodyLimit string
+
+	Gzip      bool
+	GzipLevel int
+
+	TimeoutInSeconds int
+
+	SecureHeaders         bool
+	HSTSMaxAge            int
+	HSTSExcludeSubdomains bool
+	HSTSPreload           bool
+	ContentSecurityPolicy string
+
+	RateLimit                 bool
+	RateLimitRPS              float64
+	RateLimitBurst            int
+	RateLimitExpiresInSeconds int
+
+	OpenAPI         bool
+	OpenAPIPath     string
+	OpenAPIDocsPath string
+	OpenAPITitle    string
+	OpenAPIVersion  string
+
+	StreamHeartbeatInSeconds int
+	StreamSendBuffer         int
+	StreamMaxConnections     int
+	WebSocketMaxMessageBytes int
+
+	StaticDir             string
+	StaticPrefix          string
+	StaticIndex           string
+	StaticSPA             bool
+	StaticExcludePaths    string
+	StaticMaxAgeInSeconds int
+	StaticPrecompressed   bool
+
+	IdempotencyStore                string
+	IdempotencyRequired             bool
+	IdempotencyTTLInHours           int
+	IdempotencyLockTimeoutInSeconds int
+	IdempotencyAutoMigrate          bool
+
+	CacheMaxEntries   int
+	CacheTTLInSeconds int
+
+	Debug           bool
+	DebugPath       string
+	DebugTokenScope string
+
+	JWKSTokenScopes string
 }
 
 // default values
@@ -61,26 +150,72 @@ const (
 
 	DefaultHost           = "localhost"
 	DefaultPort           = 3001
+	DefaultListen         = true
 	DefaultServerLogLevel = "PROD"
+
+	DefaultRecover   = true
+	DefaultRequestID = true
+	DefaultBodyLimit = ""
+
+	DefaultGzip      = false
+	DefaultGzipLevel = -1
+
+	DefaultTimeoutInSeconds = 0
+
+	DefaultSecureHeaders         = false
+	DefaultHSTSMaxAge            = 0
+	DefaultHSTSExcludeSubdomains = false
+	DefaultHSTSPreload           = false
+	DefaultContentSecurityPolicy = ""
+
+	DefaultRateLimit                 = false
+	DefaultRateLimitRPS              = 10.0
+	DefaultRateLimitBurst            = 20
+	DefaultRateLimitExpiresInSeconds = 180
+
+	DefaultOpenAPI         = false
+	DefaultOpenAPIPath     = "/openapi.json"
+	DefaultOpenAPIDocsPath = "/docs"
+	DefaultOpenAPITitle    = "API"
+	DefaultOpenAPIVersion  = "1.0.0"
+
+	DefaultStreamHeartbeatInSeconds = 30
+	DefaultStreamSendBuffer         = 64
+	DefaultStreamMaxConnections     = 0
+	DefaultWebSocketMaxMessageBytes = 65536
+
+	DefaultStaticDir             = ""
+	DefaultStaticPrefix          = "/"
+	DefaultStaticIndex           = "index.html"
+	DefaultStaticSPA             = false
+	DefaultStaticExcludePaths    = "/api"
+	DefaultStaticMaxAgeInSeconds = 0
+	DefaultStaticPrecompressed   = true
+
+	DefaultIdempotencyStore                = IdempotencyStoreMemory
+	DefaultIdempotencyRequired             = false
+	DefaultIdempotencyTTLInHours           = 24
+	DefaultIdempotencyLockTimeoutInSeconds = 60
+	DefaultIdempotencyAutoMigrate          = true
+
+	DefaultCacheMaxEntries   = 1000
+	DefaultCacheTTLInSeconds = 60
+
+	DefaultDebug           = false
+	DefaultDebugPath       = "/debug"
+	DefaultDebugTokenScope = ""
+
+	DefaultJWKSTokenScopes = ""
 )
 
 //! MODULE ---------------------------------------------------------------
 
 // Provides the Module struct to the fx framework, and registers lifecycle hooks.
+// Only one unnamed server can be injected per app, use InjectNamedModule for additional listeners.
 func InjectModule(scope string) fx.Option {
 	return fx.Module(
 		scope,
-		fx.Provide(func(p Params) *Module {
-
-			m := &Module{
-				scope: scope,
-			}
-			m.config = m.setupConfig(scope)
-			m.logger = m.setupLogger(scope, p)
-			m.server = m.setupServer()
-
-			return m
-		}),
+		fx.Provide(provideModule(scope)),
 		fx.Invoke(func(m *Module, p Params) {
 			p.Lifecycle.Append(fx.Hook{
 				OnStart: m.onStart,
@@ -90,6 +225,32 @@ func InjectModule(scope string) fx.Option {
 	)
 }
 
+/*
+Provides the Module struct to the fx framework under the name tag of the scope, and registers lifecycle hooks.
+Used to run additional listeners next to the main server, e.g. an internal admin port.
+Each instance reads its own configuration from its scope, including host, port and middleware.
+
+	server.InjectModule("server"),
+	server.InjectNamedModule("admin"),
+	fx.Invoke(fx.Annotate(func(admin *server.Module) {
+		admin.GetServer().GET("/health", healthHandler)
+	}, fx.ParamTags(`name:"admin"`))),
+*/
+func InjectNamedModule(scope string) fx.Option {
+	nameTag := fmt.Sprintf(`name:"%s"`, scope)
+
+	return fx.Module(
+		scope,
+		fx.Provide(fx.Annotate(provideModule(scope), fx.ResultTags(nameTag))),
+		fx.Invoke(fx.Annotate(func(m *Module, lc fx.Lifecycle) {
+			lc.Append(fx.Hook{
+				OnStart: m.onStart,
+				OnStop:  m.onStop,
+			})
+		}, fx.ParamTags(nameTag))),
+	)
+}
+
 // Instantiates new Module without using the fx framework.
 func NewServer(scope string, logger *zap.Logger) *Module {
 	m := &Module{
@@ -98,6 +259,7 @@ func NewServer(scope string, logger *zap.Logger) *Module {
 	m.logger = logger.Named("[" + scope + "]")
 	m.config = m.setupConfig(scope)
 	m.server = m.setupServer()
+	m.responseCache = m.setupResponseCache()
 
 	m.onStart(context.Background())
 
@@ -106,6 +268,32 @@ func NewServer(scope string, logger *zap.Logger) *Module {
 
 // ! INTERNAL ---------------------------------------------------------------
 
+func provideModule(scope string) func(p Params) *Module {
+	return func(p Params) *Module {
+
+		m := &Module{
+			scope: scope,
+		}
+		m.config = m.setupConfig(scope)
+		m.logger = m.setupLogger(scope, p)
+		m.server = m.setupServer()
+		if p.IdempotencyStore != nil {
+			m.idempotencyStore = p.IdempotencyStore
+		} else {
+			m.idempotencyStore = m.setupIdempotencyStore(p.Database)
+		}
+		if p.ResponseCache != nil {
+			m.responseCache = p.ResponseCache
+		} else {
+			m.responseCache = m.setupResponseCache()
+		}
+		m.token = p.Token
+		m.dotGraph = p.DotGraph
+
+		return m
+	}
+}
+
 func (m *Module) setupConfig(scope string) *Config {
 	// searches for pattern: "scope.key"
 	viper.SetDefault(util.GetConfigPath(scope, "allow_headers"), DefaultAllowHeaders)
@@ -118,8 +306,63 @@ func (m *Module) setupConfig(scope string) *Config {
 
 	viper.SetDefault(util.GetConfigPath(scope, "host"), DefaultHost)
 	viper.SetDefault(util.GetConfigPath(scope, "port"), DefaultPort)
+	viper.SetDefault(util.GetConfigPath(scope, "listen"), DefaultListen)
 	viper.SetDefault(util.GetConfigPath(scope, "server_log_level"), DefaultServerLogLevel)
 
+	viper.SetDefault(util.GetConfigPath(scope, "recover"), DefaultRecover)
+	viper.SetDefault(util.GetConfigPath(scope, "request_id"), DefaultRequestID)
+	viper.SetDefault(util.GetConfigPath(scope, "body_limit"), DefaultBodyLimit)
+
+	viper.SetDefault(util.GetConfigPath(scope, "gzip"), DefaultGzip)
+	viper.SetDefault(util.GetConfigPath(scope, "gzip_level"), DefaultGzipLevel)
+
+	viper.SetDefault(util.GetConfigPath(scope, "timeout_in_seconds"), DefaultTimeoutInSeconds)
+
+	viper.SetDefault(util.GetConfigPath(scope, "secure_headers"), DefaultSecureHeaders)
+	viper.SetDefault(util.GetConfigPath(scope, "hsts_max_age"), DefaultHSTSMaxAge)
+	viper.SetDefault(util.GetConfigPath(scope, "hsts_exclude_subdomains"), DefaultHSTSExcludeSubdomains)
+	viper.SetDefault(util.GetConfigPath(scope, "hsts_preload"), DefaultHSTSPreload)
+	viper.SetDefault(util.GetConfigPath(scope, "content_security_policy"), DefaultContentSecurityPolicy)
+
+	viper.SetDefault(util.GetConfigPath(scope, "rate_limit"), DefaultRateLimit)
+	viper.SetDefault(util.GetConfigPath(scope, "rate_limit_rps"), DefaultRateLimitRPS)
+	viper.SetDefault(util.GetConfigPath(scope, "rate_limit_burst"), DefaultRateLimitBurst)
+	viper.SetDefault(util.GetConfigPath(scope, "rate_limit_expires_in_seconds"), DefaultRateLimitExpiresInSeconds)
+
+	viper.SetDefault(util.GetConfigPath(scope, "openapi"), DefaultOpenAPI)
+	viper.SetDefault(util.GetConfigPath(scope, "openapi_path"), DefaultOpenAPIPath)
+	viper.SetDefault(util.GetConfigPath(scope, "openapi_docs_path"), DefaultOpenAPIDocsPath)
+	viper.SetDefault(util.GetConfigPath(scope, "openapi_title"), DefaultOpenAPITitle)
+	viper.SetDefault(util.GetConfigPath(scope, "openapi_version"), DefaultOpenAPIVersion)
+
+	viper.SetDefault(util.GetConfigPath(scope, "stream_heartbeat_in_seconds"), DefaultStreamHeartbeatInSeconds)
+	viper.SetDefault(util.GetConfigPath(scope, "stream_send_buffer"), DefaultStreamSendBuffer)
+	viper.SetDefault(util.GetConfigPath(scope, "stream_max_connections"), DefaultStreamMaxConnections)
+	viper.SetDefault(util.GetConfigPath(scope, "websocket_max_message_bytes"), DefaultWebSocketMaxMessageBytes)
+
+	viper.SetDefault(util.GetConfigPath(scope, "static_dir"), DefaultStaticDir)
+	viper.SetDefault(util.GetConfigPath(scope, "static_prefix"), DefaultStaticPrefix)
+	viper.SetDefault(util.GetConfigPath(scope, "static_index"), DefaultStaticIndex)
+	viper.SetDefault(util.GetConfigPath(scope, "static_spa"), DefaultStaticSPA)
+	viper.SetDefault(util.GetConfigPath(scope, "static_exclude_paths"), DefaultStaticExcludePaths)
+	viper.SetDefault(util.GetConfigPath(scope, "static_max_age_in_seconds"), DefaultStaticMaxAgeInSeconds)
+	viper.SetDefault(util.GetConfigPath(scope, "static_precompressed"), DefaultStaticPrecompressed)
+
+	viper.SetDefault(util.GetConfigPath(scope, "idempotency_store"), DefaultIdempotencyStore)
+	viper.SetDefault(util.GetConfigPath(scope, "idempotency_required"), DefaultIdempotencyRequired)
+	viper.SetDefault(util.GetConfigPath(scope, "idempotency_ttl_in_hours"), DefaultIdempotencyTTLInHours)
+	viper.SetDefault(util.GetConfigPath(scope, "idempotency_lock_timeout_in_seconds"), DefaultIdempotencyLockTimeoutInSeconds)
+	viper.SetDefault(util.GetConfigPath(scope, "idempotency_auto_migrate"), DefaultIdempotencyAutoMigrate)
+
+	viper.SetDefault(util.GetConfigPath(scope, "cache_max_entries"), DefaultCacheMaxEntries)
+	viper.SetDefault(util.GetConfigPath(scope, "cache_ttl_in_seconds"), DefaultCacheTTLInSeconds)
+
+	viper.SetDefault(util.GetConfigPath(scope, "debug"), DefaultDebug)
+	viper.SetDefault(util.GetConfigPath(scope, "debug_path"), DefaultDebugPath)
+	viper.SetDefault(util.GetConfigPath(scope, "debug_token_scope"), DefaultDebugTokenScope)
+
+	viper.SetDefault(util.GetConfigPath(scope, "jwks_token_scopes"), DefaultJWKSTokenScopes)
+
 	return &Config{
 		AllowHeaders: viper.GetString(util.GetConfigPath(scope, "allow_headers")),
 		AllowMethods: viper.GetString(util.GetConfigPath(scope, "allow_methods")),
@@ -131,7 +374,62 @@ func (m *Module) setupConfig(scope string) *Config {
 
 		Host:           viper.GetString(util.GetConfigPath(scope, "host")),
 		Port:           viper.GetInt(util.GetConfigPath(scope, "port")),
+		Listen:         viper.GetBool(util.GetConfigPath(scope, "listen")),
 		ServerLogLevel: viper.GetString(util.GetConfigPath(scope, "server_log_level")),
+
+		Recover:   viper.GetBool(util.GetConfigPath(scope, "recover")),
+		RequestID: viper.GetBool(util.GetConfigPath(scope, "request_id")),
+		BodyLimit: viper.GetString(util.GetConfigPath(scope, "body_limit")),
+
+		Gzip:      viper.GetBool(util.GetConfigPath(scope, "gzip")),
+		GzipLevel: viper.GetInt(util.GetConfigPath(scope, "gzip_level")),
+
+		TimeoutInSeconds: viper.GetInt(util.GetConfigPath(scope, "timeout_in_seconds")),
+
+		SecureHeaders:         viper.GetBool(util.GetConfigPath(scope, "secure_headers")),
+		HSTSMaxAge:            viper.GetInt(util.GetConfigPath(scope, "hsts_max_age")),
+		HSTSExcludeSubdomains: viper.GetBool(util.GetConfigPath(scope, "hsts_exclude_subdomains")),
+		HSTSPreload:           viper.GetBool(util.GetConfigPath(scope, "hsts_preload")),
+		ContentSecurityPolicy: viper.GetString(util.GetConfigPath(scope, "content_security_policy")),
+
+		RateLimit:                 viper.GetBool(util.GetConfigPath(scope, "rate_limit")),
+		RateLimitRPS:              viper.GetFloat64(util.GetConfigPath(scope, "rate_limit_rps")),
+		RateLimitBurst:            viper.GetInt(util.GetConfigPath(scope, "rate_limit_burst")),
+		RateLimitExpiresInSeconds: viper.GetInt(util.GetConfigPath(scope, "rate_limit_expires_in_seconds")),
+
+		OpenAPI:         viper.GetBool(util.GetConfigPath(scope, "openapi")),
+		OpenAPIPath:     viper.GetString(util.GetConfigPath(scope, "openapi_path")),
+		OpenAPIDocsPath: viper.GetString(util.GetConfigPath(scope, "openapi_docs_path")),
+		OpenAPITitle:    viper.GetString(util.GetConfigPath(scope, "openapi_title")),
+		OpenAPIVersion:  viper.GetString(util.GetConfigPath(scope, "openapi_version")),
+
+		StreamHeartbeatInSeconds: viper.GetInt(util.GetConfigPath(scope, "stream_heartbeat_in_seconds")),
+		StreamSendBuffer:         viper.GetInt(util.GetConfigPath(scope, "stream_send_buffer")),
+		StreamMaxConnections:     viper.GetInt(util.GetConfigPath(scope, "stream_max_connections")),
+		WebSocketMaxMessageBytes: viper.GetInt(util.GetConfigPath(scope, "websocket_max_message_bytes")),
+
+		StaticDir:             viper.GetString(util.GetConfigPath(scope, "static_dir")),
+		StaticPrefix:          viper.GetString(util.GetConfigPath(scope, "static_prefix")),
+		StaticIndex:           viper.GetString(util.GetConfigPath(scope, "static_index")),
+		StaticSPA:             viper.GetBool(util.GetConfigPath(scope, "static_spa")),
+		StaticExcludePaths:    viper.GetString(util.GetConfigPath(scope, "static_exclude_paths")),
+		StaticMaxAgeInSeconds: viper.GetInt(util.GetConfigPath(scope, "static_max_age_in_seconds")),
+		StaticPrecompressed:   viper.GetBool(util.GetConfigPath(scope, "static_precompressed")),
+
+		IdempotencyStore:                viper.GetString(util.GetConfigPath(scope, "idempotency_store")),
+		IdempotencyRequired:             viper.GetBool(util.GetConfigPath(scope, "idempotency_required")),
+		IdempotencyTTLInHours:           viper.GetInt(util.GetConfigPath(scope, "idempotency_ttl_in_hours")),
+		IdempotencyLockTimeoutInSeconds: viper.GetInt(util.GetConfigPath(scope, "idempotency_lock_timeout_in_seconds")),
+		IdempotencyAutoMigrate:          viper.GetBool(util.GetConfigPath(scope, "idempotency_auto_migrate")),
+
+		CacheMaxEntries:   viper.GetInt(util.GetConfigPath(scope, "cache_max_entries")),
+		CacheTTLInSeconds: viper.GetInt(util.GetConfigPath(scope, "cache_ttl_in_seconds")),
+
+		Debug:           viper.GetBool(util.GetConfigPath(scope, "debug")),
+		DebugPath:       viper.GetString(util.GetConfigPath(scope, "debug_path")),
+		DebugTokenScope: viper.GetString(util.GetConfigPath(scope, "debug_token_scope")),
+
+		JWKSTokenScopes: viper.GetString(util.GetConfigPath(scope, "jwks_token_scopes")),
 	}
 }
 
@@ -142,19 +440,34 @@ func (m *Module) setupLogger(scope string, p Params) *zap.Logger {
 
 func (m *Module) setupServer() *echo.Echo {
 	e := echo.New()
+	e.HTTPErrorHandler = m.handleHTTPError
+
+	m.validator = newRequestValidator()
+	e.Validator = m.validator
+
+	m.connections = newConnectionRegistry()
+	m.idempotencyStore = NewMemoryIdempotencyStore()
+
 	return e
 }
 
-func (m *Module) onStart(context.Context) error {
+func (m *Module) onStart(ctx context.Context) error {
 	m.logger.Info("Starting server")
 
-	m.setUpCorsMiddleware()
-	m.setUpCSRFMiddleware()
-	m.setUpRequestLoggerMiddleware()
+	m.validateStreamConfig()
+	m.setUpMiddlewareChain()
+	m.setUpOpenAPIRoutes()
+	m.setUpDebugRoutes()
+	m.setUpJWKSRoute()
+	m.setUpStaticFiles()
+	m.startIdempotencyStore(ctx)
 
+	// listening can be disabled to serve requests in-process, e.g. in tests
 	// server must be started in a goroutine to prevent blocking the hooks
 	// context will timeout otherwise
-	go m.startServer(true, false)
+	if m.config.Listen {
+		go m.startServer(true, false)
+	}
 
 	if viper.GetString("system.system_log_level") == "DEBUG" {
 		m.logConfigurations()
@@ -167,6 +480,13 @@ func (m *Module) onStop(context.Context) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// open streams would otherwise block the shutdown until the timeout
+	m.connections.closeAll()
+
+	if m.stopIdempotencyCleanup != nil {
+		m.stopIdempotencyCleanup()
+	}
+
 	err := m.server.Shutdown(ctx)
 	if err != nil {
 		m.logger.Error("server shutdown error", zap.Error(err))
@@ -176,7 +496,7 @@ func (m *Module) onStop(context.Context) error {
 	return nil
 }
 
-func (m *Module) setUpCorsMiddleware() {
+func (m *Module) newCorsMiddleware() echo.MiddlewareFunc {
 	corsConfig := middleware.CORSConfig{
 		AllowOrigins:     strings.Split(m.config.AllowOrigins, ","),
 		AllowMethods:     strings.Split(m.config.AllowMethods, ","),
@@ -194,13 +514,13 @@ func (m *Module) setUpCorsMiddleware() {
 		corsConfig.AllowHeaders = []string{"accept", "content-type", "authorization", "x-csrf-token", "x-requested-with", "origin", "cache-control", "pragma", "expires", "set-cookie", "cookie", "jwt"}
 	}
 
-	m.server.Use(middleware.CORSWithConfig(corsConfig))
+	return middleware.CORSWithConfig(corsConfig)
 }
 
-func (m *Module) setUpCSRFMiddleware() {
+func (m *Module) newCSRFMiddleware() echo.MiddlewareFunc {
 	// defaults to not using CSRF protection if unspecified
 	if !m.config.CSRFProtection {
-		return
+		return nil
 	}
 	CSRFConfig := middleware.CSRFConfig{
 		TokenLookup:    "cookie:_csrf",
@@ -210,14 +530,15 @@ func (m *Module) setUpCSRFMiddleware() {
 		CookieSameSite: http.SameSiteDefaultMode,
 		CookieHTTPOnly: true,
 	}
-	CSRFMiddleware := middleware.CSRFWithConfig(CSRFConfig)
-
-	m.server.Use(CSRFMiddleware)
+	return middleware.CSRFWithConfig(CSRFConfig)
 }
 
-func (m *Module) setUpRequestLoggerMiddleware() {
-	// Defaults to PROD log level if unspecified
+func (m *Module) newRequestLoggerMiddleware() echo.MiddlewareFunc {
+	// Defaults to PROD log level if unspecified or invalid
 	// Valid log levels: DEV, PROD, DEBUG
+	if !isValidServerLogLevel(m.config.ServerLogLevel) {
+		m.logger.Warn("invalid server log level, using PROD", zap.String("log_level", m.config.ServerLogLevel))
+	}
 	requestLoggerConfig := middleware.RequestLoggerConfig{
 		LogProtocol:   true,
 		LogMethod:     true,
@@ -227,14 +548,13 @@ func (m *Module) setUpRequestLoggerMiddleware() {
 		LogRemoteIP:   true,
 		LogLatency:    true,
 		LogError:      true,
+		HandleError:   true, // forwards errors to handleHTTPError so the logged status is correct
 		LogValuesFunc: m.logRequest,
 	}
-	requestLogger := middleware.RequestLoggerWithConfig(requestLoggerConfig)
-
-	m.server.Use(requestLogger)
+	return middleware.RequestLoggerWithConfig(requestLoggerConfig)
 }
 
-// helper function for setUpRequestLoggerMiddleware
+// helper function for newRequestLoggerMiddleware
 func (m *Module) logRequest(c echo.Context, v middleware.RequestLoggerValues) error {
 	switch m.config.ServerLogLevel {
 	case "DEV", "dev":
@@ -248,14 +568,6 @@ func (m *Module) logRequest(c echo.Context, v middleware.RequestLoggerValues) er
 			zap.Duration("latency", v.Latency),
 			zap.String("protocol", v.Protocol),
 		)
-	case "PROD", "prod":
-		m.logger.Info("request",
-			zap.String("URI", v.URI),
-			zap.Int("status", v.Status),
-			zap.Any("error", v.Error),
-			zap.String("request_id", v.RequestID),
-			zap.Duration("latency", v.Latency),
-		)
 	case "DEBUG", "debug":
 		m.logger.Debug("request",
 			zap.String("URI", v.URI),
@@ -269,20 +581,35 @@ func (m *Module) logRequest(c echo.Context, v middleware.RequestLoggerValues) er
 			//todo: add more as needed
 		)
 	default:
-		m.logger.Error("invalid log level", zap.String("log_level", m.config.ServerLogLevel))
-		return fmt.Errorf("invalid log level: %s", m.config.ServerLogLevel)
+		// PROD, and invalid log levels which are reported once at startup
+		m.logger.Info("request",
+			zap.String("URI", v.URI),
+			zap.Int("status", v.Status),
+			zap.Any("error", v.Error),
+			zap.String("request_id", v.RequestID),
+			zap.Duration("latency", v.Latency),
+		)
 	}
 
 	return nil
 }
 
+func isValidServerLogLevel(logLevel string) bool {
+	switch logLevel {
+	case "DEV", "dev", "PROD", "prod", "DEBUG", "debug":
+		return true
+	default:
+		return false
+	}
+}
+
 func (m *Module) startServer(HideBanner bool, HidePort bool) {
 	m.server.HideBanner = HideBanner || false
 	m.server.HidePort = HidePort || false
 
 	addr := fmt.Sprintf("%s:%d", m.config.Host, m.config.Port)
 
-	log.Printf("Server started at %s", addr)
+	log.Printf("Server [%s] started at %s", m.scope, addr)
 
 	err := m.server.Start(addr)
 	if err != nil && err != http.ErrServerClosed {
@@ -294,6 +621,7 @@ func (m *Module) logConfigurations() {
 	m.logger.Debug("----- Server Configuration -----")
 	m.logger.Debug("Host", zap.String("Host", m.config.Host))
 	m.logger.Debug("Port", zap.Int("Port", m.config.Port))
+	m.logger.Debug("Listen", zap.Bool("Listen", m.config.Listen))
 
 	m.logger.Debug("----- Cors Configuration -----")
 	m.logger.Debug("AllowOrigins", zap.String("AllowOrigins", m.config.AllowOrigins))
@@ -310,6 +638,75 @@ func (m *Module) logConfigurations() {
 		m.logger.Debug("CSRFCookieSameSite", zap.String("CSRFCookieSameSite", "Default"))
 		m.logger.Debug("CSRFCookieHTTPOnly", zap.Bool("CSRFCookieHTTPOnly", true))
 	}
+
+	m.logger.Debug("----- Middleware Configuration -----")
+	m.logger.Debug("Recover", zap.Bool("Recover", m.config.Recover))
+	m.logger.Debug("RequestID", zap.Bool("RequestID", m.config.RequestID))
+	m.logger.Debug("BodyLimit", zap.String("BodyLimit", m.config.BodyLimit))
+	m.logger.Debug("Gzip", zap.Bool("Gzip", m.config.Gzip))
+	if m.config.Gzip {
+		m.logger.Debug("GzipLevel", zap.Int("GzipLevel", m.config.GzipLevel))
+	}
+	m.logger.Debug("TimeoutInSeconds", zap.Int("TimeoutInSeconds", m.config.TimeoutInSeconds))
+	m.logger.Debug("SecureHeaders", zap.Bool("SecureHeaders", m.config.SecureHeaders))
+	if m.config.SecureHeaders {
+		m.logger.Debug("HSTSMaxAge", zap.Int("HSTSMaxAge", m.config.HSTSMaxAge))
+		m.logger.Debug("HSTSExcludeSubdomains", zap.Bool("HSTSExcludeSubdomains", m.config.HSTSExcludeSubdomains))
+		m.logger.Debug("HSTSPreload", zap.Bool("HSTSPreload", m.config.HSTSPreload))
+		m.logger.Debug("ContentSecurityPolicy", zap.String("ContentSecurityPolicy", m.config.ContentSecurityPolicy))
+	}
+	m.logger.Debug("RateLimit", zap.Bool("RateLimit", m.config.RateLimit))
+	if m.config.RateLimit {
+		m.logger.Debug("RateLimitRPS", zap.Float64("RateLimitRPS", m.config.RateLimitRPS))
+		m.logger.Debug("RateLimitBurst", zap.Int("RateLimitBurst", m.config.RateLimitBurst))
+		m.logger.Debug("RateLimitExpiresInSeconds", zap.Int("RateLimitExpiresInSeconds", m.config.RateLimitExpiresInSeconds))
+	}
+
+	m.logger.Debug("----- OpenAPI Configuration -----")
+	m.logger.Debug("OpenAPI", zap.Bool("OpenAPI", m.config.OpenAPI))
+	if m.config.OpenAPI {
+		m.logger.Debug("OpenAPIPath", zap.String("OpenAPIPath", m.config.OpenAPIPath))
+		m.logger.Debug("OpenAPIDocsPath", zap.String("OpenAPIDocsPath", m.config.OpenAPIDocsPath))
+		m.logger.Debug("OpenAPITitle", zap.String("OpenAPITitle", m.config.OpenAPITitle))
+		m.logger.Debug("OpenAPIVersion", zap.String("OpenAPIVersion", m.config.OpenAPIVersion))
+	}
+
+	m.logger.Debug("----- Stream Configuration -----")
+	m.logger.Debug("StreamHeartbeatInSeconds", zap.Int("StreamHeartbeatInSeconds", m.config.StreamHeartbeatInSeconds))
+	m.logger.Debug("StreamSendBuffer", zap.Int("StreamSendBuffer", m.config.StreamSendBuffer))
+	m.logger.Debug("StreamMaxConnections", zap.Int("StreamMaxConnections", m.config.StreamMaxConnections))
+	m.logger.Debug("WebSocketMaxMessageBytes", zap.Int("WebSocketMaxMessageBytes", m.config.WebSocketMaxMessageBytes))
+
+	m.logger.Debug("----- Static Configuration -----")
+	m.logger.Debug("StaticDir", zap.String("StaticDir", m.config.StaticDir))
+	if m.config.StaticDir != "" {
+		m.logger.Debug("StaticPrefix", zap.String("StaticPrefix", m.config.StaticPrefix))
+		m.logger.Debug("StaticIndex", zap.String("StaticIndex", m.config.StaticIndex))
+		m.logger.Debug("StaticSPA", zap.Bool("StaticSPA", m.config.StaticSPA))
+		m.logger.Debug("StaticExcludePaths", zap.String("StaticExcludePaths", m.config.StaticExcludePaths))
+		m.logger.Debug("StaticMaxAgeInSeconds", zap.Int("StaticMaxAgeInSeconds", m.config.StaticMaxAgeInSeconds))
+		m.logger.Debug("StaticPrecompressed", zap.Bool("StaticPrecompressed", m.config.StaticPrecompressed))
+	}
+
+	m.logger.Debug("----- Idempotency Configuration -----")
+	m.logger.Debug("IdempotencyStore", zap.String("IdempotencyStore", m.config.IdempotencyStore))
+	m.logger.Debug("IdempotencyRequired", zap.Bool("IdempotencyRequired", m.config.IdempotencyRequired))
+	m.logger.Debug("IdempotencyTTLInHours", zap.Int("IdempotencyTTLInHours", m.config.IdempotencyTTLInHours))
+	m.logger.Debug("IdempotencyLockTimeoutInSeconds", zap.Int("IdempotencyLockTimeoutInSeconds", m.config.IdempotencyLockTimeoutInSeconds))
+	m.logger.Debug("IdempotencyAutoMigrate", zap.Bool("IdempotencyAutoMigrate", m.config.IdempotencyAutoMigrate))
+
+	m.logger.Debug("----- Cache Configuration -----")
+	m.logger.Debug("CacheMaxEntries", zap.Int("CacheMaxEntries", m.config.CacheMaxEntries))
+	m.logger.Debug("CacheTTLInSeconds", zap.Int("CacheTTLInSeconds", m.config.CacheTTLInSeconds))
+
+	m.logger.Debug("----- Debug Configuration -----")
+	m.logger.Debug("Debug", zap.Bool("Debug", m.config.Debug))
+	if m.config.Debug {
+		m.logger.Debug("DebugPath", zap.String("DebugPath", m.config.DebugPath))
+		m.logger.Debug("DebugTokenScope", zap.String("DebugTokenScope", m.config.DebugTokenScope))
+	}
+
+	m.logger.Debug("JWKSTokenScopes", zap.String("JWKSTokenScopes", m.config.JWKSTokenScopes))
 }
 
 //! EXTERNAL ---------------------------------------------------------------
@@ -318,3 +715,8 @@ func (m *Module) logConfigurations() {
 func (m *Module) GetServer() *echo.Echo {
 	return m.server
 }
+
+// Returns the scope of the server instance, which is also its name tag if injected with InjectNamedModule
+func (m *Module) GetScope() string {
+	return m.scope
+}
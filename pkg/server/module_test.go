@@ -9,6 +9,8 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
 	"go.uber.org/zap"
 )
 
@@ -32,7 +34,27 @@ func TestSetupConfig(t *testing.T) {
 		assert.Equal(t, DefaultCSRFDomain, m.config.CSRFDomain)
 		assert.Equal(t, DefaultHost, m.config.Host)
 		assert.Equal(t, DefaultPort, m.config.Port)
+		assert.Equal(t, DefaultListen, m.config.Listen)
 		assert.Equal(t, DefaultServerLogLevel, m.config.ServerLogLevel)
+		assert.Equal(t, DefaultRecover, m.config.Recover)
+		assert.Equal(t, DefaultRequestID, m.config.RequestID)
+		assert.Equal(t, DefaultBodyLimit, m.config.BodyLimit)
+		assert.Equal(t, DefaultGzip, m.config.Gzip)
+		assert.Equal(t, DefaultGzipLevel, m.config.GzipLevel)
+		assert.Equal(t, DefaultTimeoutInSeconds, m.config.TimeoutInSeconds)
+		assert.Equal(t, DefaultSecureHeaders, m.config.SecureHeaders)
+		assert.Equal(t, DefaultHSTSMaxAge, m.config.HSTSMaxAge)
+		assert.Equal(t, DefaultRateLimit, m.config.RateLimit)
+		assert.Equal(t, DefaultRateLimitRPS, m.config.RateLimitRPS)
+		assert.Equal(t, DefaultRateLimitBurst, m.config.RateLimitBurst)
+		assert.Equal(t, DefaultRateLimitExpiresInSeconds, m.config.RateLimitExpiresInSeconds)
+		assert.Equal(t, DefaultIdempotencyStore, m.config.IdempotencyStore)
+		assert.Equal(t, DefaultIdempotencyTTLInHours, m.config.IdempotencyTTLInHours)
+		assert.Equal(t, DefaultIdempotencyLockTimeoutInSeconds, m.config.IdempotencyLockTimeoutInSeconds)
+		assert.Equal(t, DefaultCacheMaxEntries, m.config.CacheMaxEntries)
+		assert.Equal(t, DefaultCacheTTLInSeconds, m.config.CacheTTLInSeconds)
+		assert.Equal(t, DefaultDebug, m.config.Debug)
+		assert.Equal(t, DefaultDebugPath, m.config.DebugPath)
 	})
 
 	t.Run("TestSetupWithConfig", func(t *testing.T) {
@@ -49,6 +71,13 @@ func TestSetupConfig(t *testing.T) {
 		viper.Set("server.port", 3001)
 		viper.Set("server.server_log_level", "DEBUG")
 		viper.Set("server.system_log_level", "DEBUG")
+		viper.Set("server.body_limit", "2M")
+		viper.Set("server.gzip", true)
+		viper.Set("server.timeout_in_seconds", 30)
+		viper.Set("server.secure_headers", true)
+		viper.Set("server.hsts_max_age", 31536000)
+		viper.Set("server.rate_limit", true)
+		viper.Set("server.rate_limit_rps", 2.5)
 
 		m.config = m.setupConfig(m.scope)
 
@@ -62,6 +91,13 @@ func TestSetupConfig(t *testing.T) {
 		assert.Equal(t, "localhost", m.config.Host)
 		assert.Equal(t, 3001, m.config.Port)
 		assert.Equal(t, "DEBUG", m.config.ServerLogLevel)
+		assert.Equal(t, "2M", m.config.BodyLimit)
+		assert.Equal(t, true, m.config.Gzip)
+		assert.Equal(t, 30, m.config.TimeoutInSeconds)
+		assert.Equal(t, true, m.config.SecureHeaders)
+		assert.Equal(t, 31536000, m.config.HSTSMaxAge)
+		assert.Equal(t, true, m.config.RateLimit)
+		assert.Equal(t, 2.5, m.config.RateLimitRPS)
 	})
 
 	t.Run("TestSetupWithPartialConfig", func(t *testing.T) {
@@ -85,6 +121,35 @@ func TestSetupConfig(t *testing.T) {
 	})
 }
 
+func TestInjectNamedModule(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("admin.port", 3002)
+
+	type servers struct {
+		fx.In
+		Server *Module
+		Admin  *Module `name:"admin"`
+	}
+
+	var s servers
+	app := fxtest.New(t,
+		fx.Supply(zap.NewNop()),
+		InjectModule("server"),
+		InjectNamedModule("admin"),
+		fx.Populate(&s),
+	)
+	defer app.RequireStop()
+
+	assert.NotSame(t, s.Server, s.Admin)
+	assert.NotSame(t, s.Server.GetServer(), s.Admin.GetServer())
+	assert.Equal(t, "server", s.Server.GetScope())
+	assert.Equal(t, "admin", s.Admin.GetScope())
+	assert.Equal(t, DefaultPort, s.Server.config.Port)
+	assert.Equal(t, 3002, s.Admin.config.Port)
+}
+
 func TestSetupLogger(t *testing.T) {
 	scope := "server"
 	m := Module{
@@ -119,7 +184,7 @@ func TestSetUpCorsMiddleware(t *testing.T) {
 		},
 		server: echo.New(),
 	}
-	m.setUpCorsMiddleware()
+	m.server.Use(m.newCorsMiddleware())
 
 	rec := httptest.NewRecorder()
 	req := httptest.NewRequest(http.MethodOptions, "/", nil)
@@ -139,8 +204,8 @@ func TestSetUpCorsMiddleware(t *testing.T) {
 func TestCSRFMiddleware(t *testing.T) {
 
 	// creates module + middleware + route
-	newModuleWithCSRF := func() Module {
-		m := Module{
+	newModuleWithCSRF := func() *Module {
+		m := &Module{
 			scope: "server",
 			config: &Config{
 				CSRFProtection: true,
@@ -149,7 +214,7 @@ func TestCSRFMiddleware(t *testing.T) {
 			},
 			server: echo.New(),
 		}
-		m.setUpCSRFMiddleware()
+		m.server.Use(m.newCSRFMiddleware())
 		m.server.POST("/", func(c echo.Context) error {
 			return c.String(http.StatusOK, "test")
 		})
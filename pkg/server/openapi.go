@@ -0,0 +1,494 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+//go:embed templates/openapi.html.tpl
+var openAPIDocsTemplate string
+
+// Documents a registered route for the generated OpenAPI document.
+// Request and Response take a value of the type, e.g. CreateUserRequest{}.
+type RouteDoc struct {
+	OperationID string
+	Summary     string
+	Description string
+	Tags        []string
+	Request     interface{}
+	Response    interface{}
+	Status      int // status of the successful response, defaults to 200
+}
+
+// OpenAPI 3 document, only the subset generated by this module
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*openAPISchema `json:"schemas"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Description string                      `json:"description,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Parameters  []*openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required,omitempty"`
+	Schema   *openAPISchema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                         `json:"required,omitempty"`
+	Content  map[string]*openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Enum                 []string                  `json:"enum,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+}
+
+// generates schemas and collects named struct types as components
+type schemaGenerator struct {
+	schemas map[string]*openAPISchema
+	types   map[string]reflect.Type
+}
+
+var (
+	timeType               = reflect.TypeOf(time.Time{})
+	invalidSchemaNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+	parameterLocations     = [][2]string{{"path", "param"}, {"query", "query"}, {"header", "header"}}
+	documentedMethods      = []string{
+		http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete,
+		http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodTrace,
+	}
+)
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setUpOpenAPIRoutes() {
+	// defaults to not serving the OpenAPI document if unspecified
+	if !m.config.OpenAPI {
+		return
+	}
+
+	docsTemplate := template.Must(template.New("openapi").Parse(openAPIDocsTemplate))
+
+	m.server.GET(m.config.OpenAPIPath, func(c echo.Context) error {
+		spec, err := m.GetOpenAPISpec()
+		if err != nil {
+			return err
+		}
+		return c.JSONBlob(http.StatusOK, spec)
+	})
+
+	m.server.GET(m.config.OpenAPIDocsPath, func(c echo.Context) error {
+		var buf bytes.Buffer
+		err := docsTemplate.Execute(&buf, map[string]string{
+			"Title":    m.config.OpenAPITitle,
+			"SpecPath": m.config.OpenAPIPath,
+		})
+		if err != nil {
+			return err
+		}
+		return c.HTMLBlob(http.StatusOK, buf.Bytes())
+	})
+}
+
+func (m *Module) buildOpenAPIDocument() *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info: openAPIInfo{
+			Title:   m.config.OpenAPITitle,
+			Version: m.config.OpenAPIVersion,
+		},
+		Paths: make(map[string]map[string]*openAPIOperation),
+	}
+	generator := &schemaGenerator{
+		schemas: make(map[string]*openAPISchema),
+		types:   make(map[string]reflect.Type),
+	}
+	problemSchema := generator.schemaFor(reflect.TypeOf(Problem{}))
+
+	m.routeDocsMutex.RLock()
+	defer m.routeDocsMutex.RUnlock()
+
+	routes := m.server.Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+
+	for _, route := range routes {
+		if !isDocumentedRoute(route) || m.isOwnRoute(route) {
+			continue
+		}
+
+		path := toOpenAPIPath(route.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*openAPIOperation)
+		}
+
+		routeDoc := m.routeDocs[getRouteKey(route.Method, route.Path)]
+		doc.Paths[path][strings.ToLower(route.Method)] = generator.newOperation(route, routeDoc, problemSchema)
+	}
+
+	doc.Components.Schemas = generator.schemas
+	return doc
+}
+
+// the documentation, debug and JWKS routes are not part of the API
+func (m *Module) isOwnRoute(route *echo.Route) bool {
+	return route.Path == m.config.OpenAPIPath || route.Path == m.config.OpenAPIDocsPath || m.isDebugRoute(route.Path) || route.Path == token.JWKSPath
+}
+
+// skips echo internal routes and wildcards
+func isDocumentedRoute(route *echo.Route) bool {
+	if strings.Contains(route.Path, "*") {
+		return false
+	}
+	for _, method := range documentedMethods {
+		if route.Method == method {
+			return true
+		}
+	}
+	return false
+}
+
+// converts echo path params to OpenAPI format, e.g. /users/:id -> /users/{id}
+func toOpenAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func getRouteKey(method string, path string) string {
+	return method + " " + path
+}
+
+func (g *schemaGenerator) newOperation(route *echo.Route, routeDoc RouteDoc, problemSchema *openAPISchema) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: routeDoc.OperationID,
+		Summary:     routeDoc.Summary,
+		Description: routeDoc.Description,
+		Tags:        routeDoc.Tags,
+		Responses:   make(map[string]*openAPIResponse),
+	}
+
+	// path params are documented even if the request type is not
+	documentedParams := make(map[string]bool)
+	if routeDoc.Request != nil {
+		requestType := derefType(reflect.TypeOf(routeDoc.Request))
+		op.Parameters = g.parametersFor(requestType)
+		for _, p := range op.Parameters {
+			documentedParams[p.In+":"+p.Name] = true
+		}
+
+		hasBody := route.Method == http.MethodPost || route.Method == http.MethodPut || route.Method == http.MethodPatch
+		if hasBody {
+			bodySchema := g.objectSchema(requestType, true)
+			if len(bodySchema.Properties) > 0 {
+				op.RequestBody = &openAPIRequestBody{
+					Required: true,
+					Content: map[string]*openAPIMediaType{
+						echo.MIMEApplicationJSON: {Schema: bodySchema},
+					},
+				}
+			}
+		}
+	}
+	for _, segment := range strings.Split(route.Path, "/") {
+		if strings.HasPrefix(segment, ":") && !documentedParams["path:"+segment[1:]] {
+			op.Parameters = append(op.Parameters, &openAPIParameter{
+				Name:     segment[1:],
+				In:       "path",
+				Required: true,
+				Schema:   &openAPISchema{Type: "string"},
+			})
+		}
+	}
+
+	status := routeDoc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := &openAPIResponse{Description: http.StatusText(status)}
+	if routeDoc.Response != nil {
+		response.Content = map[string]*openAPIMediaType{
+			echo.MIMEApplicationJSON: {Schema: g.schemaFor(reflect.TypeOf(routeDoc.Response))},
+		}
+	}
+	op.Responses[fmt.Sprint(status)] = response
+	op.Responses["default"] = &openAPIResponse{
+		Description: "Error",
+		Content: map[string]*openAPIMediaType{
+			MIMEApplicationProblemJSON: {Schema: problemSchema},
+		},
+	}
+
+	return op
+}
+
+// collects fields tagged with param, query or header
+func (g *schemaGenerator) parametersFor(t reflect.Type) []*openAPIParameter {
+	params := []*openAPIParameter{}
+	if t.Kind() != reflect.Struct {
+		return params
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && derefType(field.Type).Kind() == reflect.Struct && field.Tag.Get("json") == "" {
+			params = append(params, g.parametersFor(derefType(field.Type))...)
+			continue
+		}
+
+		for _, location := range parameterLocations {
+			in, tag := location[0], location[1]
+			name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			params = append(params, &openAPIParameter{
+				Name:     name,
+				In:       in,
+				Required: in == "path" || isRequiredField(field),
+				Schema:   g.fieldSchema(field),
+			})
+		}
+	}
+	return params
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) *openAPISchema {
+	t = derefType(t)
+	if t == timeType {
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.objectSchema(t, false)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + g.registerSchema(t)}
+	default:
+		// interfaces and unsupported kinds accept any value
+		return &openAPISchema{}
+	}
+}
+
+// Adds a named struct to the components and returns its name.
+// Types with the same name from different packages are prefixed with the package name.
+func (g *schemaGenerator) registerSchema(t reflect.Type) string {
+	name := invalidSchemaNameChars.ReplaceAllString(t.Name(), "_")
+	if existing, exists := g.types[name]; exists && existing != t {
+		name = invalidSchemaNameChars.ReplaceAllString(t.String(), "_")
+	}
+	if _, exists := g.types[name]; exists {
+		return name
+	}
+
+	// registered before generating the schema to support recursive types
+	g.types[name] = t
+	g.schemas[name] = g.objectSchema(t, false)
+
+	return name
+}
+
+// Generates an object schema from the json fields of a struct.
+// If bodyOnly is true, fields bound from path, query or header are skipped.
+func (g *schemaGenerator) objectSchema(t reflect.Type, bodyOnly bool) *openAPISchema {
+	schema := &openAPISchema{
+		Type:       "object",
+		Properties: make(map[string]*openAPISchema),
+	}
+	if t.Kind() != reflect.Struct {
+		return schema
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		if bodyOnly && (field.Tag.Get("param") != "" || field.Tag.Get("query") != "" || field.Tag.Get("header") != "") {
+			continue
+		}
+
+		jsonName := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if jsonName == "-" {
+			continue
+		}
+		// embedded structs are flattened, as encoding/json does
+		if field.Anonymous && jsonName == "" && derefType(field.Type).Kind() == reflect.Struct {
+			embedded := g.objectSchema(derefType(field.Type), bodyOnly)
+			for name, property := range embedded.Properties {
+				schema.Properties[name] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+
+		name := jsonName
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = g.fieldSchema(field)
+		if isRequiredField(field) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+
+	return schema
+}
+
+// adds enums from "oneof" validation to the schema of the field type
+func (g *schemaGenerator) fieldSchema(field reflect.StructField) *openAPISchema {
+	schema := g.schemaFor(field.Type)
+	if schema.Type != "string" {
+		return schema
+	}
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		if strings.HasPrefix(rule, "oneof=") {
+			schema.Enum = strings.Fields(strings.TrimPrefix(rule, "oneof="))
+		}
+	}
+	return schema
+}
+
+func isRequiredField(field reflect.StructField) bool {
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Attaches documentation to a registered route, used when generating the OpenAPI document.
+Routes that are not documented are still listed, with their path parameters only.
+
+	m.Document(e.POST("/users", createUser), server.RouteDoc{
+		Summary:  "Create a user",
+		Tags:     []string{"users"},
+		Request:  CreateUserRequest{},
+		Response: User{},
+		Status:   http.StatusCreated,
+	})
+*/
+func (m *Module) Document(route *echo.Route, doc RouteDoc) *echo.Route {
+	m.routeDocsMutex.Lock()
+	defer m.routeDocsMutex.Unlock()
+
+	if m.routeDocs == nil {
+		m.routeDocs = make(map[string]RouteDoc)
+	}
+	m.routeDocs[getRouteKey(route.Method, route.Path)] = doc
+
+	return route
+}
+
+// Generates the OpenAPI document from the routes registered on the server.
+func (m *Module) GetOpenAPISpec() ([]byte, error) {
+	return json.MarshalIndent(m.buildOpenAPIDocument(), "", "  ")
+}
+
+// Writes the OpenAPI document to a file, e.g. for client generation in CI.
+func (m *Module) WriteOpenAPISpec(filePath string) error {
+	spec, err := m.GetOpenAPISpec()
+	if err != nil {
+		m.logger.Error("Failed to generate OpenAPI spec", zap.Error(err))
+		return err
+	}
+
+	err = os.WriteFile(filePath, spec, 0644)
+	if err != nil {
+		m.logger.Error("Failed to write OpenAPI spec", zap.String("path", filePath), zap.Error(err))
+		return err
+	}
+
+	m.logger.Info("OpenAPI spec written", zap.String("path", filePath))
+	return nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type testOpenAPIUser struct {
+	ID        int              `json:"id"`
+	Email     string           `json:"email"`
+	Role      string           `json:"role" validate:"oneof=admin member"`
+	CreatedAt time.Time        `json:"created_at"`
+	Manager   *testOpenAPIUser `json:"manager,omitempty"`
+	Password  string           `json:"-"`
+}
+
+type testOpenAPIUpdateRequest struct {
+	ID     int    `param:"id"`
+	Notify bool   `query:"notify"`
+	Email  string `json:"email" validate:"required,email"`
+}
+
+func newModuleWithOpenAPI() *Module {
+	m := &Module{
+		scope: "server",
+		config: &Config{
+			OpenAPI:         true,
+			OpenAPIPath:     DefaultOpenAPIPath,
+			OpenAPIDocsPath: DefaultOpenAPIDocsPath,
+			OpenAPITitle:    "Test API",
+			OpenAPIVersion:  "2.0.0",
+			ServerLogLevel:  "PROD",
+		},
+		logger: zap.NewNop(),
+	}
+	m.server = m.setupServer()
+	m.setUpOpenAPIRoutes()
+
+	noop := func(c echo.Context) error { return nil }
+	m.Document(m.server.PUT("/users/:id", noop), RouteDoc{
+		Summary:  "Update a user",
+		Tags:     []string{"users"},
+		Request:  testOpenAPIUpdateRequest{},
+		Response: testOpenAPIUser{},
+	})
+	m.server.DELETE("/users/:id", noop)
+	m.server.GET("/static/*", noop)
+
+	return m
+}
+
+func getOpenAPIDocument(t *testing.T, m *Module) map[string]interface{} {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, DefaultOpenAPIPath, nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	return doc
+}
+
+func TestGetOpenAPISpec(t *testing.T) {
+	m := newModuleWithOpenAPI()
+	doc := getOpenAPIDocument(t, m)
+
+	assert.Equal(t, "3.0.3", doc["openapi"])
+	assert.Equal(t, map[string]interface{}{"title": "Test API", "version": "2.0.0"}, doc["info"])
+
+	paths := doc["paths"].(map[string]interface{})
+	assert.Contains(t, paths, "/users/{id}")
+	assert.NotContains(t, paths, DefaultOpenAPIPath)
+	assert.NotContains(t, paths, DefaultOpenAPIDocsPath)
+	assert.NotContains(t, paths, "/static/*")
+
+	t.Run("TestDocumentedRoute", func(t *testing.T) {
+		put := paths["/users/{id}"].(map[string]interface{})["put"].(map[string]interface{})
+
+		assert.Equal(t, "Update a user", put["summary"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "integer", "format": "int64"}},
+			map[string]interface{}{"name": "notify", "in": "query", "schema": map[string]interface{}{"type": "boolean"}},
+		}, put["parameters"])
+
+		body := put["requestBody"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+		assert.Equal(t, []interface{}{"email"}, body["required"])
+		assert.Len(t, body["properties"], 1)
+
+		responses := put["responses"].(map[string]interface{})
+		assert.Contains(t, responses, "200")
+		assert.Contains(t, responses, "default")
+	})
+
+	t.Run("TestUndocumentedRoute", func(t *testing.T) {
+		del := paths["/users/{id}"].(map[string]interface{})["delete"].(map[string]interface{})
+
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+		}, del["parameters"])
+	})
+
+	t.Run("TestComponents", func(t *testing.T) {
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		assert.Contains(t, schemas, "Problem")
+		assert.Contains(t, schemas, "FieldError")
+
+		user := schemas["testOpenAPIUser"].(map[string]interface{})
+		properties := user["properties"].(map[string]interface{})
+		assert.NotContains(t, properties, "Password")
+		assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/testOpenAPIUser"}, properties["manager"])
+		assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, properties["created_at"])
+		assert.Equal(t, map[string]interface{}{"type": "string", "enum": []interface{}{"admin", "member"}}, properties["role"])
+	})
+}
+
+func TestOpenAPIDocs(t *testing.T) {
+	m := newModuleWithOpenAPI()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, DefaultOpenAPIDocsPath, nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMETextHTML))
+	assert.Contains(t, rec.Body.String(), `const specPath = "/openapi.json"`)
+}
+
+func TestWriteOpenAPISpec(t *testing.T) {
+	m := newModuleWithOpenAPI()
+	filePath := filepath.Join(t.TempDir(), "openapi.json")
+
+	err := m.WriteOpenAPISpec(filePath)
+	assert.NoError(t, err)
+
+	spec, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(spec), "/users/{id}")
+}
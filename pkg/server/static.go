@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// precompressed variants, in order of preference
+var staticEncodings = []struct {
+	name      string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Serves files from a file system, with SPA history fallback and ETags.
+type staticFiles struct {
+	mutex sync.RWMutex
+	fsys  fs.FS
+	route string
+	etags map[string]staticETag
+}
+
+// cached ETag of a file, recomputed when its size or modification time changes
+type staticETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setUpStaticFiles() {
+	// defaults to not serving static files if unspecified
+	if m.config.StaticDir == "" {
+		return
+	}
+	if _, err := os.Stat(m.config.StaticDir); err != nil {
+		m.logger.Error("static directory not found, not serving static files", zap.String("dir", m.config.StaticDir), zap.Error(err))
+		return
+	}
+	m.ServeStatic(os.DirFS(m.config.StaticDir))
+}
+
+func (m *Module) getStaticRoute() string {
+	prefix := "/" + strings.Trim(m.config.StaticPrefix, "/")
+	if prefix == "/" {
+		return "/*"
+	}
+	return prefix + "/*"
+}
+
+// Static files are served precompressed if available, so the gzip middleware is skipped for them.
+func (m *Module) isStaticRequest(c echo.Context) bool {
+	m.static.mutex.RLock()
+	defer m.static.mutex.RUnlock()
+
+	return m.static.route != "" && c.Path() == m.static.route
+}
+
+func (m *Module) isExcludedStaticPath(urlPath string) bool {
+	for _, excluded := range strings.Split(m.config.StaticExcludePaths, ",") {
+		excluded = "/" + strings.Trim(strings.TrimSpace(excluded), "/")
+		if excluded == "/" {
+			continue
+		}
+		if urlPath == excluded || strings.HasPrefix(urlPath, excluded+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Module) serveStatic(c echo.Context) error {
+	m.static.mutex.RLock()
+	fsys := m.static.fsys
+	m.static.mutex.RUnlock()
+
+	urlPath := path.Clean("/" + c.Request().URL.Path)
+
+	// API routes that are not registered respond with the usual error instead of a file
+	if m.isExcludedStaticPath(urlPath) {
+		return echo.ErrNotFound
+	}
+
+	name := strings.TrimPrefix(strings.TrimPrefix(urlPath, "/"+strings.Trim(m.config.StaticPrefix, "/")), "/")
+	if name == "" || strings.HasSuffix(c.Request().URL.Path, "/") {
+		name = path.Join(name, m.config.StaticIndex)
+	}
+
+	err := m.serveStaticFile(c, fsys, name)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// history fallback for client side routes, missing assets with an extension are not found
+	if m.config.StaticSPA && path.Ext(name) == "" {
+		err = m.serveStaticFile(c, fsys, m.config.StaticIndex)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return echo.ErrNotFound
+	}
+	return err
+}
+
+func (m *Module) serveStaticFile(c echo.Context, fsys fs.FS, name string) error {
+	if !fs.ValidPath(name) {
+		return fs.ErrNotExist
+	}
+
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return m.serveStaticFile(c, fsys, path.Join(name, m.config.StaticIndex))
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, getStaticContentType(name))
+	res.Header().Set(echo.HeaderCacheControl, m.getStaticCacheControl(name))
+
+	servedName, servedInfo := name, info
+	if m.config.StaticPrecompressed {
+		res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+		acceptEncoding := c.Request().Header.Get(echo.HeaderAcceptEncoding)
+		for _, encoding := range staticEncodings {
+			if !acceptsEncoding(acceptEncoding, encoding.name) {
+				continue
+			}
+			compressedInfo, err := fs.Stat(fsys, name+encoding.extension)
+			if err != nil || compressedInfo.IsDir() {
+				continue
+			}
+			servedName, servedInfo = name+encoding.extension, compressedInfo
+			res.Header().Set(echo.HeaderContentEncoding, encoding.name)
+			break
+		}
+	}
+
+	content, closer, err := openStaticFile(fsys, servedName)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	res.Header().Set("ETag", m.static.getETag(servedName, servedInfo, content))
+
+	// handles If-None-Match, If-Modified-Since, HEAD and range requests
+	http.ServeContent(res, c.Request(), name, info.ModTime(), content)
+	return nil
+}
+
+// The index is revalidated on every request so new deployments are picked up.
+func (m *Module) getStaticCacheControl(name string) string {
+	if path.Base(name) == m.config.StaticIndex || m.config.StaticMaxAgeInSeconds <= 0 {
+		return "no-cache"
+	}
+	return "public, max-age=" + strconv.Itoa(m.config.StaticMaxAgeInSeconds)
+}
+
+func (s *staticFiles) getETag(name string, info fs.FileInfo, content io.ReadSeeker) string {
+	s.mutex.RLock()
+	cached, ok := s.etags[name]
+	s.mutex.RUnlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.etag
+	}
+
+	hash := sha256.New()
+	io.Copy(hash, content)
+	content.Seek(0, io.SeekStart)
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	s.mutex.Lock()
+	s.etags[name] = staticETag{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	s.mutex.Unlock()
+
+	return etag
+}
+
+// Files of os.DirFS and embed.FS can seek, files of other file systems are read into memory.
+func openStaticFile(fsys fs.FS, name string) (io.ReadSeeker, io.Closer, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if seeker, ok := file.(io.ReadSeeker); ok {
+		return seeker, file, nil
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(content), io.NopCloser(nil), nil
+}
+
+// content type of the original file, not the precompressed variant
+func getStaticContentType(name string) string {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		return echo.MIMEOctetStream
+	}
+	return contentType
+}
+
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	for _, accepted := range strings.Split(acceptEncoding, ",") {
+		accepted = strings.TrimSpace(accepted)
+		name, params, _ := strings.Cut(accepted, ";")
+		if strings.TrimSpace(name) != encoding {
+			continue
+		}
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Serves static files from a file system under the configured static_prefix, e.g. a frontend build.
+Registered routes take precedence over static files. With static_spa enabled, unknown paths
+without a file extension are answered with the index file, so client side routing works.
+Paths under static_exclude_paths are never answered with files, e.g. "/api".
+Calling it again replaces the file system. Directories configured with static_dir are served automatically.
+
+	//go:embed dist
+	var assets embed.FS
+
+	dist, _ := fs.Sub(assets, "dist")
+	m.ServeStatic(dist)
+*/
+func (m *Module) ServeStatic(fsys fs.FS) {
+	m.static.mutex.Lock()
+	defer m.static.mutex.Unlock()
+
+	m.static.fsys = fsys
+	m.static.etags = make(map[string]staticETag)
+
+	if m.static.route != "" {
+		return
+	}
+	m.static.route = m.getStaticRoute()
+	m.server.Match([]string{http.MethodGet, http.MethodHead}, m.static.route, m.serveStatic)
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testStaticFS = fstest.MapFS{
+	"index.html":        {Data: []byte("<html>app</html>")},
+	"assets/app.js":     {Data: []byte("console.log('app')")},
+	"assets/app.js.gz":  {Data: []byte("gzip content")},
+	"assets/app.js.br":  {Data: []byte("brotli content")},
+	"assets/style.css":  {Data: []byte("body {}")},
+	"docs/index.html":   {Data: []byte("<html>docs</html>")},
+	"assets/image.webp": {Data: []byte("image")},
+}
+
+func newModuleWithStatic(config *Config) *Module {
+	config.ServerLogLevel = "PROD"
+	if config.StaticIndex == "" {
+		config.StaticIndex = DefaultStaticIndex
+	}
+	if config.StaticExcludePaths == "" {
+		config.StaticExcludePaths = DefaultStaticExcludePaths
+	}
+
+	m := &Module{
+		scope:  "server",
+		config: config,
+		logger: zap.NewNop(),
+	}
+	m.server = m.setupServer()
+	m.setUpMiddlewareChain()
+	return m
+}
+
+func serveStaticRequest(m *Module, method string, target string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	m.server.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServeStatic(t *testing.T) {
+	m := newModuleWithStatic(&Config{StaticMaxAgeInSeconds: 3600})
+	m.ServeStatic(testStaticFS)
+	m.server.GET("/api/users", func(c echo.Context) error {
+		return c.String(http.StatusOK, "users")
+	})
+
+	t.Run("TestFile", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/assets/style.css", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "body {}", rec.Body.String())
+		assert.Equal(t, "text/css; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "public, max-age=3600", rec.Header().Get(echo.HeaderCacheControl))
+		assert.NotEmpty(t, rec.Header().Get("ETag"))
+	})
+
+	t.Run("TestIndex", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "<html>app</html>", rec.Body.String())
+		assert.Equal(t, "no-cache", rec.Header().Get(echo.HeaderCacheControl))
+
+		rec = serveStaticRequest(m, http.MethodGet, "/docs/", nil)
+		assert.Equal(t, "<html>docs</html>", rec.Body.String())
+	})
+
+	t.Run("TestETag", func(t *testing.T) {
+		etag := serveStaticRequest(m, http.MethodGet, "/assets/style.css", nil).Header().Get("ETag")
+
+		rec := serveStaticRequest(m, http.MethodGet, "/assets/style.css", map[string]string{"If-None-Match": etag})
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Body.String())
+
+		rec = serveStaticRequest(m, http.MethodGet, "/assets/style.css", map[string]string{"If-None-Match": `"other"`})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("TestHead", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodHead, "/assets/style.css", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Body.String())
+	})
+
+	t.Run("TestRoutesTakePrecedence", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/api/users", nil)
+		assert.Equal(t, "users", rec.Body.String())
+	})
+
+	t.Run("TestNotFound", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/users/1", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = serveStaticRequest(m, http.MethodGet, "/../module.go", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("TestOtherMethods", func(t *testing.T) {
+		// unknown paths are not found, instead of matching the static route with a 405
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+			rec := serveStaticRequest(m, method, "/api/unknown", nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code, method)
+			assert.Empty(t, rec.Header().Get(echo.HeaderAllow), method)
+		}
+
+		// registered routes still respond with 405
+		rec := serveStaticRequest(m, http.MethodPost, "/api/users", nil)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, "OPTIONS, GET", rec.Header().Get(echo.HeaderAllow))
+	})
+}
+
+func TestServeStaticSPA(t *testing.T) {
+	m := newModuleWithStatic(&Config{StaticSPA: true})
+	m.ServeStatic(testStaticFS)
+
+	t.Run("TestHistoryFallback", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/users/1", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "<html>app</html>", rec.Body.String())
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, "no-cache", rec.Header().Get(echo.HeaderCacheControl))
+	})
+
+	t.Run("TestMissingAsset", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/assets/missing.js", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("TestExcludedPath", func(t *testing.T) {
+		rec := serveStaticRequest(m, http.MethodGet, "/api/unknown", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+
+		rec = serveStaticRequest(m, http.MethodGet, "/apis", nil)
+		assert.Equal(t, "<html>app</html>", rec.Body.String())
+	})
+}
+
+func TestServeStaticPrecompressed(t *testing.T) {
+	// precompressed files must not be compressed again by the gzip middleware
+	m := newModuleWithStatic(&Config{StaticPrecompressed: true, Gzip: true, GzipLevel: DefaultGzipLevel})
+	m.ServeStatic(testStaticFS)
+
+	tests := []struct {
+		acceptEncoding  string
+		contentEncoding string
+		body            string
+	}{
+		{"gzip, deflate, br", "br", "brotli content"},
+		{"gzip", "gzip", "gzip content"},
+		{"br;q=0, gzip", "gzip", "gzip content"},
+		{"", "", "console.log('app')"},
+	}
+
+	etags := make(map[string]bool)
+	for _, test := range tests {
+		rec := serveStaticRequest(m, http.MethodGet, "/assets/app.js", map[string]string{echo.HeaderAcceptEncoding: test.acceptEncoding})
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, test.contentEncoding, rec.Header().Get(echo.HeaderContentEncoding))
+		assert.Equal(t, test.body, rec.Body.String())
+		assert.Equal(t, "text/javascript; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Contains(t, rec.Header().Values(echo.HeaderVary), echo.HeaderAcceptEncoding)
+		etags[rec.Header().Get("ETag")] = true
+	}
+	// each variant has its own ETag
+	assert.Len(t, etags, 3)
+}
+
+func TestServeStaticPrefix(t *testing.T) {
+	m := newModuleWithStatic(&Config{StaticPrefix: "/app", StaticSPA: true})
+	m.ServeStatic(testStaticFS)
+
+	rec := serveStaticRequest(m, http.MethodGet, "/app/assets/style.css", nil)
+	assert.Equal(t, "body {}", rec.Body.String())
+
+	rec = serveStaticRequest(m, http.MethodGet, "/app/settings", nil)
+	assert.Equal(t, "<html>app</html>", rec.Body.String())
+
+	rec = serveStaticRequest(m, http.MethodGet, "/assets/style.css", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestStaticDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>dir</html>"), 0o644))
+
+	m := newModuleWithStatic(&Config{StaticDir: dir})
+	m.setUpStaticFiles()
+
+	rec := serveStaticRequest(m, http.MethodGet, "/", nil)
+	assert.Equal(t, "<html>dir</html>", rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get(echo.HeaderLastModified))
+
+	t.Run("TestMissingDir", func(t *testing.T) {
+		m := newModuleWithStatic(&Config{StaticDir: filepath.Join(dir, "missing")})
+		m.setUpStaticFiles()
+
+		rec := serveStaticRequest(m, http.MethodGet, "/", nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	assert.True(t, acceptsEncoding("gzip, br", "br"))
+	assert.True(t, acceptsEncoding("br;q=0.5", "br"))
+	assert.False(t, acceptsEncoding("br;q=0", "br"))
+	assert.False(t, acceptsEncoding("gzip", "br"))
+	assert.False(t, acceptsEncoding("", "gzip"))
+}
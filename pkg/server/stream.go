@@ -0,0 +1,440 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+var (
+	// returned by Send when the connection is closed
+	ErrConnectionClosed = errors.New("connection closed")
+	// returned by Send when the send buffer of the connection is full, the connection is closed
+	ErrSlowConsumer = errors.New("send buffer full, connection closed")
+)
+
+// Message pushed to SSE and WebSocket connections.
+// Data is encoded as JSON.
+type Message struct {
+	Event string      `json:"event,omitempty"`
+	Data  interface{} `json:"data"`
+}
+
+/*
+Callbacks of an SSE or WebSocket endpoint.
+OnConnect is called before the stream is opened, returning an error rejects the connection.
+Use it to subscribe the connection to topics, e.g. based on conn.User.
+OnMessage is only called for messages received on WebSocket connections.
+*/
+type StreamHandlers struct {
+	OnConnect    func(conn *Conn) error
+	OnMessage    func(conn *Conn, data []byte) error
+	OnDisconnect func(conn *Conn)
+}
+
+// A single SSE or WebSocket connection.
+type Conn struct {
+	ID string
+	// value stored under "user" by the JWT middleware of the endpoint, if any
+	User interface{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger *zap.Logger
+	send   chan Message
+
+	mutex    sync.Mutex
+	closed   bool
+	topics   map[string]bool
+	registry *connectionRegistry
+}
+
+// Tracks open connections and their topic subscriptions.
+type connectionRegistry struct {
+	mutex       sync.RWMutex
+	closed      bool
+	connections map[string]*Conn
+	topics      map[string]map[string]*Conn
+	// route paths of stream endpoints, skipped by the gzip and timeout middleware
+	paths map[string]bool
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newConnectionRegistry() *connectionRegistry {
+	return &connectionRegistry{
+		connections: make(map[string]*Conn),
+		topics:      make(map[string]map[string]*Conn),
+		paths:       make(map[string]bool),
+	}
+}
+
+func (r *connectionRegistry) addPath(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.paths[path] = true
+}
+
+// Streams are long-lived and flushed per message, so they can not be timed out or compressed.
+func (m *Module) isStreamRequest(c echo.Context) bool {
+	m.connections.mutex.RLock()
+	defer m.connections.mutex.RUnlock()
+
+	return m.connections.paths[c.Path()]
+}
+
+// Creates and registers a connection for the request.
+// Returns a 503 error if the server is shutting down or the connection limit is reached.
+func (m *Module) openConn(c echo.Context) (*Conn, error) {
+	id, err := newConnID()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	conn := &Conn{
+		ID:   id,
+		User: c.Get("user"),
+
+		ctx:    ctx,
+		cancel: cancel,
+		logger: m.logger.With(
+			zap.String("conn_id", id),
+			zap.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+		),
+		send:     make(chan Message, m.config.StreamSendBuffer),
+		topics:   make(map[string]bool),
+		registry: m.connections,
+	}
+
+	m.connections.mutex.Lock()
+	defer m.connections.mutex.Unlock()
+
+	if m.connections.closed {
+		cancel()
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "server is shutting down")
+	}
+	if m.config.StreamMaxConnections > 0 && len(m.connections.connections) >= m.config.StreamMaxConnections {
+		cancel()
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, "too many connections")
+	}
+	m.connections.connections[id] = conn
+
+	return conn, nil
+}
+
+func newConnID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate connection id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (r *connectionRegistry) remove(conn *Conn) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.connections, conn.ID)
+	for topic := range conn.topics {
+		r.removeFromTopic(topic, conn)
+	}
+}
+
+// must be called with the registry locked
+func (r *connectionRegistry) removeFromTopic(topic string, conn *Conn) {
+	subscribers := r.topics[topic]
+	delete(subscribers, conn.ID)
+	if len(subscribers) == 0 {
+		delete(r.topics, topic)
+	}
+}
+
+// Closes all connections and rejects new ones, called during onStop.
+func (r *connectionRegistry) closeAll() {
+	r.mutex.Lock()
+	r.closed = true
+	connections := make([]*Conn, 0, len(r.connections))
+	for _, conn := range r.connections {
+		connections = append(connections, conn)
+	}
+	r.mutex.Unlock()
+
+	for _, conn := range connections {
+		conn.Close()
+	}
+}
+
+func (r *connectionRegistry) getSubscribers(topic string) []*Conn {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	subscribers := make([]*Conn, 0, len(r.topics[topic]))
+	for _, conn := range r.topics[topic] {
+		subscribers = append(subscribers, conn)
+	}
+	return subscribers
+}
+
+func (r *connectionRegistry) getConnections() []*Conn {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	connections := make([]*Conn, 0, len(r.connections))
+	for _, conn := range r.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+func (m *Module) closeConn(conn *Conn, handlers StreamHandlers) {
+	conn.Close()
+	m.connections.remove(conn)
+	if handlers.OnDisconnect != nil {
+		handlers.OnDisconnect(conn)
+	}
+	conn.logger.Debug("connection closed")
+}
+
+// Falls back to the defaults for values that would panic the heartbeat ticker, or creating the send buffer.
+func (m *Module) validateStreamConfig() {
+	if m.config.StreamHeartbeatInSeconds <= 0 {
+		m.logger.Warn("stream_heartbeat_in_seconds must be positive, using default",
+			zap.Int("stream_heartbeat_in_seconds", m.config.StreamHeartbeatInSeconds),
+			zap.Int("default", DefaultStreamHeartbeatInSeconds),
+		)
+		m.config.StreamHeartbeatInSeconds = DefaultStreamHeartbeatInSeconds
+	}
+	// 0 is valid, but closes connections as slow consumers unless their writer is waiting for the next message
+	if m.config.StreamSendBuffer < 0 {
+		m.logger.Warn("stream_send_buffer must not be negative, using default",
+			zap.Int("stream_send_buffer", m.config.StreamSendBuffer),
+			zap.Int("default", DefaultStreamSendBuffer),
+		)
+		m.config.StreamSendBuffer = DefaultStreamSendBuffer
+	}
+}
+
+func (m *Module) getHeartbeatInterval() time.Duration {
+	return time.Duration(m.config.StreamHeartbeatInSeconds) * time.Second
+}
+
+// Serves a Server-Sent Events stream until the client disconnects or the connection is closed.
+func (m *Module) serveSSE(c echo.Context, handlers StreamHandlers) error {
+	conn, err := m.openConn(c)
+	if err != nil {
+		return err
+	}
+	defer m.closeConn(conn, handlers)
+
+	if handlers.OnConnect != nil {
+		if err := handlers.OnConnect(conn); err != nil {
+			return err
+		}
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	conn.logger.Debug("sse connection opened")
+
+	heartbeat := time.NewTicker(m.getHeartbeatInterval())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			return nil
+		case msg := <-conn.send:
+			if err := writeSSEMessage(res, msg); err != nil {
+				conn.logger.Debug("failed to write sse message", zap.Error(err))
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeSSEMessage(res *echo.Response, msg Message) error {
+	data, err := json.Marshal(msg.Data)
+	if err != nil {
+		return err
+	}
+	if msg.Event != "" {
+		if _, err := fmt.Fprintf(res, "event: %s\n", msg.Event); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(res, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Registers a Server-Sent Events endpoint.
+Pass the JWT middleware of the token module to authenticate connections, the parsed token is available as conn.User.
+
+	m.SSE("/events", server.StreamHandlers{
+		OnConnect: func(conn *server.Conn) error {
+			conn.Subscribe("orders")
+			return nil
+		},
+	}, tokenModule.GetJWTMiddleware("jwt_auth"))
+*/
+func (m *Module) SSE(path string, handlers StreamHandlers, middlewares ...echo.MiddlewareFunc) *echo.Route {
+	m.connections.addPath(path)
+	return m.server.GET(path, func(c echo.Context) error {
+		return m.serveSSE(c, handlers)
+	}, middlewares...)
+}
+
+// Sends a message to all connections subscribed to the topic.
+// Returns the number of connections the message was queued for.
+func (m *Module) Broadcast(topic string, msg Message) int {
+	return m.sendToAll(m.connections.getSubscribers(topic), msg)
+}
+
+// Sends a message to all open connections.
+// Returns the number of connections the message was queued for.
+func (m *Module) BroadcastAll(msg Message) int {
+	return m.sendToAll(m.connections.getConnections(), msg)
+}
+
+func (m *Module) sendToAll(connections []*Conn, msg Message) int {
+	sent := 0
+	for _, conn := range connections {
+		if err := conn.Send(msg); err != nil {
+			conn.logger.Warn("failed to send message", zap.String("event", msg.Event), zap.Error(err))
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// Returns an open connection by ID, or nil.
+func (m *Module) GetConnection(id string) *Conn {
+	m.connections.mutex.RLock()
+	defer m.connections.mutex.RUnlock()
+
+	return m.connections.connections[id]
+}
+
+// Returns the number of open connections.
+func (m *Module) GetConnectionCount() int {
+	m.connections.mutex.RLock()
+	defer m.connections.mutex.RUnlock()
+
+	return len(m.connections.connections)
+}
+
+// Returns the context of the connection, cancelled when the connection is closed.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+// Returns the logger of the connection, with the connection and request IDs attached.
+func (c *Conn) Logger() *zap.Logger {
+	return c.logger
+}
+
+/*
+Queues a message without blocking.
+If the send buffer is full, the client is not keeping up and the connection is closed.
+*/
+func (c *Conn) Send(msg Message) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return ErrConnectionClosed
+	}
+
+	select {
+	case c.send <- msg:
+		return nil
+	default:
+		c.closeLocked()
+		return ErrSlowConsumer
+	}
+}
+
+// Subscribes the connection to topics used by Broadcast.
+func (c *Conn) Subscribe(topics ...string) {
+	c.registry.mutex.Lock()
+	defer c.registry.mutex.Unlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, topic := range topics {
+		c.topics[topic] = true
+		if c.registry.topics[topic] == nil {
+			c.registry.topics[topic] = make(map[string]*Conn)
+		}
+		c.registry.topics[topic][c.ID] = c
+	}
+}
+
+// Unsubscribes the connection from topics.
+func (c *Conn) Unsubscribe(topics ...string) {
+	c.registry.mutex.Lock()
+	defer c.registry.mutex.Unlock()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, topic := range topics {
+		delete(c.topics, topic)
+		c.registry.removeFromTopic(topic, c)
+	}
+}
+
+// Returns the topics the connection is subscribed to.
+func (c *Conn) Topics() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Closes the connection. Safe to call multiple times.
+func (c *Conn) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closeLocked()
+}
+
+func (c *Conn) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.cancel()
+}
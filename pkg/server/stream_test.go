@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newModuleWithStreams(config *Config) *Module {
+	config.ServerLogLevel = "PROD"
+	if config.StreamHeartbeatInSeconds == 0 {
+		config.StreamHeartbeatInSeconds = DefaultStreamHeartbeatInSeconds
+	}
+	if config.StreamSendBuffer == 0 {
+		config.StreamSendBuffer = DefaultStreamSendBuffer
+	}
+	if config.WebSocketMaxMessageBytes == 0 {
+		config.WebSocketMaxMessageBytes = DefaultWebSocketMaxMessageBytes
+	}
+
+	m := &Module{
+		scope:  "server",
+		config: config,
+		logger: zap.NewNop(),
+	}
+	m.server = m.setupServer()
+	m.setUpMiddlewareChain()
+	return m
+}
+
+func waitForConnections(t *testing.T, m *Module, count int) {
+	assert.Eventually(t, func() bool {
+		return m.GetConnectionCount() == count
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestSSE(t *testing.T) {
+	m := newModuleWithStreams(&Config{})
+	m.SSE("/events", StreamHandlers{
+		OnConnect: func(conn *Conn) error {
+			conn.Subscribe("orders")
+			return nil
+		},
+	})
+
+	ts := httptest.NewServer(m.server)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get(echo.HeaderContentType))
+	waitForConnections(t, m, 1)
+
+	assert.Equal(t, 0, m.Broadcast("payments", Message{Event: "paid", Data: 1}))
+	assert.Equal(t, 1, m.Broadcast("orders", Message{Event: "created", Data: map[string]int{"id": 1}}))
+
+	reader := bufio.NewReader(resp.Body)
+	line, _ := reader.ReadString('\n')
+	assert.Equal(t, "event: created\n", line)
+	line, _ = reader.ReadString('\n')
+	assert.Equal(t, "data: {\"id\":1}\n", line)
+
+	// closing all connections ends the stream
+	m.connections.closeAll()
+	waitForConnections(t, m, 0)
+
+	_, err = http.Get(ts.URL + "/events")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, m.GetConnectionCount())
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	// streams are not cancelled by the request timeout
+	m := newModuleWithStreams(&Config{StreamHeartbeatInSeconds: 2, TimeoutInSeconds: 1})
+	m.SSE("/events", StreamHandlers{})
+
+	ts := httptest.NewServer(m.server)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, ": ping\n", line)
+}
+
+func TestInvalidStreamConfig(t *testing.T) {
+	m := newModuleWithStreams(&Config{StreamHeartbeatInSeconds: -1, StreamSendBuffer: -1})
+	m.validateStreamConfig()
+	assert.Equal(t, DefaultStreamHeartbeatInSeconds*time.Second, m.getHeartbeatInterval())
+	assert.Equal(t, DefaultStreamSendBuffer, m.config.StreamSendBuffer)
+
+	// connections do not panic on the ticker, nor the send buffer
+	m.SSE("/events", StreamHandlers{})
+	ts := httptest.NewServer(m.server)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	waitForConnections(t, m, 1)
+	m.connections.closeAll()
+}
+
+func TestSSERejectedOnConnect(t *testing.T) {
+	m := newModuleWithStreams(&Config{})
+	auth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get(echo.HeaderAuthorization) == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing or malformed jwt")
+			}
+			c.Set("user", "user123")
+			return next(c)
+		}
+	}
+	m.SSE("/events", StreamHandlers{
+		OnConnect: func(conn *Conn) error {
+			if conn.User != "user123" {
+				return NewError(http.StatusForbidden, "forbidden")
+			}
+			return nil
+		},
+	}, auth)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	m.server.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, 0, m.GetConnectionCount())
+}
+
+func TestStreamMaxConnections(t *testing.T) {
+	m := newModuleWithStreams(&Config{StreamMaxConnections: 1})
+	m.SSE("/events", StreamHandlers{})
+
+	ts := httptest.NewServer(m.server)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	waitForConnections(t, m, 1)
+
+	resp2, err := http.Get(ts.URL + "/events")
+	assert.NoError(t, err)
+	defer resp2.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp2.StatusCode)
+
+	m.connections.closeAll()
+}
+
+func TestConnSend(t *testing.T) {
+	m := newModuleWithStreams(&Config{StreamSendBuffer: 1})
+	m.connections = newConnectionRegistry()
+
+	c := m.server.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	conn, err := m.openConn(c)
+	assert.NoError(t, err)
+
+	t.Run("TestSlowConsumer", func(t *testing.T) {
+		assert.NoError(t, conn.Send(Message{Data: 1}))
+		assert.ErrorIs(t, conn.Send(Message{Data: 2}), ErrSlowConsumer)
+		assert.ErrorIs(t, conn.Context().Err(), context.Canceled)
+	})
+
+	t.Run("TestClosed", func(t *testing.T) {
+		assert.ErrorIs(t, conn.Send(Message{Data: 3}), ErrConnectionClosed)
+	})
+
+	t.Run("TestSubscriptions", func(t *testing.T) {
+		conn.Subscribe("a", "b")
+		assert.ElementsMatch(t, []string{"a", "b"}, conn.Topics())
+		assert.Len(t, m.connections.getSubscribers("a"), 1)
+
+		conn.Unsubscribe("a")
+		assert.Equal(t, []string{"b"}, conn.Topics())
+		assert.Len(t, m.connections.getSubscribers("a"), 0)
+
+		m.connections.remove(conn)
+		assert.Len(t, m.connections.getSubscribers("b"), 0)
+		assert.Nil(t, m.GetConnection(conn.ID))
+	})
+}
+
+func TestWebSocket(t *testing.T) {
+	m := newModuleWithStreams(&Config{AllowOrigins: "http://allowed.com"})
+	disconnected := make(chan string, 3)
+	m.WebSocket("/ws", StreamHandlers{
+		OnConnect: func(conn *Conn) error {
+			conn.Subscribe("chat")
+			return nil
+		},
+		OnMessage: func(conn *Conn, data []byte) error {
+			m.Broadcast("chat", Message{Event: "chat", Data: string(data)})
+			return nil
+		},
+		OnDisconnect: func(conn *Conn) {
+			disconnected <- conn.ID
+		},
+	})
+
+	ts := httptest.NewServer(m.server)
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+
+	t.Run("TestInvalidOrigin", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.com"}})
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		waitForConnections(t, m, 0)
+		<-disconnected
+	})
+
+	t.Run("TestMessages", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://allowed.com"}})
+		assert.NoError(t, err)
+		defer ws.Close()
+		waitForConnections(t, m, 1)
+
+		assert.NoError(t, ws.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+		var msg Message
+		assert.NoError(t, ws.ReadJSON(&msg))
+		assert.Equal(t, "chat", msg.Event)
+		assert.Equal(t, "hello", msg.Data)
+	})
+
+	t.Run("TestCloseOnStop", func(t *testing.T) {
+		ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.NoError(t, err)
+		defer ws.Close()
+		waitForConnections(t, m, 1)
+
+		m.connections.closeAll()
+
+		_, _, err = ws.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway))
+		waitForConnections(t, m, 0)
+	})
+}
@@ -0,0 +1,120 @@
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{ .Title }}</title>
+  <style>
+    body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #1f2328; background: #f6f8fa; }
+    header { background: #24292f; color: #fff; padding: 16px 32px; }
+    header h1 { margin: 0; font-size: 20px; }
+    header small { color: #8c959f; }
+    main { max-width: 1080px; margin: 24px auto; padding: 0 16px; }
+    details { background: #fff; border: 1px solid #d0d7de; border-radius: 6px; margin-bottom: 8px; }
+    summary { cursor: pointer; padding: 10px 16px; font-family: monospace; font-size: 14px; }
+    .method { display: inline-block; min-width: 64px; font-weight: bold; text-transform: uppercase; }
+    .get { color: #0969da; } .post { color: #1a7f37; } .put { color: #9a6700; } .patch { color: #8250df; } .delete { color: #cf222e; }
+    .op { padding: 0 16px 16px; }
+    .op h4 { margin: 16px 0 8px; font-size: 13px; text-transform: uppercase; color: #57606a; }
+    table { border-collapse: collapse; width: 100%; font-size: 13px; }
+    td, th { text-align: left; border-bottom: 1px solid #d0d7de; padding: 4px 8px; }
+    pre { background: #f6f8fa; padding: 8px; border-radius: 6px; overflow: auto; font-size: 12px; }
+    .summary-text { color: #57606a; font-family: sans-serif; margin-left: 8px; }
+  </style>
+</head>
+<body>
+  <header>
+    <h1 id="title">{{ .Title }}</h1>
+    <small>OpenAPI document: <a style="color:#8c959f" href="{{ .SpecPath }}">{{ .SpecPath }}</a></small>
+  </header>
+  <main id="operations"></main>
+  <script>
+    const specPath = {{ .SpecPath }};
+
+    function resolve(spec, schema) {
+      if (schema && schema.$ref) {
+        return spec.components.schemas[schema.$ref.split("/").pop()];
+      }
+      return schema;
+    }
+
+    // expands component references one level deep, recursive types stay as references
+    function expand(spec, schema, seen) {
+      schema = resolve(spec, schema) || {};
+      if (schema.type === "array") {
+        return [expand(spec, schema.items, seen)];
+      }
+      if (schema.type === "object" && schema.properties) {
+        const result = {};
+        for (const [name, property] of Object.entries(schema.properties)) {
+          if (property.$ref && seen.includes(property.$ref)) {
+            result[name] = property.$ref;
+            continue;
+          }
+          result[name] = expand(spec, property, seen.concat(property.$ref ? [property.$ref] : []));
+        }
+        return result;
+      }
+      return schema.format ? schema.type + " (" + schema.format + ")" : (schema.enum ? schema.enum.join(" | ") : schema.type || "any");
+    }
+
+    function element(tag, attributes, children) {
+      const el = document.createElement(tag);
+      Object.assign(el, attributes || {});
+      (children || []).forEach((child) => el.append(child));
+      return el;
+    }
+
+    function renderOperation(spec, path, method, op) {
+      const content = element("div", { className: "op" });
+      if (op.description) {
+        content.append(element("p", { textContent: op.description }));
+      }
+      if (op.parameters && op.parameters.length) {
+        const rows = op.parameters.map((p) => element("tr", {}, [
+          element("td", { textContent: p.name }),
+          element("td", { textContent: p.in }),
+          element("td", { textContent: p.required ? "required" : "" }),
+          element("td", { textContent: JSON.stringify(expand(spec, p.schema, [])) }),
+        ]));
+        content.append(element("h4", { textContent: "Parameters" }), element("table", {}, rows));
+      }
+      if (op.requestBody) {
+        const schema = op.requestBody.content["application/json"].schema;
+        content.append(element("h4", { textContent: "Request body" }), element("pre", { textContent: JSON.stringify(expand(spec, schema, []), null, 2) }));
+      }
+      for (const [status, response] of Object.entries(op.responses)) {
+        const media = response.content ? Object.values(response.content)[0] : null;
+        content.append(element("h4", { textContent: "Response " + status + " - " + response.description }));
+        if (media) {
+          content.append(element("pre", { textContent: JSON.stringify(expand(spec, media.schema, []), null, 2) }));
+        }
+      }
+
+      return element("details", {}, [
+        element("summary", {}, [
+          element("span", { className: "method " + method, textContent: method }),
+          path,
+          element("span", { className: "summary-text", textContent: op.summary || "" }),
+        ]),
+        content,
+      ]);
+    }
+
+    fetch(specPath)
+      .then((response) => response.json())
+      .then((spec) => {
+        document.getElementById("title").textContent = spec.info.title + " " + spec.info.version;
+        const container = document.getElementById("operations");
+        for (const [path, operations] of Object.entries(spec.paths)) {
+          for (const [method, op] of Object.entries(operations)) {
+            container.append(renderOperation(spec, path, method, op));
+          }
+        }
+      })
+      .catch((err) => {
+        document.getElementById("operations").textContent = "Failed to load " + specPath + ": " + err;
+      });
+  </script>
+</body>
+</html>
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// Echo validator backed by "validate" struct tags.
+// Field errors are reported using the json, query, param or form tag name of the field.
+type requestValidator struct {
+	validate *validator.Validate
+	messages map[string]string
+}
+
+// messages for the most common tags, others fall back to a generic message
+var defaultValidationMessages = map[string]string{
+	"required": "is required",
+	"email":    "must be a valid email address",
+	"url":      "must be a valid URL",
+	"uuid":     "must be a valid UUID",
+	"min":      "must be at least %s",
+	"max":      "must be at most %s",
+	"len":      "must have length %s",
+	"gt":       "must be greater than %s",
+	"gte":      "must be greater than or equal to %s",
+	"lt":       "must be less than %s",
+	"lte":      "must be less than or equal to %s",
+	"oneof":    "must be one of [%s]",
+	"eqfield":  "must be equal to %s",
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newRequestValidator() *requestValidator {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(getFieldName)
+
+	messages := make(map[string]string, len(defaultValidationMessages))
+	for tag, message := range defaultValidationMessages {
+		messages[tag] = message
+	}
+
+	return &requestValidator{
+		validate: v,
+		messages: messages,
+	}
+}
+
+// Implements echo.Validator, returns *ValidationError if the struct is invalid.
+func (v *requestValidator) Validate(i interface{}) error {
+	err := v.validate.Struct(i)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return err
+	}
+
+	fieldErrors := make([]FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   getFieldPath(fe),
+			Message: v.getMessage(fe),
+		})
+	}
+	return &ValidationError{Errors: fieldErrors}
+}
+
+func (v *requestValidator) getMessage(fe validator.FieldError) string {
+	message, exists := v.messages[fe.Tag()]
+	if !exists {
+		return fmt.Sprintf("failed on the '%s' validation", fe.Tag())
+	}
+	if strings.Contains(message, "%s") {
+		return fmt.Sprintf(message, fe.Param())
+	}
+	return message
+}
+
+// Returns the name of the field as the client sees it.
+func getFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "query", "param", "form"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// Strips the top level struct name, e.g. "CreateUserRequest.address.city" -> "address.city"
+func getFieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return fe.Field()
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Binds path params, query params and body into a new T, then validates it.
+Bind failures are returned as a 400 *Error, validation failures as *ValidationError.
+Both are rendered as problem+json by the server error handler.
+
+	type GetUserRequest struct {
+		ID     int    `param:"id" validate:"required,gt=0"`
+		Fields string `query:"fields"`
+	}
+
+	req, err := server.BindRequest[GetUserRequest](c)
+	if err != nil {
+		return err
+	}
+*/
+func BindRequest[T any](c echo.Context) (*T, error) {
+	req := new(T)
+
+	binder := &echo.DefaultBinder{}
+	if err := binder.BindPathParams(c, req); err != nil {
+		return nil, NewError(http.StatusBadRequest, "invalid path parameters").Wrap(err)
+	}
+	if err := binder.BindQueryParams(c, req); err != nil {
+		return nil, NewError(http.StatusBadRequest, "invalid query parameters").Wrap(err)
+	}
+	if err := binder.BindBody(c, req); err != nil {
+		return nil, NewError(http.StatusBadRequest, "invalid request body").Wrap(err)
+	}
+
+	if err := c.Validate(req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+/*
+Registers a custom validation tag, to be used by domain modules.
+Message is returned to the client as the field error, and may contain a single "%s" for the tag parameter.
+Must be called before the server starts handling requests.
+*/
+func (m *Module) RegisterValidation(tag string, fn validator.Func, message string) error {
+	err := m.validator.validate.RegisterValidation(tag, fn)
+	if err != nil {
+		return err
+	}
+	if message != "" {
+		m.validator.messages[tag] = message
+	}
+	return nil
+}
+
+/*
+Registers a struct level validation for the given types, used for rules spanning multiple fields.
+Report errors with sl.ReportError, the tag is used to look up the message.
+Must be called before the server starts handling requests.
+*/
+func (m *Module) RegisterStructValidation(fn validator.StructLevelFunc, types ...interface{}) {
+	m.validator.validate.RegisterStructValidation(fn, types...)
+}
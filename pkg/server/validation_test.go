@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testAssignRoleRequest struct {
+	Role string `json:"role" validate:"required,role"`
+}
+
+type testUpdateUserRequest struct {
+	ID      int         `param:"id" validate:"required,gt=0"`
+	Notify  bool        `query:"notify"`
+	Email   string      `json:"email" validate:"required,email"`
+	Name    string      `json:"name" validate:"min=2"`
+	Address testAddress `json:"address"`
+}
+
+func newModuleWithValidation() *Module {
+	m := &Module{
+		scope:  "server",
+		config: &Config{ServerLogLevel: "PROD"},
+		logger: zap.NewNop(),
+	}
+	m.server = m.setupServer()
+	return m
+}
+
+func TestBindRequest(t *testing.T) {
+	m := newModuleWithValidation()
+
+	var bound *testUpdateUserRequest
+	m.server.PUT("/users/:id", func(c echo.Context) error {
+		req, err := BindRequest[testUpdateUserRequest](c)
+		if err != nil {
+			return err
+		}
+		bound = req
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	send := func(path string, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		m.server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("TestValidRequest", func(t *testing.T) {
+		rec := send("/users/7?notify=true", `{"email":"a@example.com","name":"Al","address":{"city":"Taipei"}}`)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, 7, bound.ID)
+		assert.True(t, bound.Notify)
+		assert.Equal(t, "a@example.com", bound.Email)
+		assert.Equal(t, "Taipei", bound.Address.City)
+	})
+
+	t.Run("TestInvalidRequest", func(t *testing.T) {
+		rec := send("/users/7", `{"email":"invalid","name":"A"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		var problem Problem
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+		assert.ElementsMatch(t, []FieldError{
+			{Field: "email", Message: "must be a valid email address"},
+			{Field: "name", Message: "must be at least 2"},
+			{Field: "address.city", Message: "is required"},
+		}, problem.Errors)
+	})
+
+	t.Run("TestMalformedBody", func(t *testing.T) {
+		rec := send("/users/7", `{"email":`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("TestInvalidPathParam", func(t *testing.T) {
+		rec := send("/users/abc", `{}`)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestRegisterValidation(t *testing.T) {
+	m := newModuleWithValidation()
+
+	err := m.RegisterValidation("role", func(fl validator.FieldLevel) bool {
+		return fl.Field().String() == "admin" || fl.Field().String() == "member"
+	}, "must be a valid role")
+	assert.NoError(t, err)
+
+	err = m.server.Validator.Validate(&testAssignRoleRequest{Role: "admin"})
+	assert.NoError(t, err)
+
+	err = m.server.Validator.Validate(&testAssignRoleRequest{Role: "owner"})
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []FieldError{{Field: "role", Message: "must be a valid role"}}, validationErr.Errors)
+}
+
+func TestRegisterStructValidation(t *testing.T) {
+	m := newModuleWithValidation()
+
+	m.RegisterStructValidation(func(sl validator.StructLevel) {
+		req := sl.Current().Interface().(testAddress)
+		if req.City == "Atlantis" {
+			sl.ReportError(req.City, "city", "City", "real_city", "")
+		}
+	}, testAddress{})
+
+	err := m.server.Validator.Validate(&testAddress{City: "Atlantis"})
+
+	var validationErr *ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []FieldError{{Field: "city", Message: "failed on the 'real_city' validation"}}, validationErr.Errors)
+}
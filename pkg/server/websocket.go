@@ -0,0 +1,157 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// time allowed to write a message or control frame to the client
+const webSocketWriteTimeout = 10 * time.Second
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) newWebSocketUpgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: m.checkWebSocketOrigin,
+	}
+}
+
+// Allows the origins configured for CORS, or all origins if unspecified.
+// Requests without an Origin header are not from browsers and are allowed.
+func (m *Module) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin == "" || m.config.AllowOrigins == "" || m.config.AllowOrigins == "*" {
+		return true
+	}
+	for _, allowed := range strings.Split(m.config.AllowOrigins, ",") {
+		if strings.TrimSpace(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// Serves a WebSocket connection until either side closes it.
+func (m *Module) serveWebSocket(c echo.Context, handlers StreamHandlers) error {
+	conn, err := m.openConn(c)
+	if err != nil {
+		return err
+	}
+	defer m.closeConn(conn, handlers)
+
+	if handlers.OnConnect != nil {
+		if err := handlers.OnConnect(conn); err != nil {
+			return err
+		}
+	}
+
+	upgrader := m.newWebSocketUpgrader()
+	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader has already responded with an error
+		conn.logger.Debug("websocket upgrade failed", zap.Error(err))
+		return nil
+	}
+
+	conn.logger.Debug("websocket connection opened")
+
+	// the writer owns the connection and closes it when done
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.writeWebSocket(conn, ws)
+	}()
+
+	m.readWebSocket(conn, ws, handlers)
+	conn.Close()
+	<-done
+
+	return nil
+}
+
+func (m *Module) readWebSocket(conn *Conn, ws *websocket.Conn, handlers StreamHandlers) {
+	// the client must answer pings within two heartbeats
+	pongTimeout := 2 * m.getHeartbeatInterval()
+
+	ws.SetReadLimit(int64(m.config.WebSocketMaxMessageBytes))
+	ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				conn.logger.Debug("websocket read failed", zap.Error(err))
+			}
+			return
+		}
+
+		if handlers.OnMessage == nil {
+			continue
+		}
+		if err := handlers.OnMessage(conn, data); err != nil {
+			conn.logger.Warn("websocket message handler failed, closing connection", zap.Error(err))
+			return
+		}
+	}
+}
+
+func (m *Module) writeWebSocket(conn *Conn, ws *websocket.Conn) {
+	heartbeat := time.NewTicker(m.getHeartbeatInterval())
+	defer heartbeat.Stop()
+	defer ws.Close()
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			closeMessage := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+			ws.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(webSocketWriteTimeout))
+			return
+		case msg := <-conn.send:
+			ws.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if err := ws.WriteJSON(msg); err != nil {
+				conn.logger.Debug("websocket write failed", zap.Error(err))
+				conn.Close()
+				return
+			}
+		case <-heartbeat.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout)); err != nil {
+				conn.logger.Debug("websocket ping failed", zap.Error(err))
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Registers a WebSocket endpoint. Messages sent with Conn.Send or Broadcast are written as JSON.
+Browsers can not set headers on WebSocket requests, so authenticate with a token scope
+using a cookie or query token lookup, e.g. tokenModule.GetJWTMiddleware("jwt_auth") with "cookie:jwt".
+
+	m.WebSocket("/ws", server.StreamHandlers{
+		OnConnect: func(conn *server.Conn) error {
+			conn.Subscribe("chat")
+			return nil
+		},
+		OnMessage: func(conn *server.Conn, data []byte) error {
+			m.Broadcast("chat", server.Message{Event: "chat", Data: string(data)})
+			return nil
+		},
+	}, tokenModule.GetJWTMiddleware("jwt_auth"))
+*/
+func (m *Module) WebSocket(path string, handlers StreamHandlers, middlewares ...echo.MiddlewareFunc) *echo.Route {
+	m.connections.addPath(path)
+	return m.server.GET(path, func(c echo.Context) error {
+		return m.serveWebSocket(c, handlers)
+	}, middlewares...)
+}
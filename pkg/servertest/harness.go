@@ -0,0 +1,376 @@
+/*
+Package servertest runs apps built on the server module in-process for tests.
+The fx graph is built and started as usual, but the server does not bind a port.
+Requests are served through echo's ServeHTTP and recorded with httptest.
+*/
+package servertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/alsey89/gogetter/pkg/server"
+	"github.com/alsey89/gogetter/pkg/token"
+	"github.com/alsey89/gogetter/pkg/util"
+)
+
+// name of the cookie issued by the CSRF middleware of the server module
+const CSRFCookieName = "_csrf"
+
+// In-process test harness, holding the fx app and the cookies of the client.
+type Harness struct {
+	t       testing.TB
+	app     *fxtest.App
+	server  *server.Module
+	token   *token.Module
+	cookies map[string]*http.Cookie
+	headers http.Header
+}
+
+// Response of a request served by the harness.
+type Response struct {
+	*httptest.ResponseRecorder
+	t testing.TB
+}
+
+// Modifies a request before it is served.
+type RequestOption func(h *Harness, req *http.Request)
+
+type dependencies struct {
+	fx.In
+
+	Server *server.Module
+	Token  *token.Module `optional:"true"`
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Builds and starts an fx app with the server module of the given scope, without listening.
+Pass the other modules and domains of the app as options. A test logger is supplied,
+so the logger module should not be included. The app is stopped when the test ends.
+
+	h := servertest.New(t, "server",
+		token.InjectModule("jwt", "jwt_auth"),
+		user.InjectDomain("user"),
+	)
+	res := h.PostJSON("/users", map[string]string{"email": "a@example.com"})
+	assert.Equal(t, http.StatusCreated, res.Code)
+*/
+func New(t testing.TB, scope string, opts ...fx.Option) *Harness {
+	t.Helper()
+
+	// restored when the test ends, so later apps of the scope still listen
+	listenKey := util.GetConfigPath(scope, "listen")
+	previous := viper.Get(listenKey)
+	viper.Set(listenKey, false)
+	t.Cleanup(func() { restoreConfig(listenKey, previous) })
+
+	h := &Harness{
+		t:       t,
+		cookies: make(map[string]*http.Cookie),
+		headers: make(http.Header),
+	}
+
+	var deps dependencies
+	options := []fx.Option{
+		fx.Supply(zaptest.NewLogger(t)),
+		server.InjectModule(scope),
+		fx.Populate(&deps),
+		fx.NopLogger,
+	}
+	options = append(options, opts...)
+
+	h.app = fxtest.New(t, options...)
+	h.app.RequireStart()
+	t.Cleanup(h.app.RequireStop)
+
+	h.server = deps.Server
+	h.token = deps.Token
+
+	return h
+}
+
+// Returns the server module of the app.
+func (h *Harness) Server() *server.Module {
+	return h.server
+}
+
+// Returns the echo instance of the server module, e.g. to register routes in the test.
+func (h *Harness) Echo() *echo.Echo {
+	return h.server.GetServer()
+}
+
+// Sets a header sent with every following request.
+func (h *Harness) SetHeader(key string, value string) {
+	h.headers.Set(key, value)
+}
+
+// Sets a cookie sent with every following request.
+func (h *Harness) SetCookie(cookie *http.Cookie) {
+	h.cookies[cookie.Name] = cookie
+}
+
+// Returns a cookie stored from previous responses, or nil.
+func (h *Harness) Cookie(name string) *http.Cookie {
+	return h.cookies[name]
+}
+
+// Removes all stored cookies and headers.
+func (h *Harness) ClearSession() {
+	h.cookies = make(map[string]*http.Cookie)
+	h.headers = make(http.Header)
+}
+
+/*
+Generates a token for the token scope with the token module, and sends it with every following request.
+The token is placed according to the token lookup of the scope, e.g. the "jwt" cookie for "cookie:jwt".
+Requires the token module to be passed to New.
+*/
+func (h *Harness) AuthenticateJWT(tokenScope string, claims jwt.MapClaims) {
+	h.t.Helper()
+
+	lookup, token := h.generateToken(tokenScope, claims)
+	h.setToken(lookup, token)
+}
+
+/*
+Returns the CSRF token issued by the server, requesting one with a GET to "/" if no cookie is stored yet.
+Requires csrf_protection to be enabled on the server scope.
+*/
+func (h *Harness) CSRFToken() string {
+	h.t.Helper()
+
+	if cookie := h.cookies[CSRFCookieName]; cookie != nil {
+		return cookie.Value
+	}
+
+	h.Get("/")
+	cookie := h.cookies[CSRFCookieName]
+	if cookie == nil {
+		h.t.Fatalf("servertest: no %s cookie issued, is csrf_protection enabled?", CSRFCookieName)
+	}
+	return cookie.Value
+}
+
+// Serves the request in-process, sending and storing cookies.
+func (h *Harness) Do(req *http.Request, opts ...RequestOption) *Response {
+	h.t.Helper()
+
+	for key, values := range h.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	for _, cookie := range h.cookies {
+		req.AddCookie(cookie)
+	}
+	for _, opt := range opts {
+		opt(h, req)
+	}
+
+	rec := httptest.NewRecorder()
+	h.server.GetServer().ServeHTTP(rec, req)
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.MaxAge < 0 || cookie.Value == "" {
+			delete(h.cookies, cookie.Name)
+			continue
+		}
+		h.cookies[cookie.Name] = cookie
+	}
+
+	return &Response{ResponseRecorder: rec, t: h.t}
+}
+
+// Serves a request with the given method, path and body.
+func (h *Harness) Request(method string, path string, body io.Reader, opts ...RequestOption) *Response {
+	h.t.Helper()
+	return h.Do(httptest.NewRequest(method, path, body), opts...)
+}
+
+func (h *Harness) Get(path string, opts ...RequestOption) *Response {
+	h.t.Helper()
+	return h.Request(http.MethodGet, path, nil, opts...)
+}
+
+func (h *Harness) Delete(path string, opts ...RequestOption) *Response {
+	h.t.Helper()
+	return h.Request(http.MethodDelete, path, nil, opts...)
+}
+
+// Serves a POST request with the body encoded as JSON.
+func (h *Harness) PostJSON(path string, body interface{}, opts ...RequestOption) *Response {
+	h.t.Helper()
+	return h.requestJSON(http.MethodPost, path, body, opts...)
+}
+
+// Serves a PUT request with the body encoded as JSON.
+func (h *Harness) PutJSON(path string, body interface{}, opts ...RequestOption) *Response {
+	h.t.Helper()
+	return h.requestJSON(http.MethodPut, path, body, opts...)
+}
+
+// Serves a PATCH request with the body encoded as JSON.
+func (h *Harness) PatchJSON(path string, body interface{}, opts ...RequestOption) *Response {
+	h.t.Helper()
+	return h.requestJSON(http.MethodPatch, path, body, opts...)
+}
+
+// Sets a header on a single request.
+func WithHeader(key string, value string) RequestOption {
+	return func(h *Harness, req *http.Request) {
+		req.Header.Set(key, value)
+	}
+}
+
+// Adds a cookie to a single request.
+func WithCookie(cookie *http.Cookie) RequestOption {
+	return func(h *Harness, req *http.Request) {
+		req.AddCookie(cookie)
+	}
+}
+
+// Sends the CSRF token in the X-CSRF-Token header, fetching it first if needed.
+func WithCSRFToken() RequestOption {
+	return func(h *Harness, req *http.Request) {
+		csrfToken := h.CSRFToken()
+		if _, err := req.Cookie(CSRFCookieName); err != nil {
+			req.AddCookie(h.cookies[CSRFCookieName])
+		}
+		req.Header.Set(echo.HeaderXCSRFToken, csrfToken)
+	}
+}
+
+// Authenticates a single request with a token generated for the token scope.
+func WithJWT(tokenScope string, claims jwt.MapClaims) RequestOption {
+	return func(h *Harness, req *http.Request) {
+		lookup, token := h.generateToken(tokenScope, claims)
+		applyToken(req, lookup, token)
+	}
+}
+
+// Decodes the JSON body of the response, failing the test on error.
+func (r *Response) DecodeJSON(v interface{}) {
+	r.t.Helper()
+
+	if err := json.Unmarshal(r.Body.Bytes(), v); err != nil {
+		r.t.Fatalf("servertest: failed to decode response body %q: %v", r.Body.String(), err)
+	}
+}
+
+// Decodes the problem+json body of an error response.
+func (r *Response) Problem() server.Problem {
+	r.t.Helper()
+
+	var problem server.Problem
+	r.DecodeJSON(&problem)
+	return problem
+}
+
+// Formats the status and body, useful in assertion messages.
+func (r *Response) String() string {
+	return fmt.Sprintf("%d %s", r.Code, r.Body.String())
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// A nil override falls through to the config file, env and defaults. The previous value is pinned only if it came from an override.
+func restoreConfig(key string, previous interface{}) {
+	viper.Set(key, nil)
+	if viper.Get(key) != previous {
+		viper.Set(key, previous)
+	}
+}
+
+func (h *Harness) requestJSON(method string, path string, body interface{}, opts ...RequestOption) *Response {
+	h.t.Helper()
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		h.t.Fatalf("servertest: failed to encode request body: %v", err)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	return h.Do(req, opts...)
+}
+
+func (h *Harness) generateToken(tokenScope string, claims jwt.MapClaims) (string, string) {
+	h.t.Helper()
+
+	if h.token == nil {
+		h.t.Fatalf("servertest: token module not provided, pass token.InjectModule to New")
+	}
+
+	lookup, err := h.token.GetTokenLookup(tokenScope)
+	if err != nil {
+		h.t.Fatalf("servertest: %v", err)
+	}
+	token, err := h.token.GenerateToken(tokenScope, claims)
+	if err != nil {
+		h.t.Fatalf("servertest: failed to generate token: %v", err)
+	}
+
+	return lookup, *token
+}
+
+// stores the token so it is sent with every following request
+func (h *Harness) setToken(lookup string, token string) {
+	source, name, prefix := parseTokenLookup(lookup)
+	switch source {
+	case "cookie":
+		h.cookies[name] = &http.Cookie{Name: name, Value: token}
+	case "header":
+		h.headers.Set(name, prefix+token)
+	default:
+		h.t.Fatalf("servertest: token lookup %q can not be applied to every request, use WithJWT", lookup)
+	}
+}
+
+func applyToken(req *http.Request, lookup string, token string) {
+	source, name, prefix := parseTokenLookup(lookup)
+	switch source {
+	case "cookie":
+		req.AddCookie(&http.Cookie{Name: name, Value: token})
+	case "query":
+		query := req.URL.Query()
+		query.Set(name, token)
+		req.URL.RawQuery = query.Encode()
+	case "form":
+		form := url.Values{name: {token}}
+		req.Body = io.NopCloser(strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	default:
+		req.Header.Set(name, prefix+token)
+	}
+}
+
+// Uses the first source of a token lookup, e.g. "header:Authorization:Bearer ,cookie:jwt".
+// Defaults to the Authorization header with the Bearer prefix if empty, as echo-jwt does.
+func parseTokenLookup(lookup string) (source string, name string, prefix string) {
+	first := strings.TrimLeft(strings.Split(lookup, ",")[0], " ")
+	parts := strings.SplitN(first, ":", 3)
+	if len(parts) < 2 {
+		return "header", echo.HeaderAuthorization, "Bearer "
+	}
+	if len(parts) == 3 {
+		prefix = parts[2]
+	}
+	return parts[0], parts[1], prefix
+}
@@ -0,0 +1,190 @@
+package servertest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx"
+
+	"github.com/alsey89/gogetter/pkg/server"
+	"github.com/alsey89/gogetter/pkg/token"
+)
+
+type testUser struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func TestNew(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h := New(t, "server")
+
+	assert.NotNil(t, h.Server())
+	assert.Equal(t, "server", h.Server().GetScope())
+	assert.Equal(t, false, viper.GetBool("server.listen"))
+}
+
+func TestListenRestored(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.SetDefault("admin.listen", true)
+	t.Run("TestHarness", func(t *testing.T) {
+		New(t, "admin")
+		assert.False(t, viper.GetBool("admin.listen"))
+	})
+	assert.True(t, viper.GetBool("admin.listen"))
+
+	viper.Set("admin.listen", false)
+	t.Run("TestHarnessWithOverride", func(t *testing.T) {
+		New(t, "admin")
+	})
+	assert.False(t, viper.GetBool("admin.listen"))
+}
+
+func TestJSONRequests(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h := New(t, "server")
+	h.Echo().POST("/users", func(c echo.Context) error {
+		req, err := server.BindRequest[testUser](c)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusCreated, req)
+	})
+
+	t.Run("TestValidBody", func(t *testing.T) {
+		res := h.PostJSON("/users", testUser{Email: "a@example.com"})
+		assert.Equal(t, http.StatusCreated, res.Code, res.String())
+
+		var user testUser
+		res.DecodeJSON(&user)
+		assert.Equal(t, "a@example.com", user.Email)
+	})
+
+	t.Run("TestInvalidBody", func(t *testing.T) {
+		res := h.PostJSON("/users", testUser{Email: "invalid"})
+		assert.Equal(t, http.StatusUnprocessableEntity, res.Code)
+
+		problem := res.Problem()
+		assert.Equal(t, []server.FieldError{{Field: "email", Message: "must be a valid email address"}}, problem.Errors)
+	})
+}
+
+func TestCookies(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h := New(t, "server")
+	h.Echo().POST("/login", func(c echo.Context) error {
+		c.SetCookie(&http.Cookie{Name: "session", Value: "abc"})
+		return c.NoContent(http.StatusNoContent)
+	})
+	h.Echo().POST("/logout", func(c echo.Context) error {
+		c.SetCookie(&http.Cookie{Name: "session", MaxAge: -1})
+		return c.NoContent(http.StatusNoContent)
+	})
+	h.Echo().GET("/session", func(c echo.Context) error {
+		cookie, err := c.Cookie("session")
+		if err != nil {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		return c.String(http.StatusOK, cookie.Value)
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, h.Get("/session").Code)
+
+	h.PostJSON("/login", nil)
+	assert.Equal(t, "abc", h.Cookie("session").Value)
+	assert.Equal(t, "abc", h.Get("/session").Body.String())
+
+	h.PostJSON("/logout", nil)
+	assert.Nil(t, h.Cookie("session"))
+	assert.Equal(t, http.StatusUnauthorized, h.Get("/session").Code)
+}
+
+func TestCSRFToken(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("server.csrf_protection", true)
+
+	h := New(t, "server")
+	h.Echo().POST("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	csrfToken := h.CSRFToken()
+	assert.NotEmpty(t, csrfToken)
+
+	h.ClearSession()
+	res := h.PostJSON("/", nil, WithCSRFToken())
+	assert.Equal(t, http.StatusNoContent, res.Code, res.String())
+}
+
+func TestJWT(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("jwt_auth.token_lookup", "cookie:jwt")
+	viper.Set("jwt_query.token_lookup", "query:jwt")
+
+	var tokenModule *token.Module
+	h := New(t, "server",
+		token.InjectModule("jwt", "jwt_auth", "jwt_query"),
+		fx.Populate(&tokenModule),
+	)
+
+	handler := func(c echo.Context) error {
+		claims := c.Get("user").(*jwt.Token).Claims.(jwt.MapClaims)
+		return c.String(http.StatusOK, claims["sub"].(string))
+	}
+	h.Echo().GET("/me", handler, tokenModule.GetJWTMiddleware("jwt_auth"))
+	h.Echo().GET("/confirm", handler, tokenModule.GetJWTMiddleware("jwt_query"))
+
+	t.Run("TestUnauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	})
+
+	t.Run("TestWithJWT", func(t *testing.T) {
+		res := h.Get("/confirm", WithJWT("jwt_query", jwt.MapClaims{"sub": "user123"}))
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		assert.Equal(t, "user123", res.Body.String())
+	})
+
+	t.Run("TestAuthenticateJWT", func(t *testing.T) {
+		h.AuthenticateJWT("jwt_auth", jwt.MapClaims{"sub": "user456"})
+
+		res := h.Get("/me")
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		assert.Equal(t, "user456", res.Body.String())
+	})
+}
+
+func TestParseTokenLookup(t *testing.T) {
+	tests := []struct {
+		lookup string
+		source string
+		name   string
+		prefix string
+	}{
+		{"", "header", "Authorization", "Bearer "},
+		{"cookie:jwt", "cookie", "jwt", ""},
+		{"query:token", "query", "token", ""},
+		{"header:Authorization:Bearer ,cookie:jwt", "header", "Authorization", "Bearer "},
+	}
+
+	for _, test := range tests {
+		source, name, prefix := parseTokenLookup(test.lookup)
+		assert.Equal(t, test.source, source)
+		assert.Equal(t, test.name, name)
+		assert.Equal(t, test.prefix, prefix)
+	}
+}
@@ -0,0 +1,111 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// returned when a cookie value was not issued with the configured secret
+var errInvalidCookie = errors.New("invalid session cookie")
+
+// length of the random session token in bytes
+const tokenLength = 32
+
+// Encodes session tokens into cookie values, either encrypted with AES-GCM or signed with HMAC-SHA256.
+type cookieCodec struct {
+	encrypt    bool
+	aead       cipher.AEAD
+	signingKey []byte
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newCookieCodec(secret string, encrypt bool) (*cookieCodec, error) {
+	// separate keys are derived for encryption and signing
+	encryptionKey := sha256.Sum256([]byte("session-encryption:" + secret))
+	signingKey := sha256.Sum256([]byte("session-signing:" + secret))
+
+	block, err := aes.NewCipher(encryptionKey[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cookieCodec{
+		encrypt:    encrypt,
+		aead:       aead,
+		signingKey: signingKey[:],
+	}, nil
+}
+
+func (c *cookieCodec) encode(token []byte) (string, error) {
+	if !c.encrypt {
+		return base64.RawURLEncoding.EncodeToString(token) + "." + base64.RawURLEncoding.EncodeToString(c.sign(token)), nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, token, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *cookieCodec) decode(value string) ([]byte, error) {
+	if !c.encrypt {
+		encodedToken, encodedSignature, ok := strings.Cut(value, ".")
+		if !ok {
+			return nil, errInvalidCookie
+		}
+		token, err := base64.RawURLEncoding.DecodeString(encodedToken)
+		if err != nil {
+			return nil, errInvalidCookie
+		}
+		signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+		if err != nil || !hmac.Equal(signature, c.sign(token)) {
+			return nil, errInvalidCookie
+		}
+		return token, nil
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, errInvalidCookie
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	token, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errInvalidCookie
+	}
+	return token, nil
+}
+
+func (c *cookieCodec) sign(token []byte) []byte {
+	mac := hmac.New(sha256.New, c.signingKey)
+	mac.Write(token)
+	return mac.Sum(nil)
+}
+
+func newToken() ([]byte, error) {
+	token := make([]byte, tokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// session IDs are hashes of the tokens, so a leaked store can not be used to forge cookies
+func hashToken(token []byte) string {
+	hash := sha256.Sum256(token)
+	return hex.EncodeToString(hash[:])
+}
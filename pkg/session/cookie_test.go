@@ -0,0 +1,45 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookieCodec(t *testing.T) {
+	token, err := newToken()
+	assert.NoError(t, err)
+
+	for _, encrypt := range []bool{true, false} {
+		codec, err := newCookieCodec("secret", encrypt)
+		assert.NoError(t, err)
+
+		value, err := codec.encode(token)
+		assert.NoError(t, err)
+
+		decoded, err := codec.decode(value)
+		assert.NoError(t, err)
+		assert.Equal(t, token, decoded)
+
+		// cookies issued with another secret are rejected
+		otherCodec, _ := newCookieCodec("other", encrypt)
+		_, err = otherCodec.decode(value)
+		assert.ErrorIs(t, err, errInvalidCookie)
+
+		tampered := "A" + value[1:]
+		if value[0] == 'A' {
+			tampered = "B" + value[1:]
+		}
+		_, err = codec.decode(tampered)
+		assert.ErrorIs(t, err, errInvalidCookie)
+		_, err = codec.decode("")
+		assert.ErrorIs(t, err, errInvalidCookie)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	token := []byte("token")
+	assert.Len(t, hashToken(token), 64)
+	assert.Equal(t, hashToken(token), hashToken([]byte("token")))
+	assert.NotEqual(t, hashToken(token), hashToken([]byte("other")))
+}
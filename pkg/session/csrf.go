@@ -0,0 +1,55 @@
+package session
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// form field checked for the CSRF token if the header is not set
+const csrfFormField = "_csrf"
+
+// key of the CSRF token in the echo context, the same as the CSRF middleware of echo
+const CSRFContextKey = "csrf"
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Returns an echo middleware that protects session authenticated requests against CSRF.
+Each session holds its own token, rotated on login, which unsafe requests must send
+in the X-CSRF-Token header or the _csrf form field.
+Safe requests receive the token in the X-CSRF-Token response header and the echo context under "csrf".
+Requests without a session are not checked, as they carry no session cookie to abuse.
+Use it instead of csrf_protection of the server module, after the session middleware.
+
+	e.Use(sessionModule.GetSessionMiddleware(), sessionModule.GetCSRFMiddleware())
+*/
+func (m *Module) GetCSRFMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			session := FromContext(c)
+			if session == nil {
+				return next(c)
+			}
+
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				c.Set(CSRFContextKey, session.CSRFToken)
+				c.Response().Header().Set(echo.HeaderXCSRFToken, session.CSRFToken)
+				return next(c)
+			}
+
+			token := c.Request().Header.Get(echo.HeaderXCSRFToken)
+			if token == "" {
+				token = c.FormValue(csrfFormField)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+				return echo.NewHTTPError(http.StatusForbidden, "invalid csrf token")
+			}
+
+			c.Set(CSRFContextKey, session.CSRFToken)
+			return next(c)
+		}
+	}
+}
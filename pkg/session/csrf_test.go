@@ -0,0 +1,67 @@
+package session
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/alsey89/gogetter/pkg/servertest"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h, m := newSessionHarness(t)
+	h.Echo().Use(m.GetCSRFMiddleware())
+	h.Echo().GET("/form", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get(CSRFContextKey).(string))
+	})
+	h.Echo().POST("/transfer", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	t.Run("TestWithoutSession", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, h.PostJSON("/transfer", nil).Code)
+	})
+
+	h.PostJSON("/login?user=user123", nil)
+	res := h.Get("/form")
+	csrfToken := res.Body.String()
+	assert.NotEmpty(t, csrfToken)
+	assert.Equal(t, csrfToken, res.Header().Get(echo.HeaderXCSRFToken))
+
+	t.Run("TestMissingToken", func(t *testing.T) {
+		res := h.PostJSON("/transfer", nil)
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	})
+
+	t.Run("TestInvalidToken", func(t *testing.T) {
+		res := h.PostJSON("/transfer", nil, servertest.WithHeader(echo.HeaderXCSRFToken, "invalid"))
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	})
+
+	t.Run("TestHeaderToken", func(t *testing.T) {
+		res := h.PostJSON("/transfer", nil, servertest.WithHeader(echo.HeaderXCSRFToken, csrfToken))
+		assert.Equal(t, http.StatusNoContent, res.Code, res.String())
+	})
+
+	t.Run("TestFormToken", func(t *testing.T) {
+		form := url.Values{csrfFormField: {csrfToken}}
+		res := h.Request(http.MethodPost, "/transfer", strings.NewReader(form.Encode()),
+			servertest.WithHeader(echo.HeaderContentType, echo.MIMEApplicationForm))
+		assert.Equal(t, http.StatusNoContent, res.Code, res.String())
+	})
+
+	t.Run("TestRotatedOnLogin", func(t *testing.T) {
+		h.PostJSON("/login?user=user123", nil, servertest.WithHeader(echo.HeaderXCSRFToken, csrfToken))
+
+		res := h.PostJSON("/transfer", nil, servertest.WithHeader(echo.HeaderXCSRFToken, csrfToken))
+		assert.Equal(t, http.StatusForbidden, res.Code)
+	})
+}
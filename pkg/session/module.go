@@ -0,0 +1,634 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/pgconn"
+	"github.com/alsey89/gogetter/pkg/util"
+)
+
+// to be provided to the fx framework
+type Module struct {
+	config *Config
+	logger *zap.Logger
+	scope  string
+	store  Store
+	codec  *cookieCodec
+
+	stopCleanup context.CancelFunc
+}
+
+// injected through the fx framework
+type Params struct {
+	fx.In
+
+	Lifecycle fx.Lifecycle
+	Logger    *zap.Logger
+	// required by the postgres store
+	Database *pgconn.Module `optional:"true"`
+	// replaces the configured store if provided
+	Store Store `optional:"true"`
+}
+
+// holds configurations for the module
+type Config struct {
+	Store         string
+	Secret        string
+	EncryptCookie bool
+
+	CookieName     string
+	CookieDomain   string
+	CookiePath     string
+	CookieSecure   bool
+	CookieSameSite string
+
+	IdleTimeoutInMinutes     int
+	AbsoluteTimeoutInHours   int
+	CleanupIntervalInMinutes int
+	AutoMigrate              bool
+
+	CSRFCookieName   string
+	CSRFCookieDomain string
+}
+
+// store types
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// default values
+const (
+	DefaultStore         = StoreMemory
+	DefaultSecret        = "secret"
+	DefaultEncryptCookie = true
+
+	DefaultCookieName     = "session"
+	DefaultCookieDomain   = ""
+	DefaultCookiePath     = "/"
+	DefaultCookieSecure   = false
+	DefaultCookieSameSite = "lax"
+
+	DefaultIdleTimeoutInMinutes     = 30
+	DefaultAbsoluteTimeoutInHours   = 24
+	DefaultCleanupIntervalInMinutes = 10
+	DefaultAutoMigrate              = true
+
+	// name and default domain of the cookie issued by the CSRF middleware of the server module
+	DefaultCSRFCookieName   = "_csrf"
+	DefaultCSRFCookieDomain = "localhost"
+)
+
+// sessions are saved at most once per interval when only their last seen time changes
+const touchInterval = time.Minute
+
+//! MODULE ---------------------------------------------------------------
+
+/*
+Provides the Module struct to the fx framework, and registers lifecycle hooks.
+The postgres store requires the pgconn module to be injected as well.
+A custom Store provided to the fx framework replaces the configured store.
+*/
+func InjectModule(scope string) fx.Option {
+	return fx.Module(
+		scope,
+		fx.Provide(func(p Params) *Module {
+
+			m := &Module{scope: scope}
+			m.config = m.setupConfig(scope)
+			m.logger = m.setupLogger(scope, p)
+			m.codec = m.setupCodec()
+			m.store = p.Store
+			if m.store == nil {
+				m.store = m.setupStore(p.Database)
+			}
+
+			return m
+		}),
+		fx.Invoke(func(m *Module, p Params) {
+			p.Lifecycle.Append(fx.Hook{
+				OnStart: m.onStart,
+				OnStop:  m.onStop,
+			})
+		}),
+	)
+}
+
+// Instantiates new Module without using the fx framework.
+// The database is only required by the postgres store and can be nil otherwise.
+func NewSessionManager(scope string, logger *zap.Logger, database *pgconn.Module) *Module {
+	m := &Module{scope: scope}
+	m.logger = logger.Named("[" + scope + "]")
+	m.config = m.setupConfig(scope)
+	m.codec = m.setupCodec()
+	m.store = m.setupStore(database)
+
+	m.onStart(context.Background())
+
+	return m
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupConfig(scope string) *Config {
+	// searches for pattern: "scope.key"
+	viper.SetDefault(util.GetConfigPath(scope, "store"), DefaultStore)
+	viper.SetDefault(util.GetConfigPath(scope, "secret"), DefaultSecret)
+	viper.SetDefault(util.GetConfigPath(scope, "encrypt_cookie"), DefaultEncryptCookie)
+
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_name"), DefaultCookieName)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_domain"), DefaultCookieDomain)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_path"), DefaultCookiePath)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_secure"), DefaultCookieSecure)
+	viper.SetDefault(util.GetConfigPath(scope, "cookie_same_site"), DefaultCookieSameSite)
+
+	viper.SetDefault(util.GetConfigPath(scope, "idle_timeout_in_minutes"), DefaultIdleTimeoutInMinutes)
+	viper.SetDefault(util.GetConfigPath(scope, "absolute_timeout_in_hours"), DefaultAbsoluteTimeoutInHours)
+	viper.SetDefault(util.GetConfigPath(scope, "cleanup_interval_in_minutes"), DefaultCleanupIntervalInMinutes)
+	viper.SetDefault(util.GetConfigPath(scope, "auto_migrate"), DefaultAutoMigrate)
+
+	viper.SetDefault(util.GetConfigPath(scope, "csrf_cookie_name"), DefaultCSRFCookieName)
+	viper.SetDefault(util.GetConfigPath(scope, "csrf_cookie_domain"), DefaultCSRFCookieDomain)
+
+	return &Config{
+		Store:         viper.GetString(util.GetConfigPath(scope, "store")),
+		Secret:        viper.GetString(util.GetConfigPath(scope, "secret")),
+		EncryptCookie: viper.GetBool(util.GetConfigPath(scope, "encrypt_cookie")),
+
+		CookieName:     viper.GetString(util.GetConfigPath(scope, "cookie_name")),
+		CookieDomain:   viper.GetString(util.GetConfigPath(scope, "cookie_domain")),
+		CookiePath:     viper.GetString(util.GetConfigPath(scope, "cookie_path")),
+		CookieSecure:   viper.GetBool(util.GetConfigPath(scope, "cookie_secure")),
+		CookieSameSite: viper.GetString(util.GetConfigPath(scope, "cookie_same_site")),
+
+		IdleTimeoutInMinutes:     viper.GetInt(util.GetConfigPath(scope, "idle_timeout_in_minutes")),
+		AbsoluteTimeoutInHours:   viper.GetInt(util.GetConfigPath(scope, "absolute_timeout_in_hours")),
+		CleanupIntervalInMinutes: viper.GetInt(util.GetConfigPath(scope, "cleanup_interval_in_minutes")),
+		AutoMigrate:              viper.GetBool(util.GetConfigPath(scope, "auto_migrate")),
+
+		CSRFCookieName:   viper.GetString(util.GetConfigPath(scope, "csrf_cookie_name")),
+		CSRFCookieDomain: viper.GetString(util.GetConfigPath(scope, "csrf_cookie_domain")),
+	}
+}
+
+func (m *Module) setupLogger(scope string, p Params) *zap.Logger {
+	logger := p.Logger.Named("[" + scope + "]")
+	return logger
+}
+
+func (m *Module) setupCodec() *cookieCodec {
+	codec, err := newCookieCodec(m.config.Secret, m.config.EncryptCookie)
+	if err != nil {
+		m.logger.Fatal("failed to set up session cookie encryption", zap.Error(err))
+	}
+	return codec
+}
+
+func (m *Module) setupStore(database *pgconn.Module) Store {
+	switch m.config.Store {
+	case StorePostgres:
+		if database == nil {
+			m.logger.Fatal("postgres session store requires the pgconn module")
+		}
+		return NewPostgresStore(database.GetDB())
+	case StoreMemory:
+		return NewMemoryStore()
+	default:
+		m.logger.Warn("invalid session store, using memory", zap.String("store", m.config.Store))
+		return NewMemoryStore()
+	}
+}
+
+func (m *Module) onStart(ctx context.Context) error {
+	m.logger.Info("Starting session manager.")
+
+	if m.config.Secret == DefaultSecret {
+		m.logger.Warn("session secret is not set, cookies can be forged")
+	}
+
+	if store, ok := m.store.(*PostgresStore); ok && m.config.AutoMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			m.logger.Error("failed to migrate sessions table", zap.Error(err))
+		}
+	}
+
+	// expired sessions are removed in the background
+	// sessions are checked on every request, so this only frees up the store
+	if m.config.CleanupIntervalInMinutes > 0 {
+		cleanupCtx, cancel := context.WithCancel(context.Background())
+		m.stopCleanup = cancel
+		go m.cleanUpExpiredSessions(cleanupCtx, time.Duration(m.config.CleanupIntervalInMinutes)*time.Minute)
+	}
+
+	if viper.GetString("system.system_log_level") == "DEBUG" || viper.GetString("system.system_log_level") == "debug" {
+		m.logConfigurations()
+	}
+
+	return nil
+}
+
+func (m *Module) onStop(ctx context.Context) error {
+	m.logger.Info("Stopping session manager.")
+
+	if m.stopCleanup != nil {
+		m.stopCleanup()
+	}
+	return nil
+}
+
+func (m *Module) cleanUpExpiredSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.store.DeleteExpired(ctx, time.Now()); err != nil {
+				m.logger.Error("failed to delete expired sessions", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (m *Module) logConfigurations() {
+	m.logger.Debug("----- Session Configuration -----")
+	m.logger.Debug("Store", zap.String("Store", m.config.Store))
+	m.logger.Debug("EncryptCookie", zap.Bool("EncryptCookie", m.config.EncryptCookie))
+	m.logger.Debug("CookieName", zap.String("CookieName", m.config.CookieName))
+	m.logger.Debug("CookieDomain", zap.String("CookieDomain", m.config.CookieDomain))
+	m.logger.Debug("CookiePath", zap.String("CookiePath", m.config.CookiePath))
+	m.logger.Debug("CookieSecure", zap.Bool("CookieSecure", m.config.CookieSecure))
+	m.logger.Debug("CookieSameSite", zap.String("CookieSameSite", m.config.CookieSameSite))
+	m.logger.Debug("IdleTimeoutInMinutes", zap.Int("IdleTimeoutInMinutes", m.config.IdleTimeoutInMinutes))
+	m.logger.Debug("AbsoluteTimeoutInHours", zap.Int("AbsoluteTimeoutInHours", m.config.AbsoluteTimeoutInHours))
+	m.logger.Debug("CleanupIntervalInMinutes", zap.Int("CleanupIntervalInMinutes", m.config.CleanupIntervalInMinutes))
+	m.logger.Debug("AutoMigrate", zap.Bool("AutoMigrate", m.config.AutoMigrate))
+	m.logger.Debug("CSRFCookieName", zap.String("CSRFCookieName", m.config.CSRFCookieName))
+	m.logger.Debug("CSRFCookieDomain", zap.String("CSRFCookieDomain", m.config.CSRFCookieDomain))
+}
+
+// Loads the session of the request cookie, or returns nil if there is no valid session.
+func (m *Module) loadSession(c echo.Context) (*Session, error) {
+	if session := FromContext(c); session != nil {
+		return session, nil
+	}
+
+	cookie, err := c.Cookie(m.config.CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+
+	token, err := m.codec.decode(cookie.Value)
+	if err != nil {
+		m.clearCookie(c)
+		return nil, nil
+	}
+
+	ctx := c.Request().Context()
+	session, err := m.store.Get(ctx, hashToken(token))
+	if errors.Is(err, ErrSessionNotFound) {
+		// revoked or expired and cleaned up
+		m.clearCookie(c)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	now := time.Now()
+	if session.IsExpired(now) {
+		if err := m.store.Delete(ctx, session.ID); err != nil {
+			m.logger.Error("failed to delete expired session", zap.Error(err))
+		}
+		m.clearCookie(c)
+		return nil, nil
+	}
+
+	// extends the idle timeout, saved after the request
+	if now.Sub(session.LastSeenAt) >= touchInterval {
+		session.LastSeenAt = now
+		session.ExpiresAt = m.getExpiresAt(session.CreatedAt, now)
+		session.dirty = true
+	}
+
+	return session, nil
+}
+
+func (m *Module) saveSession(c echo.Context) {
+	session := FromContext(c)
+	if session == nil || !session.dirty {
+		return
+	}
+	err := m.store.Save(c.Request().Context(), session)
+	if errors.Is(err, ErrSessionNotFound) {
+		// revoked during the request
+		return
+	}
+	if err != nil {
+		m.logger.Error("failed to save session", zap.String("session_id", session.ID), zap.Error(err))
+		return
+	}
+	session.dirty = false
+}
+
+// Creates and saves a session with a new token, and sets the cookie.
+func (m *Module) createSession(c echo.Context, userID string, data map[string]interface{}, createdAt time.Time) (*Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         hashToken(token),
+		UserID:     userID,
+		CSRFToken:  csrfToken,
+		Data:       data,
+		CreatedAt:  createdAt,
+		LastSeenAt: now,
+		ExpiresAt:  m.getExpiresAt(createdAt, now),
+	}
+	if err := m.store.Create(c.Request().Context(), session); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	value, err := m.codec.encode(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session cookie: %w", err)
+	}
+	m.setCookie(c, value, m.getAbsoluteExpiry(createdAt))
+	c.Set(ContextKey, session)
+
+	return session, nil
+}
+
+func (m *Module) getAbsoluteExpiry(createdAt time.Time) time.Time {
+	return createdAt.Add(time.Duration(m.config.AbsoluteTimeoutInHours) * time.Hour)
+}
+
+// The session expires at the absolute timeout, or earlier if idle.
+// The idle timeout is disabled if not positive.
+func (m *Module) getExpiresAt(createdAt time.Time, lastSeenAt time.Time) time.Time {
+	expiresAt := m.getAbsoluteExpiry(createdAt)
+	if m.config.IdleTimeoutInMinutes <= 0 {
+		return expiresAt
+	}
+	idleExpiresAt := lastSeenAt.Add(time.Duration(m.config.IdleTimeoutInMinutes) * time.Minute)
+	if idleExpiresAt.Before(expiresAt) {
+		return idleExpiresAt
+	}
+	return expiresAt
+}
+
+func (m *Module) setCookie(c echo.Context, value string, expires time.Time) {
+	c.SetCookie(&http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Path:     m.config.CookiePath,
+		Domain:   m.config.CookieDomain,
+		Expires:  expires,
+		Secure:   m.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: parseSameSite(m.config.CookieSameSite),
+	})
+}
+
+func (m *Module) clearCookie(c echo.Context) {
+	m.setCookie(c, "", time.Unix(0, 0))
+}
+
+// A new CSRF cookie is issued by the server module on the next request,
+// so tokens obtained before login can not be reused afterwards.
+// The domain must match csrf_domain of the server scope for browsers to clear the cookie.
+func (m *Module) clearCSRFCookie(c echo.Context) {
+	if m.config.CSRFCookieName == "" {
+		return
+	}
+	if _, err := c.Cookie(m.config.CSRFCookieName); err != nil {
+		return
+	}
+	c.SetCookie(&http.Cookie{
+		Name:    m.config.CSRFCookieName,
+		Path:    "/",
+		Domain:  m.config.CSRFCookieDomain,
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+	})
+}
+
+func parseSameSite(sameSite string) http.SameSite {
+	switch strings.ToLower(sameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate csrf token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Returns an echo middleware that loads the session of the request cookie.
+The session is available through session.FromContext(c), and changes made with Set and Delete are saved after the handler.
+Invalid, expired and revoked session cookies are cleared.
+
+	server.GetServer().Use(sessionModule.GetSessionMiddleware())
+*/
+func (m *Module) GetSessionMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			session, err := m.loadSession(c)
+			if err != nil {
+				return err
+			}
+			if session != nil {
+				c.Set(ContextKey, session)
+			}
+
+			err = next(c)
+			m.saveSession(c)
+			return err
+		}
+	}
+}
+
+// Returns an echo middleware that rejects requests without a logged in session with 401.
+// Must be used after the session middleware.
+func (m *Module) RequireSession() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			session := FromContext(c)
+			if session == nil || session.UserID == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "session required")
+			}
+			return next(c)
+		}
+	}
+}
+
+/*
+Starts a new session for the user and sets the cookie.
+The current session, if any, is replaced so its token can not be reused, and its data is carried over.
+The CSRF token is rotated along with the session.
+
+	user, err := authenticate(c)
+	...
+	sess, err := sessionModule.Login(c, user.ID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{"csrf_token": sess.CSRFToken})
+*/
+func (m *Module) Login(c echo.Context, userID string) (*Session, error) {
+	current, err := m.loadSession(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if current != nil {
+		data = current.Data
+		if err := m.store.Delete(c.Request().Context(), current.ID); err != nil {
+			return nil, fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+
+	session, err := m.createSession(c, userID, data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	m.clearCSRFCookie(c)
+
+	return session, nil
+}
+
+// Replaces the token of the current session, e.g. after a change of privileges.
+// The user, data and absolute timeout of the session are kept.
+func (m *Module) Rotate(c echo.Context) (*Session, error) {
+	current, err := m.loadSession(c)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "session required")
+	}
+
+	session, err := m.createSession(c, current.UserID, current.Data, current.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.store.Delete(c.Request().Context(), current.ID); err != nil {
+		return nil, fmt.Errorf("failed to delete session: %w", err)
+	}
+	m.clearCSRFCookie(c)
+
+	return session, nil
+}
+
+// Deletes the current session and clears the session and CSRF cookies.
+func (m *Module) Logout(c echo.Context) error {
+	current, err := m.loadSession(c)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		if err := m.store.Delete(c.Request().Context(), current.ID); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+
+	m.clearCookie(c)
+	m.clearCSRFCookie(c)
+	c.Set(ContextKey, nil)
+
+	return nil
+}
+
+// Returns the active sessions of the user, e.g. to list signed in devices.
+func (m *Module) ListSessions(ctx context.Context, userID string) ([]*Session, error) {
+	sessions, err := m.store.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := make([]*Session, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.IsExpired(now) {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// Revokes a session by ID, the user is logged out on the next request.
+func (m *Module) Revoke(ctx context.Context, sessionID string) error {
+	return m.store.Delete(ctx, sessionID)
+}
+
+// Revokes all sessions of the user, e.g. after a password reset.
+func (m *Module) RevokeUser(ctx context.Context, userID string) error {
+	return m.store.DeleteByUser(ctx, userID)
+}
+
+// Revokes all sessions of the current user except the current one.
+func (m *Module) RevokeOtherSessions(c echo.Context) error {
+	current, err := m.loadSession(c)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session required")
+	}
+
+	ctx := c.Request().Context()
+	sessions, err := m.store.ListByUser(ctx, current.UserID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == current.ID {
+			continue
+		}
+		if err := m.store.Delete(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the store of the module.
+func (m *Module) GetStore() Store {
+	return m.store
+}
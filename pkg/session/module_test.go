@@ -0,0 +1,366 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/alsey89/gogetter/pkg/servertest"
+)
+
+func TestSetupConfig(t *testing.T) {
+	scope := "session"
+	m := Module{scope: scope}
+
+	t.Run("TestSetupWithNoConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		m.config = m.setupConfig(scope)
+
+		assert.Equal(t, DefaultStore, m.config.Store)
+		assert.Equal(t, DefaultSecret, m.config.Secret)
+		assert.Equal(t, DefaultEncryptCookie, m.config.EncryptCookie)
+		assert.Equal(t, DefaultCookieName, m.config.CookieName)
+		assert.Equal(t, DefaultCookieDomain, m.config.CookieDomain)
+		assert.Equal(t, DefaultCookiePath, m.config.CookiePath)
+		assert.Equal(t, DefaultCookieSecure, m.config.CookieSecure)
+		assert.Equal(t, DefaultCookieSameSite, m.config.CookieSameSite)
+		assert.Equal(t, DefaultIdleTimeoutInMinutes, m.config.IdleTimeoutInMinutes)
+		assert.Equal(t, DefaultAbsoluteTimeoutInHours, m.config.AbsoluteTimeoutInHours)
+		assert.Equal(t, DefaultCleanupIntervalInMinutes, m.config.CleanupIntervalInMinutes)
+		assert.Equal(t, DefaultAutoMigrate, m.config.AutoMigrate)
+		assert.Equal(t, DefaultCSRFCookieName, m.config.CSRFCookieName)
+		assert.Equal(t, DefaultCSRFCookieDomain, m.config.CSRFCookieDomain)
+	})
+
+	t.Run("TestSetupWithConfig", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("session.store", "postgres")
+		viper.Set("session.secret", "supersecret")
+		viper.Set("session.encrypt_cookie", false)
+		viper.Set("session.cookie_name", "sid")
+		viper.Set("session.cookie_secure", true)
+		viper.Set("session.cookie_same_site", "strict")
+		viper.Set("session.idle_timeout_in_minutes", 15)
+		viper.Set("session.absolute_timeout_in_hours", 8)
+
+		m.config = m.setupConfig(scope)
+
+		assert.Equal(t, StorePostgres, m.config.Store)
+		assert.Equal(t, "supersecret", m.config.Secret)
+		assert.Equal(t, false, m.config.EncryptCookie)
+		assert.Equal(t, "sid", m.config.CookieName)
+		assert.Equal(t, true, m.config.CookieSecure)
+		assert.Equal(t, "strict", m.config.CookieSameSite)
+		assert.Equal(t, 15, m.config.IdleTimeoutInMinutes)
+		assert.Equal(t, 8, m.config.AbsoluteTimeoutInHours)
+	})
+}
+
+func TestGetExpiresAt(t *testing.T) {
+	m := Module{config: &Config{IdleTimeoutInMinutes: 30, AbsoluteTimeoutInHours: 1}}
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, createdAt.Add(30*time.Minute), m.getExpiresAt(createdAt, createdAt))
+	assert.Equal(t, createdAt.Add(time.Hour), m.getExpiresAt(createdAt, createdAt.Add(45*time.Minute)))
+
+	m.config.IdleTimeoutInMinutes = 0
+	assert.Equal(t, createdAt.Add(time.Hour), m.getExpiresAt(createdAt, createdAt))
+}
+
+// registers handlers using the session module on a test server
+func newSessionHarness(t *testing.T) (*servertest.Harness, *Module) {
+	var m *Module
+	h := servertest.New(t, "server",
+		InjectModule("session"),
+		fx.Populate(&m),
+	)
+
+	e := h.Echo()
+	e.Use(m.GetSessionMiddleware())
+
+	e.POST("/login", func(c echo.Context) error {
+		session, err := m.Login(c, c.QueryParam("user"))
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, session.ID)
+	})
+	e.POST("/logout", func(c echo.Context) error {
+		if err := m.Logout(c); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.POST("/rotate", func(c echo.Context) error {
+		session, err := m.Rotate(c)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, session.ID)
+	})
+	e.POST("/revoke-others", func(c echo.Context) error {
+		if err := m.RevokeOtherSessions(c); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.POST("/cart", func(c echo.Context) error {
+		FromContext(c).Set("cart", c.QueryParam("item"))
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.GET("/me", func(c echo.Context) error {
+		session := FromContext(c)
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"id":   session.ID,
+			"user": session.UserID,
+			"cart": session.Get("cart"),
+		})
+	}, m.RequireSession())
+
+	return h, m
+}
+
+type meResponse struct {
+	ID   string `json:"id"`
+	User string `json:"user"`
+	Cart string `json:"cart"`
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h, m := newSessionHarness(t)
+
+	t.Run("TestUnauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	})
+
+	var sessionID string
+	t.Run("TestLogin", func(t *testing.T) {
+		res := h.PostJSON("/login?user=user123", nil)
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		sessionID = res.Body.String()
+
+		cookie := h.Cookie(DefaultCookieName)
+		assert.NotNil(t, cookie)
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+		// the cookie holds the encrypted token, not the session id
+		assert.NotContains(t, cookie.Value, sessionID)
+
+		var me meResponse
+		h.Get("/me").DecodeJSON(&me)
+		assert.Equal(t, sessionID, me.ID)
+		assert.Equal(t, "user123", me.User)
+	})
+
+	t.Run("TestData", func(t *testing.T) {
+		h.PostJSON("/cart?item=book", nil)
+
+		var me meResponse
+		h.Get("/me").DecodeJSON(&me)
+		assert.Equal(t, "book", me.Cart)
+	})
+
+	t.Run("TestRotate", func(t *testing.T) {
+		oldCookie := h.Cookie(DefaultCookieName)
+
+		res := h.PostJSON("/rotate", nil)
+		assert.Equal(t, http.StatusOK, res.Code, res.String())
+		assert.NotEqual(t, sessionID, res.Body.String())
+
+		// data is kept
+		var me meResponse
+		h.Get("/me").DecodeJSON(&me)
+		assert.Equal(t, "book", me.Cart)
+
+		// the old token is no longer valid
+		newCookie := h.Cookie(DefaultCookieName)
+		h.SetCookie(oldCookie)
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+		h.SetCookie(newCookie)
+		_, err := m.GetStore().Get(context.Background(), sessionID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("TestLogout", func(t *testing.T) {
+		res := h.PostJSON("/logout", nil)
+		assert.Equal(t, http.StatusNoContent, res.Code)
+		assert.Nil(t, h.Cookie(DefaultCookieName))
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	})
+}
+
+func TestLoginRotatesSession(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h, m := newSessionHarness(t)
+
+	firstID := h.PostJSON("/login?user=user123", nil).Body.String()
+	h.PostJSON("/cart?item=book", nil)
+	h.SetCookie(&http.Cookie{Name: DefaultCSRFCookieName, Value: "token"})
+
+	res := h.PostJSON("/login?user=user123", nil)
+	secondID := res.Body.String()
+	assert.NotEqual(t, firstID, secondID)
+
+	_, err := m.GetStore().Get(context.Background(), firstID)
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// data is carried over and the CSRF cookie of the server module is cleared
+	var me meResponse
+	h.Get("/me").DecodeJSON(&me)
+	assert.Equal(t, "book", me.Cart)
+	assert.Nil(t, h.Cookie(DefaultCSRFCookieName))
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h, m := newSessionHarness(t)
+	ctx := context.Background()
+
+	t.Run("TestIdleTimeout", func(t *testing.T) {
+		sessionID := h.PostJSON("/login?user=user123", nil).Body.String()
+
+		session, _ := m.GetStore().Get(ctx, sessionID)
+		session.LastSeenAt = time.Now().Add(-time.Hour)
+		session.ExpiresAt = time.Now().Add(-time.Minute)
+		m.GetStore().Save(ctx, session)
+
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+		assert.Nil(t, h.Cookie(DefaultCookieName))
+		_, err := m.GetStore().Get(ctx, sessionID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("TestActivityExtendsIdleTimeout", func(t *testing.T) {
+		sessionID := h.PostJSON("/login?user=user123", nil).Body.String()
+
+		session, _ := m.GetStore().Get(ctx, sessionID)
+		session.LastSeenAt = time.Now().Add(-10 * time.Minute)
+		session.ExpiresAt = time.Now().Add(20 * time.Minute)
+		m.GetStore().Save(ctx, session)
+
+		assert.Equal(t, http.StatusOK, h.Get("/me").Code)
+
+		session, _ = m.GetStore().Get(ctx, sessionID)
+		assert.WithinDuration(t, time.Now().Add(30*time.Minute), session.ExpiresAt, time.Minute)
+	})
+
+	t.Run("TestAbsoluteTimeout", func(t *testing.T) {
+		sessionID := h.PostJSON("/login?user=user123", nil).Body.String()
+
+		// activity does not extend the session past the absolute timeout
+		session, _ := m.GetStore().Get(ctx, sessionID)
+		session.CreatedAt = time.Now().Add(-24*time.Hour + 5*time.Minute)
+		session.LastSeenAt = time.Now().Add(-5 * time.Minute)
+		m.GetStore().Save(ctx, session)
+
+		assert.Equal(t, http.StatusOK, h.Get("/me").Code)
+		session, _ = m.GetStore().Get(ctx, sessionID)
+		assert.WithinDuration(t, session.CreatedAt.Add(24*time.Hour), session.ExpiresAt, time.Second)
+	})
+}
+
+func TestRevocation(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h, m := newSessionHarness(t)
+	ctx := context.Background()
+
+	// sessions on two devices
+	h.PostJSON("/login?user=user123", nil)
+	otherDevice := h.Cookie(DefaultCookieName)
+	h.ClearSession()
+	h.PostJSON("/login?user=user123", nil)
+
+	sessions, err := m.ListSessions(ctx, "user123")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	t.Run("TestRevokeOtherSessions", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, h.PostJSON("/revoke-others", nil).Code)
+
+		sessions, _ := m.ListSessions(ctx, "user123")
+		assert.Len(t, sessions, 1)
+		assert.Equal(t, http.StatusOK, h.Get("/me").Code)
+
+		current := h.Cookie(DefaultCookieName)
+		h.SetCookie(otherDevice)
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+		h.SetCookie(current)
+	})
+
+	t.Run("TestRevoke", func(t *testing.T) {
+		sessions, _ := m.ListSessions(ctx, "user123")
+		assert.NoError(t, m.Revoke(ctx, sessions[0].ID))
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	})
+
+	t.Run("TestRevokeUser", func(t *testing.T) {
+		h.PostJSON("/login?user=user123", nil)
+		assert.Equal(t, http.StatusOK, h.Get("/me").Code)
+
+		assert.NoError(t, m.RevokeUser(ctx, "user123"))
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	})
+
+	t.Run("TestRevokeDuringRequest", func(t *testing.T) {
+		h.Echo().GET("/slow", func(c echo.Context) error {
+			// e.g. revoked from another device while the request is handled
+			assert.NoError(t, m.RevokeUser(c.Request().Context(), FromContext(c).UserID))
+			return c.NoContent(http.StatusNoContent)
+		})
+
+		sessionID := h.PostJSON("/login?user=user123", nil).Body.String()
+		// touched by the request, so it is saved afterwards
+		session, _ := m.GetStore().Get(ctx, sessionID)
+		session.LastSeenAt = time.Now().Add(-10 * time.Minute)
+		assert.NoError(t, m.GetStore().Save(ctx, session))
+
+		assert.Equal(t, http.StatusNoContent, h.Get("/slow").Code)
+
+		_, err := m.GetStore().Get(ctx, sessionID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	})
+}
+
+func TestInvalidCookie(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	h, _ := newSessionHarness(t)
+
+	h.SetCookie(&http.Cookie{Name: DefaultCookieName, Value: "forged"})
+	assert.Equal(t, http.StatusUnauthorized, h.Get("/me").Code)
+	assert.Nil(t, h.Cookie(DefaultCookieName))
+}
+
+func TestNewSessionManager(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("session.store", "invalid")
+	viper.Set("session.cleanup_interval_in_minutes", 0)
+
+	m := NewSessionManager("session", zap.NewNop(), nil)
+	assert.IsType(t, &MemoryStore{}, m.GetStore())
+	assert.NoError(t, m.onStop(context.Background()))
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Row of the sessions table used by PostgresStore.
+type SessionRecord struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	UserID     string `gorm:"index"`
+	CSRFToken  string
+	Data       []byte `gorm:"type:jsonb"`
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+func (SessionRecord) TableName() string {
+	return "sessions"
+}
+
+// Store backed by postgres through the pgconn module, for deployments with multiple instances.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Use pgconn.Module.GetDB() to obtain the database.
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Creates or updates the sessions table.
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&SessionRecord{})
+}
+
+func (s *PostgresStore) Get(ctx context.Context, id string) (*Session, error) {
+	var record SessionRecord
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromRecord(&record)
+}
+
+func (s *PostgresStore) Create(ctx context.Context, session *Session) error {
+	record, err := toRecord(session)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Create(record).Error
+}
+
+// Updates the row only if it still exists, so sessions deleted during a request are not recreated.
+func (s *PostgresStore) Save(ctx context.Context, session *Session) error {
+	record, err := toRecord(session)
+	if err != nil {
+		return err
+	}
+	result := s.db.WithContext(ctx).Model(&SessionRecord{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+		"user_id":      record.UserID,
+		"csrf_token":   record.CSRFToken,
+		"data":         record.Data,
+		"last_seen_at": record.LastSeenAt,
+		"expires_at":   record.ExpiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, id string) error {
+	return s.db.WithContext(ctx).Where("id = ?", id).Delete(&SessionRecord{}).Error
+}
+
+func (s *PostgresStore) DeleteByUser(ctx context.Context, userID string) error {
+	return s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&SessionRecord{}).Error
+}
+
+func (s *PostgresStore) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	var records []SessionRecord
+	err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at").Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(records))
+	for i := range records {
+		session, err := fromRecord(&records[i])
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&SessionRecord{}).Error
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func toRecord(session *Session) (*SessionRecord, error) {
+	data, err := json.Marshal(session.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionRecord{
+		ID:         session.ID,
+		UserID:     session.UserID,
+		CSRFToken:  session.CSRFToken,
+		Data:       data,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+	}, nil
+}
+
+func fromRecord(record *SessionRecord) (*Session, error) {
+	session := &Session{
+		ID:         record.ID,
+		UserID:     record.UserID,
+		CSRFToken:  record.CSRFToken,
+		Data:       make(map[string]interface{}),
+		CreatedAt:  record.CreatedAt,
+		LastSeenAt: record.LastSeenAt,
+		ExpiresAt:  record.ExpiresAt,
+	}
+	if len(record.Data) > 0 {
+		if err := json.Unmarshal(record.Data, &session.Data); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// key of the session in the echo context
+const ContextKey = "session"
+
+/*
+A server side session. Changes made with Set and Delete are saved after the request by the session middleware.
+Data is exported for stores, changes written to it directly are not saved.
+*/
+type Session struct {
+	// hash of the token in the cookie, safe to expose e.g. to list and revoke sessions
+	ID     string
+	UserID string
+	// token validated by the CSRF middleware of the module
+	CSRFToken string
+	Data      map[string]interface{}
+
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	// the earlier of the idle and absolute timeout
+	ExpiresAt time.Time
+
+	dirty bool
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Returns the session loaded by the session middleware, or nil if there is none.
+func FromContext(c echo.Context) *Session {
+	session, _ := c.Get(ContextKey).(*Session)
+	return session
+}
+
+// Returns a value of the session data, or nil.
+// Values are restored from JSON, e.g. numbers are float64.
+func (s *Session) Get(key string) interface{} {
+	return s.Data[key]
+}
+
+// Sets a value of the session data. The value must be encodable as JSON.
+func (s *Session) Set(key string, value interface{}) {
+	if s.Data == nil {
+		s.Data = make(map[string]interface{})
+	}
+	s.Data[key] = value
+	s.dirty = true
+}
+
+// Removes a value from the session data.
+func (s *Session) Delete(key string) {
+	delete(s.Data, key)
+	s.dirty = true
+}
+
+// Reports whether the session is expired at the given time.
+func (s *Session) IsExpired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt)
+}
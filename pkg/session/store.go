@@ -0,0 +1,161 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// returned by Store.Get and Store.Save if the session does not exist
+	ErrSessionNotFound = errors.New("session not found")
+	// returned by Store.Create if a session with the ID exists
+	ErrSessionExists = errors.New("session already exists")
+)
+
+/*
+Persists sessions on the server side.
+Sessions are keyed by Session.ID, the hash of the token stored in the cookie,
+so the tokens can not be recovered from the store.
+*/
+type Store interface {
+	Get(ctx context.Context, id string) (*Session, error)
+	// creates a new session
+	Create(ctx context.Context, session *Session) error
+	// updates an existing session, returns ErrSessionNotFound if it was deleted, e.g. revoked during the request
+	Save(ctx context.Context, session *Session) error
+	Delete(ctx context.Context, id string) error
+	DeleteByUser(ctx context.Context, userID string) error
+	ListByUser(ctx context.Context, userID string) ([]*Session, error)
+	// removes sessions that expired before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// In-memory Store, for development, tests and single instance deployments.
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	sessions map[string]*Session
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return session.clone()
+}
+
+func (s *MemoryStore) Create(ctx context.Context, session *Session) error {
+	// copies are stored, so sessions behave the same as with the postgres store
+	stored, err := session.clone()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.sessions[session.ID]; ok {
+		return ErrSessionExists
+	}
+	s.sessions[session.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, session *Session) error {
+	stored, err := session.clone()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// deleted sessions are not recreated
+	if _, ok := s.sessions[session.ID]; !ok {
+		return ErrSessionNotFound
+	}
+	s.sessions[session.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteByUser(ctx context.Context, userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, session := range s.sessions {
+		if session.UserID == userID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListByUser(ctx context.Context, userID string) ([]*Session, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sessions := make([]*Session, 0)
+	for _, session := range s.sessions {
+		if session.UserID != userID {
+			continue
+		}
+		cloned, err := session.clone()
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, cloned)
+	}
+	return sessions, nil
+}
+
+func (s *MemoryStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// data is copied through JSON, the same way it is persisted in postgres
+func (s *Session) clone() (*Session, error) {
+	cloned := *s
+	cloned.dirty = false
+
+	data, err := json.Marshal(s.Data)
+	if err != nil {
+		return nil, err
+	}
+	cloned.Data = make(map[string]interface{})
+	if err := json.Unmarshal(data, &cloned.Data); err != nil {
+		return nil, err
+	}
+	return &cloned, nil
+}
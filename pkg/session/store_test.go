@@ -0,0 +1,103 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now()
+
+	session := &Session{
+		ID:        "session1",
+		UserID:    "user123",
+		Data:      map[string]interface{}{"count": 1},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	t.Run("TestCreateAndGet", func(t *testing.T) {
+		assert.NoError(t, store.Create(ctx, session))
+		assert.ErrorIs(t, store.Create(ctx, session), ErrSessionExists)
+
+		loaded, err := store.Get(ctx, "session1")
+		assert.NoError(t, err)
+		assert.Equal(t, "user123", loaded.UserID)
+		// data is restored from JSON, as with the postgres store
+		assert.Equal(t, float64(1), loaded.Get("count"))
+
+		// stored sessions are copies
+		loaded.Set("count", 2)
+		loaded, _ = store.Get(ctx, "session1")
+		assert.Equal(t, float64(1), loaded.Get("count"))
+	})
+
+	t.Run("TestNotFound", func(t *testing.T) {
+		_, err := store.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("TestSaveDoesNotCreate", func(t *testing.T) {
+		assert.ErrorIs(t, store.Save(ctx, &Session{ID: "missing"}), ErrSessionNotFound)
+		_, err := store.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("TestListAndDeleteByUser", func(t *testing.T) {
+		store.Create(ctx, &Session{ID: "session2", UserID: "user123", ExpiresAt: now.Add(time.Hour)})
+		store.Create(ctx, &Session{ID: "session3", UserID: "user456", ExpiresAt: now.Add(time.Hour)})
+
+		sessions, err := store.ListByUser(ctx, "user123")
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 2)
+
+		assert.NoError(t, store.DeleteByUser(ctx, "user123"))
+		sessions, _ = store.ListByUser(ctx, "user123")
+		assert.Len(t, sessions, 0)
+
+		_, err = store.Get(ctx, "session3")
+		assert.NoError(t, err)
+	})
+
+	t.Run("TestDeleteExpired", func(t *testing.T) {
+		store.Create(ctx, &Session{ID: "expired", UserID: "user456", ExpiresAt: now.Add(-time.Minute)})
+
+		assert.NoError(t, store.DeleteExpired(ctx, now))
+
+		_, err := store.Get(ctx, "expired")
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		_, err = store.Get(ctx, "session3")
+		assert.NoError(t, err)
+	})
+
+	t.Run("TestDelete", func(t *testing.T) {
+		assert.NoError(t, store.Delete(ctx, "session3"))
+		_, err := store.Get(ctx, "session3")
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
+
+func TestSessionRecord(t *testing.T) {
+	now := time.Now()
+	session := &Session{
+		ID:        "session1",
+		UserID:    "user123",
+		CSRFToken: "token",
+		Data:      map[string]interface{}{"cart": "book"},
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	record, err := toRecord(session)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"cart":"book"}`, string(record.Data))
+
+	restored, err := fromRecord(record)
+	assert.NoError(t, err)
+	assert.Equal(t, session, restored)
+}
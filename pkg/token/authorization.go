@@ -0,0 +1,289 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alsey89/gogetter/pkg/util"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Wrapped by policies to deny access. Other errors of a policy respond with 500.
+var ErrForbidden = errors.New("forbidden")
+
+// Holds the module wide authorization configuration, read from the module scope.
+type AuthorizationConfig struct {
+	// claims holding roles and permissions, as an array or a space separated string such as the OAuth "scope" claim
+	RolesClaim       string
+	PermissionsClaim string
+}
+
+const (
+	defaultRolesClaim       = "roles"
+	defaultPermissionsClaim = "permissions"
+)
+
+/*
+Checks access to a resource, e.g. whether the subject owns the requested record.
+Returns nil to allow access, an error wrapping ErrForbidden to deny it.
+*/
+type Policy interface {
+	Authorize(c echo.Context, claims jwt.MapClaims) error
+}
+
+// Adapts a function to the Policy interface.
+type PolicyFunc func(c echo.Context, claims jwt.MapClaims) error
+
+func (f PolicyFunc) Authorize(c echo.Context, claims jwt.MapClaims) error {
+	return f(c, claims)
+}
+
+// Requirements of a route. All fields must be satisfied.
+type Requirement struct {
+	// any of the roles
+	Roles []string
+	// all of the permissions
+	Permissions []string
+	Policies    []Policy
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupAuthorizationConfig(moduleScope string) *AuthorizationConfig {
+	viper.SetDefault(util.GetConfigPath(moduleScope, "roles_claim"), defaultRolesClaim)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "permissions_claim"), defaultPermissionsClaim)
+
+	return &AuthorizationConfig{
+		RolesClaim:       viper.GetString(util.GetConfigPath(moduleScope, "roles_claim")),
+		PermissionsClaim: viper.GetString(util.GetConfigPath(moduleScope, "permissions_claim")),
+	}
+}
+
+func (m *Module) getAuthorizationConfig() *AuthorizationConfig {
+	// modules instantiated without configuration, e.g. in tests
+	if m.authorizationConfig == nil {
+		return &AuthorizationConfig{RolesClaim: defaultRolesClaim, PermissionsClaim: defaultPermissionsClaim}
+	}
+	return m.authorizationConfig
+}
+
+// Reads a claim holding a list, either as an array or as a space separated string.
+func getClaimList(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []string:
+		return value
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns nil if the requirement is satisfied, or the reason access is denied.
+func (m *Module) checkRequirement(c echo.Context, claims jwt.MapClaims, requirement Requirement) error {
+	config := m.getAuthorizationConfig()
+
+	if len(requirement.Roles) > 0 {
+		roles := getClaimList(claims, config.RolesClaim)
+		granted := false
+		for _, role := range requirement.Roles {
+			if contains(roles, role) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return fmt.Errorf("%w: requires one of the roles %s", ErrForbidden, strings.Join(requirement.Roles, ", "))
+		}
+	}
+
+	permissions := getClaimList(claims, config.PermissionsClaim)
+	for _, permission := range requirement.Permissions {
+		if !contains(permissions, permission) {
+			return fmt.Errorf("%w: requires the permission %s", ErrForbidden, permission)
+		}
+	}
+
+	for _, policy := range requirement.Policies {
+		if err := policy.Authorize(c, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Module) authorize(c echo.Context, requirement Requirement) error {
+	claims, err := GetClaims(c)
+	if err != nil {
+		// authorization requires the JWT middleware to run first
+		return echo.NewHTTPError(http.StatusUnauthorized, "missing or invalid token")
+	}
+
+	subject, _ := claims.GetSubject()
+	fields := []zap.Field{
+		zap.String("subject", subject),
+		zap.String("method", c.Request().Method),
+		zap.String("route", c.Path()),
+		zap.String("URI", c.Request().RequestURI),
+	}
+
+	err = m.checkRequirement(c, claims, requirement)
+	switch {
+	case err == nil:
+		m.logger.Info("Authorization granted", fields...)
+		return nil
+	case errors.Is(err, ErrForbidden):
+		m.logger.Info("Authorization denied", append(fields, zap.String("reason", err.Error()))...)
+		return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions").SetInternal(err)
+	default:
+		m.logger.Error("Authorization failed", append(fields, zap.Error(err))...)
+		return echo.NewHTTPError(http.StatusInternalServerError).SetInternal(err)
+	}
+}
+
+// Splits a key of GetAuthorizationMiddleware, e.g. "GET /api/reports".
+func parseRouteKey(key string) (string, string, error) {
+	method, path, ok := strings.Cut(key, " ")
+	if !ok || method == "" || method != strings.ToUpper(method) || !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("invalid authorization route %q, expected \"METHOD /path\"", key)
+	}
+	return method, path, nil
+}
+
+// Returns the sorted keys without a registered route.
+func findUnknownRoutes(e *echo.Echo, routes map[string]Requirement) []string {
+	registered := make(map[string]bool)
+	for _, route := range e.Routes() {
+		registered[route.Method+" "+route.Path] = true
+	}
+
+	unknown := []string{}
+	for key := range routes {
+		if !registered[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Returns an echo middleware allowing requests whose token satisfies the requirement, and responding with 403 otherwise.
+Must run after GetJWTMiddleware. Every decision is logged for auditing.
+
+	e.DELETE("/users/:id", handler, tokenModule.GetJWTMiddleware("jwt_auth"), tokenModule.Authorize(token.Requirement{
+		Roles:    []string{"admin"},
+		Policies: []token.Policy{token.SubjectMatchesParam("id")},
+	}))
+*/
+func (m *Module) Authorize(requirement Requirement) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := m.authorize(c, requirement); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Returns an echo middleware requiring any of the roles. See Authorize.
+func (m *Module) RequireRoles(roles ...string) echo.MiddlewareFunc {
+	return m.Authorize(Requirement{Roles: roles})
+}
+
+// Returns an echo middleware requiring all of the permissions. See Authorize.
+func (m *Module) RequirePermissions(permissions ...string) echo.MiddlewareFunc {
+	return m.Authorize(Requirement{Permissions: permissions})
+}
+
+// Returns an echo middleware requiring all of the policies to allow access. See Authorize.
+func (m *Module) RequirePolicies(policies ...Policy) echo.MiddlewareFunc {
+	return m.Authorize(Requirement{Policies: policies})
+}
+
+/*
+Returns an echo middleware applying requirements declared per route, keyed by method and the full route path,
+including the prefix of groups. Routes without requirements are passed through. Must run after GetJWTMiddleware, e.g. on a group.
+Keys not matching a registered route are treated as a misconfiguration: they are logged on the first request,
+and every request through the middleware is denied with 500, so a typo does not disable authorization.
+
+	api := e.Group("/api")
+	api.Use(tokenModule.GetJWTMiddleware("jwt_auth"), tokenModule.GetAuthorizationMiddleware(map[string]token.Requirement{
+		"GET /api/reports":      {Permissions: []string{"reports:read"}},
+		"DELETE /api/users/:id": {Roles: []string{"admin"}},
+	}))
+*/
+func (m *Module) GetAuthorizationMiddleware(routes map[string]Requirement) echo.MiddlewareFunc {
+	for key := range routes {
+		if _, _, err := parseRouteKey(key); err != nil {
+			panic(err)
+		}
+	}
+
+	// routes are registered after the middleware is created, so they are checked on the first request
+	var once sync.Once
+	var unknown []string
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			once.Do(func() {
+				unknown = findUnknownRoutes(c.Echo(), routes)
+				if len(unknown) > 0 {
+					m.logger.Error("Authorization requirements declared for unknown routes, denying all requests",
+						zap.Strings("routes", unknown),
+					)
+				}
+			})
+			if len(unknown) > 0 {
+				return echo.NewHTTPError(http.StatusInternalServerError).
+					SetInternal(fmt.Errorf("authorization requirements declared for unknown routes: %s", strings.Join(unknown, ", ")))
+			}
+
+			requirement, ok := routes[c.Request().Method+" "+c.Path()]
+			if !ok {
+				return next(c)
+			}
+			if err := m.authorize(c, requirement); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// Returns a policy allowing access if the path parameter equals the subject of the token, e.g. for "/users/:id".
+func SubjectMatchesParam(param string) Policy {
+	return PolicyFunc(func(c echo.Context, claims jwt.MapClaims) error {
+		subject, _ := claims.GetSubject()
+		if subject == "" || subject != c.Param(param) {
+			return fmt.Errorf("%w: subject does not match the %s parameter", ErrForbidden, param)
+		}
+		return nil
+	})
+}
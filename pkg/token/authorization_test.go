@@ -0,0 +1,159 @@
+package token
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAuthorization(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	m := newKeyModule(map[string]*Config{
+		"jwt_auth": {TokenLookup: "header:Authorization:Bearer ", SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1},
+	})
+	m.logger = zap.New(core)
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	e := echo.New()
+	auth := e.Group("", m.GetJWTMiddleware("jwt_auth"))
+	auth.GET("/admin", ok, m.RequireRoles("admin", "owner"))
+	auth.GET("/reports", ok, m.RequirePermissions("reports:read", "reports:export"))
+	auth.GET("/users/:id", ok, m.RequirePolicies(SubjectMatchesParam("id")))
+	auth.GET("/broken", ok, m.RequirePolicies(PolicyFunc(func(c echo.Context, claims jwt.MapClaims) error {
+		return errors.New("database unavailable")
+	})))
+	e.GET("/unauthenticated", ok, m.RequireRoles("admin"))
+
+	send := func(path string, claims jwt.MapClaims) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if claims != nil {
+			signed, err := m.GenerateToken("jwt_auth", claims)
+			require.NoError(t, err)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+*signed)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("TestRoles", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send("/admin", jwt.MapClaims{"sub": "user123", "roles": []string{"owner"}}))
+		assert.Equal(t, http.StatusForbidden, send("/admin", jwt.MapClaims{"sub": "user123", "roles": []string{"user"}}))
+		assert.Equal(t, http.StatusForbidden, send("/admin", jwt.MapClaims{"sub": "user123"}))
+	})
+
+	t.Run("TestPermissions", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send("/reports", jwt.MapClaims{"permissions": []string{"reports:read", "reports:export"}}))
+		assert.Equal(t, http.StatusForbidden, send("/reports", jwt.MapClaims{"permissions": []string{"reports:read"}}))
+		// space separated, as in the OAuth "scope" claim
+		assert.Equal(t, http.StatusNoContent, send("/reports", jwt.MapClaims{"permissions": "reports:read reports:export"}))
+	})
+
+	t.Run("TestPolicies", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, send("/users/user123", jwt.MapClaims{"sub": "user123"}))
+		assert.Equal(t, http.StatusForbidden, send("/users/user456", jwt.MapClaims{"sub": "user123"}))
+		assert.Equal(t, http.StatusInternalServerError, send("/broken", jwt.MapClaims{"sub": "user123"}))
+	})
+
+	t.Run("TestWithoutToken", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("/unauthenticated", nil))
+	})
+
+	t.Run("TestAuditLog", func(t *testing.T) {
+		logs.TakeAll()
+		send("/admin", jwt.MapClaims{"sub": "user123", "roles": []string{"user"}})
+
+		denied := logs.FilterMessage("Authorization denied").All()
+		require.Len(t, denied, 1)
+		fields := denied[0].ContextMap()
+		assert.Equal(t, "user123", fields["subject"])
+		assert.Equal(t, "/admin", fields["route"])
+		assert.Contains(t, fields["reason"], "admin, owner")
+
+		send("/admin", jwt.MapClaims{"sub": "user123", "roles": []string{"admin"}})
+		assert.Len(t, logs.FilterMessage("Authorization granted").All(), 1)
+	})
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	m := newKeyModule(map[string]*Config{
+		"jwt_auth": {TokenLookup: "header:Authorization:Bearer ", SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1},
+	})
+	m.authorizationConfig = &AuthorizationConfig{RolesClaim: "groups", PermissionsClaim: "scope"}
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	e := echo.New()
+	api := e.Group("/api", m.GetJWTMiddleware("jwt_auth"), m.GetAuthorizationMiddleware(map[string]Requirement{
+		"GET /api/reports":        {Permissions: []string{"reports:read"}},
+		"DELETE /api/users/:id":   {Roles: []string{"admin"}},
+		"GET /api/users/:id":      {Policies: []Policy{SubjectMatchesParam("id")}},
+		"POST /api/users/:id/ban": {Roles: []string{"admin"}, Permissions: []string{"users:ban"}},
+	}))
+	api.GET("/reports", ok)
+	api.GET("/users/:id", ok)
+	api.DELETE("/users/:id", ok)
+	api.POST("/users/:id/ban", ok)
+	api.GET("/public", ok)
+
+	send := func(method string, path string, claims jwt.MapClaims) int {
+		signed, err := m.GenerateToken("jwt_auth", claims)
+		require.NoError(t, err)
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+*signed)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusNoContent, send(http.MethodGet, "/api/reports", jwt.MapClaims{"scope": "reports:read"}))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/api/reports", jwt.MapClaims{"permissions": []string{"reports:read"}}))
+
+	// requirements are matched by method
+	assert.Equal(t, http.StatusNoContent, send(http.MethodGet, "/api/users/user123", jwt.MapClaims{"sub": "user123"}))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodDelete, "/api/users/user123", jwt.MapClaims{"sub": "user123"}))
+	assert.Equal(t, http.StatusNoContent, send(http.MethodDelete, "/api/users/user123", jwt.MapClaims{"groups": []string{"admin"}}))
+
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/api/users/user123/ban", jwt.MapClaims{"groups": []string{"admin"}}))
+	assert.Equal(t, http.StatusNoContent, send(http.MethodPost, "/api/users/user123/ban", jwt.MapClaims{"groups": []string{"admin"}, "scope": "users:ban"}))
+
+	// routes without requirements only need a valid token
+	assert.Equal(t, http.StatusNoContent, send(http.MethodGet, "/api/public", jwt.MapClaims{}))
+
+	t.Run("TestUnknownRoute", func(t *testing.T) {
+		e := echo.New()
+		// the path is missing the group prefix
+		api := e.Group("/api", m.GetJWTMiddleware("jwt_auth"), m.GetAuthorizationMiddleware(map[string]Requirement{
+			"GET /reports": {Permissions: []string{"reports:read"}},
+		}))
+		api.GET("/reports", ok)
+
+		signed, err := m.GenerateToken("jwt_auth", jwt.MapClaims{"scope": "other"})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/api/reports", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+*signed)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+
+	t.Run("TestInvalidKey", func(t *testing.T) {
+		assert.Panics(t, func() {
+			m.GetAuthorizationMiddleware(map[string]Requirement{"/api/reports": {}})
+		})
+	})
+}
@@ -0,0 +1,221 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// Key the JWT middleware stores the verified *jwt.Token under in the echo context.
+const ContextKey = "user"
+
+// Claim binding a token to the scope that generated it, see Config.BindScope.
+const ScopeClaim = "token_scope"
+
+var (
+	// Returned by the context helpers when no verified token is stored in the echo context.
+	ErrNoToken = errors.New("no verified token in context")
+	// Returned for tokens generated by another scope, e.g. an email confirmation token used to authenticate.
+	ErrScopeMismatch = errors.New("token was generated for another scope")
+	// Returned for tokens without a "sub" claim by scopes requiring one.
+	ErrMissingSubject = errors.New("token has no subject")
+)
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newJTI() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate jti: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newRegisteredClaims(config *Config) (jwt.MapClaims, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"exp": jwt.NewNumericDate(now.Add(config.getExp())),
+	}
+
+	if config.SetIssuedAt {
+		claims["iat"] = jwt.NewNumericDate(now)
+	}
+	if config.SetNotBefore {
+		claims["nbf"] = jwt.NewNumericDate(now)
+	}
+	if config.GenerateJTI {
+		jti, err := newJTI()
+		if err != nil {
+			return nil, err
+		}
+		claims["jti"] = jti
+	}
+	if config.Issuer != "" {
+		claims["iss"] = config.Issuer
+	}
+	if config.Audience != "" {
+		claims["aud"] = config.Audience
+	}
+	return claims, nil
+}
+
+// Scopes verifying against a remote JWKS accept tokens of other issuers, which are not bound to a scope.
+func (c *Config) bindsScope() bool {
+	return c.BindScope && c.JWKSURL == ""
+}
+
+// Decodes the payload of a verified token, independent of the claims struct it was parsed into.
+func decodeRawClaims(token *jwt.Token) (jwt.MapClaims, error) {
+	parts := strings.Split(token.Raw, ".")
+	if len(parts) != 3 {
+		return nil, jwt.ErrTokenMalformed
+	}
+	payload, err := decodeBase64URL(parts[1])
+	if err != nil {
+		return nil, jwt.ErrTokenMalformed
+	}
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, jwt.ErrTokenMalformed
+	}
+	return claims, nil
+}
+
+func validateScopeClaims(config *Config, tokenScope string, claims jwt.MapClaims) error {
+	if config.bindsScope() {
+		// tokens issued before scope binding was enabled have no scope claim, and are accepted unless required
+		_, present := claims[ScopeClaim]
+		if scope, _ := claims[ScopeClaim].(string); (present || config.RequireScopeClaim) && scope != tokenScope {
+			return ErrScopeMismatch
+		}
+	}
+	if config.RequireSubject {
+		if subject, err := claims.GetSubject(); err != nil || subject == "" {
+			return ErrMissingSubject
+		}
+	}
+	return nil
+}
+
+func (m *Module) parseWithClaims(ctx context.Context, tokenScope string, signed string, claims jwt.Claims) (*jwt.Token, error) {
+	scopeConfig, err := m.getConfigHelper(tokenScope)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(time.Duration(scopeConfig.LeewayInSeconds) * time.Second),
+		jwt.WithIssuedAt(),
+	}
+	if scopeConfig.Issuer != "" {
+		options = append(options, jwt.WithIssuer(scopeConfig.Issuer))
+	}
+	if scopeConfig.Audience != "" {
+		options = append(options, jwt.WithAudience(scopeConfig.Audience))
+	}
+
+	// keys are looked up per token, so reloaded keys apply to existing middleware
+	token, err := jwt.NewParser(options...).ParseWithClaims(signed, claims, func(token *jwt.Token) (interface{}, error) {
+		keys, err := m.getKeysHelper(tokenScope)
+		if err != nil {
+			return nil, err
+		}
+		return keys.verifyKeyFor(ctx, token)
+	})
+	if err != nil {
+		return token, err
+	}
+
+	rawClaims, err := decodeRawClaims(token)
+	if err == nil {
+		err = validateScopeClaims(scopeConfig, tokenScope, rawClaims)
+	}
+	if err == nil {
+		err = m.checkRevocation(ctx, rawClaims)
+	}
+	if err != nil {
+		token.Valid = false
+		return token, err
+	}
+	return token, nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Parses and validates a JWT token for a specific scope, decoding its claims into T.
+T is a claims struct, typically embedding jwt.RegisteredClaims. Methods cannot be generic, hence the function.
+
+	type ConfirmationClaims struct {
+		Email string `json:"email"`
+		jwt.RegisteredClaims
+	}
+
+	claims, err := token.ParseClaims[ConfirmationClaims](tokenModule, "auth_jwt", c.QueryParam("token"))
+*/
+func ParseClaims[T any, PT interface {
+	*T
+	jwt.Claims
+}](m *Module, tokenScope string, signed string) (*T, error) {
+	claims := PT(new(T))
+	if _, err := m.parseWithClaims(context.Background(), tokenScope, signed, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Returns the token verified by the JWT middleware.
+func GetToken(c echo.Context) (*jwt.Token, error) {
+	token, ok := c.Get(ContextKey).(*jwt.Token)
+	if !ok || token == nil {
+		return nil, ErrNoToken
+	}
+	return token, nil
+}
+
+// Returns the claims of the token verified by the JWT middleware.
+func GetClaims(c echo.Context) (jwt.MapClaims, error) {
+	token, err := GetToken(c)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("unexpected claims type %T", token.Claims)
+	}
+	return claims, nil
+}
+
+/*
+Returns the claims of the token verified by the JWT middleware, decoded into T.
+
+	claims, err := token.GetClaimsAs[UserClaims](c)
+*/
+func GetClaimsAs[T any](c echo.Context) (*T, error) {
+	token, err := GetToken(c)
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := interface{}(token.Claims).(*T); ok {
+		return claims, nil
+	}
+
+	// the middleware parses into jwt.MapClaims, which are decoded the same way the token payload is
+	payload, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, err
+	}
+	claims := new(T)
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("failed to decode claims: %w", err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,244 @@
+package token
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testClaims struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+func TestParseClaims(t *testing.T) {
+	m := newKeyModule(map[string]*Config{
+		"auth_jwt": {SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1},
+		"other":    {SigningMethod: "HS256", SigningKey: "other", ExpInHours: 1},
+	})
+
+	signed, err := m.GenerateToken("auth_jwt", jwt.MapClaims{"sub": "user123", "email": "user@example.com", "roles": []string{"admin"}})
+	require.NoError(t, err)
+
+	claims, err := ParseClaims[testClaims](m, "auth_jwt", *signed)
+	require.NoError(t, err)
+	assert.Equal(t, "user123", claims.Subject)
+	assert.Equal(t, "user@example.com", claims.Email)
+	assert.Equal(t, []string{"admin"}, claims.Roles)
+	assert.True(t, claims.ExpiresAt.After(time.Now()))
+
+	// tokens of other scopes are rejected
+	_, err = ParseClaims[testClaims](m, "other", *signed)
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	_, err = ParseClaims[testClaims](m, "missing", *signed)
+	assert.Error(t, err)
+
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "user123",
+		"exp": jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+	}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = ParseClaims[testClaims](m, "auth_jwt", expired)
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	parsed, err := m.ParseToken("auth_jwt", *signed)
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", parsed.Claims.(jwt.MapClaims)["email"])
+}
+
+func TestContextClaims(t *testing.T) {
+	m := newKeyModule(map[string]*Config{
+		"echo_jwt": {TokenLookup: "header:Authorization:Bearer ", SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1},
+	})
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		claims, err := GetClaims(c)
+		require.NoError(t, err)
+		assert.Equal(t, "user123", claims["sub"])
+
+		typed, err := GetClaimsAs[testClaims](c)
+		require.NoError(t, err)
+		assert.Equal(t, "user123", typed.Subject)
+		assert.Equal(t, "user@example.com", typed.Email)
+
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetJWTMiddleware("echo_jwt"))
+
+	signed, err := m.GenerateToken("echo_jwt", jwt.MapClaims{"sub": "user123", "email": "user@example.com"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+*signed)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	t.Run("TestNoToken", func(t *testing.T) {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+		_, err := GetToken(c)
+		assert.ErrorIs(t, err, ErrNoToken)
+		_, err = GetClaims(c)
+		assert.ErrorIs(t, err, ErrNoToken)
+		_, err = GetClaimsAs[testClaims](c)
+		assert.ErrorIs(t, err, ErrNoToken)
+	})
+
+	t.Run("TestTypedToken", func(t *testing.T) {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		stored := &testClaims{Email: "user@example.com"}
+		c.Set(ContextKey, &jwt.Token{Claims: stored})
+
+		claims, err := GetClaimsAs[testClaims](c)
+		require.NoError(t, err)
+		assert.Same(t, stored, claims)
+	})
+}
+
+func TestRegisteredClaims(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	// scopes share a signing key, as in example.go
+	for _, scope := range []string{"jwt_auth", "jwt_email"} {
+		viper.Set(scope+".signing_key", "authsecret")
+		viper.Set(scope+".token_lookup", "header:Authorization:Bearer ")
+		viper.Set(scope+".issuer", "gogetter")
+		viper.Set(scope+".audience", "gogetter-api")
+	}
+	viper.Set("jwt_auth.require_subject", true)
+
+	m := NewTokenManager("jwt", zap.NewNop(), "jwt_auth", "jwt_email")
+
+	signed, err := m.GenerateToken("jwt_auth", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+	parsed, err := m.ParseToken("jwt_auth", *signed)
+	require.NoError(t, err)
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	assert.Equal(t, "gogetter", claims["iss"])
+	assert.Equal(t, "gogetter-api", claims["aud"])
+	assert.Equal(t, "jwt_auth", claims[ScopeClaim])
+	assert.NotNil(t, claims["iat"])
+	assert.NotNil(t, claims["nbf"])
+	assert.Len(t, claims["jti"], 32)
+
+	other, err := m.GenerateToken("jwt_auth", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+	otherParsed, err := m.ParseToken("jwt_auth", *other)
+	require.NoError(t, err)
+	assert.NotEqual(t, claims["jti"], otherParsed.Claims.(jwt.MapClaims)["jti"])
+
+	t.Run("TestScopeBinding", func(t *testing.T) {
+		emailToken, err := m.GenerateToken("jwt_email", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+
+		_, err = m.ParseToken("jwt_auth", *emailToken)
+		assert.ErrorIs(t, err, ErrScopeMismatch)
+		_, err = ParseClaims[testClaims](m, "jwt_auth", *emailToken)
+		assert.ErrorIs(t, err, ErrScopeMismatch)
+
+		e := echo.New()
+		e.GET("/", func(c echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		}, m.GetJWTMiddleware("jwt_auth"))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+*emailToken)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// the scope claim cannot be overridden
+		spoofed, err := m.GenerateToken("jwt_email", jwt.MapClaims{"sub": "user123", ScopeClaim: "jwt_auth"})
+		require.NoError(t, err)
+		_, err = m.ParseToken("jwt_auth", *spoofed)
+		assert.ErrorIs(t, err, ErrScopeMismatch)
+	})
+
+	t.Run("TestTokensWithoutScopeClaim", func(t *testing.T) {
+		// issued before scope binding was enabled
+		legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": "user123",
+			"iss": "gogetter",
+			"aud": "gogetter-api",
+		}).SignedString([]byte("authsecret"))
+		require.NoError(t, err)
+
+		_, err = m.ParseToken("jwt_auth", legacy)
+		assert.NoError(t, err)
+
+		viper.Set("jwt_auth.require_scope_claim", true)
+		require.NoError(t, m.Reload())
+		defer func() {
+			viper.Set("jwt_auth.require_scope_claim", false)
+			m.Reload()
+		}()
+
+		_, err = m.ParseToken("jwt_auth", legacy)
+		assert.ErrorIs(t, err, ErrScopeMismatch)
+	})
+
+	t.Run("TestRequireSubject", func(t *testing.T) {
+		_, err := m.GenerateToken("jwt_auth", jwt.MapClaims{})
+		assert.ErrorIs(t, err, ErrMissingSubject)
+
+		unbound, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss":      "gogetter",
+			"aud":      "gogetter-api",
+			ScopeClaim: "jwt_auth",
+		}).SignedString([]byte("authsecret"))
+		require.NoError(t, err)
+		_, err = m.ParseToken("jwt_auth", unbound)
+		assert.ErrorIs(t, err, ErrMissingSubject)
+	})
+
+	t.Run("TestLeeway", func(t *testing.T) {
+		sign := func(notBefore time.Time) string {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"sub":      "user123",
+				"iss":      "gogetter",
+				"aud":      "gogetter-api",
+				"nbf":      jwt.NewNumericDate(notBefore),
+				ScopeClaim: "jwt_email",
+			}).SignedString([]byte("authsecret"))
+			require.NoError(t, err)
+			return signed
+		}
+
+		// tolerates the clock of the issuer running slightly ahead
+		_, err := m.ParseToken("jwt_email", sign(time.Now().Add(10*time.Second)))
+		assert.NoError(t, err)
+		_, err = m.ParseToken("jwt_email", sign(time.Now().Add(2*time.Minute)))
+		assert.ErrorIs(t, err, jwt.ErrTokenNotValidYet)
+	})
+
+	t.Run("TestIssuerAndAudience", func(t *testing.T) {
+		viper.Set("jwt_email.issuer", "other")
+		require.NoError(t, m.Reload())
+
+		emailToken, err := m.GenerateToken("jwt_email", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+		_, err = m.ParseToken("jwt_email", *emailToken)
+		assert.NoError(t, err)
+
+		viper.Set("jwt_email.bind_scope", false)
+		viper.Set("jwt_email.audience", "")
+		require.NoError(t, m.Reload())
+
+		emailToken, err = m.GenerateToken("jwt_email", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+		_, err = m.ParseToken("jwt_auth", *emailToken)
+		assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+	})
+}
@@ -0,0 +1,129 @@
+package token
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Returned by the cookie helpers for scopes without a cookie name, and whose token lookup does not read a cookie.
+var ErrNoTokenCookie = errors.New("token scope has no cookie")
+
+//! INTERNAL ---------------------------------------------------------------
+
+// Returns the configured cookie name, or the first cookie of the token lookup, e.g. "jwt" for "header:Authorization:Bearer ,cookie:jwt".
+func (c *Config) getCookieName() string {
+	if c.CookieName != "" {
+		return c.CookieName
+	}
+	for _, source := range strings.Split(c.TokenLookup, ",") {
+		parts := strings.Split(strings.TrimSpace(source), ":")
+		if len(parts) >= 2 && parts[0] == "cookie" && parts[1] != "" {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+func (c *Config) newCookie(value string, expires time.Time, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.getCookieName(),
+		Value:    value,
+		Path:     c.CookiePath,
+		Domain:   c.CookieDomain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   c.CookieSecure,
+		HttpOnly: c.CookieHTTPOnly,
+		SameSite: parseSameSite(c.CookieSameSite),
+	}
+}
+
+func parseSameSite(sameSite string) http.SameSite {
+	switch strings.ToLower(sameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Reads the expiry of a signed token without verifying it, as the token was generated by this module.
+func getTokenExpiry(signed string) (time.Time, bool) {
+	token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+	if err != nil {
+		return time.Time{}, false
+	}
+	exp, err := token.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return time.Time{}, false
+	}
+	return exp.Time, true
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Sets a signed token of a specific scope as a cookie, with the cookie attributes configured for the scope.
+The cookie expires with the token, so browsers do not send expired tokens.
+*/
+func (m *Module) SetTokenCookie(c echo.Context, tokenScope string, signed string) error {
+	scopeConfig, err := m.getConfigHelper(tokenScope)
+	if err != nil {
+		m.logger.Error("Config not found", zap.String("Scope:", tokenScope))
+		return err
+	}
+	if scopeConfig.getCookieName() == "" {
+		return ErrNoTokenCookie
+	}
+
+	expiresAt, ok := getTokenExpiry(signed)
+	if !ok {
+		expiresAt = time.Now().Add(scopeConfig.getExp())
+	}
+	maxAge := int(time.Until(expiresAt).Seconds())
+	if maxAge <= 0 {
+		// a Max-Age of 0 would keep the cookie for the browser session
+		maxAge = -1
+	}
+
+	c.SetCookie(scopeConfig.newCookie(signed, expiresAt, maxAge))
+	return nil
+}
+
+/*
+Generates a token with the provided additional claims and sets it as a cookie, e.g. on login.
+See GenerateToken and SetTokenCookie.
+*/
+func (m *Module) GenerateTokenCookie(c echo.Context, tokenScope string, additionalClaims jwt.MapClaims) error {
+	signed, err := m.GenerateToken(tokenScope, additionalClaims)
+	if err != nil {
+		return err
+	}
+	return m.SetTokenCookie(c, tokenScope, *signed)
+}
+
+/*
+Clears the token cookie of a specific scope, e.g. on logout.
+Domain and path must match the cookie that was set, which holds as long as the scope configuration is unchanged.
+*/
+func (m *Module) ClearTokenCookie(c echo.Context, tokenScope string) error {
+	scopeConfig, err := m.getConfigHelper(tokenScope)
+	if err != nil {
+		m.logger.Error("Config not found", zap.String("Scope:", tokenScope))
+		return err
+	}
+	if scopeConfig.getCookieName() == "" {
+		return ErrNoTokenCookie
+	}
+
+	c.SetCookie(scopeConfig.newCookie("", time.Unix(0, 0), -1))
+	return nil
+}
@@ -0,0 +1,122 @@
+package token
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCookieModule() *Module {
+	return newKeyModule(map[string]*Config{
+		"jwt_auth": {
+			TokenLookup:    "header:Authorization:Bearer ,cookie:jwt",
+			SigningMethod:  "HS256",
+			SigningKey:     "secret",
+			Exp:            15 * time.Minute,
+			ExpInHours:     72,
+			CookieDomain:   "example.com",
+			CookiePath:     "/",
+			CookieSecure:   true,
+			CookieHTTPOnly: true,
+			CookieSameSite: "strict",
+			BindScope:      true,
+		},
+		"jwt_header": {TokenLookup: "header:Authorization:Bearer ", SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1},
+	})
+}
+
+func TestExp(t *testing.T) {
+	m := newCookieModule()
+
+	signed, err := m.GenerateToken("jwt_auth", jwt.MapClaims{})
+	require.NoError(t, err)
+	expiresAt, ok := getTokenExpiry(*signed)
+	require.True(t, ok)
+	// Exp takes precedence over ExpInHours
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiresAt, 2*time.Second)
+
+	signed, err = m.GenerateToken("jwt_header", jwt.MapClaims{})
+	require.NoError(t, err)
+	expiresAt, _ = getTokenExpiry(*signed)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, 2*time.Second)
+}
+
+func TestSetTokenCookie(t *testing.T) {
+	m := newCookieModule()
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetJWTMiddleware("jwt_auth"))
+
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/login", nil), rec)
+	require.NoError(t, m.GenerateTokenCookie(c, "jwt_auth", jwt.MapClaims{"sub": "user123"}))
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, "jwt", cookie.Name)
+	assert.Equal(t, "example.com", cookie.Domain)
+	assert.Equal(t, "/", cookie.Path)
+	assert.True(t, cookie.Secure)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+	assert.InDelta(t, (15 * time.Minute).Seconds(), cookie.MaxAge, 2)
+
+	// accepted by the middleware of the scope
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	t.Run("TestClearTokenCookie", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/logout", nil), rec)
+		require.NoError(t, m.ClearTokenCookie(c, "jwt_auth"))
+
+		cookies := rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "jwt", cookies[0].Name)
+		assert.Equal(t, "", cookies[0].Value)
+		assert.Equal(t, "example.com", cookies[0].Domain)
+		assert.Equal(t, -1, cookies[0].MaxAge)
+	})
+
+	t.Run("TestWithoutCookieLookup", func(t *testing.T) {
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/login", nil), httptest.NewRecorder())
+		assert.ErrorIs(t, m.GenerateTokenCookie(c, "jwt_header", jwt.MapClaims{}), ErrNoTokenCookie)
+		assert.ErrorIs(t, m.ClearTokenCookie(c, "jwt_header"), ErrNoTokenCookie)
+	})
+}
+
+func TestLogoutClearsTokenCookie(t *testing.T) {
+	m := newCookieModule()
+	m.SetRefreshStore(NewMemoryRefreshStore())
+
+	e := echo.New()
+	e.POST("/auth/logout", m.GetLogoutHandler(), m.GetJWTMiddleware("jwt_auth"))
+
+	pair, err := m.IssueTokenPair(context.Background(), "jwt_auth", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+	assert.Equal(t, int64((15 * time.Minute).Seconds()), pair.ExpiresIn)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "jwt", Value: pair.AccessToken})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "jwt", cookies[0].Name)
+	assert.Equal(t, -1, cookies[0].MaxAge)
+}
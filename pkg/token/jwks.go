@@ -0,0 +1,218 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// Route the JSON Web Key Set is conventionally served at.
+const JWKSPath = "/.well-known/jwks.json"
+
+// A public key in JSON Web Key format, as defined by RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// A JSON Web Key Set, as served at JWKSPath.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Encodes the public key without kid, use and alg.
+func newJWK(key interface{}) (JWK, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encodeBase64URL(key.N.Bytes()),
+			E:   encodeBase64URL(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   encodeBase64URL(key.X.FillBytes(make([]byte, size))),
+			Y:   encodeBase64URL(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encodeBase64URL(key),
+		}, nil
+	default:
+		return JWK{}, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// Computes the JWK thumbprint of a public key, as defined by RFC 7638.
+func thumbprint(key interface{}) (string, error) {
+	jwk, err := newJWK(key)
+	if err != nil {
+		return "", err
+	}
+
+	// required members only, in lexicographic order
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	}
+
+	hash := sha256.Sum256([]byte(members))
+	return encodeBase64URL(hash[:]), nil
+}
+
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// Decodes the public key of a JWK, e.g. from a remote JWKS.
+func (j JWK) publicKey() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBase64URL(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBase64URL(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := decodeBase64URL(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBase64URL(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := decodeBase64URL(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", j.Kty)
+	}
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Returns the public keys of the token scopes as a JSON Web Key Set, for services verifying the tokens.
+Retired keys and HMAC secrets are never published.
+*/
+func (m *Module) GetJWKS(tokenScopes ...string) (*JWKS, error) {
+	jwks := &JWKS{Keys: []JWK{}}
+
+	for _, scope := range tokenScopes {
+		keys, err := m.getKeysHelper(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys.keys {
+			if key.retired {
+				continue
+			}
+			if _, ok := key.verifyKey.([]byte); ok {
+				continue
+			}
+
+			jwk, err := newJWK(key.verifyKey)
+			if err != nil {
+				return nil, err
+			}
+			jwk.Kid = key.kid
+			jwk.Use = "sig"
+			jwk.Alg = keys.method.Alg()
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+
+	return jwks, nil
+}
+
+/*
+Returns an echo handler serving the JSON Web Key Set of the token scopes.
+The server module registers it at JWKSPath when jwks_token_scopes is configured.
+
+	e.GET(token.JWKSPath, tokenModule.GetJWKSHandler("service_jwt"))
+*/
+func (m *Module) GetJWKSHandler(tokenScopes ...string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		jwks, err := m.GetJWKS(tokenScopes...)
+		if err != nil {
+			m.logger.Error("Failed to build JWKS", zap.Error(err))
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		body, err := json.Marshal(jwks)
+		if err != nil {
+			return err
+		}
+		// keys change rarely, but rotated keys must be picked up by verifiers
+		c.Response().Header().Set("Cache-Control", "public, max-age=300")
+		return c.Blob(http.StatusOK, "application/jwk-set+json", body)
+	}
+}
@@ -0,0 +1,214 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestThumbprint(t *testing.T) {
+	// example key of RFC 7638, section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+	key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	kid, err := thumbprint(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", kid)
+}
+
+func TestKeyRotation(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	viper.Set("service_jwt.token_lookup", "header:Authorization:Bearer ")
+	viper.Set("service_jwt.signing_method", "ES256")
+	viper.Set("service_jwt.kid", "old")
+	viper.Set("service_jwt.private_key", encodePrivateKey(t, oldKey))
+
+	m := NewTokenManager("jwt", zap.NewNop(), "service_jwt")
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetJWTMiddleware("service_jwt"))
+	e.GET(JWKSPath, m.GetJWKSHandler("service_jwt"))
+
+	send := func(signed string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+signed)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	getJWKS := func() JWKS {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var jwks JWKS
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jwks))
+		return jwks
+	}
+	kidOf := func(signed string) string {
+		token, _, err := jwt.NewParser().ParseUnverified(signed, jwt.MapClaims{})
+		require.NoError(t, err)
+		return token.Header["kid"].(string)
+	}
+
+	oldToken, err := m.GenerateToken("service_jwt", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+	assert.Equal(t, "old", kidOf(*oldToken))
+	assert.Equal(t, http.StatusNoContent, send(*oldToken))
+
+	jwks := getJWKS()
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "old", jwks.Keys[0].Kid)
+	assert.Equal(t, "EC", jwks.Keys[0].Kty)
+	assert.Equal(t, "P-256", jwks.Keys[0].Crv)
+	assert.Equal(t, "ES256", jwks.Keys[0].Alg)
+
+	t.Run("TestRotate", func(t *testing.T) {
+		viper.Set("service_jwt.active_kid", "new")
+		viper.Set("service_jwt.keys", []map[string]interface{}{
+			{"kid": "new", "private_key": encodePrivateKey(t, newKey)},
+		})
+		require.NoError(t, m.Reload())
+
+		newToken, err := m.GenerateToken("service_jwt", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+		assert.Equal(t, "new", kidOf(*newToken))
+
+		// tokens signed with the previous key remain valid
+		assert.Equal(t, http.StatusNoContent, send(*newToken))
+		assert.Equal(t, http.StatusNoContent, send(*oldToken))
+		assert.Len(t, getJWKS().Keys, 2)
+	})
+
+	t.Run("TestRetire", func(t *testing.T) {
+		viper.Set("service_jwt.kid", "")
+		viper.Set("service_jwt.private_key", "")
+		viper.Set("service_jwt.keys", []map[string]interface{}{
+			{"kid": "new", "private_key": encodePrivateKey(t, newKey)},
+			{"kid": "old", "public_key": encodePublicKey(t, oldKey.Public()), "retired": true},
+		})
+		require.NoError(t, m.Reload())
+
+		assert.Equal(t, http.StatusUnauthorized, send(*oldToken))
+		jwks := getJWKS()
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, "new", jwks.Keys[0].Kid)
+	})
+
+	t.Run("TestConcurrentReload", func(t *testing.T) {
+		// run with -race, reloads are triggered e.g. by a config watcher and an admin endpoint
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, m.Reload())
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("TestInvalidReloadKeepsKeys", func(t *testing.T) {
+		viper.Set("service_jwt.active_kid", "missing")
+		assert.Error(t, m.Reload())
+
+		newToken, err := m.GenerateToken("service_jwt", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+		assert.Equal(t, "new", kidOf(*newToken))
+	})
+}
+
+func TestHMACKeyRotation(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("jwt.signing_key", "previous")
+	m := NewTokenManager("jwt", zap.NewNop(), "jwt")
+
+	// HMAC keys have no kid unless configured, so tokens issued so far have none
+	previousToken, err := m.GenerateToken("jwt", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+	parsed, err := m.ParseToken("jwt", *previousToken)
+	require.NoError(t, err)
+	assert.NotContains(t, parsed.Header, "kid")
+
+	viper.Set("jwt.active_kid", "next")
+	viper.Set("jwt.keys", []map[string]interface{}{{"kid": "next", "signing_key": "next"}})
+	require.NoError(t, m.Reload())
+
+	nextToken, err := m.GenerateToken("jwt", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+	parsed, err = m.ParseToken("jwt", *nextToken)
+	require.NoError(t, err)
+	assert.Equal(t, "next", parsed.Header["kid"])
+
+	// tokens issued before the rotation remain valid
+	_, err = m.ParseToken("jwt", *previousToken)
+	assert.NoError(t, err)
+
+	t.Run("TestAdditionalKeysRequireKid", func(t *testing.T) {
+		_, err := loadScopeKeys(&Config{
+			SigningMethod: "HS256",
+			SigningKey:    "previous",
+			Keys:          []KeyConfig{{SigningKey: "next"}},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestVerifyKeyFor(t *testing.T) {
+	keys, err := loadScopeKeys(&Config{
+		SigningMethod: "HS256",
+		SigningKey:    "current",
+		Kid:           "current",
+		Keys:          []KeyConfig{{Kid: "previous", SigningKey: "previous"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "current", keys.active.kid)
+
+	key, err := keys.verifyKeyFor(context.Background(), &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]interface{}{"kid": "previous"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("previous"), key)
+
+	_, err = keys.verifyKeyFor(context.Background(), &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]interface{}{"kid": "unknown"}})
+	assert.Error(t, err)
+
+	// tokens without kid are verified with the key of the scope itself
+	key, err = keys.verifyKeyFor(context.Background(), &jwt.Token{Method: jwt.SigningMethodHS256, Header: map[string]interface{}{}})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("current"), key)
+
+	_, err = keys.verifyKeyFor(context.Background(), &jwt.Token{Method: jwt.SigningMethodHS512, Header: map[string]interface{}{"kid": "current"}})
+	assert.Error(t, err)
+
+	// HMAC secrets are never published
+	m := newKeyModule(map[string]*Config{"scope": {SigningMethod: "HS256", SigningKey: "secret"}})
+	jwks, err := m.GetJWKS("scope")
+	assert.NoError(t, err)
+	assert.Empty(t, jwks.Keys)
+}
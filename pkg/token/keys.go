@@ -0,0 +1,350 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// Returned by GenerateToken for scopes configured with a public key only.
+var ErrVerifyOnly = errors.New("token scope is verify-only")
+
+// secret reference prefixes, e.g. "env:JWT_PRIVATE_KEY" or "file:/run/secrets/jwt.pem"
+const (
+	secretRefEnv  = "env:"
+	secretRefFile = "file:"
+)
+
+// Configures an additional key of a token scope, used to rotate keys.
+type KeyConfig struct {
+	Kid string `mapstructure:"kid"`
+	// HMAC secret, or a secret reference such as "env:JWT_SECRET"
+	SigningKey     string `mapstructure:"signing_key"`
+	PrivateKey     string `mapstructure:"private_key"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKey      string `mapstructure:"public_key"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+	// retired keys are no longer accepted, nor published
+	Retired bool `mapstructure:"retired"`
+}
+
+// A key of a token scope, identified by its kid.
+type signingKey struct {
+	kid string
+	// nil for verify-only keys
+	signKey interface{}
+	// same as the sign key for HMAC
+	verifyKey interface{}
+	retired   bool
+}
+
+// Keys of a token scope, resolved from its configuration.
+type scopeKeys struct {
+	method jwt.SigningMethod
+	// signs new tokens, nil for verify-only scopes
+	active *signingKey
+	keys   []*signingKey
+	// verifies tokens without kid, e.g. HMAC tokens issued before keys were rotated
+	base *signingKey
+	// set for scopes verifying against a remote JWKS, which have no local keys
+	remote *remoteJWKS
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+/*
+Resolves a configured value that may reference a secret:
+"env:NAME" reads the environment variable, "file:path" reads the file, anything else is used as is.
+*/
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretRefEnv):
+		name := strings.TrimPrefix(value, secretRefEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretRefFile):
+		return readKeyFile(strings.TrimPrefix(value, secretRefFile))
+	default:
+		return value, nil
+	}
+}
+
+func readKeyFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	return string(content), nil
+}
+
+// The file takes precedence over the inline value or secret reference.
+func resolvePEM(value string, file string) (string, error) {
+	if file != "" {
+		return readKeyFile(file)
+	}
+	return resolveSecret(value)
+}
+
+func loadScopeKeys(config *Config) (*scopeKeys, error) {
+	// signing methods of remote keys are determined by the JWKS
+	if config.JWKSURL != "" {
+		return &scopeKeys{remote: newRemoteJWKS(config)}, nil
+	}
+
+	method := jwt.GetSigningMethod(config.SigningMethod)
+	if method == nil || method == jwt.SigningMethodNone {
+		return nil, fmt.Errorf("unsupported signing method %s", config.SigningMethod)
+	}
+	keys := &scopeKeys{method: method}
+
+	// the key of the scope itself is kept while rotating, so tokens it issued remain valid
+	base := KeyConfig{
+		Kid:            config.Kid,
+		SigningKey:     config.SigningKey,
+		PrivateKey:     config.PrivateKey,
+		PrivateKeyFile: config.PrivateKeyFile,
+		PublicKey:      config.PublicKey,
+		PublicKeyFile:  config.PublicKeyFile,
+	}
+	hasBase := len(config.Keys) == 0 || hasKeyMaterial(method, base)
+	keyConfigs := config.Keys
+	if hasBase {
+		keyConfigs = append([]KeyConfig{base}, config.Keys...)
+	}
+	kids := make(map[string]bool)
+	for i, keyConfig := range keyConfigs {
+		key, err := loadKey(method, keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		// only the key of the scope itself may omit the kid, its tokens are verified as tokens without kid
+		isBase := hasBase && i == 0
+		if key.kid == "" && len(keyConfigs) > 1 && !isBase {
+			return nil, errors.New("every key under keys requires a kid")
+		}
+		if kids[key.kid] {
+			return nil, fmt.Errorf("duplicate kid %q", key.kid)
+		}
+		kids[key.kid] = true
+		keys.keys = append(keys.keys, key)
+	}
+	if hasBase || len(keys.keys) == 1 {
+		keys.base = keys.keys[0]
+	}
+
+	for _, key := range keys.keys {
+		if key.signKey == nil || key.retired {
+			continue
+		}
+		if config.ActiveKid == "" || key.kid == config.ActiveKid {
+			keys.active = key
+			break
+		}
+	}
+	if config.ActiveKid != "" && keys.active == nil {
+		return nil, fmt.Errorf("active kid %q has no usable private key", config.ActiveKid)
+	}
+
+	return keys, nil
+}
+
+// Whether the key is configured for the method, e.g. the signing key of an asymmetric scope is ignored.
+func hasKeyMaterial(method jwt.SigningMethod, config KeyConfig) bool {
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		return config.SigningKey != ""
+	}
+	return config.PrivateKey != "" || config.PrivateKeyFile != "" || config.PublicKey != "" || config.PublicKeyFile != ""
+}
+
+func loadKey(method jwt.SigningMethod, config KeyConfig) (*signingKey, error) {
+	key := &signingKey{kid: config.Kid, retired: config.Retired}
+
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		secret, err := resolveSecret(config.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("key %q requires a signing key", config.Kid)
+		}
+		key.signKey = []byte(secret)
+		key.verifyKey = []byte(secret)
+		return key, nil
+	}
+
+	privatePEM, err := resolvePEM(config.PrivateKey, config.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key: %w", err)
+	}
+	publicPEM, err := resolvePEM(config.PublicKey, config.PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load public key: %w", err)
+	}
+	if privatePEM == "" && publicPEM == "" {
+		return nil, fmt.Errorf("signing method %s requires a private or public key", method.Alg())
+	}
+
+	if privatePEM != "" {
+		key.signKey, err = parsePrivateKey(method, []byte(privatePEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		key.verifyKey = key.signKey.(crypto.Signer).Public()
+	}
+	// an explicit public key takes precedence over the one derived from the private key
+	if publicPEM != "" {
+		key.verifyKey, err = parsePublicKey(method, []byte(publicPEM))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+	}
+
+	// asymmetric keys are identified by their thumbprint unless configured otherwise
+	if key.kid == "" {
+		key.kid, err = thumbprint(key.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// Returns the key that verifies a token, by its kid header.
+// Tokens without kid are verified with the key of the scope itself, e.g. HMAC tokens issued before keys were rotated.
+func (k *scopeKeys) verifyKeyFor(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	if k.remote != nil {
+		return k.remote.verifyKeyFor(ctx, token)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && k.base != nil && !k.base.retired {
+		return k.base.verifyKey, nil
+	}
+	for _, key := range k.keys {
+		if key.retired {
+			continue
+		}
+		if key.kid == kid {
+			return key.verifyKey, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+func parsePrivateKey(method jwt.SigningMethod, pem []byte) (crypto.Signer, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return jwt.ParseRSAPrivateKeyFromPEM(pem)
+	case *jwt.SigningMethodECDSA:
+		return jwt.ParseECPrivateKeyFromPEM(pem)
+	case *jwt.SigningMethodEd25519:
+		key, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+		return key.(ed25519.PrivateKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported signing method %s", method.Alg())
+	}
+}
+
+func parsePublicKey(method jwt.SigningMethod, pem []byte) (crypto.PublicKey, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return jwt.ParseRSAPublicKeyFromPEM(pem)
+	case *jwt.SigningMethodECDSA:
+		return jwt.ParseECPublicKeyFromPEM(pem)
+	case *jwt.SigningMethodEd25519:
+		return jwt.ParseEdPublicKeyFromPEM(pem)
+	default:
+		return nil, fmt.Errorf("unsupported signing method %s", method.Alg())
+	}
+}
+
+// Returns the keys of a scope, loading them on first use.
+func (m *Module) getKeysHelper(scope string) (*scopeKeys, error) {
+	m.mutex.RLock()
+	keys, ok := m.keys[scope]
+	m.mutex.RUnlock()
+	if ok {
+		return keys, nil
+	}
+
+	config, err := m.getConfigHelper(scope)
+	if err != nil {
+		return nil, err
+	}
+	keys, err = loadScopeKeys(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keys for scope %s: %w", scope, err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.keys == nil {
+		m.keys = make(map[string]*scopeKeys)
+	}
+	m.keys[scope] = keys
+
+	return keys, nil
+}
+
+// Loads the keys of all scopes, so misconfigured keys are reported on start.
+func (m *Module) setupKeys() {
+	for scope := range m.configs {
+		if _, err := m.getKeysHelper(scope); err != nil {
+			m.logger.Error("Failed to load token keys", zap.String("Scope", scope), zap.Error(err))
+		}
+	}
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+/*
+Reads the configuration of all token scopes again, and replaces their keys.
+Used to rotate keys without a restart, e.g. from viper.OnConfigChange:
+add the new key with its kid to "keys", point "active_kid" to it,
+and retire the previous key once the tokens it signed expired.
+Keeps the previous configuration if any scope fails to load.
+*/
+func (m *Module) Reload() error {
+	m.mutex.RLock()
+	scopes := make([]string, 0, len(m.configs))
+	for scope := range m.configs {
+		scopes = append(scopes, scope)
+	}
+	m.mutex.RUnlock()
+
+	configs := m.setupConfig(scopes...)
+	keys := make(map[string]*scopeKeys, len(configs))
+	for scope, config := range configs {
+		scopeKeys, err := loadScopeKeys(config)
+		if err != nil {
+			m.logger.Error("Failed to reload token keys", zap.String("Scope", scope), zap.Error(err))
+			return fmt.Errorf("failed to load keys for scope %s: %w", scope, err)
+		}
+		keys[scope] = scopeKeys
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.configs = configs
+	m.keys = keys
+
+	m.logger.Info("Token keys reloaded")
+	return nil
+}
@@ -0,0 +1,185 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func encodePrivateKey(t *testing.T, key crypto.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func encodePublicKey(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func newTestKeys(t *testing.T) map[string]crypto.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return map[string]crypto.Signer{
+		"RS256": rsaKey,
+		"PS256": rsaKey,
+		"ES256": ecKey,
+		"EdDSA": edKey,
+	}
+}
+
+func newKeyModule(configs map[string]*Config) *Module {
+	return &Module{
+		configs: configs,
+		logger:  zap.NewNop(),
+	}
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	for method, key := range newTestKeys(t) {
+		t.Run(method, func(t *testing.T) {
+			m := newKeyModule(map[string]*Config{
+				"signer": {
+					SigningMethod: method,
+					PrivateKey:    encodePrivateKey(t, key),
+					ExpInHours:    1,
+				},
+				"verifier": {
+					SigningMethod: method,
+					PublicKey:     encodePublicKey(t, key.Public()),
+				},
+			})
+
+			signed, err := m.GenerateToken("signer", jwt.MapClaims{"sub": "user123"})
+			require.NoError(t, err)
+
+			verifyKeys, err := m.getKeysHelper("verifier")
+			require.NoError(t, err)
+
+			parsed, err := jwt.Parse(*signed, func(token *jwt.Token) (interface{}, error) {
+				return verifyKeys.keys[0].verifyKey, nil
+			}, jwt.WithValidMethods([]string{method}))
+			require.NoError(t, err)
+			assert.Equal(t, "user123", parsed.Claims.(jwt.MapClaims)["sub"])
+
+			// the verifying service cannot issue tokens
+			_, err = m.GenerateToken("verifier", jwt.MapClaims{})
+			assert.ErrorIs(t, err, ErrVerifyOnly)
+		})
+	}
+}
+
+func TestKeySources(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	privatePEM := encodePrivateKey(t, key)
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "private.pem")
+	require.NoError(t, os.WriteFile(keyFile, []byte(privatePEM), 0600))
+	t.Setenv("TEST_JWT_PRIVATE_KEY", privatePEM)
+	t.Setenv("TEST_JWT_SECRET", "env_secret")
+
+	t.Run("TestKeyFile", func(t *testing.T) {
+		keys, err := loadScopeKeys(&Config{SigningMethod: "ES256", PrivateKeyFile: keyFile})
+		require.NoError(t, err)
+		assert.True(t, key.Equal(keys.active.signKey))
+	})
+
+	t.Run("TestFileReference", func(t *testing.T) {
+		keys, err := loadScopeKeys(&Config{SigningMethod: "ES256", PrivateKey: "file:" + keyFile})
+		require.NoError(t, err)
+		assert.True(t, key.PublicKey.Equal(keys.keys[0].verifyKey))
+	})
+
+	t.Run("TestEnvReference", func(t *testing.T) {
+		keys, err := loadScopeKeys(&Config{SigningMethod: "ES256", PrivateKey: "env:TEST_JWT_PRIVATE_KEY"})
+		require.NoError(t, err)
+		assert.True(t, key.Equal(keys.active.signKey))
+
+		keys, err = loadScopeKeys(&Config{SigningMethod: "HS256", SigningKey: "env:TEST_JWT_SECRET"})
+		require.NoError(t, err)
+		assert.Equal(t, []byte("env_secret"), keys.active.signKey)
+	})
+
+	t.Run("TestErrors", func(t *testing.T) {
+		_, err := loadScopeKeys(&Config{SigningMethod: "ES256"})
+		assert.Error(t, err)
+
+		_, err = loadScopeKeys(&Config{SigningMethod: "ES256", PrivateKey: "env:TEST_JWT_MISSING"})
+		assert.Error(t, err)
+
+		_, err = loadScopeKeys(&Config{SigningMethod: "ES256", PrivateKeyFile: filepath.Join(dir, "missing.pem")})
+		assert.Error(t, err)
+
+		// an ECDSA key cannot be used with RS256
+		_, err = loadScopeKeys(&Config{SigningMethod: "RS256", PrivateKey: privatePEM})
+		assert.Error(t, err)
+
+		_, err = loadScopeKeys(&Config{SigningMethod: "none"})
+		assert.Error(t, err)
+	})
+}
+
+func TestAsymmetricJWTMiddleware(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	m := newKeyModule(map[string]*Config{
+		"signer": {
+			SigningMethod: "EdDSA",
+			PrivateKey:    encodePrivateKey(t, key),
+			ExpInHours:    1,
+		},
+		"verifier": {
+			TokenLookup:   "header:Authorization:Bearer ",
+			SigningMethod: "EdDSA",
+			PublicKey:     encodePublicKey(t, key.Public()),
+		},
+	})
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetJWTMiddleware("verifier"))
+
+	signed, err := m.GenerateToken("signer", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+*signed)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	// tokens signed with an HMAC secret are rejected
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user123"}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+hmacToken)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
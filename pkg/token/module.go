@@ -3,8 +3,10 @@ package token
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/alsey89/gogetter/pkg/pgconn"
 	"github.com/alsey89/gogetter/pkg/util"
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
@@ -18,6 +20,25 @@ type Module struct {
 	scope   string
 	logger  *zap.Logger
 	configs map[string]*Config
+
+	// guards configs and keys, which are replaced on Reload
+	mutex sync.RWMutex
+	keys  map[string]*scopeKeys
+
+	refreshConfig      *RefreshConfig
+	refreshStore       RefreshStore
+	stopRefreshCleanup context.CancelFunc
+
+	revocationConfig      *RevocationConfig
+	revocationStore       RevocationStore
+	revocationCache       *revocationCache
+	stopRevocationCleanup context.CancelFunc
+
+	authorizationConfig *AuthorizationConfig
+
+	oneTimeConfig      *OneTimeConfig
+	oneTimeStore       OneTimeStore
+	stopOneTimeCleanup context.CancelFunc
 }
 
 type Params struct {
@@ -25,13 +46,69 @@ type Params struct {
 
 	Lifecycle fx.Lifecycle
 	Logger    *zap.Logger
+	// required by the postgres refresh, revocation and one-time stores
+	Database *pgconn.Module `optional:"true"`
+	// replace the configured stores if provided
+	RefreshStore    RefreshStore    `optional:"true"`
+	RevocationStore RevocationStore `optional:"true"`
+	OneTimeStore    OneTimeStore    `optional:"true"`
 }
 
 type Config struct {
-	TokenLookup   string
+	TokenLookup string
+	// HMAC secret, or a secret reference such as "env:JWT_SECRET"
 	SigningKey    string
 	SigningMethod string
-	ExpInHours    int
+	// lifetime of tokens, e.g. "15m" for one-time email links. Takes precedence over ExpInHours if set.
+	Exp        time.Duration
+	ExpInHours int
+	// lifetime of refresh tokens issued with IssueTokenPair. Takes precedence over RefreshExpInHours if set.
+	RefreshExp        time.Duration
+	RefreshExpInHours int
+
+	// attributes of the cookie set by SetTokenCookie.
+	// The name defaults to the cookie of TokenLookup, e.g. "jwt" for "cookie:jwt".
+	CookieName     string
+	CookieDomain   string
+	CookiePath     string
+	CookieSecure   bool
+	CookieHTTPOnly bool
+	CookieSameSite string
+
+	// PEM keys for RS, PS, ES and EdDSA signing methods, inline or as secret references.
+	// Scopes with only a public key are verify-only.
+	PrivateKey     string
+	PrivateKeyFile string
+	PublicKey      string
+	PublicKeyFile  string
+
+	// identifies the key configured above, defaults to its thumbprint for asymmetric keys
+	Kid string
+	// signs new tokens, defaults to the first key with a private key
+	ActiveKid string
+	// additional keys, e.g. the next key while rotating; the key above keeps verifying the tokens it issued
+	Keys []KeyConfig
+
+	// verifies tokens against the keys of a remote JWKS instead, e.g. of another service or an identity provider
+	JWKSURL                         string
+	JWKSRefreshIntervalInMinutes    int
+	JWKSMinRefreshIntervalInSeconds int
+	// set as the "iss" and "aud" claims of generated tokens, and validated on verification, if set
+	Issuer   string
+	Audience string
+
+	// registered claims set by GenerateToken
+	SetIssuedAt  bool
+	SetNotBefore bool
+	GenerateJTI  bool
+	// rejects generating and verifying tokens without a "sub" claim
+	RequireSubject bool
+	// tolerated clock skew when validating "exp", "nbf" and "iat"
+	LeewayInSeconds int
+	// binds tokens to the scope that generated them, so scopes sharing a key do not accept each other's tokens
+	BindScope bool
+	// rejects tokens without the scope claim, enable once tokens issued before BindScope have expired
+	RequireScopeClaim bool
 }
 
 const (
@@ -40,6 +117,35 @@ const (
 	defaultTokenLookup   = "cookie:jwt"
 	defaultSigningMethod = "HS256"
 	defaultExpInHours    = 72
+
+	defaultCookieName     = ""
+	defaultCookieDomain   = ""
+	defaultCookiePath     = "/"
+	defaultCookieSecure   = false
+	defaultCookieHTTPOnly = true
+	defaultCookieSameSite = "lax"
+
+	defaultPrivateKey     = ""
+	defaultPrivateKeyFile = ""
+	defaultPublicKey      = ""
+	defaultPublicKeyFile  = ""
+
+	defaultKid       = ""
+	defaultActiveKid = ""
+
+	defaultJWKSURL                         = ""
+	defaultJWKSRefreshIntervalInMinutes    = 60
+	defaultJWKSMinRefreshIntervalInSeconds = 30
+	defaultIssuer                          = ""
+	defaultAudience                        = ""
+
+	defaultSetIssuedAt       = true
+	defaultSetNotBefore      = true
+	defaultGenerateJTI       = true
+	defaultRequireSubject    = false
+	defaultLeewayInSeconds   = 30
+	defaultBindScope         = true
+	defaultRequireScopeClaim = false
 )
 
 // ! Module ---------------------------------------------------------------
@@ -55,6 +161,24 @@ func InjectModule(moduleScope string, tokenScopes ...string) fx.Option {
 			m := &Module{scope: moduleScope}
 			m.logger = m.setupLogger(moduleScope, p)
 			m.configs = m.setupConfig(tokenScopes...)
+			m.setupKeys()
+			m.refreshConfig = m.setupRefreshConfig(moduleScope)
+			m.refreshStore = p.RefreshStore
+			if m.refreshStore == nil {
+				m.refreshStore = m.setupRefreshStore(p.Database)
+			}
+			m.revocationConfig = m.setupRevocationConfig(moduleScope)
+			m.revocationStore = p.RevocationStore
+			if m.revocationStore == nil {
+				m.revocationStore = m.setupRevocationStore(p.Database)
+			}
+			m.revocationCache = newRevocationCache(time.Duration(m.revocationConfig.CacheTTLInSeconds) * time.Second)
+			m.authorizationConfig = m.setupAuthorizationConfig(moduleScope)
+			m.oneTimeConfig = m.setupOneTimeConfig(moduleScope)
+			m.oneTimeStore = p.OneTimeStore
+			if m.oneTimeStore == nil {
+				m.oneTimeStore = m.setupOneTimeStore(p.Database)
+			}
 
 			return m
 		}),
@@ -74,6 +198,15 @@ func NewTokenManager(moduleScope string, logger *zap.Logger, tokenScopes ...stri
 	m := &Module{scope: moduleScope}
 	m.logger = logger.Named("[" + moduleScope + "]")
 	m.configs = m.setupConfig(tokenScopes...)
+	m.setupKeys()
+	m.refreshConfig = m.setupRefreshConfig(moduleScope)
+	m.refreshStore = m.setupRefreshStore(nil)
+	m.revocationConfig = m.setupRevocationConfig(moduleScope)
+	m.revocationStore = m.setupRevocationStore(nil)
+	m.revocationCache = newRevocationCache(time.Duration(m.revocationConfig.CacheTTLInSeconds) * time.Second)
+	m.authorizationConfig = m.setupAuthorizationConfig(moduleScope)
+	m.oneTimeConfig = m.setupOneTimeConfig(moduleScope)
+	m.oneTimeStore = m.setupOneTimeStore(nil)
 
 	m.onStart(context.Background())
 
@@ -102,21 +235,106 @@ func (m *Module) setupConfig(tokenScopes ...string) map[string]*Config {
 		viper.SetDefault(util.GetConfigPath(scope, "signing_key"), defaultSigningKey)
 		viper.SetDefault(util.GetConfigPath(scope, "signing_method"), defaultSigningMethod)
 		viper.SetDefault(util.GetConfigPath(scope, "exp_in_hours"), defaultExpInHours)
+		viper.SetDefault(util.GetConfigPath(scope, "refresh_exp_in_hours"), defaultRefreshExpInHours)
+		viper.SetDefault(util.GetConfigPath(scope, "cookie_name"), defaultCookieName)
+		viper.SetDefault(util.GetConfigPath(scope, "cookie_domain"), defaultCookieDomain)
+		viper.SetDefault(util.GetConfigPath(scope, "cookie_path"), defaultCookiePath)
+		viper.SetDefault(util.GetConfigPath(scope, "cookie_secure"), defaultCookieSecure)
+		viper.SetDefault(util.GetConfigPath(scope, "cookie_http_only"), defaultCookieHTTPOnly)
+		viper.SetDefault(util.GetConfigPath(scope, "cookie_same_site"), defaultCookieSameSite)
+		viper.SetDefault(util.GetConfigPath(scope, "private_key"), defaultPrivateKey)
+		viper.SetDefault(util.GetConfigPath(scope, "private_key_file"), defaultPrivateKeyFile)
+		viper.SetDefault(util.GetConfigPath(scope, "public_key"), defaultPublicKey)
+		viper.SetDefault(util.GetConfigPath(scope, "public_key_file"), defaultPublicKeyFile)
+		viper.SetDefault(util.GetConfigPath(scope, "kid"), defaultKid)
+		viper.SetDefault(util.GetConfigPath(scope, "active_kid"), defaultActiveKid)
+		viper.SetDefault(util.GetConfigPath(scope, "jwks_url"), defaultJWKSURL)
+		viper.SetDefault(util.GetConfigPath(scope, "jwks_refresh_interval_in_minutes"), defaultJWKSRefreshIntervalInMinutes)
+		viper.SetDefault(util.GetConfigPath(scope, "jwks_min_refresh_interval_in_seconds"), defaultJWKSMinRefreshIntervalInSeconds)
+		viper.SetDefault(util.GetConfigPath(scope, "issuer"), defaultIssuer)
+		viper.SetDefault(util.GetConfigPath(scope, "audience"), defaultAudience)
+		viper.SetDefault(util.GetConfigPath(scope, "set_issued_at"), defaultSetIssuedAt)
+		viper.SetDefault(util.GetConfigPath(scope, "set_not_before"), defaultSetNotBefore)
+		viper.SetDefault(util.GetConfigPath(scope, "generate_jti"), defaultGenerateJTI)
+		viper.SetDefault(util.GetConfigPath(scope, "require_subject"), defaultRequireSubject)
+		viper.SetDefault(util.GetConfigPath(scope, "leeway_in_seconds"), defaultLeewayInSeconds)
+		viper.SetDefault(util.GetConfigPath(scope, "bind_scope"), defaultBindScope)
+		viper.SetDefault(util.GetConfigPath(scope, "require_scope_claim"), defaultRequireScopeClaim)
+
+		var keys []KeyConfig
+		if err := viper.UnmarshalKey(util.GetConfigPath(scope, "keys"), &keys); err != nil {
+			m.logger.Error("Invalid token keys", zap.String("Scope", scope), zap.Error(err))
+		}
 
 		configs[scope] = &Config{
 			TokenLookup:   viper.GetString(util.GetConfigPath(scope, "token_lookup")),
 			SigningKey:    viper.GetString(util.GetConfigPath(scope, "signing_key")),
 			SigningMethod: viper.GetString(util.GetConfigPath(scope, "signing_method")),
-			ExpInHours:    viper.GetInt(util.GetConfigPath(scope, "exp_in_hours")),
+			// "exp" and "refresh_exp" have no default, so exp_in_hours and refresh_exp_in_hours apply unless they are set
+			Exp:        viper.GetDuration(util.GetConfigPath(scope, "exp")),
+			ExpInHours: viper.GetInt(util.GetConfigPath(scope, "exp_in_hours")),
+
+			RefreshExp:        viper.GetDuration(util.GetConfigPath(scope, "refresh_exp")),
+			RefreshExpInHours: viper.GetInt(util.GetConfigPath(scope, "refresh_exp_in_hours")),
+
+			CookieName:     viper.GetString(util.GetConfigPath(scope, "cookie_name")),
+			CookieDomain:   viper.GetString(util.GetConfigPath(scope, "cookie_domain")),
+			CookiePath:     viper.GetString(util.GetConfigPath(scope, "cookie_path")),
+			CookieSecure:   viper.GetBool(util.GetConfigPath(scope, "cookie_secure")),
+			CookieHTTPOnly: viper.GetBool(util.GetConfigPath(scope, "cookie_http_only")),
+			CookieSameSite: viper.GetString(util.GetConfigPath(scope, "cookie_same_site")),
+
+			PrivateKey:     viper.GetString(util.GetConfigPath(scope, "private_key")),
+			PrivateKeyFile: viper.GetString(util.GetConfigPath(scope, "private_key_file")),
+			PublicKey:      viper.GetString(util.GetConfigPath(scope, "public_key")),
+			PublicKeyFile:  viper.GetString(util.GetConfigPath(scope, "public_key_file")),
+
+			Kid:       viper.GetString(util.GetConfigPath(scope, "kid")),
+			ActiveKid: viper.GetString(util.GetConfigPath(scope, "active_kid")),
+			Keys:      keys,
+
+			JWKSURL:                         viper.GetString(util.GetConfigPath(scope, "jwks_url")),
+			JWKSRefreshIntervalInMinutes:    viper.GetInt(util.GetConfigPath(scope, "jwks_refresh_interval_in_minutes")),
+			JWKSMinRefreshIntervalInSeconds: viper.GetInt(util.GetConfigPath(scope, "jwks_min_refresh_interval_in_seconds")),
+			Issuer:                          viper.GetString(util.GetConfigPath(scope, "issuer")),
+			Audience:                        viper.GetString(util.GetConfigPath(scope, "audience")),
+
+			SetIssuedAt:       viper.GetBool(util.GetConfigPath(scope, "set_issued_at")),
+			SetNotBefore:      viper.GetBool(util.GetConfigPath(scope, "set_not_before")),
+			GenerateJTI:       viper.GetBool(util.GetConfigPath(scope, "generate_jti")),
+			RequireSubject:    viper.GetBool(util.GetConfigPath(scope, "require_subject")),
+			LeewayInSeconds:   viper.GetInt(util.GetConfigPath(scope, "leeway_in_seconds")),
+			BindScope:         viper.GetBool(util.GetConfigPath(scope, "bind_scope")),
+			RequireScopeClaim: viper.GetBool(util.GetConfigPath(scope, "require_scope_claim")),
 		}
 	}
 
 	return configs
 }
 
+// Lifetime of tokens of the scope, from Exp or ExpInHours.
+func (c *Config) getExp() time.Duration {
+	if c.Exp > 0 {
+		return c.Exp
+	}
+	return time.Hour * time.Duration(c.ExpInHours)
+}
+
+// Lifetime of refresh tokens of the scope, from RefreshExp or RefreshExpInHours.
+func (c *Config) getRefreshExp() time.Duration {
+	if c.RefreshExp > 0 {
+		return c.RefreshExp
+	}
+	return time.Hour * time.Duration(c.RefreshExpInHours)
+}
+
 func (m *Module) onStart(ctx context.Context) error {
 	m.logger.Info("Starting token manager.")
 
+	m.startRefreshStore(ctx)
+	m.startRevocationStore(ctx)
+	m.startOneTimeStore(ctx)
+
 	if viper.GetString("system.system_log_level") == "DEBUG" || viper.GetString("system.system_log_level") == "debug" {
 		m.logConfigurations()
 	}
@@ -126,17 +344,65 @@ func (m *Module) onStart(ctx context.Context) error {
 
 func (m *Module) onStop(ctx context.Context) error {
 	m.logger.Info("Stopping token manager.")
+
+	if m.stopRefreshCleanup != nil {
+		m.stopRefreshCleanup()
+	}
+	if m.stopRevocationCleanup != nil {
+		m.stopRevocationCleanup()
+	}
+	if m.stopOneTimeCleanup != nil {
+		m.stopOneTimeCleanup()
+	}
 	return nil
 }
 
 func (m *Module) logConfigurations() {
+	m.logger.Debug("RefreshStore", zap.String("RefreshStore", m.refreshConfig.Store))
+	m.logger.Debug("RefreshAutoMigrate", zap.Bool("RefreshAutoMigrate", m.refreshConfig.AutoMigrate))
+	m.logger.Debug("RefreshCleanupIntervalInMinutes", zap.Int("RefreshCleanupIntervalInMinutes", m.refreshConfig.CleanupIntervalInMinutes))
+	m.logger.Debug("RevocationStore", zap.String("RevocationStore", m.revocationConfig.Store))
+	m.logger.Debug("RevocationAutoMigrate", zap.Bool("RevocationAutoMigrate", m.revocationConfig.AutoMigrate))
+	m.logger.Debug("RevocationCacheTTLInSeconds", zap.Int("RevocationCacheTTLInSeconds", m.revocationConfig.CacheTTLInSeconds))
+	m.logger.Debug("RevocationCleanupIntervalInMinutes", zap.Int("RevocationCleanupIntervalInMinutes", m.revocationConfig.CleanupIntervalInMinutes))
+	m.logger.Debug("RolesClaim", zap.String("RolesClaim", m.authorizationConfig.RolesClaim))
+	m.logger.Debug("PermissionsClaim", zap.String("PermissionsClaim", m.authorizationConfig.PermissionsClaim))
+	m.logger.Debug("OneTimeStore", zap.String("OneTimeStore", m.oneTimeConfig.Store))
+	m.logger.Debug("OneTimeAutoMigrate", zap.Bool("OneTimeAutoMigrate", m.oneTimeConfig.AutoMigrate))
+	m.logger.Debug("OneTimeCleanupIntervalInMinutes", zap.Int("OneTimeCleanupIntervalInMinutes", m.oneTimeConfig.CleanupIntervalInMinutes))
+	m.logger.Debug("OneTimeExp", zap.Duration("OneTimeExp", m.oneTimeConfig.Exp))
+	m.logger.Debug("OneTimeURLParam", zap.String("OneTimeURLParam", m.oneTimeConfig.URLParam))
 	for scope, config := range m.configs {
 		m.logger.Debug("----- Token Manager Configuration -----")
 		m.logger.Debug("TokenScope", zap.String("TokenScope", scope))
 		m.logger.Debug("TokenLookup", zap.String("TokenLookup", config.TokenLookup))
 		m.logger.Debug("SigningKey", zap.String("SigningKey", config.SigningKey))
 		m.logger.Debug("SigningMethod", zap.String("SigningMethod", config.SigningMethod))
-		m.logger.Debug("ExpInHours", zap.Int("ExpInHours", config.ExpInHours))
+		m.logger.Debug("Exp", zap.Duration("Exp", config.getExp()))
+		m.logger.Debug("RefreshExp", zap.Duration("RefreshExp", config.getRefreshExp()))
+		m.logger.Debug("CookieName", zap.String("CookieName", config.getCookieName()))
+		m.logger.Debug("CookieDomain", zap.String("CookieDomain", config.CookieDomain))
+		m.logger.Debug("CookiePath", zap.String("CookiePath", config.CookiePath))
+		m.logger.Debug("CookieSecure", zap.Bool("CookieSecure", config.CookieSecure))
+		m.logger.Debug("CookieHTTPOnly", zap.Bool("CookieHTTPOnly", config.CookieHTTPOnly))
+		m.logger.Debug("CookieSameSite", zap.String("CookieSameSite", config.CookieSameSite))
+		m.logger.Debug("PrivateKeyFile", zap.String("PrivateKeyFile", config.PrivateKeyFile))
+		m.logger.Debug("PublicKeyFile", zap.String("PublicKeyFile", config.PublicKeyFile))
+		m.logger.Debug("Kid", zap.String("Kid", config.Kid))
+		m.logger.Debug("ActiveKid", zap.String("ActiveKid", config.ActiveKid))
+		m.logger.Debug("Keys", zap.Int("Keys", len(config.Keys)))
+		m.logger.Debug("JWKSURL", zap.String("JWKSURL", config.JWKSURL))
+		m.logger.Debug("JWKSRefreshIntervalInMinutes", zap.Int("JWKSRefreshIntervalInMinutes", config.JWKSRefreshIntervalInMinutes))
+		m.logger.Debug("JWKSMinRefreshIntervalInSeconds", zap.Int("JWKSMinRefreshIntervalInSeconds", config.JWKSMinRefreshIntervalInSeconds))
+		m.logger.Debug("Issuer", zap.String("Issuer", config.Issuer))
+		m.logger.Debug("Audience", zap.String("Audience", config.Audience))
+		m.logger.Debug("SetIssuedAt", zap.Bool("SetIssuedAt", config.SetIssuedAt))
+		m.logger.Debug("SetNotBefore", zap.Bool("SetNotBefore", config.SetNotBefore))
+		m.logger.Debug("GenerateJTI", zap.Bool("GenerateJTI", config.GenerateJTI))
+		m.logger.Debug("RequireSubject", zap.Bool("RequireSubject", config.RequireSubject))
+		m.logger.Debug("LeewayInSeconds", zap.Int("LeewayInSeconds", config.LeewayInSeconds))
+		m.logger.Debug("BindScope", zap.Bool("BindScope", config.BindScope))
+		m.logger.Debug("RequireScopeClaim", zap.Bool("RequireScopeClaim", config.RequireScopeClaim))
 	}
 }
 
@@ -144,6 +410,7 @@ func (m *Module) logConfigurations() {
 
 /*
 Generates a JWT token with the provided additional claims for a specific scope.
+Registered claims are set as configured for the scope, and may be overridden by the additional claims.
 Use jwt.MapClaims from "github.com/golang-jwt/jwt/v5"
 */
 func (m *Module) GenerateToken(tokenScope string, additionalClaims jwt.MapClaims) (*string, error) {
@@ -153,16 +420,40 @@ func (m *Module) GenerateToken(tokenScope string, additionalClaims jwt.MapClaims
 		return nil, err
 	}
 
-	claims := jwt.MapClaims{
-		"exp": jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(scopeConfig.ExpInHours))),
+	claims, err := newRegisteredClaims(scopeConfig)
+	if err != nil {
+		m.logger.Error("Failed to generate registered claims", zap.Error(err))
+		return nil, err
 	}
 
 	for key, value := range additionalClaims {
 		claims[key] = value
 	}
 
-	token := jwt.NewWithClaims(jwt.GetSigningMethod(scopeConfig.SigningMethod), claims)
-	t, err := token.SignedString([]byte(scopeConfig.SigningKey))
+	// set last, so additional claims cannot bind the token to another scope
+	if scopeConfig.BindScope {
+		claims[ScopeClaim] = tokenScope
+	}
+	if scopeConfig.RequireSubject {
+		if subject, _ := claims.GetSubject(); subject == "" {
+			return nil, ErrMissingSubject
+		}
+	}
+
+	keys, err := m.getKeysHelper(tokenScope)
+	if err != nil {
+		m.logger.Error("Failed to load signing key", zap.String("Scope:", tokenScope), zap.Error(err))
+		return nil, err
+	}
+	if keys.active == nil {
+		return nil, ErrVerifyOnly
+	}
+
+	token := jwt.NewWithClaims(keys.method, claims)
+	if keys.active.kid != "" {
+		token.Header["kid"] = keys.active.kid
+	}
+	t, err := token.SignedString(keys.active.signKey)
 	if err != nil {
 		m.logger.Error("Failed to generate token", zap.Error(err))
 		return nil, err
@@ -170,6 +461,20 @@ func (m *Module) GenerateToken(tokenScope string, additionalClaims jwt.MapClaims
 	return &t, nil
 }
 
+/*
+Parses and validates a JWT token for a specific scope, e.g. tokens received outside of HTTP requests.
+Validates the signature and the registered claims, as configured for the scope.
+Use ParseClaims to decode the claims into a struct instead of jwt.MapClaims.
+*/
+func (m *Module) ParseToken(tokenScope string, signed string) (*jwt.Token, error) {
+	if _, err := m.getConfigHelper(tokenScope); err != nil {
+		m.logger.Error("Config not found", zap.String("Scope:", tokenScope))
+		return nil, err
+	}
+
+	return m.parseWithClaims(context.Background(), tokenScope, signed, jwt.MapClaims{})
+}
+
 /*
 Returns an echo middleware that validates JWT tokens for a specific scope.
 Middleware validates the JWT token, parses claims, and stores them in context under the key "user".
@@ -181,14 +486,34 @@ func (m *Module) GetJWTMiddleware(tokenScope string) echo.MiddlewareFunc {
 		return nil
 	}
 
+	if _, err := m.getKeysHelper(tokenScope); err != nil {
+		m.logger.Error("Failed to load verification key", zap.String("Scope:", tokenScope), zap.Error(err))
+		return nil
+	}
+
 	return echojwt.WithConfig(echojwt.Config{
-		SigningKey:    []byte(scopeConfig.SigningKey),
-		SigningMethod: scopeConfig.SigningMethod,
-		TokenLookup:   scopeConfig.TokenLookup,
+		// revocation is checked here as well, through a cache in front of the revocation store
+		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
+			return m.parseWithClaims(c.Request().Context(), tokenScope, auth, jwt.MapClaims{})
+		},
+		TokenLookup: scopeConfig.TokenLookup,
+		ContextKey:  ContextKey,
 	})
 }
 
+// Returns the token lookup of a specific scope, e.g. "cookie:jwt".
+func (m *Module) GetTokenLookup(tokenScope string) (string, error) {
+	scopeConfig, err := m.getConfigHelper(tokenScope)
+	if err != nil {
+		return "", err
+	}
+	return scopeConfig.TokenLookup, nil
+}
+
 func (m *Module) getConfigHelper(scope string) (*Config, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
 	config, exists := m.configs[scope]
 	if !exists {
 		return nil, fmt.Errorf("config for scope %s not found", scope)
@@ -2,6 +2,7 @@ package token
 
 import (
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/spf13/viper"
@@ -111,6 +112,25 @@ func TestSetupConfig(t *testing.T) {
 		assert.Equal(t, defaultExpInHours, config.ExpInHours)
 
 	})
+
+	t.Run("TestExpDuration", func(t *testing.T) {
+		viper.Reset()
+		defer viper.Reset()
+
+		viper.Set("scope1.exp", "15m")
+		viper.Set("scope1.refresh_exp", "36h")
+
+		configs := m.setupConfig("scope1", "scope2")
+
+		assert.Equal(t, 15*time.Minute, configs["scope1"].getExp())
+		assert.Equal(t, 36*time.Hour, configs["scope1"].getRefreshExp())
+
+		// falls back to exp_in_hours and refresh_exp_in_hours
+		assert.Equal(t, time.Duration(defaultExpInHours)*time.Hour, configs["scope2"].getExp())
+		assert.Equal(t, time.Duration(defaultRefreshExpInHours)*time.Hour, configs["scope2"].getRefreshExp())
+		assert.Equal(t, "jwt", configs["scope2"].getCookieName())
+		assert.True(t, configs["scope2"].CookieHTTPOnly)
+	})
 }
 
 func TestGetConfigHelper(t *testing.T) {
@@ -191,3 +211,24 @@ func TestGetJWTMiddleware(t *testing.T) {
 	//todo: currently, only asserting that middleware exists
 	//todo: need to check if middleware is correct?
 }
+
+func TestGetTokenLookup(t *testing.T) {
+	m := Module{
+		configs: map[string]*Config{
+			"scope1": {
+				TokenLookup: "header:Authorization",
+			},
+		},
+	}
+
+	t.Run("ExistingScope", func(t *testing.T) {
+		lookup, err := m.GetTokenLookup("scope1")
+		assert.NoError(t, err)
+		assert.Equal(t, "header:Authorization", lookup)
+	})
+
+	t.Run("NonExistingScope", func(t *testing.T) {
+		_, err := m.GetTokenLookup("non_existing_scope")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,287 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/alsey89/gogetter/pkg/pgconn"
+	"github.com/alsey89/gogetter/pkg/util"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var (
+	// Returned for one-time tokens that are unknown, expired, revoked or of another purpose.
+	ErrInvalidOneTimeToken = errors.New("invalid one-time token")
+	// Returned when a one-time token is used a second time, e.g. when a link is opened twice.
+	ErrOneTimeTokenConsumed = errors.New("one-time token already used")
+)
+
+// Holds the module wide one-time token configuration, read from the module scope.
+type OneTimeConfig struct {
+	Store                    string
+	AutoMigrate              bool
+	CleanupIntervalInMinutes int
+	// lifetime of tokens issued without a TTL
+	Exp time.Duration
+	// query parameter holding the token in verification URLs
+	URLParam string
+}
+
+// one-time store types
+const (
+	OneTimeStoreMemory   = "memory"
+	OneTimeStorePostgres = "postgres"
+)
+
+const (
+	defaultOneTimeStore                    = OneTimeStoreMemory
+	defaultOneTimeAutoMigrate              = true
+	defaultOneTimeCleanupIntervalInMinutes = 60
+	defaultOneTimeExp                      = time.Hour
+	defaultOneTimeURLParam                 = "token"
+)
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupOneTimeConfig(moduleScope string) *OneTimeConfig {
+	viper.SetDefault(util.GetConfigPath(moduleScope, "one_time_store"), defaultOneTimeStore)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "one_time_auto_migrate"), defaultOneTimeAutoMigrate)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "one_time_cleanup_interval_in_minutes"), defaultOneTimeCleanupIntervalInMinutes)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "one_time_exp"), defaultOneTimeExp)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "one_time_url_param"), defaultOneTimeURLParam)
+
+	return &OneTimeConfig{
+		Store:                    viper.GetString(util.GetConfigPath(moduleScope, "one_time_store")),
+		AutoMigrate:              viper.GetBool(util.GetConfigPath(moduleScope, "one_time_auto_migrate")),
+		CleanupIntervalInMinutes: viper.GetInt(util.GetConfigPath(moduleScope, "one_time_cleanup_interval_in_minutes")),
+		Exp:                      viper.GetDuration(util.GetConfigPath(moduleScope, "one_time_exp")),
+		URLParam:                 viper.GetString(util.GetConfigPath(moduleScope, "one_time_url_param")),
+	}
+}
+
+func (m *Module) setupOneTimeStore(database *pgconn.Module) OneTimeStore {
+	switch m.oneTimeConfig.Store {
+	case OneTimeStorePostgres:
+		if database == nil {
+			m.logger.Fatal("postgres one-time store requires the pgconn module")
+		}
+		return NewPostgresOneTimeStore(database.GetDB())
+	case OneTimeStoreMemory:
+		return NewMemoryOneTimeStore()
+	default:
+		m.logger.Warn("invalid one-time store, using memory", zap.String("store", m.oneTimeConfig.Store))
+		return NewMemoryOneTimeStore()
+	}
+}
+
+func (m *Module) startOneTimeStore(ctx context.Context) {
+	if store, ok := m.oneTimeStore.(*PostgresOneTimeStore); ok && m.oneTimeConfig.AutoMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			m.logger.Error("failed to migrate one_time_tokens table", zap.Error(err))
+		}
+	}
+
+	// expired tokens are rejected on use, so this only frees up the store
+	if m.oneTimeConfig.CleanupIntervalInMinutes > 0 {
+		cleanupCtx, cancel := context.WithCancel(context.Background())
+		m.stopOneTimeCleanup = cancel
+		go m.cleanUpExpiredOneTimeTokens(cleanupCtx, time.Duration(m.oneTimeConfig.CleanupIntervalInMinutes)*time.Minute)
+	}
+}
+
+func (m *Module) cleanUpExpiredOneTimeTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.getOneTimeStore().DeleteExpired(ctx, time.Now()); err != nil {
+				m.logger.Error("failed to delete expired one-time tokens", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (m *Module) getOneTimeStore() OneTimeStore {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.oneTimeStore
+}
+
+func (m *Module) getOneTimeConfig() *OneTimeConfig {
+	// modules instantiated without configuration, e.g. in tests
+	if m.oneTimeConfig == nil {
+		return &OneTimeConfig{Exp: defaultOneTimeExp, URLParam: defaultOneTimeURLParam}
+	}
+	return m.oneTimeConfig
+}
+
+func (m *Module) getOneTimeToken(ctx context.Context, purpose string, token string) (*OneTimeToken, error) {
+	if token == "" {
+		return nil, ErrInvalidOneTimeToken
+	}
+
+	stored, err := m.getOneTimeStore().Get(ctx, hashOpaqueToken(token))
+	if errors.Is(err, ErrOneTimeTokenNotFound) {
+		return nil, ErrInvalidOneTimeToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.Purpose != purpose || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidOneTimeToken
+	}
+	if stored.ConsumedAt != nil {
+		return nil, ErrOneTimeTokenConsumed
+	}
+	return stored, nil
+}
+
+func parseVerificationURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid verification URL: %w", err)
+	}
+	if !u.IsAbs() {
+		return nil, fmt.Errorf("verification URL must be absolute: %s", baseURL)
+	}
+	return u, nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Replaces the configured one-time store, e.g. to use the postgres store with NewTokenManager.
+func (m *Module) SetOneTimeStore(store OneTimeStore) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.oneTimeStore = store
+}
+
+/*
+Issues an opaque single-use token for a purpose, e.g. email verification or password reset.
+Only the hash of the token is stored. A ttl of 0 uses the configured one_time_exp.
+Call RevokeOneTimeTokens first to invalidate links sent earlier.
+*/
+func (m *Module) IssueOneTimeToken(ctx context.Context, purpose string, subject string, ttl time.Duration, data map[string]interface{}) (string, error) {
+	if ttl <= 0 {
+		ttl = m.getOneTimeConfig().Exp
+	}
+
+	token, id, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	stored := &OneTimeToken{
+		ID:        id,
+		Purpose:   purpose,
+		Subject:   subject,
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := m.getOneTimeStore().Create(ctx, stored); err != nil {
+		m.logger.Error("Failed to issue one-time token", zap.String("Purpose", purpose), zap.Error(err))
+		return "", err
+	}
+	return token, nil
+}
+
+/*
+Returns the one-time token if it is valid for the purpose, without consuming it,
+e.g. to render a password reset form before the new password is submitted.
+*/
+func (m *Module) VerifyOneTimeToken(ctx context.Context, purpose string, token string) (*OneTimeToken, error) {
+	return m.getOneTimeToken(ctx, purpose, token)
+}
+
+/*
+Consumes the one-time token if it is valid for the purpose, and returns it.
+Returns ErrOneTimeTokenConsumed if it was used before, also by concurrent requests.
+*/
+func (m *Module) ConsumeOneTimeToken(ctx context.Context, purpose string, token string) (*OneTimeToken, error) {
+	stored, err := m.getOneTimeToken(ctx, purpose, token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	consumed, err := m.getOneTimeStore().MarkConsumed(ctx, stored.ID, now)
+	if errors.Is(err, ErrOneTimeTokenNotFound) {
+		return nil, ErrInvalidOneTimeToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrOneTimeTokenConsumed
+	}
+	stored.ConsumedAt = &now
+	return stored, nil
+}
+
+// Invalidates the outstanding one-time tokens of the subject for the purpose, e.g. after the password was reset.
+func (m *Module) RevokeOneTimeTokens(ctx context.Context, purpose string, subject string) error {
+	if err := m.getOneTimeStore().DeleteSubject(ctx, purpose, subject); err != nil {
+		m.logger.Error("Failed to revoke one-time tokens", zap.String("Purpose", purpose), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+/*
+Adds the token to the query of baseURL, as the configured one_time_url_param.
+The URL can be embedded in emails sent with the mailer module.
+
+	link, err := tokenModule.BuildVerificationURL("https://example.com/verify-email", token)
+*/
+func (m *Module) BuildVerificationURL(baseURL string, token string) (string, error) {
+	u, err := parseVerificationURL(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set(m.getOneTimeConfig().URLParam, token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+/*
+Issues a one-time token and returns the verification URL holding it. See IssueOneTimeToken and BuildVerificationURL.
+
+	link, err := tokenModule.IssueVerificationURL(ctx, "email_verification", userID, 15*time.Minute, nil, "https://example.com/verify-email")
+	body := fmt.Sprintf(`<a href="%s">Verify your email</a>`, link)
+	err = mailerModule.SendTransactionalMail(from, to, "Verify your email", body)
+*/
+func (m *Module) IssueVerificationURL(ctx context.Context, purpose string, subject string, ttl time.Duration, data map[string]interface{}, baseURL string) (string, error) {
+	// validated first, so no token is stored for an invalid URL
+	if _, err := parseVerificationURL(baseURL); err != nil {
+		return "", err
+	}
+
+	token, err := m.IssueOneTimeToken(ctx, purpose, subject, ttl, data)
+	if err != nil {
+		return "", err
+	}
+	return m.BuildVerificationURL(baseURL, token)
+}
+
+// Reads the one-time token of a verification URL from the query, or from the form if submitted.
+func (m *Module) GetOneTimeTokenParam(c echo.Context) string {
+	param := m.getOneTimeConfig().URLParam
+	if token := c.QueryParam(param); token != "" {
+		return token
+	}
+	return c.FormValue(param)
+}
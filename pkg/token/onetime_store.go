@@ -0,0 +1,241 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Returned by OneTimeStore.Get if the one-time token does not exist.
+var ErrOneTimeTokenNotFound = errors.New("one-time token not found")
+
+/*
+A one-time token, as persisted by the OneTimeStore.
+One-time tokens are keyed by ID, the hash of the opaque token handed out, so tokens can not be recovered from the store.
+*/
+type OneTimeToken struct {
+	ID string
+	// what the token may be used for, e.g. "email_verification" or "password_reset"
+	Purpose string
+	Subject string
+	// additional data, e.g. the email address to verify
+	Data      map[string]interface{}
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// set once the token was consumed
+	ConsumedAt *time.Time
+}
+
+// Persists one-time tokens.
+type OneTimeStore interface {
+	Get(ctx context.Context, id string) (*OneTimeToken, error)
+	Create(ctx context.Context, token *OneTimeToken) error
+	// marks the token as consumed, returning false if it already was, so concurrent requests cannot both succeed
+	MarkConsumed(ctx context.Context, id string, consumedAt time.Time) (bool, error)
+	// removes the tokens of the subject issued for the purpose
+	DeleteSubject(ctx context.Context, purpose string, subject string) error
+	// removes tokens that expired before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// In-memory OneTimeStore, for development, tests and single instance deployments.
+type MemoryOneTimeStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*OneTimeToken
+}
+
+// Row of the one_time_tokens table used by PostgresOneTimeStore.
+type OneTimeTokenRecord struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	Purpose    string `gorm:"index:idx_one_time_tokens_subject"`
+	Subject    string `gorm:"index:idx_one_time_tokens_subject"`
+	Data       []byte `gorm:"type:jsonb"`
+	CreatedAt  time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+	ConsumedAt *time.Time
+}
+
+func (OneTimeTokenRecord) TableName() string {
+	return "one_time_tokens"
+}
+
+// OneTimeStore backed by postgres through the pgconn module, for deployments with multiple instances.
+type PostgresOneTimeStore struct {
+	db *gorm.DB
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// data is copied through JSON, the same way it is persisted in postgres
+func (t *OneTimeToken) clone() (*OneTimeToken, error) {
+	cloned := *t
+
+	data, err := json.Marshal(t.Data)
+	if err != nil {
+		return nil, err
+	}
+	cloned.Data = make(map[string]interface{})
+	if err := json.Unmarshal(data, &cloned.Data); err != nil {
+		return nil, err
+	}
+	return &cloned, nil
+}
+
+func toOneTimeRecord(token *OneTimeToken) (*OneTimeTokenRecord, error) {
+	data, err := json.Marshal(token.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &OneTimeTokenRecord{
+		ID:         token.ID,
+		Purpose:    token.Purpose,
+		Subject:    token.Subject,
+		Data:       data,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		ConsumedAt: token.ConsumedAt,
+	}, nil
+}
+
+func fromOneTimeRecord(record *OneTimeTokenRecord) (*OneTimeToken, error) {
+	token := &OneTimeToken{
+		ID:         record.ID,
+		Purpose:    record.Purpose,
+		Subject:    record.Subject,
+		Data:       make(map[string]interface{}),
+		CreatedAt:  record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+		ConsumedAt: record.ConsumedAt,
+	}
+	if len(record.Data) > 0 {
+		if err := json.Unmarshal(record.Data, &token.Data); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+func NewMemoryOneTimeStore() *MemoryOneTimeStore {
+	return &MemoryOneTimeStore{
+		tokens: make(map[string]*OneTimeToken),
+	}
+}
+
+func (s *MemoryOneTimeStore) Get(ctx context.Context, id string) (*OneTimeToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrOneTimeTokenNotFound
+	}
+	return token.clone()
+}
+
+func (s *MemoryOneTimeStore) Create(ctx context.Context, token *OneTimeToken) error {
+	// copies are stored, so tokens behave the same as with the postgres store
+	stored, err := token.clone()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[token.ID] = stored
+	return nil
+}
+
+func (s *MemoryOneTimeStore) MarkConsumed(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return false, ErrOneTimeTokenNotFound
+	}
+	if token.ConsumedAt != nil {
+		return false, nil
+	}
+	token.ConsumedAt = &consumedAt
+	return true, nil
+}
+
+func (s *MemoryOneTimeStore) DeleteSubject(ctx context.Context, purpose string, subject string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, token := range s.tokens {
+		if token.Purpose == purpose && token.Subject == subject {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryOneTimeStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, token := range s.tokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// Use pgconn.Module.GetDB() to obtain the database.
+func NewPostgresOneTimeStore(db *gorm.DB) *PostgresOneTimeStore {
+	return &PostgresOneTimeStore{db: db}
+}
+
+// Creates or updates the one_time_tokens table.
+func (s *PostgresOneTimeStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&OneTimeTokenRecord{})
+}
+
+func (s *PostgresOneTimeStore) Get(ctx context.Context, id string) (*OneTimeToken, error) {
+	var record OneTimeTokenRecord
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOneTimeTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromOneTimeRecord(&record)
+}
+
+func (s *PostgresOneTimeStore) Create(ctx context.Context, token *OneTimeToken) error {
+	record, err := toOneTimeRecord(token)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Create(record).Error
+}
+
+func (s *PostgresOneTimeStore) MarkConsumed(ctx context.Context, id string, consumedAt time.Time) (bool, error) {
+	// the condition on consumed_at makes the update atomic across instances
+	result := s.db.WithContext(ctx).Model(&OneTimeTokenRecord{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		Update("consumed_at", consumedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *PostgresOneTimeStore) DeleteSubject(ctx context.Context, purpose string, subject string) error {
+	return s.db.WithContext(ctx).Where("purpose = ? AND subject = ?", purpose, subject).Delete(&OneTimeTokenRecord{}).Error
+}
+
+func (s *PostgresOneTimeStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&OneTimeTokenRecord{}).Error
+}
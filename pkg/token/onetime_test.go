@@ -0,0 +1,203 @@
+package token
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newOneTimeModule() *Module {
+	m := newKeyModule(map[string]*Config{})
+	m.SetOneTimeStore(NewMemoryOneTimeStore())
+	return m
+}
+
+func TestMemoryOneTimeStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryOneTimeStore()
+	now := time.Now()
+
+	require.NoError(t, store.Create(ctx, &OneTimeToken{ID: "id1", Purpose: "password_reset", Subject: "user123", Data: map[string]interface{}{"email": "user@example.com"}, ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.Create(ctx, &OneTimeToken{ID: "id2", Purpose: "password_reset", Subject: "user456", ExpiresAt: now.Add(-time.Minute)}))
+
+	token, err := store.Get(ctx, "id1")
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", token.Data["email"])
+
+	_, err = store.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrOneTimeTokenNotFound)
+
+	consumed, err := store.MarkConsumed(ctx, "id1", now)
+	assert.NoError(t, err)
+	assert.True(t, consumed)
+	consumed, _ = store.MarkConsumed(ctx, "id1", now)
+	assert.False(t, consumed)
+
+	require.NoError(t, store.DeleteExpired(ctx, now))
+	_, err = store.Get(ctx, "id2")
+	assert.ErrorIs(t, err, ErrOneTimeTokenNotFound)
+
+	require.NoError(t, store.DeleteSubject(ctx, "password_reset", "user123"))
+	_, err = store.Get(ctx, "id1")
+	assert.ErrorIs(t, err, ErrOneTimeTokenNotFound)
+}
+
+func TestOneTimeToken(t *testing.T) {
+	ctx := context.Background()
+	m := newOneTimeModule()
+
+	t.Run("TestConsume", func(t *testing.T) {
+		token, err := m.IssueOneTimeToken(ctx, "email_verification", "user123", 15*time.Minute, map[string]interface{}{"email": "user@example.com"})
+		require.NoError(t, err)
+
+		// only the hash is stored
+		_, err = m.getOneTimeStore().Get(ctx, token)
+		assert.ErrorIs(t, err, ErrOneTimeTokenNotFound)
+
+		verified, err := m.VerifyOneTimeToken(ctx, "email_verification", token)
+		require.NoError(t, err)
+		assert.Equal(t, "user123", verified.Subject)
+		assert.WithinDuration(t, time.Now().Add(15*time.Minute), verified.ExpiresAt, 2*time.Second)
+
+		consumed, err := m.ConsumeOneTimeToken(ctx, "email_verification", token)
+		require.NoError(t, err)
+		assert.Equal(t, "user@example.com", consumed.Data["email"])
+		assert.NotNil(t, consumed.ConsumedAt)
+
+		_, err = m.ConsumeOneTimeToken(ctx, "email_verification", token)
+		assert.ErrorIs(t, err, ErrOneTimeTokenConsumed)
+		_, err = m.VerifyOneTimeToken(ctx, "email_verification", token)
+		assert.ErrorIs(t, err, ErrOneTimeTokenConsumed)
+	})
+
+	t.Run("TestInvalid", func(t *testing.T) {
+		token, err := m.IssueOneTimeToken(ctx, "email_verification", "user123", 0, nil)
+		require.NoError(t, err)
+
+		_, err = m.ConsumeOneTimeToken(ctx, "password_reset", token)
+		assert.ErrorIs(t, err, ErrInvalidOneTimeToken)
+		_, err = m.ConsumeOneTimeToken(ctx, "email_verification", "unknown")
+		assert.ErrorIs(t, err, ErrInvalidOneTimeToken)
+		_, err = m.ConsumeOneTimeToken(ctx, "email_verification", "")
+		assert.ErrorIs(t, err, ErrInvalidOneTimeToken)
+
+		expired, err := m.IssueOneTimeToken(ctx, "email_verification", "user123", time.Nanosecond, nil)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		_, err = m.ConsumeOneTimeToken(ctx, "email_verification", expired)
+		assert.ErrorIs(t, err, ErrInvalidOneTimeToken)
+	})
+
+	t.Run("TestConcurrentConsume", func(t *testing.T) {
+		token, err := m.IssueOneTimeToken(ctx, "password_reset", "user123", time.Hour, nil)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		succeeded := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := m.ConsumeOneTimeToken(ctx, "password_reset", token); err == nil {
+					mutex.Lock()
+					succeeded++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("TestRevoke", func(t *testing.T) {
+		first, err := m.IssueOneTimeToken(ctx, "password_reset", "user456", time.Hour, nil)
+		require.NoError(t, err)
+		second, err := m.IssueOneTimeToken(ctx, "password_reset", "user456", time.Hour, nil)
+		require.NoError(t, err)
+		other, err := m.IssueOneTimeToken(ctx, "email_verification", "user456", time.Hour, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, m.RevokeOneTimeTokens(ctx, "password_reset", "user456"))
+
+		_, err = m.VerifyOneTimeToken(ctx, "password_reset", first)
+		assert.ErrorIs(t, err, ErrInvalidOneTimeToken)
+		_, err = m.VerifyOneTimeToken(ctx, "password_reset", second)
+		assert.ErrorIs(t, err, ErrInvalidOneTimeToken)
+		_, err = m.VerifyOneTimeToken(ctx, "email_verification", other)
+		assert.NoError(t, err)
+	})
+}
+
+func TestVerificationURL(t *testing.T) {
+	ctx := context.Background()
+	m := newOneTimeModule()
+
+	link, err := m.IssueVerificationURL(ctx, "email_verification", "user123", time.Hour, nil, "https://example.com/verify-email?lang=en")
+	require.NoError(t, err)
+
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", u.Host)
+	assert.Equal(t, "/verify-email", u.Path)
+	assert.Equal(t, "en", u.Query().Get("lang"))
+
+	// read back by the handler of the link
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, u.RequestURI(), nil), httptest.NewRecorder())
+	token := m.GetOneTimeTokenParam(c)
+	assert.Equal(t, u.Query().Get("token"), token)
+	_, err = m.ConsumeOneTimeToken(ctx, "email_verification", token)
+	assert.NoError(t, err)
+
+	t.Run("TestForm", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/reset-password", strings.NewReader("token=abc&password=secret"))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		c := e.NewContext(req, httptest.NewRecorder())
+		assert.Equal(t, "abc", m.GetOneTimeTokenParam(c))
+	})
+
+	t.Run("TestInvalidURL", func(t *testing.T) {
+		_, err := m.BuildVerificationURL("/verify-email", "abc")
+		assert.Error(t, err)
+
+		// no token is issued for an invalid URL
+		store := NewMemoryOneTimeStore()
+		m.SetOneTimeStore(store)
+		_, err = m.IssueVerificationURL(ctx, "email_verification", "user123", time.Hour, nil, "/verify-email")
+		assert.Error(t, err)
+		assert.Empty(t, store.tokens)
+	})
+}
+
+func TestOneTimeConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("jwt.one_time_exp", "15m")
+	viper.Set("jwt.one_time_url_param", "code")
+	m := NewTokenManager("jwt", zap.NewNop(), "jwt_auth")
+	defer m.onStop(context.Background())
+
+	assert.Equal(t, OneTimeStoreMemory, m.oneTimeConfig.Store)
+	assert.Equal(t, 15*time.Minute, m.oneTimeConfig.Exp)
+
+	link, err := m.IssueVerificationURL(context.Background(), "password_reset", "user123", 0, nil, "https://example.com/reset")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(link, "https://example.com/reset?code="))
+
+	stored, err := m.VerifyOneTimeToken(context.Background(), "password_reset", strings.TrimPrefix(link, "https://example.com/reset?code="))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), stored.ExpiresAt, 2*time.Second)
+}
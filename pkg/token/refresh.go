@@ -0,0 +1,355 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/alsey89/gogetter/pkg/pgconn"
+	"github.com/alsey89/gogetter/pkg/util"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+var (
+	// Returned for refresh tokens that are unknown, expired, revoked or of another scope.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// Returned when a refresh token is used a second time. Its whole family is revoked, as it was likely stolen.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Holds the module wide refresh token configuration, read from the module scope.
+type RefreshConfig struct {
+	Store                    string
+	AutoMigrate              bool
+	CleanupIntervalInMinutes int
+}
+
+// refresh store types
+const (
+	RefreshStoreMemory   = "memory"
+	RefreshStorePostgres = "postgres"
+)
+
+const (
+	defaultRefreshStore                    = RefreshStoreMemory
+	defaultRefreshAutoMigrate              = true
+	defaultRefreshCleanupIntervalInMinutes = 60
+	defaultRefreshExpInHours               = 720
+)
+
+// claims generated for every access token, which are not carried over on refresh
+var generatedClaims = []string{"exp", "iat", "nbf", "jti", "iss", "aud", ScopeClaim}
+
+// An access token and the refresh token to renew it, serialized as an OAuth 2.0 token response.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+
+	ExpiresAt        time.Time `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupRefreshConfig(moduleScope string) *RefreshConfig {
+	viper.SetDefault(util.GetConfigPath(moduleScope, "refresh_store"), defaultRefreshStore)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "refresh_auto_migrate"), defaultRefreshAutoMigrate)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "refresh_cleanup_interval_in_minutes"), defaultRefreshCleanupIntervalInMinutes)
+
+	return &RefreshConfig{
+		Store:                    viper.GetString(util.GetConfigPath(moduleScope, "refresh_store")),
+		AutoMigrate:              viper.GetBool(util.GetConfigPath(moduleScope, "refresh_auto_migrate")),
+		CleanupIntervalInMinutes: viper.GetInt(util.GetConfigPath(moduleScope, "refresh_cleanup_interval_in_minutes")),
+	}
+}
+
+func (m *Module) setupRefreshStore(database *pgconn.Module) RefreshStore {
+	switch m.refreshConfig.Store {
+	case RefreshStorePostgres:
+		if database == nil {
+			m.logger.Fatal("postgres refresh store requires the pgconn module")
+		}
+		return NewPostgresRefreshStore(database.GetDB())
+	case RefreshStoreMemory:
+		return NewMemoryRefreshStore()
+	default:
+		m.logger.Warn("invalid refresh store, using memory", zap.String("store", m.refreshConfig.Store))
+		return NewMemoryRefreshStore()
+	}
+}
+
+func (m *Module) startRefreshStore(ctx context.Context) {
+	if store, ok := m.refreshStore.(*PostgresRefreshStore); ok && m.refreshConfig.AutoMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			m.logger.Error("failed to migrate refresh_tokens table", zap.Error(err))
+		}
+	}
+
+	// expired tokens are rejected on use, so this only frees up the store
+	if m.refreshConfig.CleanupIntervalInMinutes > 0 {
+		cleanupCtx, cancel := context.WithCancel(context.Background())
+		m.stopRefreshCleanup = cancel
+		go m.cleanUpExpiredRefreshTokens(cleanupCtx, time.Duration(m.refreshConfig.CleanupIntervalInMinutes)*time.Minute)
+	}
+}
+
+func (m *Module) cleanUpExpiredRefreshTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.getRefreshStore().DeleteExpired(ctx, time.Now()); err != nil {
+				m.logger.Error("failed to delete expired refresh tokens", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (m *Module) getRefreshStore() RefreshStore {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.refreshStore
+}
+
+// Returns the opaque token handed out, and its hash used as ID in the refresh and one-time stores.
+func newOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate opaque token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashOpaqueToken(token), nil
+}
+
+func hashOpaqueToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (m *Module) issueTokenPair(ctx context.Context, tokenScope string, claims map[string]interface{}, familyID string) (*TokenPair, error) {
+	scopeConfig, err := m.getConfigHelper(tokenScope)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := m.GenerateToken(tokenScope, jwt.MapClaims(claims))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, id, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	subject, _ := claims["sub"].(string)
+	stored := &RefreshToken{
+		ID:         id,
+		FamilyID:   familyID,
+		TokenScope: tokenScope,
+		Subject:    subject,
+		Claims:     claims,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(scopeConfig.getRefreshExp()),
+	}
+	if err := m.getRefreshStore().Create(ctx, stored); err != nil {
+		return nil, err
+	}
+
+	expiresIn := scopeConfig.getExp()
+	return &TokenPair{
+		AccessToken:      *accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(expiresIn.Seconds()),
+		RefreshToken:     refreshToken,
+		ExpiresAt:        now.Add(expiresIn),
+		RefreshExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+func (m *Module) revokeRefreshFamily(ctx context.Context, stored *RefreshToken) error {
+	return m.getRefreshStore().RevokeFamily(ctx, stored.FamilyID, time.Now())
+}
+
+func bindRefreshToken(c echo.Context) string {
+	var req refreshRequest
+	if err := c.Bind(&req); err != nil {
+		return ""
+	}
+	return req.RefreshToken
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Replaces the configured refresh store, e.g. to use the postgres store with NewTokenManager.
+func (m *Module) SetRefreshStore(store RefreshStore) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.refreshStore = store
+}
+
+/*
+Generates an access token with the provided claims, paired with an opaque refresh token, e.g. on login.
+The claims are stored with the refresh token and re-issued with every refreshed access token.
+*/
+func (m *Module) IssueTokenPair(ctx context.Context, tokenScope string, claims jwt.MapClaims) (*TokenPair, error) {
+	stored := make(map[string]interface{}, len(claims))
+	for key, value := range claims {
+		stored[key] = value
+	}
+	for _, key := range generatedClaims {
+		delete(stored, key)
+	}
+
+	familyID, err := newJTI()
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := m.issueTokenPair(ctx, tokenScope, stored, familyID)
+	if err != nil {
+		m.logger.Error("Failed to issue token pair", zap.String("Scope:", tokenScope), zap.Error(err))
+		return nil, err
+	}
+	return pair, nil
+}
+
+/*
+Exchanges a refresh token for a new token pair. Refresh tokens are single-use: each refresh rotates the refresh token.
+Using a rotated refresh token again revokes every token of its family and returns ErrRefreshTokenReused.
+*/
+func (m *Module) RefreshTokenPair(ctx context.Context, tokenScope string, refreshToken string) (*TokenPair, error) {
+	store := m.getRefreshStore()
+
+	stored, err := store.Get(ctx, hashOpaqueToken(refreshToken))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if stored.TokenScope != tokenScope || stored.RevokedAt != nil || now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	marked := false
+	if stored.UsedAt == nil {
+		if marked, err = store.MarkUsed(ctx, stored.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	if !marked {
+		m.logger.Warn("Refresh token reused, revoking its family",
+			zap.String("Scope:", tokenScope), zap.String("Subject", stored.Subject))
+		if err := m.revokeRefreshFamily(ctx, stored); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	return m.issueTokenPair(ctx, tokenScope, stored.Claims, stored.FamilyID)
+}
+
+// Revokes the refresh token and every token rotated from the same login, e.g. on logout.
+func (m *Module) RevokeRefreshToken(ctx context.Context, refreshToken string) error {
+	stored, err := m.getRefreshStore().Get(ctx, hashOpaqueToken(refreshToken))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return m.revokeRefreshFamily(ctx, stored)
+}
+
+// Revokes every refresh token of the subject in the token scope, e.g. after a password change.
+func (m *Module) RevokeRefreshTokens(ctx context.Context, tokenScope string, subject string) error {
+	return m.getRefreshStore().RevokeSubject(ctx, tokenScope, subject, time.Now())
+}
+
+/*
+Returns an echo handler exchanging the refresh token in the request body for a new token pair.
+Accepts JSON or form bodies with a "refresh_token" field, and responds with the TokenPair as JSON.
+
+	e.POST("/auth/refresh", tokenModule.GetRefreshHandler("jwt_auth"))
+*/
+func (m *Module) GetRefreshHandler(tokenScope string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		refreshToken := bindRefreshToken(c)
+		if refreshToken == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "refresh_token is required")
+		}
+
+		pair, err := m.RefreshTokenPair(c.Request().Context(), tokenScope, refreshToken)
+		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		}
+		if err != nil {
+			m.logger.Error("Failed to refresh token pair", zap.String("Scope:", tokenScope), zap.Error(err))
+			return echo.NewHTTPError(http.StatusInternalServerError)
+		}
+
+		// token responses must not be cached, as required by OAuth 2.0
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.JSON(http.StatusOK, pair)
+	}
+}
+
+/*
+Returns an echo handler revoking the refresh token in the request body, and the tokens rotated along with it.
+Behind the JWT middleware, the access token of the request is revoked as well, and its cookie is cleared.
+Responds with 204, also for unknown tokens.
+
+	e.POST("/auth/logout", tokenModule.GetLogoutHandler(), tokenModule.GetJWTMiddleware("jwt_auth"))
+*/
+func (m *Module) GetLogoutHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		refreshToken := bindRefreshToken(c)
+		accessToken, _ := GetToken(c)
+		if refreshToken == "" && accessToken == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "refresh_token is required")
+		}
+
+		if refreshToken != "" {
+			if err := m.RevokeRefreshToken(c.Request().Context(), refreshToken); err != nil {
+				m.logger.Error("Failed to revoke refresh token", zap.Error(err))
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+		}
+		claims, _ := GetClaims(c)
+		if claims["jti"] != nil {
+			if err := m.RevokeToken(c.Request().Context(), accessToken); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError)
+			}
+		}
+		// the scope claim identifies the cookie the access token was read from
+		if tokenScope, _ := claims[ScopeClaim].(string); tokenScope != "" {
+			if err := m.ClearTokenCookie(c, tokenScope); err != nil && !errors.Is(err, ErrNoTokenCookie) {
+				m.logger.Warn("Failed to clear token cookie", zap.Error(err))
+			}
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
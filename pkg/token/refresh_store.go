@@ -0,0 +1,270 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Returned by RefreshStore.Get if the refresh token does not exist.
+var ErrRefreshTokenNotFound = errors.New("refresh token not found")
+
+/*
+A refresh token, as persisted by the RefreshStore.
+Refresh tokens are keyed by ID, the hash of the opaque token handed out, so tokens can not be recovered from the store.
+Tokens rotated from the same login share a FamilyID.
+*/
+type RefreshToken struct {
+	ID       string
+	FamilyID string
+	// token scope of the access tokens issued with it
+	TokenScope string
+	Subject    string
+	// additional claims of the access tokens, re-issued on every refresh
+	Claims    map[string]interface{}
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// set once the token was exchanged for a new one
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// Persists refresh tokens.
+type RefreshStore interface {
+	Get(ctx context.Context, id string) (*RefreshToken, error)
+	Create(ctx context.Context, token *RefreshToken) error
+	// marks the token as used, returning false if it already was, so concurrent refreshes cannot both succeed
+	MarkUsed(ctx context.Context, id string, usedAt time.Time) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error
+	RevokeSubject(ctx context.Context, tokenScope string, subject string, revokedAt time.Time) error
+	// removes tokens that expired before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// In-memory RefreshStore, for development, tests and single instance deployments.
+type MemoryRefreshStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*RefreshToken
+}
+
+// Row of the refresh_tokens table used by PostgresRefreshStore.
+type RefreshTokenRecord struct {
+	ID         string `gorm:"primaryKey;size:64"`
+	FamilyID   string `gorm:"index;size:64"`
+	TokenScope string `gorm:"index:idx_refresh_tokens_subject"`
+	Subject    string `gorm:"index:idx_refresh_tokens_subject"`
+	Claims     []byte `gorm:"type:jsonb"`
+	CreatedAt  time.Time
+	ExpiresAt  time.Time `gorm:"index"`
+	UsedAt     *time.Time
+	RevokedAt  *time.Time
+}
+
+func (RefreshTokenRecord) TableName() string {
+	return "refresh_tokens"
+}
+
+// RefreshStore backed by postgres through the pgconn module, for deployments with multiple instances.
+type PostgresRefreshStore struct {
+	db *gorm.DB
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+// claims are copied through JSON, the same way they are persisted in postgres
+func (t *RefreshToken) clone() (*RefreshToken, error) {
+	cloned := *t
+
+	claims, err := json.Marshal(t.Claims)
+	if err != nil {
+		return nil, err
+	}
+	cloned.Claims = make(map[string]interface{})
+	if err := json.Unmarshal(claims, &cloned.Claims); err != nil {
+		return nil, err
+	}
+	return &cloned, nil
+}
+
+func toRefreshRecord(token *RefreshToken) (*RefreshTokenRecord, error) {
+	claims, err := json.Marshal(token.Claims)
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshTokenRecord{
+		ID:         token.ID,
+		FamilyID:   token.FamilyID,
+		TokenScope: token.TokenScope,
+		Subject:    token.Subject,
+		Claims:     claims,
+		CreatedAt:  token.CreatedAt,
+		ExpiresAt:  token.ExpiresAt,
+		UsedAt:     token.UsedAt,
+		RevokedAt:  token.RevokedAt,
+	}, nil
+}
+
+func fromRefreshRecord(record *RefreshTokenRecord) (*RefreshToken, error) {
+	token := &RefreshToken{
+		ID:         record.ID,
+		FamilyID:   record.FamilyID,
+		TokenScope: record.TokenScope,
+		Subject:    record.Subject,
+		Claims:     make(map[string]interface{}),
+		CreatedAt:  record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+		UsedAt:     record.UsedAt,
+		RevokedAt:  record.RevokedAt,
+	}
+	if len(record.Claims) > 0 {
+		if err := json.Unmarshal(record.Claims, &token.Claims); err != nil {
+			return nil, err
+		}
+	}
+	return token, nil
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens: make(map[string]*RefreshToken),
+	}
+}
+
+func (s *MemoryRefreshStore) Get(ctx context.Context, id string) (*RefreshToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	return token.clone()
+}
+
+func (s *MemoryRefreshStore) Create(ctx context.Context, token *RefreshToken) error {
+	// copies are stored, so tokens behave the same as with the postgres store
+	stored, err := token.clone()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.tokens[token.ID] = stored
+	return nil
+}
+
+func (s *MemoryRefreshStore) MarkUsed(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return false, ErrRefreshTokenNotFound
+	}
+	if token.UsedAt != nil {
+		return false, nil
+	}
+	token.UsedAt = &usedAt
+	return true, nil
+}
+
+func (s *MemoryRefreshStore) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, token := range s.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshStore) RevokeSubject(ctx context.Context, tokenScope string, subject string, revokedAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, token := range s.tokens {
+		if token.TokenScope == tokenScope && token.Subject == subject && token.RevokedAt == nil {
+			token.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for id, token := range s.tokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+// Use pgconn.Module.GetDB() to obtain the database.
+func NewPostgresRefreshStore(db *gorm.DB) *PostgresRefreshStore {
+	return &PostgresRefreshStore{db: db}
+}
+
+// Creates or updates the refresh_tokens table.
+func (s *PostgresRefreshStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&RefreshTokenRecord{})
+}
+
+func (s *PostgresRefreshStore) Get(ctx context.Context, id string) (*RefreshToken, error) {
+	var record RefreshTokenRecord
+	err := s.db.WithContext(ctx).Where("id = ?", id).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromRefreshRecord(&record)
+}
+
+func (s *PostgresRefreshStore) Create(ctx context.Context, token *RefreshToken) error {
+	record, err := toRefreshRecord(token)
+	if err != nil {
+		return err
+	}
+	return s.db.WithContext(ctx).Create(record).Error
+}
+
+func (s *PostgresRefreshStore) MarkUsed(ctx context.Context, id string, usedAt time.Time) (bool, error) {
+	// the condition on used_at makes the update atomic across instances
+	result := s.db.WithContext(ctx).Model(&RefreshTokenRecord{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (s *PostgresRefreshStore) RevokeFamily(ctx context.Context, familyID string, revokedAt time.Time) error {
+	return s.db.WithContext(ctx).Model(&RefreshTokenRecord{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", revokedAt).Error
+}
+
+func (s *PostgresRefreshStore) RevokeSubject(ctx context.Context, tokenScope string, subject string, revokedAt time.Time) error {
+	return s.db.WithContext(ctx).Model(&RefreshTokenRecord{}).
+		Where("token_scope = ? AND subject = ? AND revoked_at IS NULL", tokenScope, subject).
+		Update("revoked_at", revokedAt).Error
+}
+
+func (s *PostgresRefreshStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&RefreshTokenRecord{}).Error
+}
@@ -0,0 +1,218 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRefreshModule() *Module {
+	m := newKeyModule(map[string]*Config{
+		"jwt_auth":  {SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1, RefreshExpInHours: 24},
+		"jwt_email": {SigningMethod: "HS256", SigningKey: "secret", ExpInHours: 1, RefreshExpInHours: 24},
+	})
+	m.SetRefreshStore(NewMemoryRefreshStore())
+	return m
+}
+
+func TestMemoryRefreshStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRefreshStore()
+	now := time.Now()
+
+	token := &RefreshToken{
+		ID:         "token1",
+		FamilyID:   "family1",
+		TokenScope: "jwt_auth",
+		Subject:    "user123",
+		Claims:     map[string]interface{}{"sub": "user123", "level": 1},
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	require.NoError(t, store.Create(ctx, token))
+	require.NoError(t, store.Create(ctx, &RefreshToken{ID: "token2", FamilyID: "family1", TokenScope: "jwt_auth", Subject: "user123", ExpiresAt: now.Add(time.Hour)}))
+	require.NoError(t, store.Create(ctx, &RefreshToken{ID: "token3", FamilyID: "family2", TokenScope: "jwt_auth", Subject: "user456", ExpiresAt: now.Add(-time.Minute)}))
+
+	t.Run("TestGet", func(t *testing.T) {
+		loaded, err := store.Get(ctx, "token1")
+		require.NoError(t, err)
+		// claims are restored from JSON, as with the postgres store
+		assert.Equal(t, float64(1), loaded.Claims["level"])
+
+		_, err = store.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrRefreshTokenNotFound)
+	})
+
+	t.Run("TestMarkUsed", func(t *testing.T) {
+		marked, err := store.MarkUsed(ctx, "token1", now)
+		assert.NoError(t, err)
+		assert.True(t, marked)
+
+		marked, err = store.MarkUsed(ctx, "token1", now)
+		assert.NoError(t, err)
+		assert.False(t, marked)
+	})
+
+	t.Run("TestRevoke", func(t *testing.T) {
+		require.NoError(t, store.RevokeFamily(ctx, "family1", now))
+		loaded, _ := store.Get(ctx, "token2")
+		assert.NotNil(t, loaded.RevokedAt)
+
+		require.NoError(t, store.RevokeSubject(ctx, "jwt_auth", "user456", now))
+		loaded, _ = store.Get(ctx, "token3")
+		assert.NotNil(t, loaded.RevokedAt)
+	})
+
+	t.Run("TestDeleteExpired", func(t *testing.T) {
+		require.NoError(t, store.DeleteExpired(ctx, now))
+		_, err := store.Get(ctx, "token3")
+		assert.ErrorIs(t, err, ErrRefreshTokenNotFound)
+		_, err = store.Get(ctx, "token1")
+		assert.NoError(t, err)
+	})
+}
+
+func TestRefreshTokenPair(t *testing.T) {
+	ctx := context.Background()
+	m := newRefreshModule()
+
+	pair, err := m.IssueTokenPair(ctx, "jwt_auth", jwt.MapClaims{"sub": "user123", "role": "admin", "exp": 1})
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer", pair.TokenType)
+	assert.Equal(t, int64(3600), pair.ExpiresIn)
+	assert.NotEmpty(t, pair.RefreshToken)
+
+	t.Run("TestRotation", func(t *testing.T) {
+		refreshed, err := m.RefreshTokenPair(ctx, "jwt_auth", pair.RefreshToken)
+		require.NoError(t, err)
+		assert.NotEqual(t, pair.RefreshToken, refreshed.RefreshToken)
+
+		// claims are carried over, registered claims are generated anew
+		parsed, err := m.ParseToken("jwt_auth", refreshed.AccessToken)
+		require.NoError(t, err)
+		claims := parsed.Claims.(jwt.MapClaims)
+		assert.Equal(t, "user123", claims["sub"])
+		assert.Equal(t, "admin", claims["role"])
+		exp, _ := claims.GetExpirationTime()
+		assert.True(t, exp.After(time.Now()))
+
+		pair = refreshed
+	})
+
+	t.Run("TestReuseRevokesFamily", func(t *testing.T) {
+		first, err := m.IssueTokenPair(ctx, "jwt_auth", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+		second, err := m.RefreshTokenPair(ctx, "jwt_auth", first.RefreshToken)
+		require.NoError(t, err)
+
+		// the rotated token is replayed, e.g. by an attacker who stole it
+		_, err = m.RefreshTokenPair(ctx, "jwt_auth", first.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+		// the legitimate client is logged out as well
+		_, err = m.RefreshTokenPair(ctx, "jwt_auth", second.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+		// other logins are not affected
+		_, err = m.RefreshTokenPair(ctx, "jwt_auth", pair.RefreshToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("TestConcurrentRefresh", func(t *testing.T) {
+		issued, err := m.IssueTokenPair(ctx, "jwt_auth", jwt.MapClaims{"sub": "user789"})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		succeeded := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := m.RefreshTokenPair(ctx, "jwt_auth", issued.RefreshToken); err == nil {
+					mutex.Lock()
+					succeeded++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, succeeded)
+	})
+
+	t.Run("TestInvalid", func(t *testing.T) {
+		_, err := m.RefreshTokenPair(ctx, "jwt_auth", "unknown")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+		// refresh tokens are bound to their scope
+		issued, err := m.IssueTokenPair(ctx, "jwt_email", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+		_, err = m.RefreshTokenPair(ctx, "jwt_auth", issued.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+
+		expired := &RefreshToken{ID: hashOpaqueToken("expired"), FamilyID: "expired", TokenScope: "jwt_auth", ExpiresAt: time.Now().Add(-time.Minute)}
+		require.NoError(t, m.getRefreshStore().Create(ctx, expired))
+		_, err = m.RefreshTokenPair(ctx, "jwt_auth", "expired")
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	})
+
+	t.Run("TestRevokeSubject", func(t *testing.T) {
+		issued, err := m.IssueTokenPair(ctx, "jwt_auth", jwt.MapClaims{"sub": "user456"})
+		require.NoError(t, err)
+
+		require.NoError(t, m.RevokeRefreshTokens(ctx, "jwt_auth", "user456"))
+		_, err = m.RefreshTokenPair(ctx, "jwt_auth", issued.RefreshToken)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	})
+}
+
+func TestRefreshHandlers(t *testing.T) {
+	m := newRefreshModule()
+
+	e := echo.New()
+	e.POST("/auth/refresh", m.GetRefreshHandler("jwt_auth"))
+	e.POST("/auth/logout", m.GetLogoutHandler())
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	pair, err := m.IssueTokenPair(context.Background(), "jwt_auth", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+
+	rec := post("/auth/refresh", `{"refresh_token":"`+pair.RefreshToken+`"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get(echo.HeaderCacheControl))
+
+	var refreshed TokenPair
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &refreshed))
+	assert.NotEmpty(t, refreshed.AccessToken)
+	assert.NotEmpty(t, refreshed.RefreshToken)
+
+	assert.Equal(t, http.StatusUnauthorized, post("/auth/refresh", `{"refresh_token":"`+pair.RefreshToken+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("/auth/refresh", `{}`).Code)
+
+	t.Run("TestLogout", func(t *testing.T) {
+		pair, err := m.IssueTokenPair(context.Background(), "jwt_auth", jwt.MapClaims{"sub": "user123"})
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusNoContent, post("/auth/logout", `{"refresh_token":"`+pair.RefreshToken+`"}`).Code)
+		assert.Equal(t, http.StatusUnauthorized, post("/auth/refresh", `{"refresh_token":"`+pair.RefreshToken+`"}`).Code)
+
+		// logging out twice is not an error
+		assert.Equal(t, http.StatusNoContent, post("/auth/logout", `{"refresh_token":"`+pair.RefreshToken+`"}`).Code)
+	})
+}
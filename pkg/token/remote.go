@@ -0,0 +1,241 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// timeout of requests to a remote JWKS endpoint
+const remoteJWKSTimeout = 10 * time.Second
+
+// A key of a remote JWKS.
+type remoteKey struct {
+	key interface{}
+	// empty if the JWK does not restrict its algorithm
+	alg string
+}
+
+// Caches the keys of a remote JWKS endpoint, e.g. of another service or an external identity provider.
+type remoteJWKS struct {
+	url    string
+	client *http.Client
+	// keys are refetched after this interval
+	refreshInterval time.Duration
+	// unknown kids trigger a refetch at most once per interval
+	minRefreshInterval time.Duration
+
+	mutex     sync.Mutex
+	keys      map[string]remoteKey
+	fetchedAt time.Time
+	// last attempt, including failed ones
+	attemptedAt time.Time
+	// closed when the running refetch completes, nil if none is running
+	refreshing chan struct{}
+	// error of the last refetch, returned for unknown kids
+	refreshErr error
+}
+
+/*
+Verifies tokens against the keys of a remote JWKS outside of a token scope,
+e.g. ID tokens of an OIDC provider whose JWKS URL is only known after discovery.
+*/
+type RemoteKeySet struct {
+	jwks *remoteJWKS
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func newRemoteJWKS(config *Config) *remoteJWKS {
+	return &remoteJWKS{
+		url:                config.JWKSURL,
+		client:             &http.Client{Timeout: remoteJWKSTimeout},
+		refreshInterval:    time.Duration(config.JWKSRefreshIntervalInMinutes) * time.Minute,
+		minRefreshInterval: time.Duration(config.JWKSMinRefreshIntervalInSeconds) * time.Second,
+	}
+}
+
+func (r *remoteJWKS) fetch(ctx context.Context) (map[string]remoteKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %d", res.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]remoteKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		// keys for encryption are skipped, as are key types this module does not support
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = remoteKey{key: key, alg: jwk.Alg}
+	}
+	return keys, nil
+}
+
+// Returns the key for the kid, refetching the JWKS if it is stale or the kid is unknown.
+// Cached keys are served while refetching, requests for unknown kids wait until ctx is done.
+func (r *remoteJWKS) getKey(ctx context.Context, kid string) (remoteKey, error) {
+	r.mutex.Lock()
+
+	now := time.Now()
+	key, known := r.keys[kid]
+	stale := r.keys == nil || now.Sub(r.fetchedAt) > r.refreshInterval
+	if !stale && known {
+		r.mutex.Unlock()
+		return key, nil
+	}
+
+	refreshing := r.refreshing
+	if refreshing == nil {
+		if now.Sub(r.attemptedAt) < r.minRefreshInterval {
+			r.mutex.Unlock()
+			if known {
+				return key, nil
+			}
+			return remoteKey{}, fmt.Errorf("unknown kid %q", kid)
+		}
+		r.attemptedAt = now
+		refreshing = make(chan struct{})
+		r.refreshing = refreshing
+		go r.refresh(refreshing)
+	}
+	r.mutex.Unlock()
+
+	// keeps serving cached keys while refetching, or while the endpoint is unavailable
+	if known {
+		return key, nil
+	}
+	select {
+	case <-refreshing:
+	case <-ctx.Done():
+		return remoteKey{}, ctx.Err()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key, known = r.keys[kid]
+	if !known && r.refreshErr != nil {
+		return remoteKey{}, r.refreshErr
+	}
+	if !known {
+		return remoteKey{}, fmt.Errorf("unknown kid %q", kid)
+	}
+	return key, nil
+}
+
+// Refetches the JWKS independently of the request that triggered it, so a cancelled request does not
+// fail the refetch for every request until the next attempt is allowed.
+func (r *remoteJWKS) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteJWKSTimeout)
+	defer cancel()
+
+	keys, err := r.fetch(ctx)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err == nil {
+		r.keys = keys
+		r.fetchedAt = time.Now()
+	}
+	r.refreshErr = err
+	r.refreshing = nil
+	close(done)
+}
+
+// Waits for unknown keys until ctx is done, e.g. the context of the request verifying the token.
+func (r *remoteJWKS) verifyKeyFor(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, err := r.getKey(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := token.Method.Alg()
+	if key.alg != "" && key.alg != alg {
+		return nil, fmt.Errorf("unexpected signing method %s for kid %q", alg, kid)
+	}
+	// prevents verifying e.g. an HMAC token with the public key as secret
+	if !isCompatibleMethod(token.Method, key.key) {
+		return nil, fmt.Errorf("signing method %s does not match the key of kid %q", alg, kid)
+	}
+	return key.key, nil
+}
+
+func isCompatibleMethod(method jwt.SigningMethod, key interface{}) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, isRSA := method.(*jwt.SigningMethodRSA)
+		_, isPSS := method.(*jwt.SigningMethodRSAPSS)
+		return isRSA || isPSS
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	default:
+		return false
+	}
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Caches the keys of the JWKS at url, refetched as configured with jwks_refresh_interval_in_minutes and jwks_min_refresh_interval_in_seconds.
+func NewRemoteKeySet(url string, refreshInterval time.Duration, minRefreshInterval time.Duration) *RemoteKeySet {
+	return &RemoteKeySet{jwks: &remoteJWKS{
+		url:                url,
+		client:             &http.Client{Timeout: remoteJWKSTimeout},
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+	}}
+}
+
+/*
+Returns the key for the kid of the token, for use as jwt.Keyfunc.
+Only asymmetric keys are returned, so tokens signed with HMAC are rejected.
+Use KeyfuncWithContext to stop waiting for the JWKS when the request is cancelled.
+
+	keySet := token.NewRemoteKeySet(jwksURL, time.Hour, 30*time.Second)
+	parsed, err := jwt.Parse(idToken, keySet.Keyfunc, jwt.WithIssuer(issuer), jwt.WithAudience(clientID))
+*/
+func (k *RemoteKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	return k.jwks.verifyKeyFor(context.Background(), token)
+}
+
+// Returns a jwt.Keyfunc that waits for unknown keys until ctx is done, e.g. the context of the request.
+func (k *RemoteKeySet) KeyfuncWithContext(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		return k.jwks.verifyKeyFor(ctx, token)
+	}
+}
@@ -0,0 +1,290 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Serves a JWKS that can be changed during a test, counting requests.
+type testJWKSServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	jwks     JWKS
+	requests int32
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	s := &testJWKSServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.requests, 1)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		json.NewEncoder(w).Encode(s.jwks)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testJWKSServer) setKeys(t *testing.T, method string, keys map[string]crypto.PublicKey) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jwks = JWKS{Keys: []JWK{}}
+	for kid, key := range keys {
+		jwk, err := newJWK(key)
+		require.NoError(t, err)
+		jwk.Kid = kid
+		jwk.Use = "sig"
+		jwk.Alg = method
+		s.jwks.Keys = append(s.jwks.Keys, jwk)
+	}
+}
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	for method, key := range newTestKeys(t) {
+		t.Run(method, func(t *testing.T) {
+			jwk, err := newJWK(key.Public())
+			require.NoError(t, err)
+
+			publicKey, err := jwk.publicKey()
+			require.NoError(t, err)
+			assert.True(t, publicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()))
+		})
+	}
+
+	// points off the curve are rejected
+	_, err := JWK{Kty: "EC", Crv: "P-256", X: encodeBase64URL([]byte{1}), Y: encodeBase64URL([]byte{2})}.publicKey()
+	assert.Error(t, err)
+
+	_, err = JWK{Kty: "oct"}.publicKey()
+	assert.Error(t, err)
+}
+
+func TestRemoteJWKS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rotatedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.setKeys(t, "ES256", map[string]crypto.PublicKey{"first": key.Public()})
+
+	m := newKeyModule(map[string]*Config{
+		"remote": {
+			TokenLookup:                     "header:Authorization:Bearer ",
+			JWKSURL:                         server.URL,
+			JWKSRefreshIntervalInMinutes:    60,
+			JWKSMinRefreshIntervalInSeconds: 0,
+			Issuer:                          "https://issuer.example.com",
+			Audience:                        "api",
+		},
+	})
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "user123",
+			"iss": "https://issuer.example.com",
+			"aud": "api",
+			"exp": jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}
+	}
+
+	t.Run("TestParseToken", func(t *testing.T) {
+		parsed, err := m.ParseToken("remote", signTestToken(t, jwt.SigningMethodES256, "first", key, claims()))
+		require.NoError(t, err)
+		assert.Equal(t, "user123", parsed.Claims.(jwt.MapClaims)["sub"])
+
+		// keys are cached
+		_, err = m.ParseToken("remote", signTestToken(t, jwt.SigningMethodES256, "first", key, claims()))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+	})
+
+	t.Run("TestIssuerAndAudience", func(t *testing.T) {
+		wrongIssuer := claims()
+		wrongIssuer["iss"] = "https://other.example.com"
+		_, err := m.ParseToken("remote", signTestToken(t, jwt.SigningMethodES256, "first", key, wrongIssuer))
+		assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+
+		wrongAudience := claims()
+		wrongAudience["aud"] = "admin"
+		_, err = m.ParseToken("remote", signTestToken(t, jwt.SigningMethodES256, "first", key, wrongAudience))
+		assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	})
+
+	t.Run("TestUnknownKidRefreshes", func(t *testing.T) {
+		server.setKeys(t, "ES256", map[string]crypto.PublicKey{"first": key.Public(), "second": rotatedKey.Public()})
+
+		_, err := m.ParseToken("remote", signTestToken(t, jwt.SigningMethodES256, "second", rotatedKey, claims()))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&server.requests))
+	})
+
+	t.Run("TestAlgorithmConfusion", func(t *testing.T) {
+		// the public key must not be accepted as HMAC secret
+		jwk, err := newJWK(key.Public())
+		require.NoError(t, err)
+		secret, err := json.Marshal(jwk)
+		require.NoError(t, err)
+
+		_, err = m.ParseToken("remote", signTestToken(t, jwt.SigningMethodHS256, "first", secret, claims()))
+		assert.Error(t, err)
+	})
+
+	t.Run("TestJWTMiddleware", func(t *testing.T) {
+		e := echo.New()
+		e.GET("/", func(c echo.Context) error {
+			return c.NoContent(http.StatusNoContent)
+		}, m.GetJWTMiddleware("remote"))
+
+		send := func(signed string) int {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+signed)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		assert.Equal(t, http.StatusNoContent, send(signTestToken(t, jwt.SigningMethodES256, "first", key, claims())))
+
+		unknownKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, send(signTestToken(t, jwt.SigningMethodES256, "unknown", unknownKey, claims())))
+	})
+
+	t.Run("TestVerifyOnly", func(t *testing.T) {
+		_, err := m.GenerateToken("remote", jwt.MapClaims{})
+		assert.ErrorIs(t, err, ErrVerifyOnly)
+	})
+}
+
+func TestRemoteJWKSRateLimit(t *testing.T) {
+	server := newTestJWKSServer(t)
+	server.setKeys(t, "ES256", map[string]crypto.PublicKey{})
+
+	remote := newRemoteJWKS(&Config{
+		JWKSURL:                         server.URL,
+		JWKSRefreshIntervalInMinutes:    60,
+		JWKSMinRefreshIntervalInSeconds: 60,
+	})
+
+	// unknown kids cannot be used to flood the JWKS endpoint
+	for i := 0; i < 5; i++ {
+		_, err := remote.verifyKeyFor(context.Background(), &jwt.Token{Method: jwt.SigningMethodES256, Header: map[string]interface{}{"kid": "unknown"}})
+		assert.Error(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.setKeys(t, "ES256", map[string]crypto.PublicKey{"key1": key.Public()})
+
+	keySet := NewRemoteKeySet(server.URL, time.Hour, 30*time.Second)
+	signed := signTestToken(t, jwt.SigningMethodES256, "key1", key, jwt.MapClaims{"sub": "user123", "iss": "https://idp.example.com"})
+
+	parsed, err := jwt.Parse(signed, keySet.Keyfunc, jwt.WithIssuer("https://idp.example.com"))
+	require.NoError(t, err)
+	assert.True(t, parsed.Valid)
+
+	// HMAC tokens are rejected, even if the kid matches
+	forged := signTestToken(t, jwt.SigningMethodHS256, "key1", []byte("secret"), jwt.MapClaims{"sub": "user123"})
+	_, err = jwt.Parse(forged, keySet.Keyfunc)
+	assert.Error(t, err)
+}
+
+func TestRemoteJWKSSlowRefetch(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.setKeys(t, "ES256", map[string]crypto.PublicKey{"cached": key.Public()})
+
+	remote := newRemoteJWKS(&Config{
+		JWKSURL:                         server.URL,
+		JWKSRefreshIntervalInMinutes:    60,
+		JWKSMinRefreshIntervalInSeconds: 0,
+	})
+	verify := func(ctx context.Context, kid string) error {
+		_, err := remote.verifyKeyFor(ctx, &jwt.Token{Method: jwt.SigningMethodES256, Header: map[string]interface{}{"kid": kid}})
+		return err
+	}
+	require.NoError(t, verify(context.Background(), "cached"))
+
+	// the next fetch hangs until the test ends
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	go verify(context.Background(), "unknown")
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&server.requests) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	t.Run("TestCachedKidsDoNotWait", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() { done <- verify(context.Background(), "cached") }()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("cached kid blocked by the refetch")
+		}
+	})
+
+	t.Run("TestCancelledContext", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, verify(ctx, "other"), context.Canceled)
+	})
+}
+
+func TestRemoteJWKSCancelledRequest(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.setKeys(t, "ES256", map[string]crypto.PublicKey{"first": key.Public()})
+
+	remote := newRemoteJWKS(&Config{
+		JWKSURL:                         server.URL,
+		JWKSRefreshIntervalInMinutes:    60,
+		JWKSMinRefreshIntervalInSeconds: 60,
+	})
+	token := &jwt.Token{Method: jwt.SigningMethodES256, Header: map[string]interface{}{"kid": "first"}}
+
+	// e.g. the client disconnected while the first request after startup fetched the JWKS
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = remote.verifyKeyFor(ctx, token)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// the fetch is not cancelled with the request, so other requests do not wait for the next attempt
+	_, err = remote.verifyKeyFor(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&server.requests))
+}
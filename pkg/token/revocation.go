@@ -0,0 +1,330 @@
+package token
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alsey89/gogetter/pkg/pgconn"
+	"github.com/alsey89/gogetter/pkg/util"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+// Returned when parsing a token that was revoked by jti or subject.
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// Holds the module wide revocation configuration, read from the module scope.
+type RevocationConfig struct {
+	Store       string
+	AutoMigrate bool
+	// revocations made by other instances apply after at most this long
+	CacheTTLInSeconds        int
+	CleanupIntervalInMinutes int
+}
+
+// revocation store types
+const (
+	RevocationStoreMemory   = "memory"
+	RevocationStorePostgres = "postgres"
+)
+
+const (
+	defaultRevocationStore                    = RevocationStoreMemory
+	defaultRevocationAutoMigrate              = true
+	defaultRevocationCacheTTLInSeconds        = 30
+	defaultRevocationCleanupIntervalInMinutes = 60
+)
+
+// the oldest cached entries are evicted once the cache holds this many ids, or subjects
+const revocationCacheMaxEntries = 10000
+
+// Caches revocation lookups, so the JWT middleware does not query the store on every request.
+type revocationCache struct {
+	mutex    sync.Mutex
+	ttl      time.Duration
+	ids      *cachedRevocations
+	subjects *cachedRevocations
+}
+
+// Cached entries in insertion order, which is also the order they expire in, as they share the ttl.
+type cachedRevocations struct {
+	entries map[string]*list.Element
+	// of *cachedRevocation, oldest first
+	order *list.List
+}
+
+type cachedRevocation struct {
+	key     string
+	revoked bool
+	// tokens of the subject issued before this time are revoked
+	before   time.Time
+	cachedAt time.Time
+}
+
+//! INTERNAL ---------------------------------------------------------------
+
+func (m *Module) setupRevocationConfig(moduleScope string) *RevocationConfig {
+	viper.SetDefault(util.GetConfigPath(moduleScope, "revocation_store"), defaultRevocationStore)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "revocation_auto_migrate"), defaultRevocationAutoMigrate)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "revocation_cache_ttl_in_seconds"), defaultRevocationCacheTTLInSeconds)
+	viper.SetDefault(util.GetConfigPath(moduleScope, "revocation_cleanup_interval_in_minutes"), defaultRevocationCleanupIntervalInMinutes)
+
+	return &RevocationConfig{
+		Store:                    viper.GetString(util.GetConfigPath(moduleScope, "revocation_store")),
+		AutoMigrate:              viper.GetBool(util.GetConfigPath(moduleScope, "revocation_auto_migrate")),
+		CacheTTLInSeconds:        viper.GetInt(util.GetConfigPath(moduleScope, "revocation_cache_ttl_in_seconds")),
+		CleanupIntervalInMinutes: viper.GetInt(util.GetConfigPath(moduleScope, "revocation_cleanup_interval_in_minutes")),
+	}
+}
+
+func (m *Module) setupRevocationStore(database *pgconn.Module) RevocationStore {
+	switch m.revocationConfig.Store {
+	case RevocationStorePostgres:
+		if database == nil {
+			m.logger.Fatal("postgres revocation store requires the pgconn module")
+		}
+		return NewPostgresRevocationStore(database.GetDB())
+	case RevocationStoreMemory:
+		return NewMemoryRevocationStore()
+	default:
+		m.logger.Warn("invalid revocation store, using memory", zap.String("store", m.revocationConfig.Store))
+		return NewMemoryRevocationStore()
+	}
+}
+
+func newRevocationCache(ttl time.Duration) *revocationCache {
+	return &revocationCache{
+		ttl:      ttl,
+		ids:      newCachedRevocations(),
+		subjects: newCachedRevocations(),
+	}
+}
+
+func newCachedRevocations() *cachedRevocations {
+	return &cachedRevocations{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (m *Module) startRevocationStore(ctx context.Context) {
+	if store, ok := m.revocationStore.(*PostgresRevocationStore); ok && m.revocationConfig.AutoMigrate {
+		if err := store.Migrate(ctx); err != nil {
+			m.logger.Error("failed to migrate revocation tables", zap.Error(err))
+		}
+	}
+
+	// revocations of expired tokens are no longer needed
+	if m.revocationConfig.CleanupIntervalInMinutes > 0 {
+		cleanupCtx, cancel := context.WithCancel(context.Background())
+		m.stopRevocationCleanup = cancel
+		go m.cleanUpExpiredRevocations(cleanupCtx, time.Duration(m.revocationConfig.CleanupIntervalInMinutes)*time.Minute)
+	}
+}
+
+func (m *Module) cleanUpExpiredRevocations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			store, _ := m.getRevocation()
+			if err := store.DeleteExpired(ctx, time.Now()); err != nil {
+				m.logger.Error("failed to delete expired revocations", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (m *Module) getRevocation() (RevocationStore, *revocationCache) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.revocationStore, m.revocationCache
+}
+
+func (c *revocationCache) get(cached *cachedRevocations, key string) (cachedRevocation, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := cached.entries[key]
+	if !ok {
+		return cachedRevocation{}, false
+	}
+	entry := element.Value.(*cachedRevocation)
+	if time.Since(entry.cachedAt) > c.ttl {
+		return cachedRevocation{}, false
+	}
+	return *entry, true
+}
+
+// Evicts expired entries from the front, and the oldest ones beyond the limit, without scanning the cache.
+func (c *revocationCache) set(cached *cachedRevocations, key string, entry cachedRevocation) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := cached.entries[key]; ok {
+		cached.order.Remove(element)
+		delete(cached.entries, key)
+	}
+
+	now := time.Now()
+	for oldest := cached.order.Front(); oldest != nil; oldest = cached.order.Front() {
+		expired := now.Sub(oldest.Value.(*cachedRevocation).cachedAt) > c.ttl
+		if !expired && cached.order.Len() < revocationCacheMaxEntries {
+			break
+		}
+		delete(cached.entries, oldest.Value.(*cachedRevocation).key)
+		cached.order.Remove(oldest)
+	}
+
+	entry.key = key
+	entry.cachedAt = now
+	cached.entries[key] = cached.order.PushBack(&entry)
+}
+
+func isIDRevoked(ctx context.Context, store RevocationStore, cache *revocationCache, jti string) (bool, error) {
+	if cached, ok := cache.get(cache.ids, jti); ok {
+		return cached.revoked, nil
+	}
+
+	revoked, err := store.IsIDRevoked(ctx, jti)
+	if err != nil {
+		return false, err
+	}
+	cache.set(cache.ids, jti, cachedRevocation{revoked: revoked})
+	return revoked, nil
+}
+
+func getSubjectRevokedBefore(ctx context.Context, store RevocationStore, cache *revocationCache, subject string) (time.Time, error) {
+	if cached, ok := cache.get(cache.subjects, subject); ok {
+		return cached.before, nil
+	}
+
+	before, err := store.GetSubjectRevokedBefore(ctx, subject)
+	if err != nil {
+		return time.Time{}, err
+	}
+	cache.set(cache.subjects, subject, cachedRevocation{before: before})
+	return before, nil
+}
+
+// Rejects tokens revoked by jti, or by subject if they were issued before the revocation.
+func (m *Module) checkRevocation(ctx context.Context, claims jwt.MapClaims) error {
+	store, cache := m.getRevocation()
+	// modules instantiated without revocation, e.g. in tests
+	if store == nil {
+		return nil
+	}
+
+	if jti, _ := claims["jti"].(string); jti != "" {
+		revoked, err := isIDRevoked(ctx, store, cache, jti)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil
+	}
+	before, err := getSubjectRevokedBefore(ctx, store, cache, subject)
+	if err != nil {
+		return err
+	}
+	if before.IsZero() {
+		return nil
+	}
+	// "iat" has second precision, so tokens issued in the second of the revocation are revoked as well
+	issuedAt, _ := claims.GetIssuedAt()
+	if issuedAt == nil || !issuedAt.After(before.Truncate(time.Second)) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// Tokens of every scope issued before a subject revocation must have expired before it can be removed.
+func (m *Module) getMaxTokenLifetime() time.Duration {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	var lifetime time.Duration
+	for _, config := range m.configs {
+		if scopeLifetime := config.getExp(); scopeLifetime > lifetime {
+			lifetime = scopeLifetime
+		}
+	}
+	return lifetime
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+// Replaces the configured revocation store, e.g. to use the postgres store with NewTokenManager.
+func (m *Module) SetRevocationStore(store RevocationStore) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var ttl time.Duration
+	if m.revocationConfig != nil {
+		ttl = time.Duration(m.revocationConfig.CacheTTLInSeconds) * time.Second
+	}
+	m.revocationStore = store
+	m.revocationCache = newRevocationCache(ttl)
+}
+
+/*
+Revokes a single token by its jti, e.g. the access token on logout.
+The revocation is kept until expiresAt, the expiry of the token.
+*/
+func (m *Module) RevokeTokenID(ctx context.Context, jti string, expiresAt time.Time) error {
+	store, cache := m.getRevocation()
+	if err := store.RevokeID(ctx, jti, expiresAt); err != nil {
+		m.logger.Error("Failed to revoke token", zap.Error(err))
+		return err
+	}
+	cache.set(cache.ids, jti, cachedRevocation{revoked: true})
+	return nil
+}
+
+// Revokes a parsed token, e.g. the token stored in the echo context by the JWT middleware. Requires a "jti" claim.
+func (m *Module) RevokeToken(ctx context.Context, token *jwt.Token) error {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return errors.New("unsupported claims type")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return errors.New("token has no jti")
+	}
+
+	expiresAt := time.Now().Add(m.getMaxTokenLifetime())
+	if exp, _ := claims.GetExpirationTime(); exp != nil {
+		expiresAt = exp.Time
+	}
+	return m.RevokeTokenID(ctx, jti, expiresAt)
+}
+
+// Revokes every token of the subject issued until now, in all token scopes, e.g. when an account is compromised.
+func (m *Module) RevokeSubject(ctx context.Context, subject string) error {
+	store, cache := m.getRevocation()
+	now := time.Now()
+	if err := store.RevokeSubject(ctx, subject, now, now.Add(m.getMaxTokenLifetime())); err != nil {
+		m.logger.Error("Failed to revoke subject", zap.Error(err))
+		return err
+	}
+	cache.set(cache.subjects, subject, cachedRevocation{before: now})
+	return nil
+}
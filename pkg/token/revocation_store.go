@@ -0,0 +1,165 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+/*
+Persists revoked tokens, by jti and by subject.
+Revocations are kept until the tokens they apply to expired, after which DeleteExpired removes them.
+*/
+type RevocationStore interface {
+	RevokeID(ctx context.Context, jti string, expiresAt time.Time) error
+	IsIDRevoked(ctx context.Context, jti string) (bool, error)
+	// revokes the tokens of the subject issued before the given time
+	RevokeSubject(ctx context.Context, subject string, before time.Time, expiresAt time.Time) error
+	// returns the zero time if the tokens of the subject were never revoked
+	GetSubjectRevokedBefore(ctx context.Context, subject string) (time.Time, error)
+	// removes revocations that expired before the given time
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+// In-memory RevocationStore, for development, tests and single instance deployments.
+type MemoryRevocationStore struct {
+	mutex    sync.RWMutex
+	ids      map[string]time.Time
+	subjects map[string]subjectRevocation
+}
+
+type subjectRevocation struct {
+	before    time.Time
+	expiresAt time.Time
+}
+
+// Row of the revoked_tokens table used by PostgresRevocationStore.
+type RevokedTokenRecord struct {
+	ID        string    `gorm:"primaryKey;size:128"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (RevokedTokenRecord) TableName() string {
+	return "revoked_tokens"
+}
+
+// Row of the revoked_subjects table used by PostgresRevocationStore.
+type RevokedSubjectRecord struct {
+	Subject       string `gorm:"primaryKey"`
+	RevokedBefore time.Time
+	ExpiresAt     time.Time `gorm:"index"`
+}
+
+func (RevokedSubjectRecord) TableName() string {
+	return "revoked_subjects"
+}
+
+// RevocationStore backed by postgres through the pgconn module, for deployments with multiple instances.
+type PostgresRevocationStore struct {
+	db *gorm.DB
+}
+
+//! EXTERNAL ---------------------------------------------------------------
+
+func NewMemoryRevocationStore() *MemoryRevocationStore {
+	return &MemoryRevocationStore{
+		ids:      make(map[string]time.Time),
+		subjects: make(map[string]subjectRevocation),
+	}
+}
+
+func (s *MemoryRevocationStore) RevokeID(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ids[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryRevocationStore) IsIDRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, ok := s.ids[jti]
+	return ok, nil
+}
+
+func (s *MemoryRevocationStore) RevokeSubject(ctx context.Context, subject string, before time.Time, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.subjects[subject] = subjectRevocation{before: before, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryRevocationStore) GetSubjectRevokedBefore(ctx context.Context, subject string) (time.Time, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.subjects[subject].before, nil
+}
+
+func (s *MemoryRevocationStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for jti, expiresAt := range s.ids {
+		if expiresAt.Before(before) {
+			delete(s.ids, jti)
+		}
+	}
+	for subject, revocation := range s.subjects {
+		if revocation.expiresAt.Before(before) {
+			delete(s.subjects, subject)
+		}
+	}
+	return nil
+}
+
+// Use pgconn.Module.GetDB() to obtain the database.
+func NewPostgresRevocationStore(db *gorm.DB) *PostgresRevocationStore {
+	return &PostgresRevocationStore{db: db}
+}
+
+// Creates or updates the revoked_tokens and revoked_subjects tables.
+func (s *PostgresRevocationStore) Migrate(ctx context.Context) error {
+	return s.db.WithContext(ctx).AutoMigrate(&RevokedTokenRecord{}, &RevokedSubjectRecord{})
+}
+
+func (s *PostgresRevocationStore) RevokeID(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.db.WithContext(ctx).Save(&RevokedTokenRecord{ID: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (s *PostgresRevocationStore) IsIDRevoked(ctx context.Context, jti string) (bool, error) {
+	var count int64
+	err := s.db.WithContext(ctx).Model(&RevokedTokenRecord{}).Where("id = ?", jti).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *PostgresRevocationStore) RevokeSubject(ctx context.Context, subject string, before time.Time, expiresAt time.Time) error {
+	record := &RevokedSubjectRecord{Subject: subject, RevokedBefore: before, ExpiresAt: expiresAt}
+	return s.db.WithContext(ctx).Save(record).Error
+}
+
+func (s *PostgresRevocationStore) GetSubjectRevokedBefore(ctx context.Context, subject string) (time.Time, error) {
+	var record RevokedSubjectRecord
+	err := s.db.WithContext(ctx).Where("subject = ?", subject).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return record.RevokedBefore, nil
+}
+
+func (s *PostgresRevocationStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	db := s.db.WithContext(ctx)
+	if err := db.Where("expires_at < ?", before).Delete(&RevokedTokenRecord{}).Error; err != nil {
+		return err
+	}
+	return db.Where("expires_at < ?", before).Delete(&RevokedSubjectRecord{}).Error
+}
@@ -0,0 +1,228 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Fails every lookup, e.g. while the database is unavailable.
+type failingRevocationStore struct {
+	*MemoryRevocationStore
+}
+
+func (s *failingRevocationStore) IsIDRevoked(ctx context.Context, jti string) (bool, error) {
+	return false, errors.New("database unavailable")
+}
+
+func newRevocationModule(cacheTTLInSeconds int) *Module {
+	m := newKeyModule(map[string]*Config{
+		"jwt_auth": {
+			TokenLookup:   "header:Authorization:Bearer ",
+			SigningMethod: "HS256",
+			SigningKey:    "secret",
+			ExpInHours:    1,
+			SetIssuedAt:   true,
+			GenerateJTI:   true,
+			// tolerates the "iat" in the future of tokens reissued after a revocation
+			LeewayInSeconds: 30,
+		},
+	})
+	m.revocationConfig = &RevocationConfig{CacheTTLInSeconds: cacheTTLInSeconds}
+	m.SetRevocationStore(NewMemoryRevocationStore())
+	return m
+}
+
+func TestMemoryRevocationStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRevocationStore()
+	now := time.Now()
+
+	require.NoError(t, store.RevokeID(ctx, "jti1", now.Add(time.Hour)))
+	require.NoError(t, store.RevokeID(ctx, "jti2", now.Add(-time.Minute)))
+	require.NoError(t, store.RevokeSubject(ctx, "user123", now, now.Add(time.Hour)))
+	require.NoError(t, store.RevokeSubject(ctx, "user456", now, now.Add(-time.Minute)))
+
+	revoked, err := store.IsIDRevoked(ctx, "jti1")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, _ = store.IsIDRevoked(ctx, "unknown")
+	assert.False(t, revoked)
+
+	before, err := store.GetSubjectRevokedBefore(ctx, "user123")
+	assert.NoError(t, err)
+	assert.True(t, before.Equal(now))
+
+	before, _ = store.GetSubjectRevokedBefore(ctx, "unknown")
+	assert.True(t, before.IsZero())
+
+	t.Run("TestDeleteExpired", func(t *testing.T) {
+		require.NoError(t, store.DeleteExpired(ctx, now))
+
+		revoked, _ := store.IsIDRevoked(ctx, "jti2")
+		assert.False(t, revoked)
+		before, _ := store.GetSubjectRevokedBefore(ctx, "user456")
+		assert.True(t, before.IsZero())
+
+		revoked, _ = store.IsIDRevoked(ctx, "jti1")
+		assert.True(t, revoked)
+	})
+}
+
+func TestRevocation(t *testing.T) {
+	ctx := context.Background()
+	m := newRevocationModule(30)
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetJWTMiddleware("jwt_auth"))
+
+	send := func(signed string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+signed)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	generate := func(claims jwt.MapClaims) string {
+		signed, err := m.GenerateToken("jwt_auth", claims)
+		require.NoError(t, err)
+		return *signed
+	}
+
+	t.Run("TestRevokeToken", func(t *testing.T) {
+		signed := generate(jwt.MapClaims{"sub": "user123"})
+		other := generate(jwt.MapClaims{"sub": "user123"})
+		assert.Equal(t, http.StatusNoContent, send(signed))
+
+		parsed, err := m.ParseToken("jwt_auth", signed)
+		require.NoError(t, err)
+		require.NoError(t, m.RevokeToken(ctx, parsed))
+
+		// revocations made by this instance apply immediately, despite the cache
+		assert.Equal(t, http.StatusUnauthorized, send(signed))
+		_, err = m.ParseToken("jwt_auth", signed)
+		assert.ErrorIs(t, err, ErrTokenRevoked)
+
+		assert.Equal(t, http.StatusNoContent, send(other))
+	})
+
+	t.Run("TestRevokeSubject", func(t *testing.T) {
+		signed := generate(jwt.MapClaims{"sub": "user456"})
+		assert.Equal(t, http.StatusNoContent, send(signed))
+
+		require.NoError(t, m.RevokeSubject(ctx, "user456"))
+		assert.Equal(t, http.StatusUnauthorized, send(signed))
+
+		// tokens issued after the revocation are accepted
+		reissued := generate(jwt.MapClaims{"sub": "user456", "iat": jwt.NewNumericDate(time.Now().Add(time.Second))})
+		assert.Equal(t, http.StatusNoContent, send(reissued))
+
+		// tokens without "iat" cannot be told apart, and are rejected
+		withoutIssuedAt := generate(jwt.MapClaims{"sub": "user456", "iat": nil})
+		assert.Equal(t, http.StatusUnauthorized, send(withoutIssuedAt))
+	})
+
+	t.Run("TestCache", func(t *testing.T) {
+		signed := generate(jwt.MapClaims{"sub": "user789"})
+		assert.Equal(t, http.StatusNoContent, send(signed))
+
+		// revoked by another instance sharing the store
+		parsed, err := m.ParseToken("jwt_auth", signed)
+		require.NoError(t, err)
+		jti := parsed.Claims.(jwt.MapClaims)["jti"].(string)
+		store, cache := m.getRevocation()
+		require.NoError(t, store.RevokeID(ctx, jti, time.Now().Add(time.Hour)))
+
+		assert.Equal(t, http.StatusNoContent, send(signed))
+
+		// applies once the cached lookup expired
+		cache.mutex.Lock()
+		cache.ttl = time.Nanosecond
+		cache.mutex.Unlock()
+		assert.Equal(t, http.StatusUnauthorized, send(signed))
+	})
+
+	t.Run("TestStoreErrorRejects", func(t *testing.T) {
+		m.SetRevocationStore(&failingRevocationStore{NewMemoryRevocationStore()})
+		defer m.SetRevocationStore(NewMemoryRevocationStore())
+
+		assert.Equal(t, http.StatusUnauthorized, send(generate(jwt.MapClaims{"sub": "user123"})))
+	})
+}
+
+func TestLogoutRevokesAccessToken(t *testing.T) {
+	m := newRevocationModule(30)
+	m.SetRefreshStore(NewMemoryRefreshStore())
+	m.configs["jwt_auth"].RefreshExpInHours = 24
+
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	}, m.GetJWTMiddleware("jwt_auth"))
+	e.POST("/auth/logout", m.GetLogoutHandler(), m.GetJWTMiddleware("jwt_auth"))
+
+	pair, err := m.IssueTokenPair(context.Background(), "jwt_auth", jwt.MapClaims{"sub": "user123"})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader(`{"refresh_token":"`+pair.RefreshToken+`"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+pair.AccessToken)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+pair.AccessToken)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	_, err = m.RefreshTokenPair(context.Background(), "jwt_auth", pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestRevocationCache(t *testing.T) {
+	t.Run("TestLimit", func(t *testing.T) {
+		cache := newRevocationCache(time.Hour)
+		for i := 0; i < revocationCacheMaxEntries+5; i++ {
+			cache.set(cache.ids, strconv.Itoa(i), cachedRevocation{})
+		}
+
+		// the oldest entries are evicted
+		assert.Equal(t, revocationCacheMaxEntries, len(cache.ids.entries))
+		assert.Equal(t, revocationCacheMaxEntries, cache.ids.order.Len())
+		_, ok := cache.get(cache.ids, "0")
+		assert.False(t, ok)
+		_, ok = cache.get(cache.ids, strconv.Itoa(revocationCacheMaxEntries+4))
+		assert.True(t, ok)
+	})
+
+	t.Run("TestExpired", func(t *testing.T) {
+		cache := newRevocationCache(50 * time.Millisecond)
+		cache.set(cache.ids, "old", cachedRevocation{revoked: true})
+		time.Sleep(60 * time.Millisecond)
+
+		// updated entries move to the back, expired ones are evicted on the next insert
+		cache.set(cache.ids, "updated", cachedRevocation{})
+		cache.set(cache.ids, "updated", cachedRevocation{revoked: true})
+		assert.NotContains(t, cache.ids.entries, "old")
+		assert.Equal(t, 1, cache.ids.order.Len())
+
+		cached, ok := cache.get(cache.ids, "updated")
+		require.True(t, ok)
+		assert.True(t, cached.revoked)
+	})
+}